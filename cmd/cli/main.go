@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"database/sql"
 	"dexianta/refci/core"
@@ -13,6 +12,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"syscall"
@@ -20,14 +20,15 @@ import (
 )
 
 type runtimeConfig struct {
-	Repo string
-	Env  []string
+	Repo    string
+	Env     []string
+	Secrets []string // values masked in job logs
 }
 
 const appVersion = "0.5.4"
 
 // - refci init (for init root)
-// - refci clone -i <ssh-key> <git-repo> (this download the code into repos folder)
+// - refci clone [-i <ssh-key> | --token-file <path>] <git-repo> (this download the code into repos folder)
 // - refci -e <env_path>  <repos/repo_name>  // to start running poll for this one repo
 // - future direction: parse each repos root/.refci folder, and generate .env file, the bash script file name can match the branch pattern
 func main() {
@@ -51,6 +52,20 @@ func run(args []string) error {
 		return runInit(args[1:])
 	case "clone":
 		return runClone(args[1:])
+	case "lint":
+		return runLint(args[1:])
+	case "config":
+		return runConfig(args[1:])
+	case "cache":
+		return runCache(args[1:])
+	case "secret":
+		return runSecret(args[1:])
+	case "log":
+		return runLog(args[1:])
+	case "gc":
+		return runGC(args[1:])
+	case "grep":
+		return runGrep(args[1:])
 	case "version":
 		fmt.Println(appVersion)
 		return nil
@@ -89,10 +104,12 @@ func runInit(args []string) error {
 func runClone(args []string) error {
 	fs := flag.NewFlagSet("clone", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
-	var identityPath string
+	var identityPath, tokenFile, tokenEnv string
 	fs.StringVar(&identityPath, "i", "", "ssh private key path")
 	fs.StringVar(&identityPath, "identity", "", "ssh private key path")
 	fs.StringVar(&identityPath, "ssh-key", "", "ssh private key path")
+	fs.StringVar(&tokenFile, "token-file", "", "file holding an https access token")
+	fs.StringVar(&tokenEnv, "token-env", "", "env var holding an https access token")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			printCloneUsage(os.Stdout)
@@ -108,16 +125,38 @@ func runClone(args []string) error {
 		return errors.New("clone requires exactly one git URL")
 	}
 
-	keyPath, err := normalizeSSHIdentityPath(identityPath)
+	remote, err := core.ParseRemoteURL(rest[0])
 	if err != nil {
-		printCloneUsage(os.Stderr)
 		return err
 	}
+	repo := remote.RepoName()
 
-	repoURL := strings.TrimSpace(rest[0])
-	repo := core.ParseGithubUrl(repoURL)
-	if repo == "" {
-		return fmt.Errorf("invalid github URL: %q", repoURL)
+	var (
+		keyPath string
+		token   string
+	)
+	switch remote.Kind {
+	case core.RemoteSSH:
+		if tokenFile != "" || tokenEnv != "" {
+			return errors.New("tokens are only used with https remotes")
+		}
+		keyPath, err = normalizeSSHIdentityPath(identityPath)
+		if err != nil {
+			printCloneUsage(os.Stderr)
+			return err
+		}
+	case core.RemoteHTTPS:
+		if identityPath != "" {
+			return errors.New("-i is only used with ssh remotes")
+		}
+		token, err = readCloneToken(tokenFile, tokenEnv)
+		if err != nil {
+			return err
+		}
+	case core.RemoteFile:
+		if identityPath != "" || tokenFile != "" || tokenEnv != "" {
+			return errors.New("file remotes take no credentials")
+		}
 	}
 
 	if err := ensureRootAtCWD(); err != nil {
@@ -125,24 +164,582 @@ func runClone(args []string) error {
 	}
 
 	mirrorPath := filepath.Join(core.Root, "repos", core.ToLocalRepo(repo))
-	sshHost := refciSSHHostAlias(repo)
-	if err := ensureRefciSSHHost(sshHost, keyPath); err != nil {
-		return err
+	var gitConfig []core.GitConfig
+	cloneURL := remote.CloneURL("")
+	switch remote.Kind {
+	case core.RemoteSSH:
+		sshHost := refciSSHHostAlias(repo)
+		if err := ensureRefciSSHHost(sshHostEntry{
+			Alias:        sshHost,
+			HostName:     remote.Host,
+			Port:         remote.Port,
+			User:         remote.User,
+			IdentityFile: keyPath,
+		}); err != nil {
+			return err
+		}
+		cloneURL = remote.CloneURL(sshHost)
+		fmt.Printf("configured ssh host %s with %s\n", sshHost, keyPath)
+	case core.RemoteHTTPS:
+		if token != "" {
+			tokenPath, err := core.WriteRepoToken(repo, token)
+			if err != nil {
+				return err
+			}
+			gitConfig, err = core.TokenCredentialHelper(remote.User, tokenPath)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("stored https token in %s\n", tokenPath)
+		}
 	}
-	cloneURL := githubSSHURLForHost(sshHost, repo)
-	if err := core.CloneMirror(context.Background(), cloneURL, mirrorPath); err != nil {
+	if err := core.CloneMirror(context.Background(), cloneURL, mirrorPath, gitConfig...); err != nil {
 		return err
 	}
 
-	fmt.Printf("configured ssh host %s with %s\n", sshHost, keyPath)
 	fmt.Printf("cloned %s into %s\n", repo, mirrorPath)
 	return nil
 }
 
+func runLint(args []string) error {
+	if len(args) == 1 && isHelpArg(args[0]) {
+		printLintUsage(os.Stdout)
+		return nil
+	}
+	if len(args) > 1 {
+		printLintUsage(os.Stderr)
+		return fmt.Errorf("lint accepts at most one argument")
+	}
+
+	path := filepath.Join(".refci", "conf.yml")
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, ".refci", "conf.yml")
+	}
+
+	jobs, err := core.LoadJobConfs(path)
+	var confErrs core.ConfErrors
+	if errors.As(err, &confErrs) {
+		for _, e := range confErrs {
+			fmt.Fprintln(os.Stderr, e.Error())
+		}
+		return fmt.Errorf("%s: %d problem(s)", path, len(confErrs))
+	}
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("%s: no jobs defined", path)
+	}
+	fmt.Printf("%s: ok (%d jobs)\n", path, len(jobs))
+	return nil
+}
+
+func runConfig(args []string) error {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	unset := fs.Bool("unset", false, "remove the setting")
+	global := fs.Bool("global", false, "root-level settings instead of a repo's")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printConfigUsage(os.Stdout)
+			return nil
+		}
+		printConfigUsage(os.Stderr)
+		return err
+	}
+	rest := fs.Args()
+	if *global {
+		// Root-level settings live under an empty repo name.
+		rest = append([]string{core.GlobalSettingsRepo}, rest...)
+	}
+	if len(rest) == 0 || len(rest) > 3 || *unset && len(rest) != 2 {
+		printConfigUsage(os.Stderr)
+		return errors.New("config requires a repo target (or --global) and optionally a key and value")
+	}
+
+	db, dbRepo, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	repo, kind, label := core.GlobalSettingsRepo, "global", "global"
+	lookup, normalize, specs := core.LookupGlobalSetting, core.NormalizeGlobalSetting, core.GlobalSettingSpecs()
+	valueOf := func(key string) (string, error) { return core.GlobalSettingValue(dbRepo, key) }
+	if !*global {
+		if repo, _, err = resolveRepoTarget(rest[0]); err != nil {
+			return err
+		}
+		kind, label = "repo", repo
+		lookup, normalize, specs = core.LookupRepoSetting, core.NormalizeRepoSetting, core.RepoSettingSpecs()
+		valueOf = func(key string) (string, error) { return core.RepoSettingValue(dbRepo, repo, key) }
+	}
+
+	switch {
+	case *unset:
+		if _, ok := lookup(rest[1]); !ok {
+			return fmt.Errorf("unknown %s setting %q", kind, rest[1])
+		}
+		return dbRepo.DeleteRepoSetting(repo, rest[1])
+	case len(rest) == 3:
+		value, err := normalize(rest[1], rest[2])
+		if err != nil {
+			return fmt.Errorf("%s: %w", rest[1], err)
+		}
+		if err := dbRepo.SetRepoSetting(core.RepoSetting{Repo: repo, Key: rest[1], Value: value}); err != nil {
+			return err
+		}
+		fmt.Printf("%s %s = %s\n", label, rest[1], value)
+		return nil
+	case len(rest) == 2:
+		value, err := valueOf(rest[1])
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+		return nil
+	}
+
+	stored, err := dbRepo.RepoSettings(repo)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	for _, s := range stored {
+		values[s.Key] = s.Value
+	}
+	for _, spec := range specs {
+		if value, ok := values[spec.Key]; ok {
+			fmt.Printf("%s = %s\n", spec.Key, value)
+		} else {
+			fmt.Printf("%s = %s (default)\n", spec.Key, spec.Default)
+		}
+		fmt.Printf("    %s\n", spec.Help)
+	}
+	return nil
+}
+
+func runCache(args []string) error {
+	if len(args) == 0 || isHelpArg(args[0]) {
+		printCacheUsage(os.Stdout)
+		return nil
+	}
+	if err := ensureRootAtCWD(); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "ls":
+		if len(args) > 2 {
+			printCacheUsage(os.Stderr)
+			return errors.New("cache ls accepts at most one repo target")
+		}
+		repo := ""
+		if len(args) == 2 {
+			r, _, err := resolveRepoTarget(args[1])
+			if err != nil {
+				return err
+			}
+			repo = r
+		}
+		entries, err := core.ListCaches(repo)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Println("no caches")
+			return nil
+		}
+		var total int64
+		for _, e := range entries {
+			total += e.Size
+			fmt.Printf("%s\t%s\t%s\t%s\tused %s\t%s\n", e.Repo, e.Branch, e.Key, core.FormatByteSize(e.Size), e.LastUsed.Local().Format("2006-01-02 15:04"), strings.Join(e.Paths, ","))
+		}
+		fmt.Printf("%d caches, %s\n", len(entries), core.FormatByteSize(total))
+		return nil
+	case "rm":
+		if len(args) < 2 {
+			printCacheUsage(os.Stderr)
+			return errors.New("cache rm requires a repo target")
+		}
+		repo, _, err := resolveRepoTarget(args[1])
+		if err != nil {
+			return err
+		}
+		n, err := core.RemoveCaches(repo, args[2:])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d caches from %s\n", n, repo)
+		return nil
+	}
+	printCacheUsage(os.Stderr)
+	return fmt.Errorf("unknown cache command %q", args[0])
+}
+
+// runLog prints a job log as plain text, optionally only some of its
+// streams and with the time of each line.
+func runLog(args []string) error {
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	streams := fs.String("stream", "", "comma-separated streams to print: refci, stdout, stderr")
+	timestamps := fs.Bool("timestamps", false, "prefix each line with when it was written")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printLogUsage(os.Stdout)
+			return nil
+		}
+		printLogUsage(os.Stderr)
+		return err
+	}
+	if fs.NArg() != 1 {
+		printLogUsage(os.Stderr)
+		return errors.New("log requires a run id or log file")
+	}
+
+	opts := core.LogExportOptions{Timestamps: *timestamps}
+	for _, name := range strings.Split(*streams, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		stream, err := core.ParseLogStream(name)
+		if err != nil {
+			return err
+		}
+		opts.Streams = append(opts.Streams, stream)
+	}
+
+	logPath := fs.Arg(0)
+	if info, err := os.Stat(logPath); err != nil || info.IsDir() {
+		db, dbRepo, err := openDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		job, err := findJobByRunID(dbRepo, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(job.LogPath) == "" {
+			return fmt.Errorf("run %s has no log (status %s)", job.RunID, job.Status)
+		}
+		logPath = job.LogPath
+	}
+	return core.ExportLog(os.Stdout, logPath, opts)
+}
+
+// runGrep searches the job logs of all runs, oldest first, so the first
+// line printed is where a message first appeared.
+func runGrep(args []string) error {
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	repoTarget := fs.String("repo", "", "only runs of this repo")
+	job := fs.String("job", "", "only runs of this job")
+	since := fs.String("since", "", "only runs started since: a duration (36h, 7d) or a date (2006-01-02)")
+	limit := fs.Int("limit", 100, "print at most this many lines; 0 for all")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printGrepUsage(os.Stdout)
+			return nil
+		}
+		printGrepUsage(os.Stderr)
+		return err
+	}
+	if fs.NArg() != 1 || fs.Arg(0) == "" {
+		printGrepUsage(os.Stderr)
+		return errors.New("grep requires one pattern")
+	}
+
+	search := core.LogSearch{Pattern: fs.Arg(0), Job: strings.TrimSpace(*job)}
+	if *limit > 0 {
+		search.Limit = *limit + 1 // one more to tell whether there are more
+	}
+	if strings.TrimSpace(*since) != "" {
+		t, err := core.ParseSince(*since, time.Now())
+		if err != nil {
+			return fmt.Errorf("--since: %w", err)
+		}
+		search.Since = t
+	}
+	if strings.TrimSpace(*repoTarget) != "" {
+		repo, _, err := resolveRepoTarget(*repoTarget)
+		if err != nil {
+			return err
+		}
+		search.Repo = repo
+	}
+
+	db, dbRepo, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	matches, err := core.SearchLogs(dbRepo, search)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		fmt.Println("no matches")
+		return nil
+	}
+	more := *limit > 0 && len(matches) > *limit
+	if more {
+		matches = matches[:*limit]
+	}
+	runs := map[string]bool{}
+	for _, m := range matches {
+		runs[m.Job.RunID] = true
+		fmt.Printf("%s  %s  %s  %s  %s  %s:%d: %s\n",
+			m.Job.Start.Local().Format("2006-01-02 15:04"), m.Job.Repo, m.Job.Name, m.Job.Branch, shortSHA(m.Job.SHA), m.Job.RunID, m.Line+1, m.Text)
+	}
+	if more {
+		fmt.Printf("first %d matching lines in %d runs; raise --limit for more\n", len(matches), len(runs))
+	} else {
+		fmt.Printf("%d matching lines in %d runs\n", len(matches), len(runs))
+	}
+	return nil
+}
+
+// runGC applies the log retention settings to one repo, or to all of them.
+func runGC(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dryRun := fs.Bool("dry-run", false, "print what would be removed")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printGCUsage(os.Stdout)
+			return nil
+		}
+		printGCUsage(os.Stderr)
+		return err
+	}
+	if fs.NArg() > 1 {
+		printGCUsage(os.Stderr)
+		return errors.New("gc accepts at most one repo target")
+	}
+
+	db, dbRepo, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	repo := ""
+	if fs.NArg() == 1 {
+		if repo, _, err = resolveRepoTarget(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	policy, err := core.LoadGlobalSetting(dbRepo)
+	if err != nil {
+		return err
+	}
+	report, err := core.CollectGarbage(dbRepo, repo, policy, time.Now(), *dryRun)
+	if err != nil {
+		return err
+	}
+	fmt.Println(report.String())
+	return nil
+}
+
+// parseSecretScope reads the scope flags and target shared by the secret
+// commands: [--job <name>] (--global | <repo-target>).
+func parseSecretScope(name string, args []string) (core.SecretScope, []string, error) {
+	fs := flag.NewFlagSet("secret "+name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	global := fs.Bool("global", false, "global secret")
+	job := fs.String("job", "", "job secret")
+	if err := fs.Parse(args); err != nil {
+		return core.SecretScope{}, nil, err
+	}
+	rest := fs.Args()
+	if *global {
+		if *job != "" {
+			return core.SecretScope{}, nil, errors.New("--job needs a repo target, not --global")
+		}
+		return core.SecretScope{}, rest, nil
+	}
+	if len(rest) == 0 {
+		return core.SecretScope{}, nil, fmt.Errorf("secret %s requires --global or a repo target", name)
+	}
+	repo, _, err := resolveRepoTarget(rest[0])
+	if err != nil {
+		return core.SecretScope{}, nil, err
+	}
+	return core.SecretScope{Repo: repo, Job: strings.TrimSpace(*job)}, rest[1:], nil
+}
+
+func runSecret(args []string) error {
+	if len(args) == 0 || isHelpArg(args[0]) {
+		printSecretUsage(os.Stdout)
+		return nil
+	}
+	cmd := args[0]
+	scope, rest, err := parseSecretScope(cmd, args[1:])
+	if err != nil {
+		printSecretUsage(os.Stderr)
+		return err
+	}
+
+	db, dbRepo, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch cmd {
+	case "ls":
+		if len(rest) != 0 {
+			printSecretUsage(os.Stderr)
+			return errors.New("secret ls takes no key")
+		}
+		secrets, err := dbRepo.ListSecrets(scope.Repo)
+		if err != nil {
+			return err
+		}
+		for _, s := range secrets {
+			if scope.Job != "" && s.Job != scope.Job {
+				continue
+			}
+			fmt.Printf("%-30s %-24s %s\n", core.SecretScope{Repo: s.Repo, Job: s.Job}, s.Key, s.UpdatedAt.Local().Format(time.DateTime))
+		}
+		return nil
+	case "rm":
+		if len(rest) != 1 {
+			printSecretUsage(os.Stderr)
+			return errors.New("secret rm requires a key")
+		}
+		secrets, err := core.ListScopeSecrets(dbRepo, scope)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(secrets, func(s core.Secret) bool { return s.Key == rest[0] }) {
+			return fmt.Errorf("secret %s not set in %s", rest[0], scope)
+		}
+		return dbRepo.DeleteSecret(scope.Repo, scope.Job, rest[0])
+	case "get":
+		if len(rest) != 1 {
+			printSecretUsage(os.Stderr)
+			return errors.New("secret get requires a key")
+		}
+		store, err := core.OpenSecretStore(dbRepo, false)
+		if err != nil {
+			return err
+		}
+		value, ok, err := store.Get(scope, rest[0])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("secret %s not set in %s", rest[0], scope)
+		}
+		fmt.Println(value)
+		return nil
+	case "set":
+		if len(rest) != 1 && len(rest) != 2 {
+			printSecretUsage(os.Stderr)
+			return errors.New("secret set requires a key and optionally a value")
+		}
+		var value string
+		if len(rest) == 2 {
+			value = rest[1]
+		} else {
+			// Read from stdin so the value stays out of shell history.
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("read secret value: %w", err)
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		store, err := core.OpenSecretStore(dbRepo, true)
+		if err != nil {
+			return err
+		}
+		if err := store.Set(scope, rest[0], value); err != nil {
+			return err
+		}
+		fmt.Printf("set %s in %s\n", rest[0], scope)
+		return nil
+	case "import":
+		if len(rest) != 1 {
+			printSecretUsage(os.Stderr)
+			return errors.New("secret import requires an env file")
+		}
+		var cfg runtimeConfig
+		if err := readEnvFile(&cfg, rest[0], true); err != nil {
+			return err
+		}
+		store, err := core.OpenSecretStore(dbRepo, true)
+		if err != nil {
+			return err
+		}
+		for _, kv := range cfg.Env {
+			key, value, _ := strings.Cut(kv, "=")
+			if err := store.Set(scope, key, value); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("imported %d secrets into %s\n", len(cfg.Env), scope)
+		return nil
+	}
+	printSecretUsage(os.Stderr)
+	return fmt.Errorf("unknown secret command %q", cmd)
+}
+
+// describeConfigError turns config validation errors into a one-line status
+// that leads with the file position; each problem is logged when logf is set.
+func describeConfigError(err error, logf func(string, ...any)) error {
+	var confErrs core.ConfErrors
+	if !errors.As(err, &confErrs) {
+		return fmt.Errorf("load .refci/conf.yml: %w", err)
+	}
+	if logf != nil {
+		for _, e := range confErrs {
+			logf("config error %s", e.Error())
+		}
+	}
+	return fmt.Errorf("config error at %w", confErrs)
+}
+
+func readCloneToken(tokenFile, tokenEnv string) (string, error) {
+	if tokenFile != "" && tokenEnv != "" {
+		return "", errors.New("use only one of --token-file and --token-env")
+	}
+	if tokenEnv != "" {
+		token := strings.TrimSpace(os.Getenv(tokenEnv))
+		if token == "" {
+			return "", fmt.Errorf("env var %s is empty", tokenEnv)
+		}
+		return token, nil
+	}
+	if tokenFile == "" {
+		return "", nil
+	}
+	path, err := expandHomePath(strings.TrimSpace(tokenFile))
+	if err != nil {
+		return "", err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(raw))
+	if token == "" {
+		return "", fmt.Errorf("token file is empty: %s", path)
+	}
+	return token, nil
+}
+
+// gcInterval is how often a poller applies the log retention settings.
+const gcInterval = time.Hour
+
 func runPollLoop(args []string) error {
 	fs := flag.NewFlagSet("refci", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 	envPath := fs.String("e", ".env", "env file path")
+	secretsPath := fs.String("secrets", "", "secrets file path; every value is passed to jobs and masked in their logs")
 	interval := fs.Duration("interval", 3*time.Second, "poll interval")
 	monitorMode := fs.Bool("monitor", false, "monitor only (no automatic fetch/poll; manual restart/cancel still available)")
 	if err := fs.Parse(args); err != nil {
@@ -178,17 +775,23 @@ func runPollLoop(args []string) error {
 	}
 
 	cfg := runtimeConfig{Repo: repo}
+	envLoader := &runtimeConfigLoader{repo: repo, envPath: *envPath, secretsPath: *secretsPath}
 	ciLogger, err := core.NewCIActivityLogger(repo)
 	if err != nil {
 		return err
 	}
 	runner := core.NewJobRunner(dbRepo)
 	runner.SetLogger(ciLogger.Logf)
+	confLoader := core.NewJobConfLoader(repo)
 	if !*monitorMode {
-		cfg, err = parseRuntimeConfig(repo, *envPath)
+		cfg, err = envLoader.load()
 		if err != nil {
 			return err
 		}
+		runner.SetSecrets(cfg.Secrets)
+		if err := setupSecretStore(dbRepo, runner, repo); err != nil {
+			return err
+		}
 	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -232,9 +835,12 @@ func runPollLoop(args []string) error {
 		defer close(statusCh)
 
 		doPoll := func() {}
+		doGC := func() {}
 		var ticker *time.Ticker
 		var tickerCh <-chan time.Time
+		var gcTickerCh <-chan time.Time
 		lastErr := ""
+		lastEnvErr := ""
 
 		if !*monitorMode {
 			doPoll = func() {
@@ -242,6 +848,21 @@ func runPollLoop(args []string) error {
 				started := time.Now()
 				ciLogger.Logf("poll tick start")
 
+				// A broken edit keeps the previous env until it is fixed.
+				if next, changed, err := envLoader.reload(); err != nil {
+					if msg := err.Error(); msg != lastEnvErr {
+						ciLogger.Logf("env reload failed, keeping previous env: %v", err)
+						reportStatus("env file: "+msg+" (keeping previous env)", true)
+						lastEnvErr = msg
+					}
+				} else if changed {
+					cfg = next
+					runner.SetSecrets(cfg.Secrets)
+					ciLogger.Logf("env reloaded vars=%d secrets=%d", len(cfg.Env), len(cfg.Secrets))
+					reportStatus("env file reloaded", false)
+					lastEnvErr = ""
+				}
+
 				fetchStarted := time.Now()
 				ciLogger.Logf("fetch mirror start path=%s", mirrorPath)
 				if err := fetchMirror(ctx, mirrorPath); err != nil {
@@ -250,18 +871,28 @@ func runPollLoop(args []string) error {
 				} else {
 					ciLogger.Logf("fetch mirror done in %s", time.Since(fetchStarted).Round(time.Millisecond))
 					loadStarted := time.Now()
-					ciLogger.Logf("load job config start ref=HEAD")
-					jobs, err := core.LoadJobConfsFromRepo(ctx, cfg.Repo, "HEAD")
+					source, err := core.LoadConfigSource(dbRepo, cfg.Repo)
 					if err != nil {
-						ciLogger.Logf("load job config failed after %s: %v", time.Since(loadStarted).Round(time.Millisecond), err)
-						loopErr = fmt.Errorf("load .refci/conf.yml: %w", err)
-					} else if len(jobs) == 0 {
-						ciLogger.Logf("load job config done in %s count=0", time.Since(loadStarted).Round(time.Millisecond))
-						loopErr = fmt.Errorf("no jobs found in .refci/conf.yml for %s", cfg.Repo)
+						loopErr = fmt.Errorf("read repo settings: %w", err)
 					} else {
-						ciLogger.Logf("load job config done in %s count=%d", time.Since(loadStarted).Round(time.Millisecond), len(jobs))
-						if err := pollOnce(ctx, dbRepo, runner, cfg, jobs, ciLogger.Logf); err != nil {
-							loopErr = fmt.Errorf("poll failed: %w", err)
+						ciLogger.Logf("load job config start ref=HEAD source=%s", source.Mode)
+						jobs, headErr := confLoader.Load(ctx, "HEAD")
+						branchMode := source.Mode == core.ConfigSourceBranch
+						if headErr != nil {
+							ciLogger.Logf("load job config failed after %s: %v", time.Since(loadStarted).Round(time.Millisecond), headErr)
+						} else {
+							ciLogger.Logf("load job config done in %s count=%d", time.Since(loadStarted).Round(time.Millisecond), len(jobs))
+						}
+						switch {
+						case headErr != nil && !branchMode:
+							loopErr = describeConfigError(headErr, ciLogger.Logf)
+						case headErr == nil && len(jobs) == 0 && !branchMode:
+							loopErr = fmt.Errorf("no jobs found in .refci/conf.yml for %s", cfg.Repo)
+						default:
+							set := jobConfSet{head: jobs, headErr: headErr, source: source, loader: confLoader}
+							if err := pollOnce(ctx, dbRepo, runner, cfg, set, ciLogger.Logf); err != nil {
+								loopErr = fmt.Errorf("poll failed: %w", err)
+							}
 						}
 					}
 				}
@@ -283,10 +914,29 @@ func runPollLoop(args []string) error {
 				}
 			}
 
+			doGC = func() {
+				policy, err := core.LoadGlobalSetting(dbRepo)
+				if err != nil {
+					ciLogger.Logf("gc failed: %v", err)
+					return
+				}
+				report, err := core.CollectGarbage(dbRepo, cfg.Repo, policy, time.Now(), false)
+				if err != nil {
+					ciLogger.Logf("gc failed: %v", err)
+					return
+				}
+				ciLogger.Logf("gc done: %s", report)
+			}
+
 			doPoll()
 			ticker = time.NewTicker(*interval)
 			tickerCh = ticker.C
 			defer ticker.Stop()
+
+			doGC()
+			gcTicker := time.NewTicker(gcInterval)
+			gcTickerCh = gcTicker.C
+			defer gcTicker.Stop()
 		}
 
 		for {
@@ -303,7 +953,7 @@ func runPollLoop(args []string) error {
 				return
 			case req := <-rerunCh:
 				ciLogger.Logf("rerun requested job=%s branch=%s sha=%s", req.Name, req.Branch, shortSHA(req.SHA))
-				if err := rerunJob(ctx, dbRepo, runner, cfg, req); err != nil {
+				if err := rerunJob(ctx, dbRepo, runner, cfg, confLoader, req); err != nil {
 					ciLogger.Logf("rerun failed job=%s branch=%s sha=%s: %v", req.Name, req.Branch, shortSHA(req.SHA), err)
 					reportStatus(fmt.Sprintf("restart failed for %s/%s: %v", req.Name, req.Branch, err), true)
 					continue
@@ -321,6 +971,8 @@ func runPollLoop(args []string) error {
 				reportStatus(fmt.Sprintf("cancel requested for %s/%s@%s", req.Name, req.Branch, shortSHA(req.SHA)), false)
 			case <-tickerCh:
 				doPoll()
+			case <-gcTickerCh:
+				doGC()
 			}
 		}
 	}()
@@ -394,7 +1046,7 @@ func runMonitorPicker() error {
 			case req := <-rerunCh:
 				runner, err := getRunner(req.Repo)
 				if err == nil {
-					err = rerunJob(ctx, dbRepo, runner, runtimeConfig{Repo: req.Repo}, req)
+					err = rerunJob(ctx, dbRepo, runner, runtimeConfig{Repo: req.Repo}, core.NewJobConfLoader(req.Repo), req)
 				}
 				if err != nil {
 					reportStatus(fmt.Sprintf("restart failed for %s/%s: %v", req.Name, req.Branch, err), true)
@@ -427,46 +1079,118 @@ func runMonitorPicker() error {
 	return nil
 }
 
-func parseRuntimeConfig(repo, envPath string) (runtimeConfig, error) {
-	f, err := os.Open(envPath)
-	if err != nil {
-		return runtimeConfig{}, fmt.Errorf("open env file: %w", err)
+// setupSecretStore hands the secret store to runner. Without a key there is
+// nothing to inject yet, unless secrets were stored with a key that is now
+// gone; the runner opens the store itself once a secret is set.
+func setupSecretStore(dbRepo core.DbRepo, runner *core.JobRunner, repo string) error {
+	store, err := core.OpenSecretStore(dbRepo, false)
+	if err == nil {
+		runner.SetSecretStore(store)
+		return nil
 	}
-	defer f.Close()
-
-	var cfg runtimeConfig
-	cfg.Repo = repo
+	if !errors.Is(err, core.ErrNoSecretKey) {
+		return err
+	}
+	for _, scope := range []string{"", repo} {
+		secrets, listErr := dbRepo.ListSecrets(scope)
+		if listErr != nil {
+			return listErr
+		}
+		if len(secrets) > 0 {
+			return fmt.Errorf("stored secrets cannot be read: %w", err)
+		}
+	}
+	return nil
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+// parseRuntimeConfig reads the env file and, when secretsPath is set, the
+// secrets file. Env lines prefixed with "secret" are masked in job logs, as
+// is every value of the secrets file.
+func parseRuntimeConfig(repo, envPath, secretsPath string) (runtimeConfig, error) {
+	cfg := runtimeConfig{Repo: repo}
+	if err := readEnvFile(&cfg, envPath, false); err != nil {
+		return runtimeConfig{}, err
+	}
+	if secretsPath != "" {
+		if err := readEnvFile(&cfg, secretsPath, true); err != nil {
+			return runtimeConfig{}, err
 		}
+	}
+	return cfg, nil
+}
 
-		line = strings.TrimPrefix(line, "export ")
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
+func readEnvFile(cfg *runtimeConfig, path string, allSecret bool) error {
+	vars, err := core.ReadDotenv(path)
+	if err != nil {
+		var syntaxErr *core.DotenvError
+		if errors.As(err, &syntaxErr) {
+			return err
+		}
+		kind := "env"
+		if allSecret {
+			kind = "secrets"
 		}
+		return fmt.Errorf("read %s file: %w", kind, err)
+	}
+	for _, v := range vars {
+		cfg.Env = append(cfg.Env, v.Key+"="+v.Value)
+		if (allSecret || v.Secret) && v.Value != "" {
+			cfg.Secrets = append(cfg.Secrets, v.Value)
+		}
+	}
+	return nil
+}
+
+// runtimeConfigLoader re-reads the env and secrets files when their size or
+// modification time changes, so edits apply without restarting the poller.
+type runtimeConfigLoader struct {
+	repo        string
+	envPath     string
+	secretsPath string
+	stamp       string
+}
 
-		key := strings.TrimSpace(parts[0])
-		val := strings.TrimSpace(parts[1])
-		val = strings.Trim(val, "\"")
-		val = strings.Trim(val, "'")
-		if key == "" {
+// fileStamp identifies the current version of the files.
+func (l *runtimeConfigLoader) fileStamp() (string, error) {
+	var b strings.Builder
+	for _, path := range []string{l.envPath, l.secretsPath} {
+		if path == "" {
 			continue
 		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("stat %s: %w", path, err)
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
 
-		cfg.Env = append(cfg.Env, key+"="+val)
+// load reads the files unconditionally.
+func (l *runtimeConfigLoader) load() (runtimeConfig, error) {
+	stamp, err := l.fileStamp()
+	if err != nil {
+		return runtimeConfig{}, err
 	}
-	if err := scanner.Err(); err != nil {
-		return runtimeConfig{}, fmt.Errorf("read env file: %w", err)
+	cfg, err := parseRuntimeConfig(l.repo, l.envPath, l.secretsPath)
+	if err != nil {
+		return runtimeConfig{}, err
 	}
-
+	l.stamp = stamp
 	return cfg, nil
 }
 
+// reload reads the files again if they changed since the last successful
+// load; changed is false when they did not.
+func (l *runtimeConfigLoader) reload() (cfg runtimeConfig, changed bool, err error) {
+	stamp, err := l.fileStamp()
+	if err != nil || stamp == l.stamp {
+		return runtimeConfig{}, false, err
+	}
+	cfg, err = l.load()
+	return cfg, err == nil, err
+}
+
 func fetchMirror(ctx context.Context, mirrorPath string) error {
 	if _, err := os.Stat(mirrorPath); err != nil {
 		if !os.IsNotExist(err) {
@@ -523,11 +1247,16 @@ func refciSSHHostAlias(repo string) string {
 	return "refci-" + core.ToLocalRepo(strings.ToLower(strings.TrimSpace(repo)))
 }
 
-func githubSSHURLForHost(host, repo string) string {
-	return fmt.Sprintf("git@%s:%s.git", strings.TrimSpace(host), strings.TrimSpace(repo))
+// sshHostEntry is one managed Host block in ~/.ssh/config.
+type sshHostEntry struct {
+	Alias        string
+	HostName     string
+	Port         string
+	User         string
+	IdentityFile string
 }
 
-func ensureRefciSSHHost(host, identityPath string) error {
+func ensureRefciSSHHost(entry sshHostEntry) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return fmt.Errorf("resolve home directory: %w", err)
@@ -543,7 +1272,7 @@ func ensureRefciSSHHost(host, identityPath string) error {
 		return fmt.Errorf("read ssh config: %w", err)
 	}
 
-	next, err := upsertRefciSSHHostBlock(string(raw), host, identityPath)
+	next, err := upsertRefciSSHHostBlock(string(raw), entry)
 	if err != nil {
 		return err
 	}
@@ -556,19 +1285,21 @@ func ensureRefciSSHHost(host, identityPath string) error {
 	return nil
 }
 
-func upsertRefciSSHHostBlock(config, host, identityPath string) (string, error) {
-	host = strings.TrimSpace(host)
-	identityPath = strings.TrimSpace(identityPath)
+func upsertRefciSSHHostBlock(config string, entry sshHostEntry) (string, error) {
+	host := strings.TrimSpace(entry.Alias)
 	if host == "" {
 		return "", errors.New("ssh host alias is required")
 	}
-	if identityPath == "" {
+	if strings.TrimSpace(entry.HostName) == "" {
+		return "", errors.New("ssh host name is required")
+	}
+	if strings.TrimSpace(entry.IdentityFile) == "" {
 		return "", errors.New("ssh identity path is required")
 	}
 
 	begin := refciSSHConfigBegin(host)
 	end := refciSSHConfigEnd(host)
-	block := refciSSHHostBlock(host, identityPath)
+	block := refciSSHHostBlock(entry)
 	if start := strings.Index(config, begin); start >= 0 {
 		endStart := strings.Index(config[start:], end)
 		if endStart < 0 {
@@ -594,14 +1325,19 @@ func upsertRefciSSHHostBlock(config, host, identityPath string) (string, error)
 	return config + "\n" + block, nil
 }
 
-func refciSSHHostBlock(host, identityPath string) string {
-	return fmt.Sprintf(
-		"%s\nHost %s\n  HostName github.com\n  User git\n  IdentityFile %s\n  IdentitiesOnly yes\n%s\n",
-		refciSSHConfigBegin(host),
-		host,
-		identityPath,
-		refciSSHConfigEnd(host),
-	)
+func refciSSHHostBlock(entry sshHostEntry) string {
+	host := strings.TrimSpace(entry.Alias)
+	user := strings.TrimSpace(entry.User)
+	if user == "" {
+		user = "git"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\nHost %s\n  HostName %s\n", refciSSHConfigBegin(host), host, strings.TrimSpace(entry.HostName))
+	if port := strings.TrimSpace(entry.Port); port != "" {
+		fmt.Fprintf(&b, "  Port %s\n", port)
+	}
+	fmt.Fprintf(&b, "  User %s\n  IdentityFile %s\n  IdentitiesOnly yes\n%s\n", user, strings.TrimSpace(entry.IdentityFile), refciSSHConfigEnd(host))
+	return b.String()
 }
 
 func refciSSHConfigBegin(host string) string {
@@ -676,11 +1412,10 @@ func resolveRepoTarget(target string) (repo string, mirrorPath string, err error
 		return "", "", errors.New("repo target is required")
 	}
 
-	base := filepath.Base(filepath.Clean(input))
-
-	if strings.HasPrefix(input, "repos/") || strings.Contains(input, string(os.PathSeparator)) {
+	if isRepoPathTarget(input) {
+		base := filepath.Base(filepath.Clean(input))
 		repo = strings.ReplaceAll(base, "--", "/")
-		if repo == "" {
+		if repo == "" || repo == "." || repo == string(os.PathSeparator) {
 			return "", "", fmt.Errorf("cannot infer repo from %q", target)
 		}
 		path := input
@@ -690,74 +1425,288 @@ func resolveRepoTarget(target string) (repo string, mirrorPath string, err error
 		return repo, path, nil
 	}
 
-	repo = input
+	repo = strings.Trim(input, "/")
 	if strings.Contains(repo, "--") && !strings.Contains(repo, "/") {
 		repo = strings.ReplaceAll(repo, "--", "/")
 	}
 	return repo, filepath.Join(core.Root, "repos", core.ToLocalRepo(repo)), nil
 }
 
-func pollOnce(ctx context.Context, dbRepo core.DbRepo, runner *core.JobRunner, cfg runtimeConfig, jobs []core.JobConf, logf func(string, ...any)) error {
-	for _, jc := range jobs {
-		branchSHA, err := core.ListBranchHeadsByPattern(ctx, cfg.Repo, jc.BranchPattern)
-		if err != nil {
-			logPollEvent(logf, "scan job=%s pattern=%q failed listing branches: %v", jc.Name, jc.BranchPattern, err)
-			return err
+// isRepoPathTarget reports whether target names a mirror directory rather
+// than a repo identity such as owner/repo or gitlab.com/group/repo.
+func isRepoPathTarget(target string) bool {
+	if filepath.IsAbs(target) || strings.HasPrefix(target, ".") {
+		return true
+	}
+	return strings.HasPrefix(filepath.ToSlash(target), "repos/")
+}
+
+// jobConfSet resolves which job configs apply to a branch: HEAD's, or the
+// branch tip's when the repo reads configs from branches and trusts it.
+type jobConfSet struct {
+	head    []core.JobConf
+	headErr error
+	source  core.ConfigSource
+	loader  *core.JobConfLoader
+}
+
+// forTarget returns the job configs for branch at sha and the ref they came
+// from.
+func (s jobConfSet) forTarget(ctx context.Context, branch, sha string) ([]core.JobConf, string, error) {
+	if s.source.UsesBranchConfig(branch) {
+		confs, err := s.loader.Load(ctx, sha)
+		return confs, shortSHA(sha), err
+	}
+	return s.head, "HEAD", s.headErr
+}
+
+// jobScan collects one job's poll results for the scan log line.
+type jobScan struct {
+	pattern string
+	results []string
+	queued  int
+}
+
+func pollOnce(ctx context.Context, dbRepo core.DbRepo, runner *core.JobRunner, cfg runtimeConfig, set jobConfSet, logf func(string, ...any)) error {
+	targets, err := core.ListBranchHeadsByPattern(ctx, cfg.Repo, "*")
+	if err != nil {
+		logPollEvent(logf, "scan failed listing branches: %v", err)
+		return err
+	}
+	pulls, err := core.ListPullRequests(ctx, cfg.Repo)
+	if err != nil {
+		logPollEvent(logf, "scan failed listing pull requests: %v", err)
+		return err
+	}
+	for _, pr := range pulls {
+		if pr.MergeSHA != "" {
+			targets[core.PullRequestBranch(pr.Number)] = pr.MergeSHA
 		}
-		if len(branchSHA) == 0 {
-			logPollEvent(logf, "scan job=%s pattern=%q matched=0", jc.Name, jc.BranchPattern)
+	}
+
+	scope, err := core.RepoSettingValue(dbRepo, cfg.Repo, core.SettingDirectiveScope)
+	if err != nil {
+		logPollEvent(logf, "scan failed reading repo settings: %v", err)
+		return err
+	}
+	directives := core.NewDirectiveReader(cfg.Repo, scope)
+
+	scans := map[string]*jobScan{}
+	for _, jc := range set.head {
+		scans[jc.Name] = &jobScan{pattern: jc.BranchPattern}
+	}
+
+	var confErr error
+	for _, branch := range sortedBranchNames(targets) {
+		sha := targets[branch]
+		confs, ref, err := set.forTarget(ctx, branch, sha)
+		if errors.Is(err, core.ErrNoJobConf) {
+			logPollEvent(logf, "scan branch=%s config=%s missing", branch, ref)
 			continue
 		}
-
-		branches := sortedBranchNames(branchSHA)
-		results := make([]string, 0, len(branches))
-		queuedCount := 0
-		for _, branch := range branches {
-			sha := branchSHA[branch]
-			latestJob, err := dbRepo.LatestJobByNameBranch(cfg.Repo, jc.Name, branch)
-			if err != nil {
-				logPollEvent(logf, "scan job=%s branch=%s failed reading latest job: %v", jc.Name, branch, err)
-				return err
+		if err != nil {
+			logPollEvent(logf, "scan branch=%s config=%s invalid: %v", branch, ref, err)
+			if confErr == nil {
+				confErr = fmt.Errorf("branch %s: %w", branch, describeConfigError(err, nil))
 			}
-			prevSHA := latestJob.SHA
-			if prevSHA == sha {
-				results = append(results, fmt.Sprintf("%s@%s=no-change", branch, shortSHA(sha)))
+			continue
+		}
+
+		for _, jc := range confs {
+			if !jobAppliesTo(jc, branch) {
 				continue
 			}
-
-			shouldRun, err := core.ShouldRunByPathPatterns(ctx, cfg.Repo, prevSHA, sha, jc.PathPatterns)
+			result, queued, err := pollTarget(ctx, dbRepo, runner, cfg, directives, jc, branch, sha, logf)
 			if err != nil {
-				logPollEvent(logf, "scan job=%s branch=%s failed checking paths: %v", jc.Name, branch, err)
 				return err
 			}
-			if !shouldRun {
-				results = append(results, fmt.Sprintf("%s@%s=path-skip(prev=%s)", branch, shortSHA(sha), shortSHA(prevSHA)))
-				continue
+			scan := scans[jc.Name]
+			if scan == nil {
+				scan = &jobScan{pattern: jc.BranchPattern}
+				scans[jc.Name] = scan
 			}
-
-			jobConf := jc
-			jobConf.Repo = cfg.Repo
-			if err := runner.QueueJob(jobConf, cfg.Env, branch, sha); err != nil {
-				logPollEvent(logf, "queue job=%s branch=%s sha=%s failed: %v", jc.Name, branch, shortSHA(sha), err)
-				return err
+			if ref != "HEAD" {
+				result += " config=" + ref
 			}
-			queuedCount++
-			if prevSHA == "" {
-				results = append(results, fmt.Sprintf("%s@%s=queued(first-run)", branch, shortSHA(sha)))
-				continue
+			scan.results = append(scan.results, result)
+			if queued {
+				scan.queued++
 			}
-			results = append(results, fmt.Sprintf("%s@%s=queued(prev=%s)", branch, shortSHA(sha), shortSHA(prevSHA)))
+		}
+	}
+
+	names := make([]string, 0, len(scans))
+	for name := range scans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		scan := scans[name]
+		if len(scan.results) == 0 {
+			logPollEvent(logf, "scan job=%s pattern=%q matched=0", name, scan.pattern)
+			continue
 		}
 		logPollEvent(
 			logf,
 			"scan job=%s pattern=%q matched=%d queued=%d results=%s",
-			jc.Name,
-			jc.BranchPattern,
-			len(branches),
-			queuedCount,
-			strings.Join(results, ", "),
+			name,
+			scan.pattern,
+			len(scan.results),
+			scan.queued,
+			strings.Join(scan.results, ", "),
 		)
 	}
+
+	if err := cancelClosedPullRequestJobs(dbRepo, runner, cfg.Repo, pulls, logf); err != nil {
+		return err
+	}
+	return confErr
+}
+
+// jobAppliesTo reports whether jc runs on branch: pull request branches need
+// pull_requests, other branches a matching branch_pattern.
+func jobAppliesTo(jc core.JobConf, branch string) bool {
+	if _, isPull := core.ParsePullRequestBranch(branch); isPull {
+		return jc.PullRequests
+	}
+	return jc.ScansBranches() && branchMatchesForRerun(branch, jc.BranchPattern)
+}
+
+// pollTarget decides whether job jc should run for branch at sha, and queues
+// or records a skip accordingly. It returns a short result for the scan log.
+func pollTarget(ctx context.Context, dbRepo core.DbRepo, runner *core.JobRunner, cfg runtimeConfig, directives *core.DirectiveReader, jc core.JobConf, branch, sha string, logf func(string, ...any)) (string, bool, error) {
+	latestJob, err := dbRepo.LatestJobByNameBranch(cfg.Repo, jc.Name, branch)
+	if err != nil {
+		logPollEvent(logf, "scan job=%s branch=%s failed reading latest job: %v", jc.Name, branch, err)
+		return "", false, err
+	}
+	prevSHA := latestJob.SHA
+	prevLabel := shortSHA(prevSHA)
+	if prevLabel == "" {
+		prevLabel = "-"
+	}
+	if prevSHA == sha {
+		return fmt.Sprintf("%s@%s=no-change", branch, shortSHA(sha)), false, nil
+	}
+
+	decision, decided, err := directiveDecision(ctx, directives, jc, branch, prevSHA, sha, logf)
+	if err != nil {
+		return "", false, err
+	}
+	if decided {
+		return queueOrSkip(runner, cfg, jc, branch, sha, prevLabel, decision, logf)
+	}
+
+	decision, err = core.EvaluatePathFilters(ctx, cfg.Repo, prevSHA, sha, jc.PathPatterns, jc.PathsIgnore)
+	if err != nil {
+		logPollEvent(logf, "scan job=%s branch=%s failed checking paths: %v", jc.Name, branch, err)
+		return "", false, err
+	}
+
+	if decision.Run && jc.If != "" {
+		ok, err := evaluateJobIf(ctx, cfg, jc, branch, prevSHA, sha, logf)
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			decision.Reason += "; if: true"
+		} else {
+			decision = core.PathDecision{Reason: fmt.Sprintf("if: %s is false", jc.If)}
+		}
+	}
+	return queueOrSkip(runner, cfg, jc, branch, sha, prevLabel, decision, logf)
+}
+
+// directiveDecision applies the commit directives of the push to jc. ok is
+// false when no directive concerns the job and the usual checks apply.
+func directiveDecision(ctx context.Context, directives *core.DirectiveReader, jc core.JobConf, branch, prevSHA, sha string, logf func(string, ...any)) (core.PathDecision, bool, error) {
+	d, err := directives.For(ctx, prevSHA, sha)
+	if err != nil {
+		logPollEvent(logf, "scan job=%s branch=%s failed reading commit directives: %v", jc.Name, branch, err)
+		return core.PathDecision{}, false, err
+	}
+	action, reason := d.Decide(jc.Name)
+	switch action {
+	case core.DirectiveSkip:
+		logPollEvent(logf, "directive job=%s branch=%s sha=%s action=skip reason=%q", jc.Name, branch, shortSHA(sha), reason)
+		return core.PathDecision{Reason: reason}, true, nil
+	case core.DirectiveRun:
+		logPollEvent(logf, "directive job=%s branch=%s sha=%s action=run reason=%q", jc.Name, branch, shortSHA(sha), reason)
+		return core.PathDecision{Run: true, Reason: reason}, true, nil
+	}
+	return core.PathDecision{}, false, nil
+}
+
+// queueOrSkip queues jc for branch at sha or records a skip row, according to
+// decision.
+func queueOrSkip(runner *core.JobRunner, cfg runtimeConfig, jc core.JobConf, branch, sha, prevLabel string, decision core.PathDecision, logf func(string, ...any)) (string, bool, error) {
+	jobConf := jc
+	jobConf.Repo = cfg.Repo
+	if !decision.Run {
+		if err := runner.RecordSkip(jobConf, branch, sha, decision.Reason); err != nil {
+			logPollEvent(logf, "record skip job=%s branch=%s sha=%s failed: %v", jc.Name, branch, shortSHA(sha), err)
+			return "", false, err
+		}
+		return fmt.Sprintf("%s@%s=skipped(prev=%s, %s)", branch, shortSHA(sha), prevLabel, decision.Reason), false, nil
+	}
+
+	if err := runner.QueueJob(jobConf, cfg.Env, branch, sha, decision.Reason); err != nil {
+		logPollEvent(logf, "queue job=%s branch=%s sha=%s failed: %v", jc.Name, branch, shortSHA(sha), err)
+		return "", false, err
+	}
+	return fmt.Sprintf("%s@%s=queued(prev=%s, %s)", branch, shortSHA(sha), prevLabel, decision.Reason), true, nil
+}
+
+// evaluateJobIf evaluates jc's if: expression for branch at sha and logs the
+// result.
+func evaluateJobIf(ctx context.Context, cfg runtimeConfig, jc core.JobConf, branch, prevSHA, sha string, logf func(string, ...any)) (bool, error) {
+	expr, err := core.ParseExpr(jc.If)
+	if err != nil {
+		// Validation already parsed it; this only guards hand-built configs.
+		logPollEvent(logf, "if job=%s branch=%s sha=%s expr=%q invalid: %v", jc.Name, branch, shortSHA(sha), jc.If, err)
+		return false, fmt.Errorf("job %s: if: %w", jc.Name, err)
+	}
+	env, err := core.NewExprEnv(ctx, cfg.Repo, branch, prevSHA, sha, cfg.Env)
+	if err != nil {
+		logPollEvent(logf, "if job=%s branch=%s sha=%s failed: %v", jc.Name, branch, shortSHA(sha), err)
+		return false, err
+	}
+	result := expr.Eval(env)
+	logPollEvent(logf, "if job=%s branch=%s sha=%s expr=%q result=%t", jc.Name, branch, shortSHA(sha), jc.If, result)
+	return result, nil
+}
+
+// cancelClosedPullRequestJobs cancels running/pending pull request jobs whose
+// merge ref is gone, which is how GitHub signals a closed or merged PR.
+func cancelClosedPullRequestJobs(dbRepo core.DbRepo, runner *core.JobRunner, repo string, pulls []core.PullRequest, logf func(string, ...any)) error {
+	open := make(map[int]bool, len(pulls))
+	for _, pr := range pulls {
+		if pr.MergeSHA != "" {
+			open[pr.Number] = true
+		}
+	}
+
+	const reason = "pull request closed"
+	for _, status := range []string{core.StatusRunning, core.StatusPending} {
+		jobs, err := dbRepo.ListJob(core.JobFilter{Repo: repo, Status: status})
+		if err != nil {
+			return err
+		}
+		for _, job := range jobs {
+			if job.PRNumber == 0 || open[job.PRNumber] {
+				continue
+			}
+			logPollEvent(logf, "cancel closed pull request job=%s pr=%d sha=%s", job.Name, job.PRNumber, shortSHA(job.SHA))
+			if err := runner.CancelWithReason(job, reason); err == nil {
+				continue
+			} else if !strings.Contains(err.Error(), "job is not running") {
+				return err
+			}
+			if err := dbRepo.UpdateJob(job.RunID, core.StatusCanceled, reason, ""); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -777,7 +1726,7 @@ func logPollEvent(logf func(string, ...any), format string, args ...any) {
 	logf(format, args...)
 }
 
-func rerunJob(ctx context.Context, dbRepo core.DbRepo, runner *core.JobRunner, cfg runtimeConfig, req tui.RerunRequest) error {
+func rerunJob(ctx context.Context, dbRepo core.DbRepo, runner *core.JobRunner, cfg runtimeConfig, confLoader *core.JobConfLoader, req tui.RerunRequest) error {
 	if strings.TrimSpace(req.RunID) == "" || strings.TrimSpace(req.Name) == "" || strings.TrimSpace(req.Branch) == "" || strings.TrimSpace(req.SHA) == "" {
 		return errors.New("invalid restart request")
 	}
@@ -787,22 +1736,32 @@ func rerunJob(ctx context.Context, dbRepo core.DbRepo, runner *core.JobRunner, c
 		return err
 	}
 	status := strings.ToLower(strings.TrimSpace(jobRow.Status))
-	if status != core.StatusFailed && status != core.StatusCanceled {
-		return fmt.Errorf("job status is %q; only failed/canceled jobs can be restarted", jobRow.Status)
+	if status != core.StatusFailed && status != core.StatusCanceled && status != core.StatusSkipped {
+		return fmt.Errorf("job status is %q; only failed/canceled/skipped jobs can be restarted", jobRow.Status)
+	}
+
+	// Any active run counts, not only the latest row, which may be a skip.
+	for _, status := range []string{core.StatusRunning, core.StatusPending} {
+		active, err := dbRepo.ListJob(core.JobFilter{Repo: cfg.Repo, Name: req.Name, Branch: req.Branch, Status: status, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(active) > 0 {
+			return fmt.Errorf("job %s/%s has a %s run at %s; cancel it before restarting an older run", req.Name, req.Branch, status, shortSHA(active[0].SHA))
+		}
 	}
 
-	latestJob, err := dbRepo.LatestJobByNameBranch(cfg.Repo, req.Name, req.Branch)
+	source, err := core.LoadConfigSource(dbRepo, cfg.Repo)
 	if err != nil {
-		return err
+		return fmt.Errorf("read repo settings: %w", err)
 	}
-	latestStatus := strings.ToLower(strings.TrimSpace(latestJob.Status))
-	if latestJob.RunID != "" && latestJob.RunID != jobRow.RunID && (latestStatus == core.StatusRunning || latestStatus == core.StatusPending) {
-		return fmt.Errorf("latest job %s/%s is %q; cancel it before restarting an older run", req.Name, req.Branch, latestJob.Status)
+	ref := "HEAD"
+	if source.UsesBranchConfig(req.Branch) {
+		ref = jobRow.SHA
 	}
-
-	jobConfs, err := core.LoadJobConfsFromRepo(ctx, cfg.Repo, "HEAD")
+	jobConfs, err := confLoader.Load(ctx, ref)
 	if err != nil {
-		return fmt.Errorf("load .refci/conf.yml: %w", err)
+		return describeConfigError(err, nil)
 	}
 	jobConf, err := findRerunJobConf(jobConfs, req.Name, req.Branch)
 	if err != nil {
@@ -904,7 +1863,7 @@ func findRerunJobConf(jobConfs []core.JobConf, name, branch string) (core.JobCon
 			continue
 		}
 		sameName = append(sameName, jc)
-		if branchMatchesForRerun(branchValue, jc.BranchPattern) {
+		if jobAppliesTo(jc, branchValue) {
 			matched = append(matched, jc)
 		}
 	}
@@ -972,16 +1931,27 @@ func printMainUsage(w io.Writer) {
 	fmt.Fprintln(w, "Usage:")
 	fmt.Fprintln(w, "  refci")
 	fmt.Fprintln(w, "  refci init [path]")
-	fmt.Fprintln(w, "  refci clone -i <ssh-private-key> <git-repo-url>")
-	fmt.Fprintln(w, "  refci -e <env_file> [-interval 3s] <repo-target>")
+	fmt.Fprintln(w, "  refci clone -i <ssh-private-key> <ssh-repo-url>")
+	fmt.Fprintln(w, "  refci clone [--token-file <path>] <https-repo-url>")
+	fmt.Fprintln(w, "  refci clone file:///path/to/repo.git")
+	fmt.Fprintln(w, "  refci lint [path]")
+	fmt.Fprintln(w, "  refci config [--unset] <repo-target> [key [value]]")
+	fmt.Fprintln(w, "  refci cache ls [repo-target] | rm <repo-target> [key...]")
+	fmt.Fprintln(w, "  refci secret set|get|rm|ls|import [--job <name>] (--global | <repo-target>) [KEY [value] | env-file]")
+	fmt.Fprintln(w, "  refci log [--stream <names>] [--timestamps] <run-id | log-file>")
+	fmt.Fprintln(w, "  refci gc [--dry-run] [repo-target]")
+	fmt.Fprintln(w, "  refci grep [--repo <repo-target>] [--job <name>] [--since <7d | date>] <pattern>")
+	fmt.Fprintln(w, "  refci -e <env_file> [-secrets <file>] [-interval 3s] <repo-target>")
 	fmt.Fprintln(w, "  refci --monitor [repo-target]")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "Repo target:")
-	fmt.Fprintln(w, "  owner/repo | owner--repo | repos/owner--repo | /abs/path/to/repos/owner--repo")
+	fmt.Fprintln(w, "  owner/repo | host/owner/repo | owner--repo | repos/owner--repo | /abs/path/to/repos/owner--repo")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "Examples:")
 	fmt.Fprintln(w, "  refci init .")
 	fmt.Fprintln(w, "  refci clone -i ~/.ssh/refci-owner-repo git@github.com:owner/repo.git")
+	fmt.Fprintln(w, "  refci clone -i ~/.ssh/refci-app ssh://git@git.example.com:2222/team/app.git")
+	fmt.Fprintln(w, "  refci clone --token-env GITLAB_TOKEN https://gitlab.com/group/app.git")
 	fmt.Fprintln(w, "  refci")
 	fmt.Fprintln(w, "  refci -e .env owner/repo")
 	fmt.Fprintln(w, "  refci --monitor owner/repo")
@@ -990,6 +1960,13 @@ func printMainUsage(w io.Writer) {
 	fmt.Fprintln(w, "  refci --help")
 	fmt.Fprintln(w, "  refci init --help")
 	fmt.Fprintln(w, "  refci clone --help")
+	fmt.Fprintln(w, "  refci lint --help")
+	fmt.Fprintln(w, "  refci config --help")
+	fmt.Fprintln(w, "  refci cache --help")
+	fmt.Fprintln(w, "  refci secret --help")
+	fmt.Fprintln(w, "  refci log --help")
+	fmt.Fprintln(w, "  refci gc --help")
+	fmt.Fprintln(w, "  refci grep --help")
 }
 
 func printInitUsage(w io.Writer) {
@@ -997,27 +1974,112 @@ func printInitUsage(w io.Writer) {
 	fmt.Fprintln(w, "Create a refci root at path (default: current directory).")
 }
 
+func printLintUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: refci lint [path]")
+	fmt.Fprintln(w, "Validate a job config. path is a conf.yml file or a repo checkout")
+	fmt.Fprintln(w, "(default: .refci/conf.yml). Problems are printed as file:line:column.")
+}
+
+func printConfigUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: refci config <repo-target>                list settings")
+	fmt.Fprintln(w, "       refci config <repo-target> <key>          print one setting")
+	fmt.Fprintln(w, "       refci config <repo-target> <key> <value>  set a setting")
+	fmt.Fprintln(w, "       refci config --unset <repo-target> <key>  restore the default")
+	fmt.Fprintln(w, "       refci config --global [--unset] [key [value]]")
+	fmt.Fprintln(w, "                                         the same for root-level settings")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Settings:")
+	for _, spec := range core.RepoSettingSpecs() {
+		fmt.Fprintf(w, "  %s (default %q)\n", spec.Key, spec.Default)
+		fmt.Fprintf(w, "      %s\n", spec.Help)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Global settings:")
+	for _, spec := range core.GlobalSettingSpecs() {
+		fmt.Fprintf(w, "  %s (default %q)\n", spec.Key, spec.Default)
+		fmt.Fprintf(w, "      %s\n", spec.Help)
+	}
+}
+
+func printCacheUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: refci cache ls [repo-target]            list job caches, most recently used first")
+	fmt.Fprintln(w, "       refci cache rm <repo-target> [key...]   remove the named caches on every branch, or all of the repo's")
+	fmt.Fprintln(w, "Caches live under <root>/cache/<repo>/<branch>; a job restores its branch's entry, else the")
+	fmt.Fprintln(w, "default branch's, and saves only to its own branch. Each repo keeps at most cache_max_size (see refci config).")
+}
+
+func printSecretUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: refci secret set [--job <name>] (--global | <repo-target>) KEY [value]")
+	fmt.Fprintln(w, "       refci secret get [--job <name>] (--global | <repo-target>) KEY")
+	fmt.Fprintln(w, "       refci secret rm [--job <name>] (--global | <repo-target>) KEY")
+	fmt.Fprintln(w, "       refci secret ls [--job <name>] (--global | <repo-target>)")
+	fmt.Fprintln(w, "       refci secret import [--job <name>] (--global | <repo-target>) <env-file>")
+	fmt.Fprintln(w, "Secrets are encrypted (AES-256-GCM) in refci.db and added to the env of matching jobs;")
+	fmt.Fprintln(w, "job secrets override repo secrets, which override global ones. All are masked in job logs.")
+	fmt.Fprintln(w, "set reads the value from stdin when it is not given.")
+	fmt.Fprintf(w, "The key is <root>/credentials/secret.key, created by the first set, or derived from $%s.\n", core.SecretPassphraseEnv)
+}
+
+func printLogUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: refci log [--stream <names>] [--timestamps] <run-id | log-file>")
+	fmt.Fprintln(w, "Print a job log as plain text.")
+	fmt.Fprintln(w, "  --stream      comma-separated streams to keep: refci (step headers and notes), stdout, stderr")
+	fmt.Fprintln(w, "  --timestamps  prefix each line with the time it was written")
+}
+
+func printGrepUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: refci grep [--repo <repo-target>] [--job <name>] [--since <7d | date>] [--limit 100] <pattern>")
+	fmt.Fprintln(w, "Search the logs of past runs for a substring, oldest run first, so the first line printed")
+	fmt.Fprintln(w, "is where the text first appeared. The search ignores case unless the pattern has upper-case")
+	fmt.Fprintln(w, "letters. Each line reads: started  repo  job  branch  sha  run-id:line: text")
+	fmt.Fprintln(w, "(see refci log <run-id>). Logs are indexed as runs finish; older runs are indexed on first use.")
+}
+
+func printGCUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: refci gc [--dry-run] [repo-target]")
+	fmt.Fprintln(w, "Remove job logs and run dirs past the retention settings (log_retention_days,")
+	fmt.Fprintln(w, "log_retention_runs, prune_job_rows; see refci config --global) and rotate ci.log files")
+	fmt.Fprintln(w, "larger than ci_log_max_size. Without a repo target every repo is cleaned.")
+	fmt.Fprintln(w, "The latest run of each job and branch and running jobs are always kept.")
+	fmt.Fprintln(w, "A running poller does the same for its repo every hour.")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Retention is off by default: logs are kept until it is enabled, e.g.")
+	fmt.Fprintln(w, "  refci config --global log_retention_days 30")
+	fmt.Fprintln(w, "  refci config --global log_retention_runs 50")
+	fmt.Fprintln(w, "Run refci gc --dry-run first to see what would be removed.")
+}
+
 func printCloneUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: refci clone -i <ssh-private-key> <git-repo-url>")
-	fmt.Fprintln(w, "Clone a mirror repo into <root>/repos and configure a per-repo SSH host alias.")
+	fmt.Fprintln(w, "Usage: refci clone -i <ssh-private-key> <ssh-repo-url>")
+	fmt.Fprintln(w, "       refci clone [--token-file <path> | --token-env <name>] <https-repo-url>")
+	fmt.Fprintln(w, "       refci clone file:///path/to/repo.git")
+	fmt.Fprintln(w, "Clone a mirror repo into <root>/repos.")
+	fmt.Fprintln(w, "SSH remotes get a per-repo SSH host alias; HTTPS tokens are stored under <root>/credentials")
+	fmt.Fprintln(w, "and served to git through a credential helper.")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "Flags:")
 	fmt.Fprintln(w, "  -i, --identity, --ssh-key string")
-	fmt.Fprintln(w, "      ssh private key path for this repo's GitHub deploy key")
+	fmt.Fprintln(w, "      ssh private key path for this repo's deploy key (ssh remotes)")
+	fmt.Fprintln(w, "  --token-file string")
+	fmt.Fprintln(w, "      file holding an access token (https remotes)")
+	fmt.Fprintln(w, "  --token-env string")
+	fmt.Fprintln(w, "      env var holding an access token (https remotes)")
 }
 
 func printPollUsage(w io.Writer) {
-	fmt.Fprintln(w, "Usage: refci -e <env_file> [-interval 3s] <repo-target>")
+	fmt.Fprintln(w, "Usage: refci -e <env_file> [-secrets <file>] [-interval 3s] <repo-target>")
 	fmt.Fprintln(w, "       refci --monitor [repo-target]")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "Flags:")
 	fmt.Fprintln(w, "  -e string")
-	fmt.Fprintln(w, "      env file path (default \".env\")")
+	fmt.Fprintln(w, "      env file path (default \".env\"); prefix a line with \"secret\" to mask its value in job logs")
+	fmt.Fprintln(w, "  -secrets string")
+	fmt.Fprintln(w, "      secrets file in the env file format; every value is passed to jobs and masked in their logs")
 	fmt.Fprintln(w, "  -interval duration")
 	fmt.Fprintln(w, "      poll interval (default 3s)")
 	fmt.Fprintln(w, "  --monitor")
 	fmt.Fprintln(w, "      monitor mode (no automatic fetch/poll; manual restart/cancel only; no env file required)")
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "Repo target:")
-	fmt.Fprintln(w, "  owner/repo | owner--repo | repos/owner--repo | /abs/path/to/repos/owner--repo")
+	fmt.Fprintln(w, "  owner/repo | host/owner/repo | owner--repo | repos/owner--repo | /abs/path/to/repos/owner--repo")
 }
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"dexianta/refci/core"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
 func TestUpsertRefciSSHHostBlockAddsManagedHost(t *testing.T) {
-	got, err := upsertRefciSSHHostBlock("", "refci-owner--repo", "/tmp/refci-owner-repo")
+	got, err := upsertRefciSSHHostBlock("", testSSHHostEntry("/tmp/refci-owner-repo"))
 	if err != nil {
 		t.Fatalf("upsert returned error: %v", err)
 	}
@@ -28,9 +31,9 @@ func TestUpsertRefciSSHHostBlockAddsManagedHost(t *testing.T) {
 }
 
 func TestUpsertRefciSSHHostBlockReplacesManagedHost(t *testing.T) {
-	config := refciSSHHostBlock("refci-owner--repo", "/tmp/old-key")
+	config := refciSSHHostBlock(testSSHHostEntry("/tmp/old-key"))
 
-	got, err := upsertRefciSSHHostBlock(config, "refci-owner--repo", "/tmp/new-key")
+	got, err := upsertRefciSSHHostBlock(config, testSSHHostEntry("/tmp/new-key"))
 	if err != nil {
 		t.Fatalf("upsert returned error: %v", err)
 	}
@@ -45,8 +48,152 @@ func TestUpsertRefciSSHHostBlockReplacesManagedHost(t *testing.T) {
 func TestUpsertRefciSSHHostBlockRejectsUnmanagedHost(t *testing.T) {
 	config := "Host refci-owner--repo\n  HostName github.com\n"
 
-	_, err := upsertRefciSSHHostBlock(config, "refci-owner--repo", "/tmp/refci-owner-repo")
+	_, err := upsertRefciSSHHostBlock(config, testSSHHostEntry("/tmp/refci-owner-repo"))
 	if err == nil {
 		t.Fatal("expected unmanaged host conflict")
 	}
 }
+
+func TestRefciSSHHostBlockCustomHostAndPort(t *testing.T) {
+	got := refciSSHHostBlock(sshHostEntry{
+		Alias:        "refci-git.example.com--team--app",
+		HostName:     "git.example.com",
+		Port:         "2222",
+		User:         "gitea",
+		IdentityFile: "/tmp/refci-app",
+	})
+
+	for _, part := range []string{
+		"Host refci-git.example.com--team--app",
+		"HostName git.example.com",
+		"Port 2222",
+		"User gitea",
+	} {
+		if !strings.Contains(got, part) {
+			t.Fatalf("block missing %q:\n%s", part, got)
+		}
+	}
+}
+
+func TestResolveRepoTarget(t *testing.T) {
+	oldRoot := core.Root
+	core.Root = "/srv/refci"
+	defer func() {
+		core.Root = oldRoot
+	}()
+
+	tests := []struct {
+		target string
+		repo   string
+		path   string
+	}{
+		{"owner/repo", "owner/repo", "/srv/refci/repos/owner--repo"},
+		{"owner--repo", "owner/repo", "/srv/refci/repos/owner--repo"},
+		{"gitlab.com/group/app", "gitlab.com/group/app", "/srv/refci/repos/gitlab.com--group--app"},
+		{"repos/gitlab.com--group--app", "gitlab.com/group/app", "/srv/refci/repos/gitlab.com--group--app"},
+		{"./repos/owner--repo", "owner/repo", "/srv/refci/repos/owner--repo"},
+		{"/abs/repos/local--app", "local/app", "/abs/repos/local--app"},
+	}
+	for _, tt := range tests {
+		repo, path, err := resolveRepoTarget(tt.target)
+		if err != nil {
+			t.Fatalf("resolveRepoTarget(%q) error = %v", tt.target, err)
+		}
+		if repo != tt.repo || path != tt.path {
+			t.Fatalf("resolveRepoTarget(%q) = (%q, %q), want (%q, %q)", tt.target, repo, path, tt.repo, tt.path)
+		}
+	}
+}
+
+func testSSHHostEntry(identityPath string) sshHostEntry {
+	return sshHostEntry{
+		Alias:        "refci-owner--repo",
+		HostName:     "github.com",
+		User:         "git",
+		IdentityFile: identityPath,
+	}
+}
+
+func TestFindRerunJobConfPullRequestBranch(t *testing.T) {
+	confs := []core.JobConf{
+		{Name: "test", BranchPattern: "main"},
+		{Name: "test", PullRequests: true},
+	}
+
+	got, err := findRerunJobConf(confs, "test", "pr:12")
+	if err != nil {
+		t.Fatalf("findRerunJobConf(pr:12) error = %v", err)
+	}
+	if !got.PullRequests {
+		t.Fatalf("findRerunJobConf(pr:12) = %+v, want pull request conf", got)
+	}
+
+	got, err = findRerunJobConf(confs, "test", "main")
+	if err != nil {
+		t.Fatalf("findRerunJobConf(main) error = %v", err)
+	}
+	if got.PullRequests {
+		t.Fatalf("findRerunJobConf(main) = %+v, want branch conf", got)
+	}
+}
+
+func TestParseRuntimeConfigSecrets(t *testing.T) {
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, ".env")
+	secretsPath := filepath.Join(dir, "secrets.env")
+	if err := os.WriteFile(envPath, []byte("# env\nREGION=us-east-1\nsecret API_TOKEN=\"abc123xyz\"\nsecret export DB_PASS='p4ssw0rd'\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.WriteFile(secretsPath, []byte("DEPLOY_KEY=deploy-key-value\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	cfg, err := parseRuntimeConfig("acme/app", envPath, secretsPath)
+	if err != nil {
+		t.Fatalf("parseRuntimeConfig() error = %v", err)
+	}
+	wantEnv := []string{"REGION=us-east-1", "API_TOKEN=abc123xyz", "DB_PASS=p4ssw0rd", "DEPLOY_KEY=deploy-key-value"}
+	if strings.Join(cfg.Env, ",") != strings.Join(wantEnv, ",") {
+		t.Fatalf("Env = %v, want %v", cfg.Env, wantEnv)
+	}
+	wantSecrets := []string{"abc123xyz", "p4ssw0rd", "deploy-key-value"}
+	if strings.Join(cfg.Secrets, ",") != strings.Join(wantSecrets, ",") {
+		t.Fatalf("Secrets = %v, want %v", cfg.Secrets, wantSecrets)
+	}
+}
+
+func TestRuntimeConfigLoaderReloadsChangedEnvFile(t *testing.T) {
+	envPath := filepath.Join(t.TempDir(), ".env")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(envPath, []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+	write("A=1\n")
+	loader := &runtimeConfigLoader{repo: "acme/app", envPath: envPath}
+	cfg, err := loader.load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if strings.Join(cfg.Env, ",") != "A=1" {
+		t.Fatalf("Env = %v, want [A=1]", cfg.Env)
+	}
+	if _, changed, err := loader.reload(); err != nil || changed {
+		t.Fatalf("reload() unchanged = %v, %v; want false, nil", changed, err)
+	}
+
+	write("A=22\nsecret B=token\n")
+	cfg, changed, err := loader.reload()
+	if err != nil || !changed {
+		t.Fatalf("reload() = %v, %v; want changed", changed, err)
+	}
+	if strings.Join(cfg.Env, ",") != "A=22,B=token" || strings.Join(cfg.Secrets, ",") != "token" {
+		t.Fatalf("cfg = %+v, want the new values", cfg)
+	}
+
+	write("A=1\nBROKEN\n")
+	if _, changed, err := loader.reload(); err == nil || changed || !strings.Contains(err.Error(), ".env:2:") {
+		t.Fatalf("reload() broken file = %v, %v; want a line-numbered error", changed, err)
+	}
+}
@@ -0,0 +1,614 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CacheConf is a job's cache: section. Paths are repo-relative directories or
+// files restored into the worktree before the first step and saved after a
+// successful run under the rendered Key.
+type CacheConf struct {
+	Key   string   `yaml:"key"`
+	Paths []string `yaml:"paths"`
+}
+
+// CacheKeyData is what a cache key template sees besides hashFiles.
+type CacheKeyData struct {
+	Job    string
+	Branch string
+	OS     string
+	Arch   string
+}
+
+// CacheEntry describes one saved cache under Root/cache/<repo>/<branch>/<key>.
+type CacheEntry struct {
+	Repo     string    `json:"repo"`
+	Branch   string    `json:"branch"`
+	Key      string    `json:"key"`
+	Paths    []string  `json:"paths"`
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// DefaultCacheMaxSize is the default per-repo cache budget.
+const DefaultCacheMaxSize = "5GiB"
+
+const (
+	cacheMetaFile = "entry.json"
+	cacheDataDir  = "data"
+	cacheTmpDir   = ".tmp"
+	maxCacheKey   = 200
+)
+
+// cacheMu serializes restores, renames and evictions so an entry is never
+// removed while it is being copied into a worktree.
+var cacheMu sync.Mutex
+
+func cacheRepoDir(repo string) string {
+	return filepath.Join(Root, "cache", ToLocalRepo(repo))
+}
+
+// cacheEntryDir is where key is saved for branch. Branch names are escaped so
+// feature/x and feature-x never share a dir.
+func cacheEntryDir(repo, branch, key string) string {
+	return filepath.Join(cacheRepoDir(repo), url.PathEscape(branch), key)
+}
+
+func cacheTemplate(src string) (*template.Template, error) {
+	return template.New("cache key").Option("missingkey=error").Funcs(template.FuncMap{
+		// Parsing only needs the name; RenderCacheKey binds the worktree.
+		"hashFiles": func(patterns ...string) (string, error) { return "", nil },
+	}).Parse(src)
+}
+
+// RenderCacheKey expands the key template in workDir. hashFiles returns the
+// sha256 of every file matching the path_patterns-style globs (empty when
+// nothing matches). Characters outside [A-Za-z0-9._-] become "-".
+func RenderCacheKey(src, workDir string, data CacheKeyData) (string, error) {
+	tmpl, err := cacheTemplate(src)
+	if err != nil {
+		return "", err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"hashFiles": func(patterns ...string) (string, error) { return hashFiles(workDir, patterns) },
+	})
+	if data.OS == "" {
+		data.OS = runtime.GOOS
+	}
+	if data.Arch == "" {
+		data.Arch = runtime.GOARCH
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	key := sanitizeCacheKey(b.String())
+	if key == "" {
+		return "", fmt.Errorf("cache key %q renders empty", src)
+	}
+	return key, nil
+}
+
+func sanitizeCacheKey(v string) string {
+	v = strings.TrimSpace(v)
+	out := make([]byte, 0, len(v))
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if isIdentByte(c, false) || c == '.' || c == '-' {
+			out = append(out, c)
+		} else {
+			out = append(out, '-')
+		}
+	}
+	key := strings.TrimLeft(string(out), ".")
+	if len(key) > maxCacheKey {
+		key = key[:maxCacheKey]
+	}
+	return key
+}
+
+func hashFiles(workDir string, patterns []string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(workDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(workDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if pathSelected(rel, patterns, nil) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashFiles: %w", err)
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, rel := range files {
+		f, err := os.Open(filepath.Join(workDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return "", fmt.Errorf("hashFiles: %w", err)
+		}
+		fmt.Fprintf(h, "%s\x00", rel)
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			return "", fmt.Errorf("hashFiles: %w", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// validateCacheConf checks a cache: section without a worktree.
+func validateCacheConf(c CacheConf) (field string, err error) {
+	if strings.TrimSpace(c.Key) == "" {
+		return "key", errors.New("cache key is required")
+	}
+	if _, err := cacheTemplate(c.Key); err != nil {
+		return "key", fmt.Errorf("bad key template: %v", err)
+	}
+	if len(c.Paths) == 0 {
+		return "paths", errors.New("at least one cache path is required")
+	}
+	for i, p := range c.Paths {
+		if _, err := cleanCachePath(p); err != nil {
+			return fmt.Sprintf("paths[%d]", i), err
+		}
+	}
+	return "", nil
+}
+
+func cleanCachePath(p string) (string, error) {
+	v := strings.TrimSpace(p)
+	if v == "" || path.IsAbs(v) || filepath.IsAbs(v) {
+		return "", fmt.Errorf("cache path %q must be relative to the repo", p)
+	}
+	v = path.Clean(filepath.ToSlash(v))
+	if v == "." || v == ".git" || v == ".." || strings.HasPrefix(v, "../") || strings.HasPrefix(v, ".git/") {
+		return "", fmt.Errorf("cache path %q must stay inside the repo and outside .git", p)
+	}
+	return v, nil
+}
+
+// cacheWorkPath joins the clean cache path rel to workDir. It fails when a
+// parent dir of rel is a symlink, so a job cannot point a cache path at files
+// outside its worktree; rel itself may be a symlink and is copied as one.
+func cacheWorkPath(workDir, rel string) (string, error) {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		parent := strings.Join(parts[:i], "/")
+		info, err := os.Lstat(filepath.Join(workDir, filepath.FromSlash(parent)))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("cache path %q goes through symlink %s", rel, parent)
+		}
+	}
+	return filepath.Join(workDir, filepath.FromSlash(rel)), nil
+}
+
+// RestoreCache copies the entry saved under key into workDir, looking in the
+// scope of each branch in order. It returns the branch whose entry was
+// restored, or false on a miss.
+func RestoreCache(repo string, branches []string, key string, paths []string, workDir string) (string, bool, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	for _, branch := range branches {
+		if branch == "" {
+			continue
+		}
+		dir := cacheEntryDir(repo, branch, key)
+		entry, err := readCacheEntry(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+		if err := restoreCacheEntry(dir, paths, workDir); err != nil {
+			return "", false, err
+		}
+		entry.LastUsed = time.Now().UTC()
+		if err := writeCacheEntry(dir, entry); err != nil {
+			return "", false, err
+		}
+		return branch, true, nil
+	}
+	return "", false, nil
+}
+
+func restoreCacheEntry(dir string, paths []string, workDir string) error {
+	for _, p := range paths {
+		rel, err := cleanCachePath(p)
+		if err != nil {
+			return err
+		}
+		src := filepath.Join(dir, cacheDataDir, filepath.FromSlash(rel))
+		if _, err := os.Lstat(src); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		dst, err := cacheWorkPath(workDir, rel)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return fmt.Errorf("clear %s: %w", rel, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := copyTree(src, dst); err != nil {
+			return fmt.Errorf("restore %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// SaveCache stores paths from workDir under key in branch's scope unless the
+// key already exists there, then evicts least recently used entries until the
+// repo's caches fit in maxSize bytes. The copy is made in a temp dir and
+// renamed into place, so a concurrent restore never sees a partial entry.
+func SaveCache(repo, branch, key string, paths []string, workDir string, maxSize int64) (CacheEntry, bool, error) {
+	if branch == "" {
+		return CacheEntry{}, false, errors.New("cache save needs a branch")
+	}
+	repoDir := cacheRepoDir(repo)
+	final := cacheEntryDir(repo, branch, key)
+	if _, err := os.Stat(final); err == nil {
+		return CacheEntry{}, false, nil
+	}
+
+	tmp := filepath.Join(repoDir, cacheTmpDir, uuid.NewString())
+	if err := os.MkdirAll(filepath.Join(tmp, cacheDataDir), 0o755); err != nil {
+		return CacheEntry{}, false, fmt.Errorf("create cache dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	now := time.Now().UTC()
+	entry := CacheEntry{Repo: repo, Branch: branch, Key: key, Created: now, LastUsed: now}
+	for _, p := range paths {
+		rel, err := cleanCachePath(p)
+		if err != nil {
+			return CacheEntry{}, false, err
+		}
+		src, err := cacheWorkPath(workDir, rel)
+		if err != nil {
+			return CacheEntry{}, false, err
+		}
+		if _, err := os.Lstat(src); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		dst := filepath.Join(tmp, cacheDataDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return CacheEntry{}, false, err
+		}
+		if err := copyTree(src, dst); err != nil {
+			return CacheEntry{}, false, fmt.Errorf("save %s: %w", rel, err)
+		}
+		entry.Paths = append(entry.Paths, rel)
+	}
+	if len(entry.Paths) == 0 {
+		return CacheEntry{}, false, nil
+	}
+	size, err := dirSize(tmp)
+	if err != nil {
+		return CacheEntry{}, false, err
+	}
+	entry.Size = size
+	if err := writeCacheEntry(tmp, entry); err != nil {
+		return CacheEntry{}, false, err
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+		return CacheEntry{}, false, fmt.Errorf("create cache dir: %w", err)
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		if _, statErr := os.Stat(final); statErr == nil {
+			// Another run saved the same key first.
+			return CacheEntry{}, false, nil
+		}
+		return CacheEntry{}, false, fmt.Errorf("store cache: %w", err)
+	}
+	if _, err := evictCaches(repo, maxSize, entry); err != nil {
+		return entry, true, err
+	}
+	return entry, true, nil
+}
+
+// ListCaches returns the entries of repo, or of every repo when repo is
+// empty, most recently used first.
+func ListCaches(repo string) ([]CacheEntry, error) {
+	dirs := []string{cacheRepoDir(repo)}
+	if repo == "" {
+		repoDirs, err := os.ReadDir(filepath.Join(Root, "cache"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		dirs = dirs[:0]
+		for _, d := range repoDirs {
+			if d.IsDir() {
+				dirs = append(dirs, filepath.Join(Root, "cache", d.Name()))
+			}
+		}
+	}
+
+	var out []CacheEntry
+	for _, dir := range dirs {
+		branches, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range branches {
+			if !b.IsDir() || b.Name() == cacheTmpDir {
+				continue
+			}
+			keys, err := os.ReadDir(filepath.Join(dir, b.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range keys {
+				if !k.IsDir() {
+					continue
+				}
+				entry, err := readCacheEntry(filepath.Join(dir, b.Name(), k.Name()))
+				if err != nil {
+					continue
+				}
+				out = append(out, entry)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].LastUsed.After(out[j].LastUsed) })
+	return out, nil
+}
+
+// RemoveCaches deletes the named entries of repo on every branch, or all of
+// them when keys is empty, and returns how many were removed.
+func RemoveCaches(repo string, keys []string) (int, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if len(keys) == 0 {
+		entries, err := ListCaches(repo)
+		if err != nil {
+			return 0, err
+		}
+		if err := os.RemoveAll(cacheRepoDir(repo)); err != nil {
+			return 0, err
+		}
+		return len(entries), nil
+	}
+	entries, err := ListCaches(repo)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, key := range keys {
+		found := false
+		for _, e := range entries {
+			if e.Key != sanitizeCacheKey(key) {
+				continue
+			}
+			if err := os.RemoveAll(cacheEntryDir(repo, e.Branch, e.Key)); err != nil {
+				return removed, err
+			}
+			found = true
+			removed++
+		}
+		if !found {
+			return removed, fmt.Errorf("no cache %q for %s", key, repo)
+		}
+	}
+	return removed, nil
+}
+
+// evictCaches removes the least recently used entries of repo until the rest
+// fit in maxSize. keep is never evicted. Callers hold cacheMu.
+func evictCaches(repo string, maxSize int64, keep CacheEntry) ([]CacheEntry, error) {
+	if maxSize <= 0 {
+		return nil, nil
+	}
+	entries, err := ListCaches(repo)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	var evicted []CacheEntry
+	for i := len(entries) - 1; i >= 0 && total > maxSize; i-- {
+		if entries[i].Branch == keep.Branch && entries[i].Key == keep.Key {
+			continue
+		}
+		if err := os.RemoveAll(cacheEntryDir(repo, entries[i].Branch, entries[i].Key)); err != nil {
+			return evicted, err
+		}
+		total -= entries[i].Size
+		evicted = append(evicted, entries[i])
+	}
+	return evicted, nil
+}
+
+func readCacheEntry(dir string) (CacheEntry, error) {
+	b, err := os.ReadFile(filepath.Join(dir, cacheMetaFile))
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return CacheEntry{}, fmt.Errorf("read cache entry %s: %w", dir, err)
+	}
+	return entry, nil
+}
+
+func writeCacheEntry(dir string, entry CacheEntry) error {
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, cacheMetaFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, cacheMetaFile))
+}
+
+// copyTree copies a file, symlink or directory from src to dst, keeping
+// modes and symlinks as they are.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(p, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ParseByteSize parses sizes like 512MB, 5GiB or 1048576.
+func ParseByteSize(v string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(v))
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+		{"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q", v)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// FormatByteSize renders n with a binary unit, e.g. 1.5GiB.
+func FormatByteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,259 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	work := t.TempDir()
+	writeTestFile(t, filepath.Join(work, "go.sum"), "a v1.0.0 h1:x\n")
+	writeTestFile(t, filepath.Join(work, "web/package-lock.json"), "{}\n")
+
+	data := CacheKeyData{Job: "test", Branch: "feature/x", OS: "linux", Arch: "amd64"}
+	first, err := RenderCacheKey(`go-{{ .OS }}-{{ hashFiles "go.sum" "**/package-lock.json" }}`, work, data)
+	if err != nil {
+		t.Fatalf("RenderCacheKey() error = %v", err)
+	}
+	if !strings.HasPrefix(first, "go-linux-") || len(first) != len("go-linux-")+64 {
+		t.Fatalf("RenderCacheKey() = %q, want go-linux-<sha256>", first)
+	}
+
+	writeTestFile(t, filepath.Join(work, "go.sum"), "a v1.0.1 h1:y\n")
+	second, err := RenderCacheKey(`go-{{ .OS }}-{{ hashFiles "go.sum" "**/package-lock.json" }}`, work, data)
+	if err != nil {
+		t.Fatalf("RenderCacheKey() error = %v", err)
+	}
+	if second == first {
+		t.Fatal("RenderCacheKey() did not change after go.sum changed")
+	}
+
+	if got, _ := RenderCacheKey(`{{ .Job }}/{{ .Branch }}`, work, data); got != "test-feature-x" {
+		t.Fatalf("RenderCacheKey() = %q, want unsafe characters replaced", got)
+	}
+	if _, err := RenderCacheKey(`{{ .Nope }}`, work, data); err == nil {
+		t.Fatal("RenderCacheKey() with an unknown field should fail")
+	}
+}
+
+func TestCacheSaveRestoreAndEvict(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	work := t.TempDir()
+	writeTestFile(t, filepath.Join(work, "node_modules/a/index.js"), strings.Repeat("a", 100))
+	if err := os.Symlink("a/index.js", filepath.Join(work, "node_modules/link.js")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+
+	if _, saved, err := SaveCache("acme/app", "main", "k1", []string{"node_modules", "missing"}, work, 1<<20); err != nil || !saved {
+		t.Fatalf("SaveCache(k1) = %v, %v", saved, err)
+	}
+	if _, saved, err := SaveCache("acme/app", "main", "k1", []string{"node_modules"}, work, 1<<20); err != nil || saved {
+		t.Fatalf("SaveCache(k1 again) = %v, %v; want existing key kept", saved, err)
+	}
+
+	restored := t.TempDir()
+	writeTestFile(t, filepath.Join(restored, "node_modules/stale.js"), "old")
+	if _, hit, err := RestoreCache("acme/app", []string{"main"}, "k1", []string{"node_modules"}, restored); err != nil || !hit {
+		t.Fatalf("RestoreCache(k1) = %v, %v", hit, err)
+	}
+	if b, err := os.ReadFile(filepath.Join(restored, "node_modules/link.js")); err != nil || len(b) != 100 {
+		t.Fatalf("restored symlink read = %d bytes, %v", len(b), err)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "node_modules/stale.js")); !os.IsNotExist(err) {
+		t.Fatalf("stale file survived restore: %v", err)
+	}
+	if _, hit, err := RestoreCache("acme/app", []string{"main"}, "nope", []string{"node_modules"}, restored); err != nil || hit {
+		t.Fatalf("RestoreCache(nope) = %v, %v; want miss", hit, err)
+	}
+
+	// k1 was used last, so saving k2 and k3 under a 250 byte budget evicts k2.
+	time.Sleep(10 * time.Millisecond)
+	if _, _, err := SaveCache("acme/app", "main", "k2", []string{"node_modules"}, work, 250); err != nil {
+		t.Fatalf("SaveCache(k2) error = %v", err)
+	}
+	if _, _, err := RestoreCache("acme/app", []string{"main"}, "k1", []string{"node_modules"}, restored); err != nil {
+		t.Fatalf("RestoreCache(k1) error = %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, _, err := SaveCache("acme/app", "main", "k3", []string{"node_modules"}, work, 250); err != nil {
+		t.Fatalf("SaveCache(k3) error = %v", err)
+	}
+	entries, err := ListCaches("")
+	if err != nil {
+		t.Fatalf("ListCaches() error = %v", err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	if strings.Join(keys, ",") != "k3,k1" {
+		t.Fatalf("cache keys = %v, want [k3 k1] after evicting k2", keys)
+	}
+
+	if n, err := RemoveCaches("acme/app", nil); err != nil || n != 2 {
+		t.Fatalf("RemoveCaches() = %d, %v", n, err)
+	}
+}
+
+func TestCacheScopedByBranch(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	mainWork := t.TempDir()
+	writeTestFile(t, filepath.Join(mainWork, ".deps/ok"), "main")
+	if _, saved, err := SaveCache("acme/app", "main", "deps", []string{".deps"}, mainWork, 1<<20); err != nil || !saved {
+		t.Fatalf("SaveCache(main) = %v, %v", saved, err)
+	}
+	// A pull request saves the same key into its own scope only.
+	prWork := t.TempDir()
+	writeTestFile(t, filepath.Join(prWork, ".deps/ok"), "poisoned")
+	if _, saved, err := SaveCache("acme/app", "pr:7", "deps", []string{".deps"}, prWork, 1<<20); err != nil || !saved {
+		t.Fatalf("SaveCache(pr:7) = %v, %v", saved, err)
+	}
+	// feature/x and feature-x must not share a scope.
+	if _, saved, err := SaveCache("acme/app", "feature-x", "deps", []string{".deps"}, prWork, 1<<20); err != nil || !saved {
+		t.Fatalf("SaveCache(feature-x) = %v, %v", saved, err)
+	}
+
+	tests := []struct {
+		name     string
+		branches []string
+		key      string
+		wantFrom string
+		want     string
+	}{
+		{name: "main", branches: []string{"main"}, key: "deps", wantFrom: "main", want: "main"},
+		{name: "pull request own scope", branches: []string{"pr:7", "main"}, key: "deps", wantFrom: "pr:7", want: "poisoned"},
+		{name: "falls back to the default branch", branches: []string{"feature/x", "main"}, key: "deps", wantFrom: "main", want: "main"},
+		{name: "other branch only", branches: []string{"feature/x"}, key: "deps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			work := t.TempDir()
+			from, hit, err := RestoreCache("acme/app", tt.branches, tt.key, []string{".deps"}, work)
+			if err != nil {
+				t.Fatalf("RestoreCache() error = %v", err)
+			}
+			if from != tt.wantFrom || hit != (tt.want != "") {
+				t.Fatalf("RestoreCache() = %q, %v; want %q", from, hit, tt.wantFrom)
+			}
+			if tt.want == "" {
+				return
+			}
+			if b, err := os.ReadFile(filepath.Join(work, ".deps/ok")); err != nil || string(b) != tt.want {
+				t.Fatalf("restored .deps/ok = %q, %v; want %q", b, err, tt.want)
+			}
+		})
+	}
+
+	entries, err := ListCaches("acme/app")
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("ListCaches() = %d entries, %v; want 3", len(entries), err)
+	}
+	if n, err := RemoveCaches("acme/app", []string{"deps"}); err != nil || n != 3 {
+		t.Fatalf("RemoveCaches(deps) = %d, %v; want every branch's entry removed", n, err)
+	}
+}
+
+func TestCacheRejectsSymlinkedParents(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	outside := t.TempDir()
+	writeTestFile(t, filepath.Join(outside, "cache/secret"), "keep out")
+	work := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(work, "node_modules")); err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	if _, _, err := SaveCache("acme/app", "main", "k", []string{"node_modules/cache"}, work, 1<<20); err == nil || !strings.Contains(err.Error(), "goes through symlink node_modules") {
+		t.Fatalf("SaveCache() error = %v, want symlinked parent rejected", err)
+	}
+
+	src := t.TempDir()
+	writeTestFile(t, filepath.Join(src, "node_modules/cache/secret"), "cached")
+	if _, saved, err := SaveCache("acme/app", "main", "k", []string{"node_modules/cache"}, src, 1<<20); err != nil || !saved {
+		t.Fatalf("SaveCache() = %v, %v", saved, err)
+	}
+	if _, _, err := RestoreCache("acme/app", []string{"main"}, "k", []string{"node_modules/cache"}, work); err == nil || !strings.Contains(err.Error(), "goes through symlink node_modules") {
+		t.Fatalf("RestoreCache() error = %v, want symlinked parent rejected", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(outside, "cache/secret")); err != nil || string(b) != "keep out" {
+		t.Fatalf("file outside the worktree = %q, %v; want it untouched", b, err)
+	}
+}
+
+func TestJobRunnerRestoresAndSavesCache(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+
+	run := func(runID string) string {
+		work := t.TempDir()
+		writeTestFile(t, filepath.Join(work, "deps.lock"), "v1\n")
+		req := RunJobRequest{
+			RunID:   runID,
+			Repo:    "acme/app",
+			Name:    "build",
+			Branch:  "main",
+			SHA:     "deadbeefcafebabe",
+			WorkDir: work,
+			Steps: []RunStep{{Name: "deps", Args: []string{"bash", "-c",
+				"if [ -f .deps/ok ]; then echo cached; else mkdir -p .deps && echo fetched > .deps/ok; fi"}}},
+			Cache: &CacheConf{Key: `deps-{{ hashFiles "deps.lock" }}`, Paths: []string{".deps"}},
+		}
+		logPath, err := runner.Start(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		if job := waitJobDone(t, repo, runID); job.Status != StatusFinished {
+			t.Fatalf("job status = %q (%s)", job.Status, job.Msg)
+		}
+		body, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		return string(body)
+	}
+
+	first := run("run-cache-1")
+	if !strings.Contains(first, ": miss") || !strings.Contains(first, "==> cache save deps-") {
+		t.Fatalf("first log = %q, want a miss and a save", first)
+	}
+	second := run("run-cache-2")
+	if !strings.Contains(second, ": hit") || !strings.Contains(second, "cached") {
+		t.Fatalf("second log = %q, want a hit and the restored deps", second)
+	}
+}
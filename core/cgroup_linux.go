@@ -0,0 +1,214 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cgroupFS is where the unified (v2) hierarchy is mounted.
+const cgroupFS = "/sys/fs/cgroup"
+
+var cgroupControllers = []string{"memory", "cpu", "pids"}
+
+var (
+	cgroupOnce   sync.Once
+	cgroupParent string
+	cgroupErr    error
+)
+
+// runCgroup is the cgroup one job run's steps are started in.
+type runCgroup struct {
+	dir string
+	fd  int
+}
+
+// newRunCgroup creates a cgroup for runID with limits applied. It fails when
+// cgroup v2 is not mounted or the memory, cpu and pids controllers are not
+// delegated to the runner; callers then run the job without limits.
+func newRunCgroup(runID string, limits LimitsConf) (*runCgroup, error) {
+	cgroupOnce.Do(func() {
+		cgroupParent, cgroupErr = prepareCgroupParent()
+	})
+	if cgroupErr != nil {
+		return nil, cgroupErr
+	}
+
+	dir := filepath.Join(cgroupParent, "run-"+sanitizePathToken(runID))
+	if err := os.Mkdir(dir, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	writes := map[string]string{}
+	if n := limits.memoryBytes(); n > 0 {
+		writes["memory.max"] = strconv.FormatInt(n, 10)
+		writes["memory.oom.group"] = "1"
+	}
+	if limits.CPU > 0 {
+		const period = 100000
+		writes["cpu.max"] = fmt.Sprintf("%d %d", int64(limits.CPU*period), period)
+	}
+	if limits.PIDs > 0 {
+		writes["pids.max"] = strconv.Itoa(limits.PIDs)
+	}
+	for file, value := range writes {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644); err != nil {
+			_ = os.Remove(dir)
+			return nil, fmt.Errorf("set %s: %w", file, err)
+		}
+	}
+
+	fd, err := syscall.Open(dir, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		_ = os.Remove(dir)
+		return nil, fmt.Errorf("open cgroup: %w", err)
+	}
+	return &runCgroup{dir: dir, fd: fd}, nil
+}
+
+// prepareCgroupParent finds the delegated cgroup and creates the refci
+// subtree with the controllers enabled. A cgroup with member processes
+// cannot enable controllers for its children, so when the runner's own
+// cgroup is used the runner first moves itself into a refci-runner leaf.
+func prepareCgroupParent() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupFS, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not mounted at %s", cgroupFS)
+	}
+	base := os.Getenv(cgroupEnv)
+	own := base == ""
+	if own {
+		rel, err := ownCgroup()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(cgroupFS, rel)
+	}
+
+	available, err := os.ReadFile(filepath.Join(base, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("read %s controllers: %w", base, err)
+	}
+	var missing []string
+	for _, c := range cgroupControllers {
+		if !containsString(strings.Fields(string(available)), c) {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("controllers %s are not delegated to %s", strings.Join(missing, ","), base)
+	}
+
+	err = enableCgroupControllers(base)
+	if errors.Is(err, syscall.EBUSY) && own {
+		leaf := filepath.Join(base, "refci-runner")
+		if mkErr := os.Mkdir(leaf, 0o755); mkErr != nil && !errors.Is(mkErr, os.ErrExist) {
+			return "", fmt.Errorf("create %s: %w", leaf, mkErr)
+		}
+		if mvErr := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0o644); mvErr != nil {
+			return "", fmt.Errorf("move runner to %s: %w", leaf, mvErr)
+		}
+		err = enableCgroupControllers(base)
+	}
+	if err != nil {
+		return "", fmt.Errorf("enable controllers in %s: %w", base, err)
+	}
+
+	parent := filepath.Join(base, "refci")
+	if err := os.Mkdir(parent, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("create %s: %w", parent, err)
+	}
+	if err := enableCgroupControllers(parent); err != nil {
+		return "", fmt.Errorf("enable controllers in %s: %w", parent, err)
+	}
+	return parent, nil
+}
+
+// ownCgroup returns the runner's cgroup path relative to cgroupFS.
+func ownCgroup() (string, error) {
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("read own cgroup: %w", err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			return strings.TrimSpace(rel), nil
+		}
+	}
+	return "", errors.New("runner is not in a cgroup v2 hierarchy")
+}
+
+func enableCgroupControllers(dir string) error {
+	value := "+" + strings.Join(cgroupControllers, " +")
+	return os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(value), 0o644)
+}
+
+// apply makes processes started with attr begin in the cgroup.
+func (cg *runCgroup) apply(attr *syscall.SysProcAttr) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = cg.fd
+}
+
+// usage reads the run's peak memory and total CPU time, which include every
+// process the steps started.
+func (cg *runCgroup) usage() (JobUsage, error) {
+	var u JobUsage
+	peak, err := os.ReadFile(filepath.Join(cg.dir, "memory.peak"))
+	if err != nil {
+		return u, err
+	}
+	u.PeakMemory, err = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+	if err != nil {
+		return u, fmt.Errorf("parse memory.peak: %w", err)
+	}
+	usec, err := readCgroupKey(filepath.Join(cg.dir, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return u, err
+	}
+	u.CPUTime = time.Duration(usec) * time.Microsecond
+	return u, nil
+}
+
+// oomKilled reports whether the memory limit killed a process of the run.
+func (cg *runCgroup) oomKilled() bool {
+	n, err := readCgroupKey(filepath.Join(cg.dir, "memory.events"), "oom_kill")
+	return err == nil && n > 0
+}
+
+// close kills whatever is left in the cgroup and removes it.
+func (cg *runCgroup) close() {
+	_ = syscall.Close(cg.fd)
+	_ = os.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0o644)
+	for i := 0; i < 50; i++ {
+		if err := os.Remove(cg.dir); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func readCgroupKey(path, key string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("%s not found in %s", key, path)
+}
+
+// rusagePeakMemory returns the peak RSS in bytes from a wait status.
+func rusagePeakMemory(state *os.ProcessState) int64 {
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return int64(ru.Maxrss) * 1024
+	}
+	return 0
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+
+package core
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// runCgroup is unused outside Linux; limits are reported as not enforced.
+type runCgroup struct {
+	dir string
+}
+
+func newRunCgroup(string, LimitsConf) (*runCgroup, error) {
+	return nil, errors.New("cgroups are only supported on linux")
+}
+
+func (cg *runCgroup) apply(*syscall.SysProcAttr) {}
+
+func (cg *runCgroup) usage() (JobUsage, error) {
+	return JobUsage{}, errors.New("cgroups are only supported on linux")
+}
+
+func (cg *runCgroup) oomKilled() bool { return false }
+
+func (cg *runCgroup) close() {}
+
+// rusagePeakMemory returns the peak RSS in bytes from a wait status. Darwin
+// reports ru_maxrss in bytes, the BSDs in kilobytes.
+func rusagePeakMemory(state *os.ProcessState) int64 {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) * 1024
+}
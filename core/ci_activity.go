@@ -60,7 +60,8 @@ func (l *CIActivityLogger) Logf(format string, args ...any) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	// Created again after a rotation.
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
 	if err != nil {
 		return
 	}
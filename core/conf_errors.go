@@ -0,0 +1,351 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfError is one problem in a job config file, positioned at the YAML node
+// that caused it.
+type ConfError struct {
+	File   string
+	Line   int
+	Column int
+	Job    string
+	Field  string
+	Msg    string
+}
+
+func (e ConfError) Error() string {
+	var b strings.Builder
+	if pos := e.Position(); pos != "" {
+		b.WriteString(pos)
+		b.WriteString(": ")
+	}
+	if e.Job != "" {
+		fmt.Fprintf(&b, "job %s: ", e.Job)
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&b, "%s: ", e.Field)
+	}
+	b.WriteString(e.Msg)
+	return b.String()
+}
+
+// Position renders file:line:column, leaving out unknown parts.
+func (e ConfError) Position() string {
+	pos := e.File
+	if e.Line > 0 {
+		pos += ":" + strconv.Itoa(e.Line)
+		if e.Column > 0 {
+			pos += ":" + strconv.Itoa(e.Column)
+		}
+	}
+	return strings.TrimPrefix(pos, ":")
+}
+
+func (e ConfError) withJob(job string) ConfError {
+	if e.Job == "" {
+		e.Job = job
+	}
+	return e
+}
+
+// ConfErrors collects every problem found in one config file.
+type ConfErrors []ConfError
+
+func (e ConfErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "invalid job config"
+	case 1:
+		return e[0].Error()
+	default:
+		return fmt.Sprintf("%s (and %d more)", e[0].Error(), len(e)-1)
+	}
+}
+
+// withConfFile sets File on every ConfError in err; other errors pass through.
+func withConfFile(err error, file string) error {
+	var confErrs ConfErrors
+	if !errors.As(err, &confErrs) {
+		return err
+	}
+	out := make(ConfErrors, len(confErrs))
+	for i, e := range confErrs {
+		if e.File == "" {
+			e.File = file
+		}
+		out[i] = e
+	}
+	return out
+}
+
+func nodeError(node *yaml.Node, job, field, msg string) ConfError {
+	return ConfError{Line: node.Line, Column: node.Column, Job: job, Field: field, Msg: msg}
+}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+): `)
+
+// yamlConfError converts a yaml.v3 error, which only carries a line inside
+// its text, into a ConfError. fallback positions errors without a line.
+func yamlConfError(err error, fallback *yaml.Node) ConfError {
+	msg := err.Error()
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = typeErr.Errors[0]
+	}
+	msg = strings.TrimPrefix(msg, "yaml: ")
+
+	out := ConfError{Msg: msg}
+	if m := yamlLinePattern.FindStringSubmatchIndex(msg); m != nil {
+		out.Line, _ = strconv.Atoi(msg[m[2]:m[3]])
+		out.Msg = msg[:m[0]] + msg[m[1]:]
+	} else if fallback != nil {
+		out.Line, out.Column = fallback.Line, fallback.Column
+	}
+	return out
+}
+
+var (
+	jobConfSpecFields = yamlFieldNames(JobConfSpec{})
+	stepConfFields    = yamlFieldNames(StepConf{})
+	cacheConfFields   = yamlFieldNames(CacheConf{})
+	limitsConfFields  = yamlFieldNames(LimitsConf{})
+)
+
+// yamlFieldNames lists the yaml keys a struct accepts, from its yaml tags.
+func yamlFieldNames(v any) []string {
+	t := reflect.TypeOf(v)
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (p *confParser) checkKnownFields(node *yaml.Node, job, prefix string, known []string) ConfErrors {
+	var errs ConfErrors
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if containsString(known, key.Value) {
+			continue
+		}
+		msg := "unknown field"
+		if hint := closestName(key.Value, known); hint != "" {
+			msg += fmt.Sprintf(" (did you mean %s?)", hint)
+		}
+		errs = append(errs, p.nodeError(key, job, prefix+key.Value, msg))
+	}
+	return errs
+}
+
+// mappingValue returns the value node for key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// validateJobSpec checks what decoding cannot: which command fields are set
+// and whether the patterns are usable.
+func (p *confParser) validateJobSpec(name string, spec JobConfSpec, node *yaml.Node) ConfErrors {
+	var errs ConfErrors
+	at := func(field string) *yaml.Node {
+		if v := mappingValue(node, field); v != nil {
+			return v
+		}
+		return node
+	}
+
+	hasScript := strings.TrimSpace(spec.Script) != ""
+	hasRun := strings.TrimSpace(spec.Run) != ""
+	switch {
+	case len(spec.Steps) > 0 && (hasScript || hasRun):
+		errs = append(errs, p.nodeError(at("steps"), name, "steps", "steps cannot be combined with script or run"))
+	case hasScript && hasRun:
+		errs = append(errs, p.nodeError(at("run"), name, "run", "script and run are mutually exclusive"))
+	case len(spec.Steps) == 0 && !hasScript && !hasRun:
+		errs = append(errs, p.nodeError(node, name, "", "one of script, run or steps is required"))
+	}
+
+	if err := validateBranchPattern(spec.BranchPattern); err != nil {
+		errs = append(errs, p.nodeError(at("branch_pattern"), name, "branch_pattern", err.Error()))
+	}
+	errs = append(errs, p.validatePathPatterns(name, "path_patterns", spec.PathPatterns, at("path_patterns"))...)
+	errs = append(errs, p.validatePathPatterns(name, "paths_ignore", spec.PathsIgnore, at("paths_ignore"))...)
+	if _, err := ShellArgs(spec.Shell, "script"); err != nil {
+		errs = append(errs, p.nodeError(at("shell"), name, "shell", err.Error()))
+	}
+	if spec.Cache != nil {
+		if field, err := validateCacheConf(*spec.Cache); err != nil {
+			errs = append(errs, p.sectionError(at("cache"), name, "cache", field, err))
+		}
+	}
+	if spec.Limits != nil {
+		if field, err := validateLimitsConf(*spec.Limits); err != nil {
+			errs = append(errs, p.sectionError(at("limits"), name, "limits", field, err))
+		}
+	}
+	errs = append(errs, p.validateReports(name, spec.Reports, at("reports"))...)
+	if strings.TrimSpace(spec.If) != "" {
+		if _, err := ParseExpr(spec.If); err != nil {
+			errs = append(errs, p.nodeError(at("if"), name, "if", err.Error()))
+		}
+	}
+
+	stepsNode := at("steps")
+	stepNames := map[string]int{}
+	for i, step := range spec.Steps {
+		stepNode := stepsNode
+		if stepsNode.Kind == yaml.SequenceNode && i < len(stepsNode.Content) {
+			stepNode = stepsNode.Content[i]
+		}
+		field := fmt.Sprintf("steps[%d]", i)
+		stepScript := strings.TrimSpace(step.Script) != ""
+		stepRun := strings.TrimSpace(step.Run) != ""
+		if stepScript == stepRun {
+			errs = append(errs, p.nodeError(stepNode, name, field, "step needs exactly one of script or run"))
+		}
+		if _, err := ShellArgs(step.Shell, "script"); err != nil {
+			errs = append(errs, p.nodeError(stepNode, name, field+".shell", err.Error()))
+		}
+		if stepName := strings.TrimSpace(step.Name); stepName != "" {
+			if first, exists := stepNames[stepName]; exists {
+				errs = append(errs, p.nodeError(stepNode, name, field+".name", fmt.Sprintf("duplicate step name (also steps[%d])", first)))
+			} else {
+				stepNames[stepName] = i
+			}
+		}
+	}
+	return errs
+}
+
+// sectionError reports err for field (e.g. "paths[0]") of the section
+// mapping node, positioned at the field when it is present.
+func (p *confParser) sectionError(node *yaml.Node, job, section, field string, err error) ConfError {
+	if field == "" {
+		return p.nodeError(node, job, section, err.Error())
+	}
+	at := node
+	if v := mappingValue(node, strings.SplitN(field, "[", 2)[0]); v != nil {
+		at = v
+	}
+	return p.nodeError(at, job, section+"."+field, err.Error())
+}
+
+// validateBranchPattern mirrors ListBranchHeadsByPattern: an exact branch or
+// a single trailing wildcard.
+func validateBranchPattern(pattern string) error {
+	p := normalizeBranchPattern(pattern)
+	if strings.Contains(p, "*") && (strings.Count(p, "*") != 1 || !strings.HasSuffix(p, "*")) {
+		return fmt.Errorf("only a single trailing wildcard is supported: %q", pattern)
+	}
+	if strings.ContainsAny(p, "?[") {
+		return fmt.Errorf("only * wildcards are supported: %q", pattern)
+	}
+	return nil
+}
+
+func (p *confParser) validatePathPatterns(job, field string, patterns []string, node *yaml.Node) ConfErrors {
+	var errs ConfErrors
+	for i, raw := range patterns {
+		itemNode := node
+		if node.Kind == yaml.SequenceNode && i < len(node.Content) {
+			itemNode = node.Content[i]
+		}
+		itemField := fmt.Sprintf("%s[%d]", field, i)
+		pattern := normalizeRepoRelPath(strings.TrimPrefix(strings.TrimSpace(raw), "!"))
+		if pattern == "" {
+			errs = append(errs, p.nodeError(itemNode, job, itemField, "empty path pattern"))
+			continue
+		}
+		for _, part := range splitPathParts(pattern) {
+			if _, err := path.Match(part, ""); err != nil {
+				errs = append(errs, p.nodeError(itemNode, job, itemField, fmt.Sprintf("bad glob %q: %v", raw, err)))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func (p *confParser) validateReports(job string, patterns []string, node *yaml.Node) ConfErrors {
+	var errs ConfErrors
+	for i, raw := range patterns {
+		itemNode := node
+		if node.Kind == yaml.SequenceNode && i < len(node.Content) {
+			itemNode = node.Content[i]
+		}
+		itemField := fmt.Sprintf("reports[%d]", i)
+		pattern, err := cleanReportPattern(raw)
+		if err != nil {
+			errs = append(errs, p.nodeError(itemNode, job, itemField, err.Error()))
+			continue
+		}
+		for _, part := range splitPathParts(pattern) {
+			if _, err := path.Match(part, ""); err != nil {
+				errs = append(errs, p.nodeError(itemNode, job, itemField, fmt.Sprintf("bad glob %q: %v", raw, err)))
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// closestName returns the candidate within edit distance 2 of v, if any.
+func closestName(v string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(v, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
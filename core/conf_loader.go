@@ -0,0 +1,166 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const jobConfPath = ".refci/conf.yml"
+
+// ErrNoJobConf is returned when a ref has no .refci/conf.yml.
+var ErrNoJobConf = errors.New("no " + jobConfPath)
+
+// maxCachedJobConfs bounds the loader cache; it is cleared when full.
+const maxCachedJobConfs = 256
+
+// JobConfLoader reads job configs from a repo mirror at any ref. Parsed
+// results, including validation errors but not git failures, are cached by
+// the conf.yml blob hash, so polling many branches that share a config parses
+// it once. An entry also records the blobs of the files it included and is
+// only reused while they are unchanged at the requested ref.
+type JobConfLoader struct {
+	repo string
+
+	mu    sync.Mutex
+	cache map[string]jobConfEntry
+}
+
+type jobConfEntry struct {
+	confs []JobConf
+	err   error
+	deps  map[string]string // included path -> blob hash, "" when missing
+}
+
+func NewJobConfLoader(repo string) *JobConfLoader {
+	return &JobConfLoader{
+		repo:  repo,
+		cache: map[string]jobConfEntry{},
+	}
+}
+
+// Load returns the job configs at rev (a branch, sha or HEAD).
+func (l *JobConfLoader) Load(ctx context.Context, rev string) ([]JobConf, error) {
+	if strings.TrimSpace(l.repo) == "" {
+		return nil, fmt.Errorf("repo is required")
+	}
+	if strings.TrimSpace(rev) == "" {
+		rev = "HEAD"
+	}
+	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(l.repo))
+
+	blob, err := confBlobAt(ctx, mirrorPath, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	entry, ok := l.cache[blob]
+	l.mu.Unlock()
+	if ok && !depsUnchanged(ctx, mirrorPath, rev, entry.deps) {
+		ok = false
+	}
+	if !ok {
+		entry = l.parseBlob(ctx, mirrorPath, rev, blob)
+		// Git failures, a canceled ctx among them, say nothing about the
+		// config, so only configs and config errors are cached.
+		var confErrs ConfErrors
+		if entry.err != nil && !errors.As(entry.err, &confErrs) {
+			return nil, entry.err
+		}
+		l.mu.Lock()
+		if len(l.cache) >= maxCachedJobConfs {
+			l.cache = map[string]jobConfEntry{}
+		}
+		l.cache[blob] = entry
+		l.mu.Unlock()
+	}
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return append([]JobConf(nil), entry.confs...), nil
+}
+
+func (l *JobConfLoader) parseBlob(ctx context.Context, mirrorPath, rev, blob string) jobConfEntry {
+	content, err := runGitOutput(ctx, mirrorPath, "cat-file", "blob", blob)
+	if err != nil {
+		return jobConfEntry{err: err}
+	}
+
+	deps := map[string]string{}
+	var gitErr error // a failed read that is not a missing file
+	read := func(p string) (string, error) {
+		depBlob, err := blobAt(ctx, mirrorPath, rev, p)
+		if errors.Is(err, errNoSuchFile) {
+			// Another ref with this conf.yml may have the file.
+			deps[p] = ""
+			return "", err
+		}
+		if err != nil {
+			gitErr = err
+			return "", err
+		}
+		deps[p] = depBlob
+		content, err := runGitOutput(ctx, mirrorPath, "cat-file", "blob", depBlob)
+		if err != nil {
+			gitErr = err
+		}
+		return content, err
+	}
+	confs, err := ParseJobConfFiles(jobConfPath, content, read)
+	if gitErr != nil {
+		return jobConfEntry{err: gitErr}
+	}
+	if err != nil {
+		return jobConfEntry{err: err, deps: deps}
+	}
+	for i := range confs {
+		confs[i].Repo = l.repo
+	}
+	return jobConfEntry{confs: confs, deps: deps}
+}
+
+// depsUnchanged reports whether every included file still has the cached
+// blob at rev, and every missing one is still missing.
+func depsUnchanged(ctx context.Context, mirrorPath, rev string, deps map[string]string) bool {
+	for p, want := range deps {
+		got, err := blobAt(ctx, mirrorPath, rev, p)
+		if want == "" && errors.Is(err, errNoSuchFile) {
+			continue
+		}
+		if err != nil || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// confBlobAt resolves the blob hash of conf.yml at rev, or ErrNoJobConf.
+func confBlobAt(ctx context.Context, mirrorPath, rev string) (string, error) {
+	blob, err := blobAt(ctx, mirrorPath, rev, jobConfPath)
+	if errors.Is(err, errNoSuchFile) {
+		return "", fmt.Errorf("%w at %s", ErrNoJobConf, rev)
+	}
+	return blob, err
+}
+
+var errNoSuchFile = errors.New("no such file")
+
+// blobAt resolves the blob hash of the repo file p at rev.
+func blobAt(ctx context.Context, mirrorPath, rev, p string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", rev+":"+p)
+	cmd.Dir = mirrorPath
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", fmt.Errorf("%w: %s at %s", errNoSuchFile, p, rev)
+		}
+		return "", fmt.Errorf("git rev-parse %s:%s failed: %w", rev, p, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestJobConfLoaderReadsEachRefAndCachesByBlob(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	mainSHA := src.commit(t, ".refci/conf.yml", "build:\n  script: build.sh\n", "add config")
+	src.git(t, "checkout", "-q", "-b", "feature")
+	src.commit(t, "README.md", "docs\n", "docs only")
+	featureSHA := src.commit(t, ".refci/conf.yml", "build:\n  script: build.sh\nlint:\n  run: make lint\n", "add lint job")
+	src.git(t, "checkout", "-q", "-b", "empty", mainSHA)
+	src.git(t, "rm", "-q", ".refci/conf.yml")
+	src.git(t, "commit", "-q", "-m", "drop config")
+	emptySHA := src.git(t, "rev-parse", "HEAD")
+	src.git(t, "checkout", "-q", "main")
+	src.mirror(t, "acme/app")
+
+	loader := NewJobConfLoader("acme/app")
+	ctx := context.Background()
+
+	head, err := loader.Load(ctx, "HEAD")
+	if err != nil {
+		t.Fatalf("Load(HEAD) error = %v", err)
+	}
+	if len(head) != 1 || head[0].Name != "build" || head[0].Repo != "acme/app" {
+		t.Fatalf("Load(HEAD) = %+v, want build for acme/app", head)
+	}
+
+	feature, err := loader.Load(ctx, featureSHA)
+	if err != nil {
+		t.Fatalf("Load(feature) error = %v", err)
+	}
+	if len(feature) != 2 || feature[1].Name != "lint" {
+		t.Fatalf("Load(feature) = %+v, want build and lint", feature)
+	}
+
+	// The docs-only commit shares main's conf.yml blob.
+	docsSHA := src.git(t, "rev-parse", "feature~1")
+	if _, err := loader.Load(ctx, docsSHA); err != nil {
+		t.Fatalf("Load(docs) error = %v", err)
+	}
+	if got := len(loader.cache); got != 2 {
+		t.Fatalf("cache entries = %d, want 2 distinct blobs", got)
+	}
+
+	if _, err := loader.Load(ctx, emptySHA); !errors.Is(err, ErrNoJobConf) {
+		t.Fatalf("Load(no config) error = %v, want ErrNoJobConf", err)
+	}
+}
+
+func TestConfigSourceUsesBranchConfig(t *testing.T) {
+	cases := []struct {
+		source ConfigSource
+		branch string
+		want   bool
+	}{
+		{ConfigSource{Mode: ConfigSourceHead, Trusted: []string{"*"}}, "feature-a", false},
+		{ConfigSource{Mode: ConfigSourceBranch, Trusted: []string{"*"}}, "feature-a", true},
+		{ConfigSource{Mode: ConfigSourceBranch, Trusted: []string{"*"}}, "pr:12", false},
+		{ConfigSource{Mode: ConfigSourceBranch, Trusted: []string{"main", "pr:*"}}, "pr:12", true},
+		{ConfigSource{Mode: ConfigSourceBranch, Trusted: []string{"*"}}, "pr/12", true},
+		{ConfigSource{Mode: ConfigSourceBranch, Trusted: []string{"main", "release-*"}}, "release-1", true},
+		{ConfigSource{Mode: ConfigSourceBranch, Trusted: []string{"main", "release-*"}}, "feature-a", false},
+	}
+	for _, tc := range cases {
+		if got := tc.source.UsesBranchConfig(tc.branch); got != tc.want {
+			t.Fatalf("%+v.UsesBranchConfig(%q) = %v, want %v", tc.source, tc.branch, got, tc.want)
+		}
+	}
+}
+
+func TestRepoSettingsRoundTrip(t *testing.T) {
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+
+	source, err := LoadConfigSource(repo, "acme/app")
+	if err != nil {
+		t.Fatalf("LoadConfigSource() error = %v", err)
+	}
+	if source.Mode != ConfigSourceHead || len(source.Trusted) != 1 || source.Trusted[0] != "*" {
+		t.Fatalf("default source = %+v, want head with * trusted", source)
+	}
+
+	if _, err := NormalizeRepoSetting(SettingConfigSource, "tip"); err == nil {
+		t.Fatalf("NormalizeRepoSetting(tip) error = nil, want error")
+	}
+	if _, err := NormalizeRepoSetting(SettingConfigTrustedBranches, "*-x"); err == nil {
+		t.Fatalf("NormalizeRepoSetting(*-x) error = nil, want error")
+	}
+	trusted, err := NormalizeRepoSetting(SettingConfigTrustedBranches, " main , release-* ")
+	if err != nil || trusted != "main,release-*" {
+		t.Fatalf("NormalizeRepoSetting(trusted) = %q, %v", trusted, err)
+	}
+
+	for _, s := range []RepoSetting{
+		{Repo: "acme/app", Key: SettingConfigSource, Value: ConfigSourceBranch},
+		{Repo: "acme/app", Key: SettingConfigTrustedBranches, Value: trusted},
+	} {
+		if err := repo.SetRepoSetting(s); err != nil {
+			t.Fatalf("SetRepoSetting(%s) error = %v", s.Key, err)
+		}
+	}
+	source, err = LoadConfigSource(repo, "acme/app")
+	if err != nil {
+		t.Fatalf("LoadConfigSource() error = %v", err)
+	}
+	if source.Mode != ConfigSourceBranch || len(source.Trusted) != 2 {
+		t.Fatalf("stored source = %+v, want branch with two trusted patterns", source)
+	}
+
+	if err := repo.DeleteRepoSetting("acme/app", SettingConfigSource); err != nil {
+		t.Fatalf("DeleteRepoSetting() error = %v", err)
+	}
+	if mode, _ := RepoSettingValue(repo, "acme/app", SettingConfigSource); mode != ConfigSourceHead {
+		t.Fatalf("mode after unset = %q, want head", mode)
+	}
+}
+
+func TestJobConfLoaderDoesNotCacheGitFailures(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	sha := src.commit(t, ".refci/conf.yml", "build:\n  run: make\n", "add config")
+	mirrorPath := src.mirror(t, "acme/app")
+
+	// Unpack the mirror so the conf.yml blob can be taken away while the
+	// tree that names it stays.
+	packs, err := filepath.Glob(filepath.Join(mirrorPath, "objects", "pack", "*.pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("mirror packs = %v, %v; want one", packs, err)
+	}
+	pack, err := os.ReadFile(packs[0])
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if err := os.RemoveAll(filepath.Join(mirrorPath, "objects", "pack")); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	unpack := exec.Command("git", "unpack-objects", "-q")
+	unpack.Dir = mirrorPath
+	unpack.Stdin = bytes.NewReader(pack)
+	if out, err := unpack.CombinedOutput(); err != nil {
+		t.Fatalf("git unpack-objects: %v\n%s", err, out)
+	}
+	blob := src.git(t, "rev-parse", sha+":.refci/conf.yml")
+	object := filepath.Join(mirrorPath, "objects", blob[:2], blob[2:])
+	hidden := object + ".hidden"
+	if err := os.Rename(object, hidden); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	loader := NewJobConfLoader("acme/app")
+	ctx := context.Background()
+	if _, err := loader.Load(ctx, sha); err == nil {
+		t.Fatal("Load() with the conf.yml blob missing error = nil")
+	}
+	if got := len(loader.cache); got != 0 {
+		t.Fatalf("cache entries = %d after a git failure, want 0", got)
+	}
+
+	if err := os.Rename(hidden, object); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	confs, err := loader.Load(ctx, sha)
+	if err != nil || len(confs) != 1 || confs[0].Name != "build" {
+		t.Fatalf("Load() once the blob is back = %+v, %v; want build", confs, err)
+	}
+}
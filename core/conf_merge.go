@@ -0,0 +1,339 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config composition, applied before a job is validated:
+//
+//	include:                  # other repo files, merged before this one
+//	  - .refci/common.yml
+//	defaults:                 # base of every job
+//	  branch_pattern: main
+//	.go-job:                  # template: never runs, only extended
+//	  shell: bash -euo pipefail
+//	test:
+//	  extends: .go-job        # or a list, merged left to right
+//	  run: go test ./...
+//
+// A job is defaults, then its extends chain, then its own keys. Mappings are
+// merged key by key; scalars and lists replace what they override.
+
+// ConfReader returns the content of a repo-relative config file.
+type ConfReader func(path string) (string, error)
+
+const (
+	confKeyInclude  = "include"
+	confKeyDefaults = "defaults"
+	confKeyExtends  = "extends"
+)
+
+// confParser keeps the file every node came from so errors in merged jobs
+// point at the right file, and the files already loaded so a file included
+// twice (a includes b and c, both include d) is merged once.
+type confParser struct {
+	read   ConfReader
+	files  map[*yaml.Node]string
+	loaded map[string]bool
+	errs   ConfErrors
+}
+
+// confDoc is a config file with its includes folded in, in merge order.
+type confDoc struct {
+	defaults []*yaml.Node
+	jobs     []confJob
+}
+
+type confJob struct {
+	name  string
+	key   *yaml.Node
+	value *yaml.Node
+}
+
+func (p *confParser) nodeError(node *yaml.Node, job, field, msg string) ConfError {
+	e := nodeError(node, job, field, msg)
+	e.File = p.files[node]
+	return e
+}
+
+// loadFile parses raw as file and recursively loads its includes. stack holds
+// the files being included, for cycle detection; a file loaded before through
+// another include is skipped.
+func (p *confParser) loadFile(file, raw string, stack []string) confDoc {
+	if p.loaded == nil {
+		p.loaded = map[string]bool{}
+	}
+	p.loaded[file] = true
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(raw), &doc); err != nil {
+		e := yamlConfError(err, nil)
+		e.File = file
+		p.errs = append(p.errs, e)
+		return confDoc{}
+	}
+	p.registerFile(&doc, file)
+	if len(doc.Content) == 0 {
+		return confDoc{}
+	}
+	root := doc.Content[0]
+	if root.Kind == yaml.ScalarNode && root.Tag == "!!null" {
+		return confDoc{}
+	}
+	if root.Kind != yaml.MappingNode {
+		p.errs = append(p.errs, p.nodeError(root, "", "", "top level must map job names to job definitions"))
+		return confDoc{}
+	}
+
+	var (
+		out      confDoc
+		own      confDoc
+		includes []*yaml.Node
+	)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valNode := root.Content[i], root.Content[i+1]
+		switch strings.TrimSpace(keyNode.Value) {
+		case confKeyInclude:
+			includes = append(includes, p.listItems(valNode, confKeyInclude)...)
+		case confKeyDefaults:
+			if valNode.Kind != yaml.MappingNode {
+				p.errs = append(p.errs, p.nodeError(valNode, "", confKeyDefaults, "defaults must be a map"))
+				continue
+			}
+			own.defaults = append(own.defaults, valNode)
+		default:
+			own.jobs = append(own.jobs, confJob{name: strings.TrimSpace(keyNode.Value), key: keyNode, value: valNode})
+		}
+	}
+
+	for _, inc := range includes {
+		incPath, err := normalizeIncludePath(inc.Value)
+		if err != nil {
+			p.errs = append(p.errs, p.nodeError(inc, "", confKeyInclude, err.Error()))
+			continue
+		}
+		if containsString(stack, incPath) || incPath == file {
+			chain := append(append(append([]string{}, stack...), file), incPath)
+			p.errs = append(p.errs, p.nodeError(inc, "", confKeyInclude, "include cycle: "+strings.Join(chain, " -> ")))
+			continue
+		}
+		if p.loaded[incPath] {
+			continue
+		}
+		if p.read == nil {
+			p.errs = append(p.errs, p.nodeError(inc, "", confKeyInclude, "include needs a repo to read from"))
+			continue
+		}
+		content, err := p.read(incPath)
+		if err != nil {
+			p.errs = append(p.errs, p.nodeError(inc, "", confKeyInclude, fmt.Sprintf("read %s: %v", incPath, err)))
+			continue
+		}
+		sub := p.loadFile(incPath, content, append(append([]string{}, stack...), file))
+		out.defaults = append(out.defaults, sub.defaults...)
+		out.jobs = append(out.jobs, sub.jobs...)
+	}
+
+	out.defaults = append(out.defaults, own.defaults...)
+	out.jobs = append(out.jobs, own.jobs...)
+	return out
+}
+
+// resolveJobs checks job names across all files and returns the runnable
+// jobs with defaults and extends applied.
+func (p *confParser) resolveJobs(doc confDoc) []confJob {
+	byName := map[string]confJob{}
+	var names []string
+	for _, job := range doc.jobs {
+		if job.name == "" || job.name == "." {
+			p.errs = append(p.errs, p.nodeError(job.key, "", "", "job name is empty"))
+			continue
+		}
+		if first, exists := byName[job.name]; exists {
+			where := fmt.Sprintf("line %d", first.key.Line)
+			if f := p.files[first.key]; f != "" {
+				where = fmt.Sprintf("%s:%d", f, first.key.Line)
+			}
+			p.errs = append(p.errs, p.nodeError(job.key, job.name, "", fmt.Sprintf("duplicate job name (first defined at %s)", where)))
+			continue
+		}
+		byName[job.name] = job
+		names = append(names, job.name)
+	}
+
+	var defaults *yaml.Node
+	for _, d := range doc.defaults {
+		defaults = p.merge(defaults, d)
+	}
+
+	resolved := map[string]*yaml.Node{}
+	var out []confJob
+	for _, name := range names {
+		node := p.resolveExtends(name, byName, resolved, nil)
+		if node == nil || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if defaults != nil && node.Kind == yaml.MappingNode {
+			node = p.merge(defaults, node)
+		}
+		out = append(out, confJob{name: name, key: byName[name].key, value: node})
+	}
+	return out
+}
+
+// resolveExtends returns the job's node merged over everything it extends,
+// without the extends key. It returns nil after recording an error.
+func (p *confParser) resolveExtends(name string, byName map[string]confJob, resolved map[string]*yaml.Node, chain []string) *yaml.Node {
+	if node, ok := resolved[name]; ok {
+		return node
+	}
+	job := byName[name]
+	if job.value.Kind != yaml.MappingNode {
+		// parseJobNode reports non-map jobs; templates are never parsed.
+		if strings.HasPrefix(name, ".") {
+			p.errs = append(p.errs, p.nodeError(job.value, name, "", "template must be a map"))
+			return nil
+		}
+		return job.value
+	}
+
+	extendsNode := mappingValue(job.value, confKeyExtends)
+	if extendsNode == nil {
+		resolved[name] = job.value
+		return job.value
+	}
+
+	chain = append(chain, name)
+	var base *yaml.Node
+	for _, parent := range p.listItems(extendsNode, confKeyExtends) {
+		parentName := strings.TrimSpace(parent.Value)
+		if containsString(chain, parentName) {
+			p.errs = append(p.errs, p.nodeError(parent, name, confKeyExtends, "extends cycle: "+strings.Join(append(chain, parentName), " -> ")))
+			return nil
+		}
+		if _, ok := byName[parentName]; !ok {
+			msg := fmt.Sprintf("extends unknown job %q", parentName)
+			if hint := closestName(parentName, jobNames(byName)); hint != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", hint)
+			}
+			p.errs = append(p.errs, p.nodeError(parent, name, confKeyExtends, msg))
+			return nil
+		}
+		parentNode := p.resolveExtends(parentName, byName, resolved, chain)
+		if parentNode == nil {
+			return nil
+		}
+		if parentNode.Kind != yaml.MappingNode {
+			p.errs = append(p.errs, p.nodeError(parent, name, confKeyExtends, fmt.Sprintf("cannot extend %q: not a map", parentName)))
+			return nil
+		}
+		base = p.merge(base, parentNode)
+	}
+
+	node := p.merge(base, p.withoutKey(job.value, confKeyExtends))
+	resolved[name] = node
+	return node
+}
+
+// merge returns over applied on top of base. Mappings merge key by key and
+// recursively; any other node in over replaces the base value. Inputs are
+// never modified.
+func (p *confParser) merge(base, over *yaml.Node) *yaml.Node {
+	if base == nil {
+		return over
+	}
+	if over == nil {
+		return base
+	}
+	if base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+
+	out := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: over.Line, Column: over.Column}
+	p.files[out] = p.files[over]
+	out.Content = append(out.Content, base.Content...)
+	for i := 0; i+1 < len(over.Content); i += 2 {
+		key, val := over.Content[i], over.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(out.Content); j += 2 {
+			if out.Content[j].Value == key.Value {
+				out.Content[j] = key
+				out.Content[j+1] = p.merge(out.Content[j+1], val)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			out.Content = append(out.Content, key, val)
+		}
+	}
+	return out
+}
+
+func (p *confParser) withoutKey(node *yaml.Node, key string) *yaml.Node {
+	out := &yaml.Node{Kind: node.Kind, Tag: node.Tag, Line: node.Line, Column: node.Column}
+	p.files[out] = p.files[node]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			continue
+		}
+		out.Content = append(out.Content, node.Content[i], node.Content[i+1])
+	}
+	return out
+}
+
+// listItems accepts a single scalar or a list of scalars.
+func (p *confParser) listItems(node *yaml.Node, field string) []*yaml.Node {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return []*yaml.Node{node}
+	case yaml.SequenceNode:
+		var items []*yaml.Node
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				p.errs = append(p.errs, p.nodeError(item, "", field, "entries must be strings"))
+				continue
+			}
+			items = append(items, item)
+		}
+		return items
+	default:
+		p.errs = append(p.errs, p.nodeError(node, "", field, "must be a string or a list of strings"))
+		return nil
+	}
+}
+
+func (p *confParser) registerFile(node *yaml.Node, file string) {
+	p.files[node] = file
+	for _, child := range node.Content {
+		p.registerFile(child, file)
+	}
+}
+
+// normalizeIncludePath cleans a repo-relative include path and rejects paths
+// that leave the repo.
+func normalizeIncludePath(raw string) (string, error) {
+	v := strings.TrimSpace(raw)
+	if v == "" {
+		return "", fmt.Errorf("include path is empty")
+	}
+	if strings.HasPrefix(v, "/") {
+		return "", fmt.Errorf("include path must be repo-relative: %q", raw)
+	}
+	cleaned := path.Clean(v)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("include path leaves the repo: %q", raw)
+	}
+	return cleaned, nil
+}
+
+func jobNames(byName map[string]confJob) []string {
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	return names
+}
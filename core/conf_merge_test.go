@@ -0,0 +1,257 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func mapReader(files map[string]string) ConfReader {
+	return func(p string) (string, error) {
+		content, ok := files[p]
+		if !ok {
+			return "", fmt.Errorf("no such file")
+		}
+		return content, nil
+	}
+}
+
+// Merge rules: defaults < extends (left to right) < the job's own keys.
+// Scalars and lists replace; they are never appended.
+func TestParseJobConfFilesMergeRules(t *testing.T) {
+	raw := `
+defaults:
+  branch_pattern: main
+  path_patterns: [src/**]
+  shell: sh
+.go:
+  shell: bash -euo pipefail
+  path_patterns: [go.mod, "**/*.go"]
+.release:
+  branch_pattern: release-*
+test:
+  extends: .go
+  run: go test ./...
+ship:
+  extends: [.go, .release]
+  path_patterns: []
+  script: ship.sh
+docs:
+  script: docs.sh
+`
+	confs, err := ParseJobConfFiles("conf.yml", raw, nil)
+	if err != nil {
+		t.Fatalf("ParseJobConfFiles() error = %v", err)
+	}
+	got := map[string]JobConf{}
+	for _, c := range confs {
+		got[c.Name] = c
+	}
+	if len(got) != 3 {
+		t.Fatalf("jobs = %v, want docs, ship and test (templates never run)", confs)
+	}
+
+	// Only defaults apply.
+	if c := got["docs"]; c.BranchPattern != "main" || c.Shell != "sh" || !reflect.DeepEqual(c.PathPatterns, []string{"src/**"}) {
+		t.Fatalf("docs = %+v, want defaults", c)
+	}
+	// The template replaces the default shell and list.
+	if c := got["test"]; c.BranchPattern != "main" || c.Shell != "bash -euo pipefail" || !reflect.DeepEqual(c.PathPatterns, []string{"go.mod", "**/*.go"}) {
+		t.Fatalf("test = %+v, want .go over defaults", c)
+	}
+	// Later templates win over earlier ones; the job's empty list clears the patterns.
+	if c := got["ship"]; c.BranchPattern != "release-*" || c.Shell != "bash -euo pipefail" || len(c.PathPatterns) != 0 {
+		t.Fatalf("ship = %+v, want .release over .go and own empty path_patterns", c)
+	}
+}
+
+// Nested mappings merge key by key; the overriding side wins per key.
+func TestConfMergeDeepMergesMappings(t *testing.T) {
+	var base, over yaml.Node
+	if err := yaml.Unmarshal([]byte("a: 1\nm:\n  x: 1\n  y: [1, 2]\n"), &base); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal([]byte("b: 2\nm:\n  y: [3]\n  z: 3\n"), &over); err != nil {
+		t.Fatal(err)
+	}
+	p := &confParser{files: map[*yaml.Node]string{}}
+	merged := p.merge(base.Content[0], over.Content[0])
+
+	var out map[string]any
+	if err := merged.Decode(&out); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := map[string]any{
+		"a": 1,
+		"b": 2,
+		"m": map[string]any{"x": 1, "y": []any{3}, "z": 3},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("merged = %#v, want %#v", out, want)
+	}
+	if len(base.Content[0].Content) != 4 {
+		t.Fatalf("merge modified its base input")
+	}
+}
+
+func TestParseJobConfFilesIncludes(t *testing.T) {
+	files := map[string]string{
+		".refci/common.yml":    "include: .refci/templates.yml\ndefaults:\n  branch_pattern: main\nlint:\n  run: make lint\n",
+		".refci/templates.yml": ".base:\n  shell: bash -euo pipefail\n",
+	}
+	raw := "include:\n  - .refci/common.yml\ntest:\n  extends: .base\n  run: make test\n"
+	confs, err := ParseJobConfFiles(".refci/conf.yml", raw, mapReader(files))
+	if err != nil {
+		t.Fatalf("ParseJobConfFiles() error = %v", err)
+	}
+	if len(confs) != 2 || confs[0].Name != "lint" || confs[1].Shell != "bash -euo pipefail" || confs[1].BranchPattern != "main" {
+		t.Fatalf("confs = %+v, want lint and test with included defaults and template", confs)
+	}
+}
+
+func TestParseJobConfFilesDiamondInclude(t *testing.T) {
+	// conf includes a and b, which both include common: common's jobs and
+	// defaults are merged once instead of failing as duplicates.
+	files := map[string]string{
+		"a.yml":      "include: common.yml\nlint:\n  run: make lint\n",
+		"b.yml":      "include: common.yml\nvet:\n  run: make vet\n",
+		"common.yml": "defaults:\n  branch_pattern: main\nsetup:\n  run: make setup\n",
+	}
+	raw := "include: [a.yml, b.yml]\ntest:\n  run: make test\n"
+	confs, err := ParseJobConfFiles(".refci/conf.yml", raw, mapReader(files))
+	if err != nil {
+		t.Fatalf("ParseJobConfFiles() error = %v", err)
+	}
+	var names []string
+	for _, c := range confs {
+		names = append(names, c.Name)
+		if c.BranchPattern != "main" {
+			t.Fatalf("job %s branch_pattern = %q, want main from common.yml", c.Name, c.BranchPattern)
+		}
+	}
+	if got := strings.Join(names, ","); got != "lint,setup,test,vet" {
+		t.Fatalf("jobs = %s, want lint,setup,test,vet", got)
+	}
+}
+
+func TestParseJobConfFilesCompositionErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		raw   string
+		want  string
+	}{
+		{
+			name:  "include cycle",
+			files: map[string]string{"a.yml": "include: b.yml\n", "b.yml": "include: a.yml\n"},
+			raw:   "include: a.yml\n",
+			want:  "b.yml:1:10: include: include cycle: .refci/conf.yml -> a.yml -> b.yml -> a.yml",
+		},
+		{
+			name: "missing include",
+			raw:  "include: nope.yml\n",
+			want: ".refci/conf.yml:1:10: include: read nope.yml: no such file",
+		},
+		{
+			name: "include outside repo",
+			raw:  "include: ../x.yml\n",
+			want: "include path leaves the repo",
+		},
+		{
+			name: "extends unknown",
+			raw:  ".base:\n  shell: sh\ntest:\n  extends: .bsae\n  run: x\n",
+			want: ".refci/conf.yml:4:12: job test: extends: extends unknown job \".bsae\" (did you mean .base?)",
+		},
+		{
+			name: "extends cycle",
+			raw:  ".a:\n  extends: .b\n.b:\n  extends: .a\ntest:\n  extends: .a\n  run: x\n",
+			want: "extends cycle",
+		},
+		{
+			name:  "error in included template",
+			files: map[string]string{"t.yml": ".base:\n  shel: sh\n"},
+			raw:   "include: t.yml\ntest:\n  extends: .base\n  run: x\n",
+			want:  "t.yml:2:3: job test: shel: unknown field (did you mean shell?)",
+		},
+		{
+			name:  "duplicate across files",
+			files: map[string]string{"t.yml": "test:\n  run: y\n"},
+			raw:   "include: t.yml\ntest:\n  run: x\n",
+			want:  ".refci/conf.yml:2:1: job test: duplicate job name (first defined at t.yml:1)",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseJobConfFiles(".refci/conf.yml", tc.raw, mapReader(tc.files))
+			var confErrs ConfErrors
+			if !errors.As(err, &confErrs) {
+				t.Fatalf("error = %v, want ConfErrors", err)
+			}
+			if !strings.Contains(confErrs[0].Error(), tc.want) {
+				t.Fatalf("error = %q, want it to contain %q", confErrs[0].Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestJobConfLoaderReparsesWhenIncludeChanges(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	src.commit(t, ".refci/base.yml", "defaults:\n  branch_pattern: main\n", "base")
+	first := src.commit(t, ".refci/conf.yml", "include: .refci/base.yml\ntest:\n  run: make\n", "config")
+	second := src.commit(t, ".refci/base.yml", "defaults:\n  branch_pattern: develop\n", "retarget")
+	src.mirror(t, "acme/app")
+
+	loader := NewJobConfLoader("acme/app")
+	for _, tc := range []struct{ sha, want string }{{first, "main"}, {second, "develop"}, {first, "main"}} {
+		confs, err := loader.Load(context.Background(), tc.sha)
+		if err != nil {
+			t.Fatalf("Load(%s) error = %v", shortSHA(tc.sha), err)
+		}
+		if confs[0].BranchPattern != tc.want {
+			t.Fatalf("Load(%s) branch_pattern = %q, want %q", shortSHA(tc.sha), confs[0].BranchPattern, tc.want)
+		}
+	}
+}
+
+func TestJobConfLoaderRereadsMissingInclude(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	// Both branches share conf.yml; only feature has the included file.
+	src := newTestGitRepo(t)
+	mainSHA := src.commit(t, ".refci/conf.yml", "include: .refci/common.yml\ntest:\n  run: make\n", "config")
+	src.git(t, "checkout", "-q", "-b", "feature")
+	featureSHA := src.commit(t, ".refci/common.yml", "defaults:\n  branch_pattern: feature\n", "common")
+	src.git(t, "checkout", "-q", "main")
+	src.mirror(t, "acme/app")
+
+	loader := NewJobConfLoader("acme/app")
+	ctx := context.Background()
+	if _, err := loader.Load(ctx, mainSHA); err == nil || !strings.Contains(err.Error(), "read .refci/common.yml") {
+		t.Fatalf("Load(main) error = %v, want the missing include", err)
+	}
+	confs, err := loader.Load(ctx, featureSHA)
+	if err != nil {
+		t.Fatalf("Load(feature) error = %v", err)
+	}
+	if confs[0].BranchPattern != "feature" {
+		t.Fatalf("Load(feature) branch_pattern = %q, want feature", confs[0].BranchPattern)
+	}
+	if _, err := loader.Load(ctx, mainSHA); err == nil {
+		t.Fatal("Load(main) again error = nil, want the missing include")
+	}
+}
@@ -1,6 +1,9 @@
 package core
 
-import "time"
+import (
+	"iter"
+	"time"
+)
 
 type CodeRepo struct {
 	Repo string
@@ -13,8 +16,24 @@ type RepoSetting struct {
 	Value string
 }
 
+// Secret is one encrypted secret value. An empty Repo is a global secret,
+// an empty Job a repo-wide one.
+type Secret struct {
+	Repo       string
+	Job        string
+	Key        string
+	Nonce      []byte
+	Ciphertext []byte
+	UpdatedAt  time.Time
+}
+
+// GlobalSetting is the retention policy read from the root-level settings
+// by LoadGlobalSetting.
 type GlobalSetting struct {
-	LogRetentionDays int
+	LogRetentionDays int   // logs and run dirs of older runs are removed; 0 keeps them
+	LogRetentionRuns int   // runs kept per job and branch; 0 keeps all
+	CILogMaxSize     int64 // ci.log is rotated to ci.log.1 past this size
+	PruneJobRows     bool  // also delete the rows of removed runs
 }
 
 type Job struct {
@@ -24,11 +43,52 @@ type Job struct {
 	Branch       string
 	SHA          string
 	CommitAuthor string
+	Reason       string // why the job was queued (path match, first run, restart)
+	PRNumber     int    // pull request number for pr:<n> runs, 0 otherwise
+	Command      string // resolved command line(s) the run executes
 	LogPath      string
 	Start        time.Time
 	End          time.Time
 	Status       string
 	Msg          string
+	PeakMemory   int64         // peak memory of the run in bytes, 0 when unknown
+	CPUTime      time.Duration // user+system CPU time of the run
+}
+
+// JobUsage is the resource usage measured for one run.
+type JobUsage struct {
+	PeakMemory int64
+	CPUTime    time.Duration
+}
+
+// Commit is the metadata of one commit, captured when a job is queued.
+type Commit struct {
+	Repo           string
+	SHA            string
+	Subject        string
+	Body           string
+	AuthorName     string
+	AuthorEmail    string
+	AuthorTime     time.Time
+	CommitterName  string
+	CommitterEmail string
+	CommitTime     time.Time
+	Parents        []string
+}
+
+// JobStep is one step of a job run. Its output is the byte range
+// [LogStart, LogEnd) of the job log file.
+type JobStep struct {
+	RunID    string
+	Index    int
+	Name     string
+	Command  string
+	Status   string
+	Start    time.Time
+	End      time.Time
+	LogStart int64
+	LogEnd   int64
+	Msg      string
 }
 
 var (
@@ -37,21 +97,76 @@ var (
 	StatusCanceled = "canceled"
 	StatusFailed   = "failed"
 	StatusFinished = "finished"
+	StatusSkipped  = "skipped" // evaluated but intentionally not run
 )
 
+// LogSearch selects the job log lines SearchLogs returns.
+type LogSearch struct {
+	Pattern string    // substring, ignoring case unless it has upper-case letters
+	Repo    string    // all repos when empty
+	Job     string    // all jobs when empty
+	Since   time.Time // runs started at or after; all when zero
+	Limit   int       // at most this many lines; all when 0
+}
+
+// LogMatch is one job log line matching a search. Line counts from 0.
+type LogMatch struct {
+	Job  Job
+	Line int
+	Text string
+}
+
+// JobFilter selects jobs for ListJob, newest first. Empty fields match
+// everything.
 type JobFilter struct {
-	Repo   string
-	Name   string
-	Branch string
-	Status string
-	Limit  int
+	Repo          string
+	Name          string
+	Branch        string
+	Status        string
+	ExcludeStatus string
+	Author        string    // part of the commit author, ignoring case
+	Since         time.Time // started at or after
+	Until         time.Time // started before
+	After         JobCursor // for the next page: only jobs listed after this one
+	Limit         int
+}
+
+// JobCursor is a job's position in ListJob's order, for keyset paging.
+type JobCursor struct {
+	Start time.Time
+	RunID string
+}
+
+// Cursor is the position to page from after the job.
+func (j Job) Cursor() JobCursor {
+	return JobCursor{Start: j.Start, RunID: j.RunID}
 }
 
 type DbRepo interface {
 	LatestJobByNameBranch(repo, name, branch string) (Job, error)
 	JobByRunID(runID string) (Job, error)
-	CreateJob(runID, repo, name, branch, sha, commitAuthor string) error
+	CreateJob(job Job) error                            // inserts a pending row; only identity, author, reason and command are used
 	UpdateJob(runID, status, msg, logPath string) error // for cancel, or finish etc
+	UpdateJobUsage(runID string, usage JobUsage) error
 	ListJob(filter JobFilter) ([]Job, error)
 	ListJobNames(repo string) ([]string, error)
+	UpsertCommit(c Commit) error
+	CommitBySHA(repo, sha string) (Commit, error)       // zero Commit when unknown
+	CreateJobSteps(runID string, steps []JobStep) error // inserts pending step rows (name, command) in order
+	UpdateJobStep(step JobStep) error                   // status, msg and log range by run id and index
+	ListJobSteps(runID string) ([]JobStep, error)
+	RepoSettings(repo string) ([]RepoSetting, error)
+	SetRepoSetting(s RepoSetting) error
+	DeleteRepoSetting(repo, key string) error
+	ListSecrets(repo string) ([]Secret, error) // repo "" lists the global secrets; a repo lists its repo and job secrets
+	SetSecret(s Secret) error
+	DeleteSecret(repo, job, key string) error
+	DeleteJob(runID string) error                                 // removes the job row, its steps, test cases and indexed log
+	IndexJobLog(runID string, lines iter.Seq2[int, string]) error // replaces the run's lines (number, text) in the log search index
+	DeleteJobLogIndex(runID string) error
+	ListUnindexedJobs(repo string) ([]Job, error)       // done runs with a log that is not in the search index
+	SearchJobLogs(search LogSearch) ([]LogMatch, error) // oldest run first; matches ignore case
+	ReplaceTestCases(runID string, cases []TestCase) error
+	ListTestCases(runID string) ([]TestCase, error)                    // in report order
+	TestCaseHistory(repo, job string, runs int) ([]TestCaseRun, error) // results of the job's last runs with reports, newest run first
 }
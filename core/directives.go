@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Values of SettingDirectiveScope.
+const (
+	DirectiveScopeHead  = "head"  // only the new head commit's message counts
+	DirectiveScopeRange = "range" // every commit pushed since the last evaluated sha counts
+)
+
+// CommitDirectives are the run controls found in commit messages:
+//
+//	[skip ci] or [ci skip]   skip every job
+//	refci-skip: lint, docs   skip the named jobs ("*" for all)
+//	refci-run: deploy        run the named jobs ("*" for all), bypassing path
+//	                         filters and if: expressions
+//
+// Markers are matched case-insensitively anywhere in the message; refci-skip
+// and refci-run are read from trailer lines and may repeat.
+type CommitDirectives struct {
+	SkipAll bool
+	Skip    []string
+	Run     []string
+	// Sources names the commits each directive came from, for skip reasons.
+	Sources map[string]string
+}
+
+// DirectiveAction is what the directives ask for one job.
+type DirectiveAction int
+
+const (
+	DirectiveNone DirectiveAction = iota
+	DirectiveSkip
+	DirectiveRun
+)
+
+// ParseCommitDirectives reads the directives in one commit message; sha is
+// only used to label where they came from.
+func ParseCommitDirectives(sha, message string) CommitDirectives {
+	var d CommitDirectives
+	lower := strings.ToLower(message)
+	if strings.Contains(lower, "[skip ci]") || strings.Contains(lower, "[ci skip]") {
+		d.SkipAll = true
+		d.source("[skip ci]", sha)
+	}
+	for _, line := range strings.Split(message, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		var list *[]string
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "refci-skip":
+			list = &d.Skip
+		case "refci-run":
+			list = &d.Run
+		default:
+			continue
+		}
+		for _, job := range splitSettingList(value) {
+			*list = append(*list, job)
+			d.source(strings.ToLower(strings.TrimSpace(key))+": "+job, sha)
+		}
+	}
+	return d
+}
+
+func (d *CommitDirectives) source(directive, sha string) {
+	if d.Sources == nil {
+		d.Sources = map[string]string{}
+	}
+	if _, ok := d.Sources[directive]; !ok {
+		d.Sources[directive] = shortSHA(sha)
+	}
+}
+
+// merge adds other's directives to d.
+func (d *CommitDirectives) merge(other CommitDirectives) {
+	d.SkipAll = d.SkipAll || other.SkipAll
+	d.Skip = append(d.Skip, other.Skip...)
+	d.Run = append(d.Run, other.Run...)
+	for directive, sha := range other.Sources {
+		d.source(directive, sha)
+	}
+}
+
+// Decide returns the action for job and a reason for the job row. A directive
+// naming the job beats a wildcard, and at the same level run beats skip:
+// refci-run: job > refci-skip: job > refci-run: * > refci-skip: * / [skip ci].
+func (d CommitDirectives) Decide(job string) (DirectiveAction, string) {
+	switch {
+	case containsString(d.Run, job):
+		return DirectiveRun, d.reason("refci-run: " + job)
+	case containsString(d.Skip, job):
+		return DirectiveSkip, d.reason("refci-skip: " + job)
+	case containsString(d.Run, "*"):
+		return DirectiveRun, d.reason("refci-run: *")
+	case containsString(d.Skip, "*"):
+		return DirectiveSkip, d.reason("refci-skip: *")
+	case d.SkipAll:
+		return DirectiveSkip, d.reason("[skip ci]")
+	}
+	return DirectiveNone, ""
+}
+
+func (d CommitDirectives) reason(directive string) string {
+	return fmt.Sprintf("commit directive %s in %s", directive, d.Sources[directive])
+}
+
+// DirectiveReader reads and caches the directives of pushes within one poll.
+type DirectiveReader struct {
+	repo  string
+	scope string
+	cache map[string]CommitDirectives
+}
+
+// NewDirectiveReader returns a reader for repo using scope (head or range).
+func NewDirectiveReader(repo, scope string) *DirectiveReader {
+	return &DirectiveReader{repo: repo, scope: scope, cache: map[string]CommitDirectives{}}
+}
+
+// For returns the directives of the push from prevSHA to sha. In range scope
+// every commit since the merge base counts; without a usable prevSHA only sha
+// itself is read.
+func (r *DirectiveReader) For(ctx context.Context, prevSHA, sha string) (CommitDirectives, error) {
+	if r.scope != DirectiveScopeRange {
+		prevSHA = ""
+	}
+	key := prevSHA + ".." + sha
+	if d, ok := r.cache[key]; ok {
+		return d, nil
+	}
+
+	d, err := r.read(ctx, prevSHA, sha)
+	if err != nil {
+		return CommitDirectives{}, err
+	}
+	r.cache[key] = d
+	return d, nil
+}
+
+func (r *DirectiveReader) read(ctx context.Context, prevSHA, sha string) (CommitDirectives, error) {
+	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(r.repo))
+	rev := []string{"-1", sha}
+	if prevSHA != "" && commitExists(ctx, mirrorPath, prevSHA) {
+		base, ok, err := mergeBase(ctx, mirrorPath, prevSHA, sha)
+		if err != nil {
+			return CommitDirectives{}, err
+		}
+		if ok && base != sha {
+			rev = []string{base + ".." + sha}
+		}
+	}
+
+	args := append([]string{"log", "--format=%H%x00%B%x1e"}, rev...)
+	out, err := runGitOutput(ctx, mirrorPath, args...)
+	if err != nil {
+		return CommitDirectives{}, err
+	}
+	var d CommitDirectives
+	for _, record := range strings.Split(out, "\x1e") {
+		commitSHA, message, ok := strings.Cut(strings.TrimLeft(record, "\n"), "\x00")
+		if !ok {
+			continue
+		}
+		d.merge(ParseCommitDirectives(commitSHA, message))
+	}
+	return d, nil
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCommitDirectivesDecide(t *testing.T) {
+	tests := []struct {
+		message string
+		job     string
+		want    DirectiveAction
+		reason  string
+	}{
+		{"Fix typo", "test", DirectiveNone, ""},
+		{"Fix typo [skip ci]", "test", DirectiveSkip, "commit directive [skip ci] in abc1234def56"},
+		{"Fix typo [CI SKIP]", "test", DirectiveSkip, "commit directive [skip ci] in abc1234def56"},
+		{"Docs\n\nrefci-skip: lint, test", "test", DirectiveSkip, "commit directive refci-skip: test in abc1234def56"},
+		{"Docs\n\nrefci-skip: lint", "test", DirectiveNone, ""},
+		{"Docs\n\nRefci-Skip: *", "test", DirectiveSkip, "commit directive refci-skip: * in abc1234def56"},
+		{"Deploy [skip ci]\n\nrefci-run: deploy", "deploy", DirectiveRun, "commit directive refci-run: deploy in abc1234def56"},
+		{"Deploy [skip ci]\n\nrefci-run: deploy", "test", DirectiveSkip, "commit directive [skip ci] in abc1234def56"},
+		{"All\n\nrefci-run: *\nrefci-skip: slow", "slow", DirectiveSkip, "commit directive refci-skip: slow in abc1234def56"},
+		{"All\n\nrefci-run: *\nrefci-skip: slow", "test", DirectiveRun, "commit directive refci-run: * in abc1234def56"},
+	}
+	for _, tt := range tests {
+		d := ParseCommitDirectives("abc1234def567890", tt.message)
+		got, reason := d.Decide(tt.job)
+		if got != tt.want || reason != tt.reason {
+			t.Fatalf("Decide(%q, %q) = %v, %q; want %v, %q", tt.message, tt.job, got, reason, tt.want, tt.reason)
+		}
+	}
+}
+
+func TestDirectiveReaderScopes(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	prev := src.commit(t, "README.md", "v1\n", "initial")
+	src.commit(t, "a.txt", "a\n", "WIP [skip ci]")
+	head := src.commit(t, "b.txt", "b\n", "Finish\n\nrefci-skip: lint")
+	src.mirror(t, "acme/app")
+	ctx := context.Background()
+
+	d, err := NewDirectiveReader("acme/app", DirectiveScopeHead).For(ctx, prev, head)
+	if err != nil {
+		t.Fatalf("For(head) error = %v", err)
+	}
+	if action, _ := d.Decide("test"); action != DirectiveNone {
+		t.Fatalf("head scope Decide(test) = %v, want none: [skip ci] is not on the tip", action)
+	}
+	if action, _ := d.Decide("lint"); action != DirectiveSkip {
+		t.Fatalf("head scope Decide(lint) = %v, want skip", action)
+	}
+
+	d, err = NewDirectiveReader("acme/app", DirectiveScopeRange).For(ctx, prev, head)
+	if err != nil {
+		t.Fatalf("For(range) error = %v", err)
+	}
+	if action, _ := d.Decide("test"); action != DirectiveSkip {
+		t.Fatalf("range scope Decide(test) = %v, want skip from an earlier pushed commit", action)
+	}
+
+	d, err = NewDirectiveReader("acme/app", DirectiveScopeRange).For(ctx, "", prev)
+	if err != nil {
+		t.Fatalf("For(first run) error = %v", err)
+	}
+	if action, _ := d.Decide("test"); action != DirectiveNone {
+		t.Fatalf("range scope without previous sha Decide(test) = %v, want none", action)
+	}
+}
@@ -0,0 +1,312 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// EnvVar is one assignment of an env file.
+type EnvVar struct {
+	Key    string
+	Value  string
+	Secret bool // the line was prefixed with "secret"
+	Line   int
+}
+
+// DotenvError is a syntax error in an env file.
+type DotenvError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *DotenvError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// ReadDotenv parses the env file at path; ${VAR} falls back to refci's own
+// environment for variables the file does not define.
+func ReadDotenv(path string) ([]EnvVar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDotenv(path, data, os.LookupEnv)
+}
+
+// ParseDotenv parses env file data with dotenv semantics:
+//
+//	KEY=value                 unquoted, "# comment" after whitespace is dropped
+//	KEY='literal $value'      no escapes or interpolation, may span lines
+//	KEY="a\n\"b\" ${OTHER}"   \n \r \t \" \\ \$ escapes and interpolation, may span lines
+//	export KEY=value          export is ignored
+//	secret KEY=value          the value is masked in job logs
+//
+// $VAR, ${VAR} and ${VAR:-default} expand to variables defined earlier in
+// the file, then to lookup. name labels errors.
+func ParseDotenv(name string, data []byte, lookup func(string) (string, bool)) ([]EnvVar, error) {
+	p := dotenvParser{name: name, src: string(data), line: 1, lookup: lookup, vars: map[string]string{}}
+	var out []EnvVar
+	for {
+		v, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return out, nil
+		}
+		p.vars[v.Key] = v.Value
+		out = append(out, v)
+	}
+}
+
+type dotenvParser struct {
+	name   string
+	src    string
+	pos    int
+	line   int
+	lookup func(string) (string, bool)
+	vars   map[string]string
+}
+
+func (p *dotenvParser) errorf(line int, format string, args ...any) error {
+	return &DotenvError{File: p.name, Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *dotenvParser) peek() byte {
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *dotenvParser) advance() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+func (p *dotenvParser) skipBlanks() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// word reads up to whitespace, '=' or a comment.
+func (p *dotenvParser) word() string {
+	start := p.pos
+	for p.pos < len(p.src) && !strings.ContainsRune(" \t\r\n=#", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+// next parses the next assignment; ok is false at the end of the file.
+func (p *dotenvParser) next() (EnvVar, bool, error) {
+	for {
+		p.skipBlanks()
+		switch p.peek() {
+		case 0:
+			return EnvVar{}, false, nil
+		case '\n':
+			p.advance()
+			continue
+		case '#':
+			p.skipLine()
+			continue
+		}
+		break
+	}
+
+	v := EnvVar{Line: p.line}
+	key := p.word()
+	for (key == "export" || key == "secret") && (p.peek() == ' ' || p.peek() == '\t') {
+		v.Secret = v.Secret || key == "secret"
+		p.skipBlanks()
+		key = p.word()
+	}
+	if key == "" {
+		return EnvVar{}, false, p.errorf(v.Line, "expected KEY=value")
+	}
+	if !envKeyPattern.MatchString(key) {
+		return EnvVar{}, false, p.errorf(v.Line, "invalid variable name %q", key)
+	}
+	v.Key = key
+
+	p.skipBlanks()
+	if p.peek() != '=' {
+		return EnvVar{}, false, p.errorf(v.Line, "expected = after %s", key)
+	}
+	p.advance()
+	p.skipBlanks()
+
+	var err error
+	switch p.peek() {
+	case '\'':
+		v.Value, err = p.singleQuoted()
+	case '"':
+		v.Value, err = p.doubleQuoted()
+	default:
+		v.Value, err = p.unquoted()
+	}
+	if err != nil {
+		return EnvVar{}, false, err
+	}
+
+	// Only a comment may follow a quoted value.
+	p.skipBlanks()
+	switch p.peek() {
+	case 0, '\n':
+	case '#':
+		p.skipLine()
+	default:
+		return EnvVar{}, false, p.errorf(p.line, "unexpected %q after the value of %s", p.peek(), key)
+	}
+	return v, true, nil
+}
+
+func (p *dotenvParser) singleQuoted() (string, error) {
+	startLine := p.line
+	p.advance()
+	start := p.pos
+	for p.pos < len(p.src) {
+		if p.src[p.pos] == '\'' {
+			value := p.src[start:p.pos]
+			p.advance()
+			return value, nil
+		}
+		p.advance()
+	}
+	return "", p.errorf(startLine, "unterminated single-quoted value")
+}
+
+func (p *dotenvParser) doubleQuoted() (string, error) {
+	startLine := p.line
+	p.advance()
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.advance()
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos >= len(p.src) {
+				continue
+			}
+			switch e := p.advance(); e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(e)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		case '$':
+			if err := p.expand(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf(startLine, "unterminated double-quoted value")
+}
+
+// unquoted reads to the end of the line; a '#' at the start or after
+// whitespace starts a comment.
+func (p *dotenvParser) unquoted() (string, error) {
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '\n' {
+			break
+		}
+		if c == '#' && (b.Len() == 0 || strings.ContainsRune(" \t", rune(p.src[p.pos-1]))) {
+			p.skipLine()
+			break
+		}
+		p.advance()
+		if c == '$' {
+			if err := p.expand(&b); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return strings.TrimRight(b.String(), " \t\r"), nil
+}
+
+// expand writes the variable reference following a '$' to b; a '$' that
+// does not start a reference is kept as is.
+func (p *dotenvParser) expand(b *strings.Builder) error {
+	line := p.line
+	if p.peek() == '{' {
+		end := strings.IndexAny(p.src[p.pos:], "}\n")
+		if end < 0 || p.src[p.pos+end] != '}' {
+			return p.errorf(line, "unterminated ${ in value")
+		}
+		ref := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		name, fallback, hasFallback := strings.Cut(ref, ":-")
+		if !envKeyPattern.MatchString(name) {
+			return p.errorf(line, "invalid variable reference ${%s}", ref)
+		}
+		value, ok := p.resolve(name)
+		if (!ok || value == "") && hasFallback {
+			value = fallback
+		}
+		b.WriteString(value)
+		return nil
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && isEnvNameByte(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		b.WriteByte('$')
+		return nil
+	}
+	value, _ := p.resolve(p.src[start:p.pos])
+	b.WriteString(value)
+	return nil
+}
+
+func (p *dotenvParser) resolve(name string) (string, bool) {
+	if v, ok := p.vars[name]; ok {
+		return v, true
+	}
+	if p.lookup != nil {
+		return p.lookup(name)
+	}
+	return "", false
+}
+
+func isEnvNameByte(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "HOME" {
+			return "/home/ci", true
+		}
+		return "", false
+	}
+	tests := []struct {
+		name    string
+		input   string
+		want    string // KEY=value pairs joined by "|"; secrets prefixed with "!"
+		wantErr string
+	}{
+		{name: "plain", input: "A=1\nB = two words \n", want: "A=1|B=two words"},
+		{name: "comments", input: "# c\n\nA=1 # note\nB=a#b\nC=#x\n", want: "A=1|B=a#b|C="},
+		{name: "export and secret", input: "export A=1\nsecret B=2\nexport secret C=3\nexport=4\n", want: "A=1|!B=2|!C=3|export=4"},
+		{name: "quote in other quotes", input: `A="a'b"` + "\n" + `B='say "hi"'` + "\n", want: `A=a'b|B=say "hi"`},
+		{name: "double-quoted escapes", input: `A="x\ny\t\"z\"\\ \$HOME"`, want: "A=x\ny\t\"z\"\\ $HOME"},
+		{name: "single quotes are literal", input: `A='x\n $HOME'`, want: `A=x\n $HOME`},
+		{name: "interpolation", input: "A=one\nB=${A}-$A-${HOME}\nC=\"${MISSING:-dflt} $UNSET.\"\nD=cost $5\n", want: "A=one|B=one-one-/home/ci|C=dflt .|D=cost $5"},
+		{name: "multi-line", input: "KEY=\"-----BEGIN-----\nabc\n-----END-----\"\nNEXT='a\nb' # c\n", want: "KEY=-----BEGIN-----\nabc\n-----END-----|NEXT=a\nb"},
+		{name: "later wins in interpolation", input: "A=1\nA=2\nB=$A\n", want: "A=1|A=2|B=2"},
+		{name: "missing equals", input: "A=1\nJUST_A_WORD\n", wantErr: ".env:2: expected = after JUST_A_WORD"},
+		{name: "bad name", input: "\n1A=x\n", wantErr: ".env:2: invalid variable name \"1A\""},
+		{name: "unterminated quote", input: "A=1\nB=\"open\nstill open\n", wantErr: ".env:2: unterminated double-quoted value"},
+		{name: "text after quote", input: "A='x' y\n", wantErr: ".env:1: unexpected 'y' after the value of A"},
+		{name: "unterminated reference", input: "A=1\n\nB=${A\n", wantErr: ".env:3: unterminated ${ in value"},
+		{name: "line after multi-line value", input: "A=\"x\ny\"\nB\n", wantErr: ".env:3: expected = after B"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := ParseDotenv(".env", []byte(tt.input), lookup)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ParseDotenv() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDotenv() error = %v", err)
+			}
+			var got []string
+			for _, v := range vars {
+				pair := v.Key + "=" + v.Value
+				if v.Secret {
+					pair = "!" + pair
+				}
+				got = append(got, pair)
+			}
+			if strings.Join(got, "|") != tt.want {
+				t.Fatalf("ParseDotenv() = %q, want %q", strings.Join(got, "|"), tt.want)
+			}
+		})
+	}
+}
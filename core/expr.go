@@ -0,0 +1,496 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Expr is a compiled if: expression. The language is deliberately small:
+//
+//	branch == default_branch && !contains(message, '[skip ci]')
+//	author_email == "bot@example.com" || env.FORCE_CI != ""
+//	changed('services/**') && startsWith(branch, 'release-')
+//
+// Values are strings or booleans. Variables are branch, sha, message, author,
+// author_email, default_branch and env.NAME (empty when unset). Functions are
+// contains, startsWith, endsWith (all case-sensitive) and changed(pattern...),
+// which uses the path_patterns glob rules. == and != compare the string forms;
+// && , || and ! use truthiness, where a non-empty string is true.
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// ExprEnv is what an expression is evaluated against.
+type ExprEnv struct {
+	Branch        string
+	SHA           string
+	Message       string
+	Author        string
+	AuthorEmail   string
+	DefaultBranch string
+	Env           map[string]string
+	// ChangedFiles are the files touched since the previous run. When
+	// AllChanged is set the previous run is unknown (first run, unreachable
+	// sha) and changed() is always true.
+	ChangedFiles []string
+	AllChanged   bool
+}
+
+// ExprError is a parse error at a byte offset in the expression.
+type ExprError struct {
+	Offset int
+	Msg    string
+}
+
+func (e ExprError) Error() string {
+	return fmt.Sprintf("at character %d: %s", e.Offset+1, e.Msg)
+}
+
+var exprVariables = []string{"branch", "sha", "message", "author", "author_email", "default_branch"}
+
+var exprFuncs = map[string]int{
+	"contains":   2,
+	"startsWith": 2,
+	"endsWith":   2,
+	"changed":    -1,
+}
+
+// ParseExpr compiles src. Unknown variables and functions, wrong argument
+// counts and bad changed() globs are reported here so conf.yml validation can
+// catch them before a poll.
+func ParseExpr(src string) (*Expr, error) {
+	p := &exprParser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, ExprError{Offset: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source the expression was parsed from.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval reports whether the expression is true for env.
+func (e *Expr) Eval(env ExprEnv) bool {
+	return e.root.eval(env).truthy()
+}
+
+type exprValue struct {
+	s      string
+	b      bool
+	isBool bool
+}
+
+func boolValue(b bool) exprValue {
+	return exprValue{b: b, isBool: true}
+}
+
+func (v exprValue) truthy() bool {
+	if v.isBool {
+		return v.b
+	}
+	return v.s != ""
+}
+
+func (v exprValue) String() string {
+	if v.isBool {
+		if v.b {
+			return "true"
+		}
+		return "false"
+	}
+	return v.s
+}
+
+type exprNode interface {
+	eval(env ExprEnv) exprValue
+}
+
+type (
+	literalNode  struct{ v exprValue }
+	variableNode struct{ name string }
+	envNode      struct{ name string }
+	notNode      struct{ x exprNode }
+	binaryNode   struct {
+		op   string
+		l, r exprNode
+	}
+	callNode struct {
+		name string
+		args []exprNode
+	}
+)
+
+func (n literalNode) eval(ExprEnv) exprValue { return n.v }
+
+func (n variableNode) eval(env ExprEnv) exprValue {
+	switch n.name {
+	case "branch":
+		return exprValue{s: env.Branch}
+	case "sha":
+		return exprValue{s: env.SHA}
+	case "message":
+		return exprValue{s: env.Message}
+	case "author":
+		return exprValue{s: env.Author}
+	case "author_email":
+		return exprValue{s: env.AuthorEmail}
+	case "default_branch":
+		return exprValue{s: env.DefaultBranch}
+	}
+	return exprValue{}
+}
+
+func (n envNode) eval(env ExprEnv) exprValue { return exprValue{s: env.Env[n.name]} }
+
+func (n notNode) eval(env ExprEnv) exprValue { return boolValue(!n.x.eval(env).truthy()) }
+
+func (n binaryNode) eval(env ExprEnv) exprValue {
+	switch n.op {
+	case "&&":
+		return boolValue(n.l.eval(env).truthy() && n.r.eval(env).truthy())
+	case "||":
+		return boolValue(n.l.eval(env).truthy() || n.r.eval(env).truthy())
+	case "==":
+		return boolValue(n.l.eval(env).String() == n.r.eval(env).String())
+	default:
+		return boolValue(n.l.eval(env).String() != n.r.eval(env).String())
+	}
+}
+
+func (n callNode) eval(env ExprEnv) exprValue {
+	args := make([]string, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(env).String()
+	}
+	switch n.name {
+	case "contains":
+		return boolValue(strings.Contains(args[0], args[1]))
+	case "startsWith":
+		return boolValue(strings.HasPrefix(args[0], args[1]))
+	case "endsWith":
+		return boolValue(strings.HasSuffix(args[0], args[1]))
+	}
+	// changed
+	if env.AllChanged {
+		return boolValue(true)
+	}
+	for _, file := range env.ChangedFiles {
+		if pathSelected(file, args, nil) {
+			return boolValue(true)
+		}
+	}
+	return boolValue(false)
+}
+
+type exprTokenKind int
+
+const (
+	tokEOF exprTokenKind = iota
+	tokIdent
+	tokString
+	tokOp
+)
+
+type exprToken struct {
+	kind exprTokenKind
+	text string
+	pos  int
+}
+
+func (t exprToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type exprParser struct {
+	src  string
+	toks []exprToken
+	i    int
+}
+
+func (p *exprParser) lex() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return ExprError{Offset: i, Msg: "unterminated string"}
+			}
+			p.toks = append(p.toks, exprToken{kind: tokString, text: b.String(), pos: i})
+			i = j + 1
+		case isIdentByte(c, true):
+			j := i
+			for j < len(src) && (isIdentByte(src[j], false) || src[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, exprToken{kind: tokIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "&&", "||", "!", "(", ")", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return ExprError{Offset: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			p.toks = append(p.toks, exprToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	p.toks = append(p.toks, exprToken{kind: tokEOF, pos: len(src)})
+	return nil
+}
+
+func isIdentByte(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.toks[p.i]
+}
+
+func (p *exprParser) next() exprToken {
+	tok := p.toks[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *exprParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = binaryNode{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!="} {
+		if p.accept(op) {
+			r, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return binaryNode{op: op, l: l, r: r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return literalNode{v: exprValue{s: tok.text}}, nil
+	case tokOp:
+		if tok.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, ExprError{Offset: p.peek().pos, Msg: fmt.Sprintf("expected ) but found %s", p.peek())}
+			}
+			return x, nil
+		}
+	case tokIdent:
+		if p.accept("(") {
+			return p.parseCall(tok)
+		}
+		return identNode(tok)
+	}
+	return nil, ExprError{Offset: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+}
+
+func identNode(tok exprToken) (exprNode, error) {
+	switch {
+	case tok.text == "true" || tok.text == "false":
+		return literalNode{v: boolValue(tok.text == "true")}, nil
+	case strings.HasPrefix(tok.text, "env."):
+		name := strings.TrimPrefix(tok.text, "env.")
+		if name == "" || strings.Contains(name, ".") {
+			return nil, ExprError{Offset: tok.pos, Msg: fmt.Sprintf("bad env reference %q", tok.text)}
+		}
+		return envNode{name: name}, nil
+	case containsString(exprVariables, tok.text):
+		return variableNode{name: tok.text}, nil
+	}
+	msg := fmt.Sprintf("unknown variable %q", tok.text)
+	if hint := closestName(tok.text, exprVariables); hint != "" {
+		msg += fmt.Sprintf(" (did you mean %s?)", hint)
+	}
+	return nil, ExprError{Offset: tok.pos, Msg: msg}
+}
+
+func (p *exprParser) parseCall(name exprToken) (exprNode, error) {
+	arity, ok := exprFuncs[name.text]
+	if !ok {
+		names := make([]string, 0, len(exprFuncs))
+		for fn := range exprFuncs {
+			names = append(names, fn)
+		}
+		msg := fmt.Sprintf("unknown function %q", name.text)
+		if hint := closestName(name.text, names); hint != "" {
+			msg += fmt.Sprintf(" (did you mean %s?)", hint)
+		}
+		return nil, ExprError{Offset: name.pos, Msg: msg}
+	}
+
+	var args []exprNode
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, ExprError{Offset: p.peek().pos, Msg: fmt.Sprintf("expected , or ) but found %s", p.peek())}
+			}
+		}
+	}
+
+	switch {
+	case arity >= 0 && len(args) != arity:
+		return nil, ExprError{Offset: name.pos, Msg: fmt.Sprintf("%s takes %d arguments, got %d", name.text, arity, len(args))}
+	case arity < 0 && len(args) == 0:
+		return nil, ExprError{Offset: name.pos, Msg: fmt.Sprintf("%s needs at least one pattern", name.text)}
+	}
+	if name.text == "changed" {
+		for _, arg := range args {
+			lit, ok := arg.(literalNode)
+			if !ok {
+				continue
+			}
+			for _, part := range splitPathParts(normalizeRepoRelPath(strings.TrimPrefix(lit.v.s, "!"))) {
+				if _, err := path.Match(part, ""); err != nil {
+					return nil, ExprError{Offset: name.pos, Msg: fmt.Sprintf("bad glob %q: %v", lit.v.s, err)}
+				}
+			}
+		}
+	}
+	return callNode{name: name.text, args: args}, nil
+}
+
+// NewExprEnv gathers what an if: expression can see for branch at sha: the
+// commit metadata, the repo's default branch, the files pushed since prevSHA
+// and the job environment (the process env overlaid with env).
+func NewExprEnv(ctx context.Context, repo, branch, prevSHA, sha string, env []string) (ExprEnv, error) {
+	commit, err := CommitAtSHA(ctx, repo, sha)
+	if err != nil {
+		return ExprEnv{}, fmt.Errorf("read commit: %w", err)
+	}
+	defaultBranch, err := DefaultBranch(ctx, repo)
+	if err != nil {
+		return ExprEnv{}, fmt.Errorf("read default branch: %w", err)
+	}
+	files, known, _, err := PushedChanges(ctx, repo, prevSHA, sha)
+	if err != nil {
+		return ExprEnv{}, fmt.Errorf("list changed files: %w", err)
+	}
+
+	vars := map[string]string{}
+	for _, kv := range append(jobProcessEnv(), env...) {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+	message := commit.Subject
+	if commit.Body != "" {
+		message += "\n\n" + commit.Body
+	}
+	return ExprEnv{
+		Branch:        branch,
+		SHA:           sha,
+		Message:       message,
+		Author:        commit.AuthorName,
+		AuthorEmail:   commit.AuthorEmail,
+		DefaultBranch: defaultBranch,
+		Env:           vars,
+		ChangedFiles:  files,
+		AllChanged:    !known,
+	}, nil
+}
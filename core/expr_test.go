@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	env := ExprEnv{
+		Branch:        "main",
+		SHA:           "abc123",
+		Message:       "Fix parser\n\n[skip ci]",
+		Author:        "Alice",
+		AuthorEmail:   "alice@example.com",
+		DefaultBranch: "main",
+		Env:           map[string]string{"DEPLOY": "1"},
+		ChangedFiles:  []string{"services/api/main.go", "docs/guide.md"},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`branch == default_branch`, true},
+		{`branch != 'main'`, false},
+		{`!contains(message, "[skip ci]")`, false},
+		{`author == 'Alice' && endsWith(author_email, '@example.com')`, true},
+		{`startsWith(branch, 'release-') || env.DEPLOY == '1'`, true},
+		{`env.MISSING`, false},
+		{`env.DEPLOY`, true},
+		{`!(branch == 'main' && false)`, true},
+		{`true == 'true'`, true},
+		{`changed('services/**')`, true},
+		{`changed('web/**', '**/*.md')`, true},
+		{`changed('docs/**', '!**/*.md')`, false},
+		{`contains(message, 'it\'s')`, false},
+	}
+	for _, tt := range tests {
+		expr, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q) error = %v", tt.expr, err)
+		}
+		if got := expr.Eval(env); got != tt.want {
+			t.Fatalf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	expr, _ := ParseExpr(`changed('web/**')`)
+	if expr.Eval(env) {
+		t.Fatal("changed(web/**) should be false")
+	}
+	env.AllChanged = true
+	if !expr.Eval(env) {
+		t.Fatal("changed() should be true when the previous run is unknown")
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`brnch == 'main'`, `at character 1: unknown variable "brnch" (did you mean branch?)`},
+		{`contain(message, 'x')`, `unknown function "contain" (did you mean contains?)`},
+		{`contains(message)`, `contains takes 2 arguments, got 1`},
+		{`changed()`, `changed needs at least one pattern`},
+		{`changed('[a')`, `bad glob "[a"`},
+		{`branch == 'main`, `at character 11: unterminated string`},
+		{`branch = 'main'`, `unexpected character '='`},
+		{`(branch == 'main'`, `expected ) but found end of expression`},
+		{`branch 'main'`, `at character 8: unexpected string "main"`},
+		{`env. == ''`, `bad env reference "env."`},
+	}
+	for _, tt := range tests {
+		_, err := ParseExpr(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Fatalf("ParseExpr(%q) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestNewExprEnvReadsCommitAndChanges(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	prev := src.commit(t, "README.md", "v1\n", "initial")
+	src.git(t, "checkout", "-q", "-b", "feature")
+	sha := src.commit(t, "services/api/main.go", "package main\n", "Add api\n\n[skip ci]")
+	src.git(t, "checkout", "-q", "main")
+	src.mirror(t, "acme/app")
+
+	env, err := NewExprEnv(context.Background(), "acme/app", "feature", prev, sha, []string{"DEPLOY=1"})
+	if err != nil {
+		t.Fatalf("NewExprEnv() error = %v", err)
+	}
+	if env.DefaultBranch != "main" || env.Author != "Alice" || env.Message != "Add api\n\n[skip ci]" || env.Env["DEPLOY"] != "1" {
+		t.Fatalf("NewExprEnv() = default=%q author=%q message=%q DEPLOY=%q", env.DefaultBranch, env.Author, env.Message, env.Env["DEPLOY"])
+	}
+	if env.AllChanged || len(env.ChangedFiles) != 1 || env.ChangedFiles[0] != "services/api/main.go" {
+		t.Fatalf("NewExprEnv() changes = %v (all=%v)", env.ChangedFiles, env.AllChanged)
+	}
+
+	env, err = NewExprEnv(context.Background(), "acme/app", "feature", "", sha, nil)
+	if err != nil {
+		t.Fatalf("NewExprEnv() error = %v", err)
+	}
+	if !env.AllChanged {
+		t.Fatal("NewExprEnv() without a previous sha should mark everything changed")
+	}
+}
@@ -2,15 +2,27 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func CloneMirror(ctx context.Context, repoURL, dstPath string) error {
+// GitConfig is one git config entry applied to a mirror.
+type GitConfig struct {
+	Key   string
+	Value string
+}
+
+// CloneMirror clones repoURL as a mirror at dstPath. config entries are set
+// before the initial fetch and persist in the mirror for later fetches.
+func CloneMirror(ctx context.Context, repoURL, dstPath string, config ...GitConfig) error {
 	url := strings.TrimSpace(repoURL)
 	dst := strings.TrimSpace(dstPath)
 	if url == "" {
@@ -30,7 +42,12 @@ func CloneMirror(ctx context.Context, repoURL, dstPath string) error {
 		return fmt.Errorf("create clone parent dir: %w", err)
 	}
 
-	if err := runGit(ctx, "", "clone", "--mirror", url, dst); err != nil {
+	args := []string{"clone", "--mirror"}
+	for _, c := range config {
+		args = append(args, "--config", c.Key+"="+c.Value)
+	}
+	args = append(args, url, dst)
+	if err := runGit(ctx, "", args...); err != nil {
 		return err
 	}
 	return nil
@@ -54,21 +71,45 @@ func EnsureWorktree(ctx context.Context, repo, branch, sha string) (string, erro
 	}
 
 	shaValue := strings.TrimSpace(sha)
-	if _, err := os.Stat(worktreePath); os.IsNotExist(err) {
-		if err := runGit(ctx, mirrorPath, "worktree", "add", "--detach", worktreePath, shaValue); err != nil {
+	_, err := os.Lstat(worktreePath)
+	switch {
+	case err == nil && ownedWorktree(worktreePath):
+		if err := runGit(ctx, worktreePath, "reset", "--hard", shaValue); err != nil {
 			return "", err
 		}
 		return worktreePath, nil
-	} else if err != nil {
+	case err == nil:
+		// A worktree handed to the run_as sandbox user is never used by git
+		// as refci again: that user could have pointed its .git at a config
+		// or hooks of their own. It is checked out from scratch instead.
+		if err := os.RemoveAll(worktreePath); err != nil {
+			return "", fmt.Errorf("remove sandbox worktree: %w", err)
+		}
+		if err := runGit(ctx, mirrorPath, "worktree", "prune"); err != nil {
+			return "", err
+		}
+	case !os.IsNotExist(err):
 		return "", fmt.Errorf("stat worktree path: %w", err)
 	}
 
-	if err := runGit(ctx, worktreePath, "reset", "--hard", shaValue); err != nil {
+	if err := runGit(ctx, mirrorPath, "worktree", "add", "--detach", worktreePath, shaValue); err != nil {
 		return "", err
 	}
 	return worktreePath, nil
 }
 
+// ownedWorktree reports whether the worktree directory and its .git file
+// are refci's own, not symlinks and not another user's.
+func ownedWorktree(worktreePath string) bool {
+	for _, p := range []string{worktreePath, filepath.Join(worktreePath, ".git")} {
+		info, err := os.Lstat(p)
+		if err != nil || info.Mode()&os.ModeSymlink != 0 || !ownedByEUID(info) {
+			return false
+		}
+	}
+	return true
+}
+
 func ListBranchHeads(ctx context.Context, mirrorPath string) (map[string]string, error) {
 	path := strings.TrimSpace(mirrorPath)
 	if path == "" {
@@ -108,6 +149,88 @@ func ListBranchHeads(ctx context.Context, mirrorPath string) (map[string]string,
 	return heads, nil
 }
 
+// PullRequest is a GitHub pull request as seen through refs/pull/<n>/*.
+// MergeSHA is empty once the PR is closed or cannot be merged.
+type PullRequest struct {
+	Number   int
+	HeadSHA  string
+	MergeSHA string
+}
+
+// pullRequestBranchPrefix holds a ':', which git refnames cannot contain, so
+// a pull request never shares a name with a real branch such as pr/12.
+const pullRequestBranchPrefix = "pr:"
+
+// PullRequestBranch is the branch name pull request runs are recorded under.
+func PullRequestBranch(number int) string {
+	return pullRequestBranchPrefix + strconv.Itoa(number)
+}
+
+func ParsePullRequestBranch(branch string) (int, bool) {
+	raw, ok := strings.CutPrefix(strings.TrimSpace(branch), pullRequestBranchPrefix)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ListPullRequests reads refs/pull/<n>/head and refs/pull/<n>/merge, which
+// GitHub publishes and `clone --mirror` fetches along with branches.
+func ListPullRequests(ctx context.Context, repo string) ([]PullRequest, error) {
+	repoName := strings.TrimSpace(repo)
+	if repoName == "" {
+		return nil, fmt.Errorf("repo is required")
+	}
+
+	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(repoName))
+	out, err := runGitOutput(ctx, mirrorPath, "for-each-ref", "refs/pull", "--format=%(refname)\t%(objectname)")
+	if err != nil {
+		return nil, err
+	}
+
+	byNumber := map[int]*PullRequest{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		row := strings.TrimSpace(line)
+		if row == "" {
+			continue
+		}
+		ref, sha, ok := strings.Cut(row, "\t")
+		if !ok {
+			return nil, fmt.Errorf("invalid git ref row: %q", row)
+		}
+		parts := strings.Split(strings.TrimPrefix(ref, "refs/pull/"), "/")
+		if len(parts) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(parts[0])
+		if err != nil || n <= 0 {
+			continue
+		}
+		pr := byNumber[n]
+		if pr == nil {
+			pr = &PullRequest{Number: n}
+			byNumber[n] = pr
+		}
+		switch parts[1] {
+		case "head":
+			pr.HeadSHA = strings.TrimSpace(sha)
+		case "merge":
+			pr.MergeSHA = strings.TrimSpace(sha)
+		}
+	}
+
+	pulls := make([]PullRequest, 0, len(byNumber))
+	for _, pr := range byNumber {
+		pulls = append(pulls, *pr)
+	}
+	sort.Slice(pulls, func(i, k int) bool { return pulls[i].Number < pulls[k].Number })
+	return pulls, nil
+}
+
 func ListBranchHeadsByPattern(ctx context.Context, repo, branchPattern string) (map[string]string, error) {
 	repoName := strings.TrimSpace(repo)
 	if repoName == "" {
@@ -134,73 +257,154 @@ func ListBranchHeadsByPattern(ctx context.Context, repo, branchPattern string) (
 	return out, nil
 }
 
-func ShouldRunByPathPatterns(ctx context.Context, repo, prevSHA, newSHA string, patterns []string) (bool, error) {
-	if len(patterns) == 0 {
-		return true, nil
+// PathDecision records whether a job's path filters let newSHA run, and why.
+type PathDecision struct {
+	Run    bool
+	Reason string
+}
+
+// PushedChanges lists the files touched by the commits pushed since prevSHA.
+// Changes are taken from the merge base of prevSHA and newSHA, so a force-push
+// or rebase only considers the commits that are actually new. known is false
+// when there is nothing to compare against (no previous sha, unreachable sha,
+// unrelated histories); reason then says why.
+func PushedChanges(ctx context.Context, repo, prevSHA, newSHA string) (files []string, known bool, reason string, err error) {
+	if prevSHA == "" {
+		return nil, false, "first run", nil
+	}
+	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(repo))
+	if !commitExists(ctx, mirrorPath, prevSHA) {
+		return nil, false, fmt.Sprintf("previous sha %s unreachable", shortSHA(prevSHA)), nil
+	}
+	base, ok, err := mergeBase(ctx, mirrorPath, prevSHA, newSHA)
+	if err != nil {
+		return nil, false, "", err
+	}
+	if !ok {
+		return nil, false, fmt.Sprintf("no merge base with previous sha %s", shortSHA(prevSHA)), nil
 	}
 
+	if base == newSHA {
+		// Branch moved back to an ancestor: nothing new was pushed, so compare
+		// the trees directly.
+		files, err = ListChangedFiles(ctx, repo, prevSHA, newSHA)
+	} else {
+		files, err = ListCommitRangeFiles(ctx, repo, base, newSHA)
+	}
+	if err != nil {
+		return nil, false, "", err
+	}
+	return files, true, fmt.Sprintf("%d changed files since %s", len(files), shortSHA(base)), nil
+}
+
+// EvaluatePathFilters checks every file touched by the commits pushed since
+// prevSHA (see PushedChanges) against patterns and ignore. Patterns prefixed
+// with ! exclude paths; the last matching pattern wins, and a list of only
+// exclusions starts from "all files". Files matching any ignore pattern never
+// trigger a run.
+func EvaluatePathFilters(ctx context.Context, repo, prevSHA, newSHA string, patterns, ignore []string) (PathDecision, error) {
 	if newSHA == "" {
-		return false, nil
+		return PathDecision{Reason: "no sha"}, nil
 	}
 	if prevSHA == "" {
-		return true, nil
+		return PathDecision{Run: true, Reason: "first run"}, nil
 	}
 	if prevSHA == newSHA {
-		return false, nil
+		return PathDecision{Reason: "no change"}, nil
+	}
+	if len(patterns) == 0 && len(ignore) == 0 {
+		return PathDecision{Run: true, Reason: fmt.Sprintf("new commits since %s", shortSHA(prevSHA))}, nil
 	}
 
-	files, err := ListChangedFiles(ctx, repo, prevSHA, newSHA)
+	files, known, reason, err := PushedChanges(ctx, repo, prevSHA, newSHA)
 	if err != nil {
-		return false, err
+		return PathDecision{}, err
 	}
-	for _, file := range files {
-		if matchAnyPathPattern(file, patterns) {
-			return true, nil
-		}
+	if !known {
+		return PathDecision{Run: true, Reason: reason}, nil
 	}
-	return false, nil
-}
 
-func LoadJobConfsFromRepo(ctx context.Context, repo, ref string) ([]JobConf, error) {
-	repoName := repo
-	if repoName == "" {
-		return nil, fmt.Errorf("repo is required")
+	var matched []string
+	for _, file := range files {
+		if pathSelected(file, patterns, ignore) {
+			matched = append(matched, file)
+		}
 	}
-	rev := ref
-	if rev == "" {
-		rev = "HEAD"
+	if len(matched) == 0 {
+		return PathDecision{Reason: "path-skip: " + reason + ", none matched"}, nil
 	}
+	return PathDecision{Run: true, Reason: "paths matched: " + summarizeFiles(matched, 3)}, nil
+}
 
-	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(repoName))
-	// get the latest config with git show
-	content, err := runGitOutput(ctx, mirrorPath, "show", rev+":.refci/conf.yml")
+// DefaultBranch returns the branch the mirror's HEAD points at.
+func DefaultBranch(ctx context.Context, repo string) (string, error) {
+	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(repo))
+	out, err := runGitOutput(ctx, mirrorPath, "symbolic-ref", "--short", "HEAD")
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	return strings.TrimSpace(out), nil
+}
 
-	confs := ParseJobConfs(content)
-	for i := range confs {
-		confs[i].Repo = repoName
-	}
-	return confs, nil
+// LoadJobConfsFromRepo reads the job configs at ref from the repo mirror
+// without caching; pollers use a JobConfLoader instead.
+func LoadJobConfsFromRepo(ctx context.Context, repo, ref string) ([]JobConf, error) {
+	return NewJobConfLoader(repo).Load(ctx, ref)
 }
 
-func CommitAuthorAtSHA(ctx context.Context, repo, sha string) (string, error) {
+// commitFormat separates fields with NUL so subjects and bodies can hold
+// anything; the body is last because it may span lines.
+const commitFormat = "%H%x00%P%x00%an%x00%ae%x00%at%x00%cn%x00%ce%x00%ct%x00%s%x00%b"
+
+// CommitAtSHA reads the metadata of one commit from the mirror.
+func CommitAtSHA(ctx context.Context, repo, sha string) (Commit, error) {
 	repoName := strings.TrimSpace(repo)
 	if repoName == "" {
-		return "", fmt.Errorf("repo is required")
+		return Commit{}, fmt.Errorf("repo is required")
 	}
 	shaValue := strings.TrimSpace(sha)
 	if shaValue == "" {
-		return "", fmt.Errorf("sha is required")
+		return Commit{}, fmt.Errorf("sha is required")
 	}
 
 	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(repoName))
-	out, err := runGitOutput(ctx, mirrorPath, "show", "-s", "--format=%an", shaValue)
+	out, err := runGitOutput(ctx, mirrorPath, "show", "-s", "--format="+commitFormat, shaValue)
 	if err != nil {
-		return "", err
+		return Commit{}, err
 	}
-	return strings.TrimSpace(out), nil
+	c, err := parseCommitRecord(out)
+	if err != nil {
+		return Commit{}, err
+	}
+	c.Repo = repoName
+	return c, nil
+}
+
+func parseCommitRecord(raw string) (Commit, error) {
+	fields := strings.SplitN(raw, "\x00", 10)
+	if len(fields) != 10 {
+		return Commit{}, fmt.Errorf("invalid commit record: %q", raw)
+	}
+	authorTime, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return Commit{}, fmt.Errorf("parse author time: %w", err)
+	}
+	commitTime, err := strconv.ParseInt(fields[7], 10, 64)
+	if err != nil {
+		return Commit{}, fmt.Errorf("parse commit time: %w", err)
+	}
+	return Commit{
+		SHA:            strings.TrimSpace(fields[0]),
+		Parents:        strings.Fields(fields[1]),
+		AuthorName:     fields[2],
+		AuthorEmail:    fields[3],
+		AuthorTime:     time.Unix(authorTime, 0).UTC(),
+		CommitterName:  fields[5],
+		CommitterEmail: fields[6],
+		CommitTime:     time.Unix(commitTime, 0).UTC(),
+		Subject:        fields[8],
+		Body:           strings.TrimSpace(fields[9]),
+	}, nil
 }
 
 func ListChangedFiles(ctx context.Context, repo, oldSHA, newSHA string) ([]string, error) {
@@ -216,17 +420,70 @@ func ListChangedFiles(ctx context.Context, repo, oldSHA, newSHA string) ([]strin
 	if err != nil {
 		return nil, err
 	}
+	return splitFileList(out), nil
+}
 
+// ListCommitRangeFiles lists every file touched by any commit in
+// baseSHA..newSHA, including files that a later commit reverted.
+func ListCommitRangeFiles(ctx context.Context, repo, baseSHA, newSHA string) ([]string, error) {
+	if repo == "" {
+		return nil, fmt.Errorf("repo is required")
+	}
+	if baseSHA == "" || newSHA == "" {
+		return nil, nil
+	}
+
+	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(repo))
+	out, err := runGitOutput(ctx, mirrorPath, "log", "--format=", "--name-only", baseSHA+".."+newSHA)
+	if err != nil {
+		return nil, err
+	}
+	return splitFileList(out), nil
+}
+
+func splitFileList(out string) []string {
 	lines := strings.Split(out, "\n")
 	files := make([]string, 0, len(lines))
+	seen := make(map[string]struct{}, len(lines))
 	for _, line := range lines {
 		file := line
 		if file == "" {
 			continue
 		}
+		if _, ok := seen[file]; ok {
+			continue
+		}
+		seen[file] = struct{}{}
 		files = append(files, file)
 	}
-	return files, nil
+	return files
+}
+
+func commitExists(ctx context.Context, mirrorPath, sha string) bool {
+	return runGit(ctx, mirrorPath, "cat-file", "-e", sha+"^{commit}") == nil
+}
+
+// mergeBase returns the best common ancestor of a and b; ok is false when the
+// histories are unrelated.
+func mergeBase(ctx context.Context, mirrorPath, a, b string) (string, bool, error) {
+	cmd := exec.CommandContext(ctx, "git", "merge-base", a, b)
+	cmd.Dir = mirrorPath
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("git merge-base %s %s failed: %w", a, b, err)
+	}
+	return strings.TrimSpace(string(out)), true, nil
+}
+
+func summarizeFiles(files []string, max int) string {
+	if len(files) <= max {
+		return strings.Join(files, ", ")
+	}
+	return fmt.Sprintf("%s (+%d more)", strings.Join(files[:max], ", "), len(files)-max)
 }
 
 func normalizeBranchPattern(pattern string) string {
@@ -259,6 +516,33 @@ func matchAnyPathPattern(file string, patterns []string) bool {
 	return false
 }
 
+// pathSelected applies include patterns (with ! negations, last match wins)
+// and then the ignore list to file.
+func pathSelected(file string, patterns, ignore []string) bool {
+	target := normalizeRepoRelPath(file)
+	included := len(patterns) == 0
+	if !included {
+		included = true
+		for _, p := range patterns {
+			if !strings.HasPrefix(strings.TrimSpace(p), "!") {
+				included = false
+				break
+			}
+		}
+	}
+	for _, p := range patterns {
+		raw := strings.TrimSpace(p)
+		negated := strings.HasPrefix(raw, "!")
+		if matchPathPattern(normalizeRepoRelPath(strings.TrimPrefix(raw, "!")), target) {
+			included = !negated
+		}
+	}
+	if !included {
+		return false
+	}
+	return !matchAnyPathPattern(target, ignore)
+}
+
 func normalizeRepoRelPath(v string) string {
 	s := v
 	s = strings.TrimPrefix(s, "./")
@@ -323,7 +607,7 @@ func toLocalBranch(branch string) string {
 	s = strings.TrimPrefix(s, "refs/")
 	s = strings.ReplaceAll(s, "/", "--")
 	s = strings.ReplaceAll(s, "\\", "--")
-	s = strings.ReplaceAll(s, ":", "_")
+	// ":" is kept so pr:<n> gets its own worktree; no real branch has one.
 	s = strings.ReplaceAll(s, " ", "_")
 	if s == "" {
 		return "default"
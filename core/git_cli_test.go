@@ -0,0 +1,245 @@
+package core
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGitRepo is a throwaway non-bare repo used as a file:// remote.
+type testGitRepo struct {
+	dir string
+}
+
+func newTestGitRepo(t *testing.T) testGitRepo {
+	t.Helper()
+	r := testGitRepo{dir: filepath.Join(t.TempDir(), "src")}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		t.Fatalf("create repo dir: %v", err)
+	}
+	r.git(t, "init", "-q", "-b", "main")
+	return r
+}
+
+func (r testGitRepo) git(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Alice",
+		"GIT_AUTHOR_EMAIL=alice@example.com",
+		"GIT_COMMITTER_NAME=Alice",
+		"GIT_COMMITTER_EMAIL=alice@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes file and commits it, returning the new sha.
+func (r testGitRepo) commit(t *testing.T, file, content, message string) string {
+	t.Helper()
+	p := filepath.Join(r.dir, file)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatalf("create dir for %s: %v", file, err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", file, err)
+	}
+	r.git(t, "add", "-A")
+	r.git(t, "commit", "-q", "-m", message)
+	return r.git(t, "rev-parse", "HEAD")
+}
+
+// mirror clones r into Root/repos as repo and returns the mirror path.
+func (r testGitRepo) mirror(t *testing.T, repo string) string {
+	t.Helper()
+	mirrorPath := filepath.Join(Root, "repos", ToLocalRepo(repo))
+	if err := CloneMirror(context.Background(), "file://"+r.dir, mirrorPath); err != nil {
+		t.Fatalf("CloneMirror() error = %v", err)
+	}
+	return mirrorPath
+}
+
+func TestPathSelected(t *testing.T) {
+	tests := []struct {
+		file     string
+		patterns []string
+		ignore   []string
+		want     bool
+	}{
+		{"services/api/main.go", []string{"services/**"}, nil, true},
+		{"docs/readme.md", []string{"services/**"}, nil, false},
+		{"services/api/README.md", []string{"services/**", "!**/*.md"}, nil, false},
+		{"services/api/main.go", []string{"services/**", "!**/*.md"}, nil, true},
+		{"services/api/CHANGELOG.md", []string{"services/**", "!**/*.md", "services/api/CHANGELOG.md"}, nil, true},
+		{"docs/guide.md", []string{"!docs/**"}, nil, false},
+		{"main.go", []string{"!docs/**"}, nil, true},
+		{"docs/guide.md", nil, []string{"docs/**"}, false},
+		{"services/api/testdata/x.json", []string{"services/**"}, []string{"**/testdata/**"}, false},
+	}
+	for _, tt := range tests {
+		if got := pathSelected(tt.file, tt.patterns, tt.ignore); got != tt.want {
+			t.Fatalf("pathSelected(%q, %q, %q) = %v, want %v", tt.file, tt.patterns, tt.ignore, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluatePathFiltersUsesAllPushedCommits(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	prev := src.commit(t, "README.md", "v1\n", "initial")
+	src.commit(t, "services/api/main.go", "package main\n", "touch api")
+	src.git(t, "rm", "-q", "services/api/main.go")
+	src.git(t, "commit", "-q", "-m", "revert api")
+	head := src.commit(t, "docs/guide.md", "guide\n", "docs")
+	src.mirror(t, "acme/app")
+
+	ctx := context.Background()
+	got, err := EvaluatePathFilters(ctx, "acme/app", prev, head, []string{"services/**"}, nil)
+	if err != nil {
+		t.Fatalf("EvaluatePathFilters() error = %v", err)
+	}
+	if !got.Run || !strings.Contains(got.Reason, "services/api/main.go") {
+		t.Fatalf("EvaluatePathFilters() = %+v, want run on reverted services change", got)
+	}
+
+	got, err = EvaluatePathFilters(ctx, "acme/app", prev, head, []string{"services/**"}, []string{"services/api/**"})
+	if err != nil {
+		t.Fatalf("EvaluatePathFilters() error = %v", err)
+	}
+	if got.Run {
+		t.Fatalf("EvaluatePathFilters() = %+v, want skip when only ignored paths changed", got)
+	}
+}
+
+func TestEvaluatePathFiltersAfterForcePush(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	base := src.commit(t, "README.md", "v1\n", "initial")
+	src.git(t, "checkout", "-q", "-b", "feature")
+	oldTip := src.commit(t, "services/api/main.go", "package main\n", "feature work")
+	mirrorPath := src.mirror(t, "acme/app")
+	src.git(t, "reset", "-q", "--hard", base)
+	newTip := src.commit(t, "docs/guide.md", "guide\n", "rewritten feature")
+
+	ctx := context.Background()
+	if err := FetchMirror(ctx, mirrorPath); err != nil {
+		t.Fatalf("FetchMirror() error = %v", err)
+	}
+	got, err := EvaluatePathFilters(ctx, "acme/app", oldTip, newTip, []string{"services/**"}, nil)
+	if err != nil {
+		t.Fatalf("EvaluatePathFilters() error = %v", err)
+	}
+	if got.Run {
+		t.Fatalf("EvaluatePathFilters() = %+v, want skip: only docs changed since merge base", got)
+	}
+
+	got, err = EvaluatePathFilters(ctx, "acme/app", strings.Repeat("a", 40), newTip, []string{"services/**"}, nil)
+	if err != nil {
+		t.Fatalf("EvaluatePathFilters() error = %v", err)
+	}
+	if !got.Run || !strings.Contains(got.Reason, "unreachable") {
+		t.Fatalf("EvaluatePathFilters() = %+v, want run for unreachable previous sha", got)
+	}
+}
+
+func TestCommitAtSHAStoresFullMetadata(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	parent := src.commit(t, "README.md", "v1\n", "initial")
+	sha := src.commit(t, "main.go", "package main\n", "Add main\n\nLonger body line.\nSecond line.")
+	src.mirror(t, "acme/app")
+
+	commit, err := CommitAtSHA(context.Background(), "acme/app", sha)
+	if err != nil {
+		t.Fatalf("CommitAtSHA() error = %v", err)
+	}
+	if commit.SHA != sha || commit.Subject != "Add main" || commit.Body != "Longer body line.\nSecond line." {
+		t.Fatalf("CommitAtSHA() = %+v", commit)
+	}
+	if commit.AuthorEmail != "alice@example.com" || commit.CommitterName != "Alice" || commit.CommitTime.IsZero() {
+		t.Fatalf("CommitAtSHA() people/time = %+v", commit)
+	}
+	if len(commit.Parents) != 1 || commit.Parents[0] != parent {
+		t.Fatalf("CommitAtSHA().Parents = %v, want [%s]", commit.Parents, parent)
+	}
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	if err := repo.UpsertCommit(commit); err != nil {
+		t.Fatalf("UpsertCommit() error = %v", err)
+	}
+	stored, err := repo.CommitBySHA("acme/app", sha)
+	if err != nil {
+		t.Fatalf("CommitBySHA() error = %v", err)
+	}
+	if stored.Body != commit.Body || !stored.CommitTime.Equal(commit.CommitTime) || len(stored.Parents) != 1 {
+		t.Fatalf("CommitBySHA() = %+v, want %+v", stored, commit)
+	}
+}
+
+func TestListPullRequestsReadsHeadAndMergeRefs(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	base := src.commit(t, "README.md", "v1\n", "initial")
+	head := src.commit(t, "main.go", "package main\n", "feature")
+	src.git(t, "update-ref", "refs/pull/7/head", head)
+	src.git(t, "update-ref", "refs/pull/7/merge", base)
+	src.git(t, "update-ref", "refs/pull/9/head", head)
+	src.mirror(t, "acme/app")
+
+	pulls, err := ListPullRequests(context.Background(), "acme/app")
+	if err != nil {
+		t.Fatalf("ListPullRequests() error = %v", err)
+	}
+	want := []PullRequest{
+		{Number: 7, HeadSHA: head, MergeSHA: base},
+		{Number: 9, HeadSHA: head},
+	}
+	if len(pulls) != len(want) {
+		t.Fatalf("ListPullRequests() = %+v, want %+v", pulls, want)
+	}
+	for i := range want {
+		if pulls[i] != want[i] {
+			t.Fatalf("ListPullRequests()[%d] = %+v, want %+v", i, pulls[i], want[i])
+		}
+	}
+
+	if n, ok := ParsePullRequestBranch(PullRequestBranch(7)); !ok || n != 7 {
+		t.Fatalf("ParsePullRequestBranch(PullRequestBranch(7)) = %d, %v", n, ok)
+	}
+	if _, ok := ParsePullRequestBranch("pr-7"); ok {
+		t.Fatal("ParsePullRequestBranch(pr-7) should not parse")
+	}
+	if _, ok := ParsePullRequestBranch("pr/7"); ok {
+		t.Fatal("ParsePullRequestBranch(pr/7) should not parse; it is a real branch name")
+	}
+}
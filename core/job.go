@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -23,27 +24,105 @@ type RunJobRequest struct {
 	Branch       string
 	SHA          string
 	CommitAuthor string
+	Reason       string
+	PRNumber     int
 	ScriptPath   string
 	WorkDir      string
 	Env          []string
+	Steps        []RunStep // when empty, ScriptPath runs as the only step
+	Cache        *CacheConf
+	Limits       *LimitsConf
+	Reports      []string // test report globs, relative to WorkDir
+	RunAs        *RunAs   // sandbox account for the steps; nil runs them as refci
+	Secrets      []string // values masked in the job log
 }
 
 type JobRunner struct {
-	dbRepo            DbRepo
-	cancelGrace       time.Duration
-	exitCleanupGrace  time.Duration
-	logf              func(string, ...any)
+	dbRepo           DbRepo
+	cancelGrace      time.Duration
+	exitCleanupGrace time.Duration
+	logf             func(string, ...any)
+	secretStore      *SecretStore // opened on first use; nil until a key exists
 
 	mu      sync.Mutex
 	running map[string]*runningJob
+	secrets []string
+}
+
+// RunStep is one command of a job run.
+type RunStep struct {
+	Name string
+	Args []string // argv, e.g. bash <script>
+}
+
+// runSteps returns req.Steps, or the single ScriptPath step when no steps
+// were given.
+func (req RunJobRequest) runSteps() []RunStep {
+	if len(req.Steps) > 0 {
+		return req.Steps
+	}
+	script := strings.TrimSpace(req.ScriptPath)
+	if script == "" {
+		return nil
+	}
+	return []RunStep{{Name: filepath.Base(script), Args: []string{"bash", script}}}
 }
 
 type runningJob struct {
-	cancel   context.CancelFunc
-	cmd      *exec.Cmd
-	done     chan struct{}
-	canceled atomic.Bool
-	started  time.Time
+	cancel       context.CancelFunc
+	done         chan struct{}
+	canceled     atomic.Bool
+	cancelReason atomic.Value // string
+	started      time.Time
+
+	mu  sync.Mutex
+	cmd *exec.Cmd // current step
+
+	// Only touched by the runJob goroutine.
+	cgroup *runCgroup // nil when the job has no limits or they are not enforced
+	usage  JobUsage   // summed from the steps' rusage
+	stdout io.Writer  // the log's stdout stream, masked when the job has secrets
+	stderr io.Writer  // the same for stderr
+	masks  []*maskingWriter
+}
+
+var errJobCanceled = errors.New("canceled before start")
+
+// stepOutputGrace is how long a finished step's output pipe may stay open.
+const stepOutputGrace = time.Second
+
+// startCmd starts cmd as the current step unless the job was canceled, so a
+// cancel either sees the new process or prevents it from starting.
+func (rj *runningJob) startCmd(cmd *exec.Cmd) error {
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+	if rj.canceled.Load() {
+		return errJobCanceled
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	rj.cmd = cmd
+	return nil
+}
+
+// maskStream returns w, masking secrets when there are any to mask.
+func (rj *runningJob) maskStream(w io.Writer, secrets []string) io.Writer {
+	m := newMaskingWriter(w, secrets)
+	if m == nil {
+		return w
+	}
+	rj.masks = append(rj.masks, m)
+	return m
+}
+
+func (rj *runningJob) pid() int {
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+	if rj.cmd == nil || rj.cmd.Process == nil {
+		return 0
+	}
+	return rj.cmd.Process.Pid
 }
 
 func NewJobRunner(dbRepo DbRepo) *JobRunner {
@@ -59,7 +138,25 @@ func (j *JobRunner) SetLogger(logf func(string, ...any)) {
 	j.logf = logf
 }
 
-func (j *JobRunner) QueueJob(jobConf JobConf, envs []string, branch, sha string) error {
+// SetSecretStore sets the store whose global, repo and job secrets are added
+// to each job's env (and masked in its log).
+func (j *JobRunner) SetSecretStore(store *SecretStore) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.secretStore = store
+}
+
+// SetSecrets sets the values masked in the logs of jobs started afterwards.
+func (j *JobRunner) SetSecrets(secrets []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.secrets = append([]string(nil), secrets...)
+}
+
+// QueueJob starts jobConf at sha unless that sha is already the latest run,
+// canceling the branch's still-active previous runs. reason is stored on the
+// job row.
+func (j *JobRunner) QueueJob(jobConf JobConf, envs []string, branch, sha, reason string) error {
 	name := jobConf.Name
 	if name == "" {
 		return fmt.Errorf("job name is required")
@@ -74,21 +171,59 @@ func (j *JobRunner) QueueJob(jobConf JobConf, envs []string, branch, sha string)
 		return nil
 	}
 
-	if latestJob.Status == StatusRunning || latestJob.Status == StatusPending {
-		j.logEvent(
-			"queue cancel previous job=%s branch=%s run=%s prev_sha=%s status=%s",
-			latestJob.Name,
-			latestJob.Branch,
-			shortRunID(latestJob.RunID),
-			shortSHA(latestJob.SHA),
-			strings.ToLower(strings.TrimSpace(latestJob.Status)),
-		)
-		if err = j.Cancel(latestJob); err != nil {
+	// The latest row may be a skipped evaluation recorded while an older
+	// run is still going, so every active run is looked up.
+	for _, status := range []string{StatusRunning, StatusPending} {
+		active, err := j.dbRepo.ListJob(JobFilter{Repo: jobConf.Repo, Name: name, Branch: branch, Status: status})
+		if err != nil {
 			return err
 		}
+		for _, prev := range active {
+			j.logEvent(
+				"queue cancel previous job=%s branch=%s run=%s prev_sha=%s status=%s",
+				prev.Name,
+				prev.Branch,
+				shortRunID(prev.RunID),
+				shortSHA(prev.SHA),
+				status,
+			)
+			if err = j.CancelWithReason(prev, "superseded by "+shortSHA(sha)); err != nil {
+				return err
+			}
+		}
 	}
 
-	return j.runJobAtSHA(jobConf, envs, branch, sha)
+	return j.runJobAtSHA(jobConf, envs, branch, sha, reason)
+}
+
+// RecordSkip stores a skipped row for jobConf at sha so the evaluation shows
+// up next to real runs. It never touches the worktree or running jobs.
+func (j *JobRunner) RecordSkip(jobConf JobConf, branch, sha, reason string) error {
+	name := jobConf.Name
+	if name == "" {
+		return fmt.Errorf("job name is required")
+	}
+
+	commitAuthor := j.captureCommit(jobConf.Repo, sha).AuthorName
+
+	runID := newRunID()
+	if err := j.dbRepo.CreateJob(Job{
+		RunID:        runID,
+		Repo:         jobConf.Repo,
+		Name:         name,
+		Branch:       branch,
+		SHA:          sha,
+		CommitAuthor: commitAuthor,
+		Reason:       reason,
+		PRNumber:     pullRequestNumber(branch),
+	}); err != nil {
+		return fmt.Errorf("create skipped job row: %w", err)
+	}
+	if err := j.dbRepo.UpdateJob(runID, StatusSkipped, reason, ""); err != nil {
+		return fmt.Errorf("set job skipped: %w", err)
+	}
+	j.logEvent("job skipped name=%s branch=%s run=%s sha=%s reason=%s", name, branch, shortRunID(runID), shortSHA(sha), trimLogMessage(reason))
+	return nil
 }
 
 func (j *JobRunner) RerunJob(jobConf JobConf, envs []string, branch, sha string) error {
@@ -102,10 +237,10 @@ func (j *JobRunner) RerunJob(jobConf JobConf, envs []string, branch, sha string)
 	}
 
 	j.logEvent("rerun queued job=%s branch=%s sha=%s", name, branch, shortSHA(sha))
-	return j.runJobAtSHA(jobConf, envs, branch, sha)
+	return j.runJobAtSHA(jobConf, envs, branch, sha, "manual restart")
 }
 
-func (j *JobRunner) runJobAtSHA(jobConf JobConf, envs []string, branch, sha string) error {
+func (j *JobRunner) runJobAtSHA(jobConf JobConf, envs []string, branch, sha, reason string) error {
 	name := jobConf.Name
 	j.logEvent("prepare job=%s branch=%s sha=%s worktree", name, branch, shortSHA(sha))
 	workDir, err := EnsureWorktree(context.Background(), jobConf.Repo, branch, sha)
@@ -113,18 +248,27 @@ func (j *JobRunner) runJobAtSHA(jobConf JobConf, envs []string, branch, sha stri
 		j.logEvent("prepare failed job=%s branch=%s sha=%s: %v", name, branch, shortSHA(sha), err)
 		return err
 	}
-	scriptPath := filepath.Join(workDir, jobConf.ScriptPath)
-	if _, err := os.Stat(scriptPath); err != nil {
-		j.logEvent("prepare failed job=%s branch=%s sha=%s missing_script=%s", name, branch, shortSHA(sha), scriptPath)
-		return fmt.Errorf("script not found: %s", scriptPath)
+	runID := newRunID()
+	steps, err := resolveRunSteps(jobConf, workDir, RunDir(jobConf.Repo, runID))
+	if err != nil {
+		j.logEvent("prepare failed job=%s branch=%s sha=%s: %v", name, branch, shortSHA(sha), err)
+		return err
 	}
 
-	commitAuthor, err := CommitAuthorAtSHA(context.Background(), jobConf.Repo, sha)
+	runAs, err := j.loadRunAs()
 	if err != nil {
-		commitAuthor = ""
+		j.logEvent("prepare failed job=%s branch=%s sha=%s: %v", name, branch, shortSHA(sha), err)
+		return err
 	}
 
-	runID := newRunID()
+	secretEnv, err := j.jobSecretEnv(jobConf.Repo, name)
+	if err != nil {
+		j.logEvent("prepare failed job=%s branch=%s sha=%s: %v", name, branch, shortSHA(sha), err)
+		return err
+	}
+
+	commitAuthor := j.captureCommit(jobConf.Repo, sha).AuthorName
+
 	if _, err = j.Start(context.Background(), RunJobRequest{
 		RunID:        runID,
 		Repo:         jobConf.Repo,
@@ -132,9 +276,16 @@ func (j *JobRunner) runJobAtSHA(jobConf JobConf, envs []string, branch, sha stri
 		Branch:       branch,
 		SHA:          sha,
 		CommitAuthor: commitAuthor,
-		ScriptPath:   scriptPath,
+		Reason:       reason,
+		PRNumber:     pullRequestNumber(branch),
 		WorkDir:      workDir,
-		Env:          envs,
+		Env:          append(append([]string(nil), envs...), secretEnv...),
+		Steps:        steps,
+		Cache:        jobConf.Cache,
+		Limits:       jobConf.Limits,
+		Reports:      jobConf.Reports,
+		RunAs:        runAs,
+		Secrets:      append(j.currentSecrets(), envValues(secretEnv)...),
 	}); err != nil {
 		j.logEvent("start failed job=%s branch=%s sha=%s: %v", name, branch, shortSHA(sha), err)
 		return err
@@ -143,11 +294,113 @@ func (j *JobRunner) runJobAtSHA(jobConf JobConf, envs []string, branch, sha stri
 	return nil
 }
 
+func (j *JobRunner) currentSecrets() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string(nil), j.secrets...)
+}
+
+// jobSecretEnv returns the stored secrets for one job as KEY=value entries.
+func (j *JobRunner) jobSecretEnv(repo, job string) ([]string, error) {
+	store, err := j.openSecretStore()
+	if err != nil || store == nil {
+		return nil, err
+	}
+	env, err := store.ForJob(repo, job)
+	if err != nil {
+		return nil, fmt.Errorf("load secrets: %w", err)
+	}
+	return env, nil
+}
+
+// openSecretStore returns the secret store, opening it once a key exists so
+// secrets set after refci started are injected without a restart. It is nil
+// while there is no key.
+func (j *JobRunner) openSecretStore() (*SecretStore, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.secretStore != nil {
+		return j.secretStore, nil
+	}
+	store, err := OpenSecretStore(j.dbRepo, false)
+	if errors.Is(err, ErrNoSecretKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open secret store: %w", err)
+	}
+	j.secretStore = store
+	return store, nil
+}
+
+// loadRunAs reads the run_as setting; nil means steps run as refci itself.
+func (j *JobRunner) loadRunAs() (*RunAs, error) {
+	spec, err := GlobalSettingValue(j.dbRepo, SettingRunAs)
+	if err != nil {
+		return nil, fmt.Errorf("read run_as: %w", err)
+	}
+	return ResolveRunAs(spec)
+}
+
+// resolveRunSteps turns the job's steps into commands inside workDir. Script
+// steps must exist in the checkout; inline run steps are written to runDir.
+// Both run through the step's shell.
+func resolveRunSteps(jobConf JobConf, workDir, runDir string) ([]RunStep, error) {
+	confSteps := jobConf.StepList()
+	if len(confSteps) == 0 {
+		return nil, fmt.Errorf("job %s has no script, run or steps", jobConf.Name)
+	}
+	steps := make([]RunStep, 0, len(confSteps))
+	for i, step := range confSteps {
+		var scriptPath string
+		switch {
+		case strings.TrimSpace(step.Script) != "":
+			scriptPath = filepath.Join(workDir, step.Script)
+			if _, err := os.Stat(scriptPath); err != nil {
+				return nil, fmt.Errorf("script not found: %s", scriptPath)
+			}
+		case strings.TrimSpace(step.Run) != "":
+			p, err := writeInlineScript(runDir, i, step.Run)
+			if err != nil {
+				return nil, err
+			}
+			scriptPath = p
+		default:
+			return nil, fmt.Errorf("step %s of job %s needs script or run", step.Name, jobConf.Name)
+		}
+		args, err := ShellArgs(step.Shell, scriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("step %s of job %s: %w", step.Name, jobConf.Name, err)
+		}
+		steps = append(steps, RunStep{Name: step.Name, Args: args})
+	}
+	return steps, nil
+}
+
+// captureCommit reads the commit metadata for sha and stores it in the
+// commits table. Failures are logged and yield a zero Commit; they never
+// block the job.
+func (j *JobRunner) captureCommit(repo, sha string) Commit {
+	commit, err := CommitAtSHA(context.Background(), repo, sha)
+	if err != nil {
+		j.logEvent("commit metadata unavailable repo=%s sha=%s: %v", repo, shortSHA(sha), err)
+		return Commit{}
+	}
+	if err := j.dbRepo.UpsertCommit(commit); err != nil {
+		j.logEvent("commit metadata store failed repo=%s sha=%s: %v", repo, shortSHA(sha), err)
+	}
+	return commit
+}
+
 func (r *JobRunner) Start(ctx context.Context, req RunJobRequest) (string, error) {
 	key := strings.TrimSpace(req.RunID)
 	if key == "" {
 		return "", fmt.Errorf("job run id is required")
 	}
+	steps := req.runSteps()
+	if len(steps) == 0 {
+		return "", fmt.Errorf("job has no steps: %s", req.Name)
+	}
 	r.mu.Lock()
 	if _, exists := r.running[key]; exists {
 		r.mu.Unlock()
@@ -155,55 +408,73 @@ func (r *JobRunner) Start(ctx context.Context, req RunJobRequest) (string, error
 	}
 	r.mu.Unlock()
 
-	if err := r.dbRepo.CreateJob(req.RunID, req.Repo, req.Name, req.Branch, req.SHA, req.CommitAuthor); err != nil {
+	stepRows := make([]JobStep, 0, len(steps))
+	commands := make([]string, 0, len(steps))
+	for _, step := range steps {
+		command := FormatCommandLine(step.Args)
+		stepRows = append(stepRows, JobStep{Name: step.Name, Command: command})
+		commands = append(commands, command)
+	}
+
+	if err := r.dbRepo.CreateJob(Job{
+		RunID:        req.RunID,
+		Repo:         req.Repo,
+		Name:         req.Name,
+		Branch:       req.Branch,
+		SHA:          req.SHA,
+		CommitAuthor: req.CommitAuthor,
+		Reason:       req.Reason,
+		PRNumber:     req.PRNumber,
+		Command:      strings.Join(commands, " && "),
+	}); err != nil {
 		return "", fmt.Errorf("create job row: %w", err)
 	}
 
-	logPath, logFile, err := createJobLogFile(req)
+	logPath, f, err := createJobLogFile(req)
 	if err != nil {
 		_ = r.dbRepo.UpdateJob(req.RunID, StatusFailed, err.Error(), "")
 		return "", err
 	}
+	logFile := newJobLog(f)
 
-	runCtx, cancel := context.WithCancel(ctx)
-	cmd := exec.CommandContext(runCtx, "bash", req.ScriptPath)
-	cmd.Dir = strings.TrimSpace(req.WorkDir)
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	cmd.Env = append(os.Environ(), req.Env...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-	if err := r.dbRepo.UpdateJob(req.RunID, StatusRunning, "", logPath); err != nil {
+	if err := r.dbRepo.CreateJobSteps(req.RunID, stepRows); err != nil {
 		_ = logFile.Close()
-		cancel()
-		return "", fmt.Errorf("set job running: %w", err)
+		_ = r.dbRepo.UpdateJob(req.RunID, StatusFailed, err.Error(), "")
+		return "", fmt.Errorf("create job steps: %w", err)
 	}
 
-	if err := cmd.Start(); err != nil {
+	if err := r.dbRepo.UpdateJob(req.RunID, StatusRunning, "", logPath); err != nil {
 		_ = logFile.Close()
-		_ = r.dbRepo.UpdateJob(req.RunID, StatusFailed, err.Error(), "")
-		cancel()
-		return "", fmt.Errorf("start job process: %w", err)
+		return "", fmt.Errorf("set job running: %w", err)
 	}
 
+	runCtx, cancel := context.WithCancel(ctx)
 	rj := &runningJob{
 		cancel:  cancel,
-		cmd:     cmd,
 		done:    make(chan struct{}),
 		started: time.Now(),
 	}
+	secrets := append(storeSecrets(), req.Secrets...)
+	rj.stdout = rj.maskStream(logFile.streamWriter(StreamStdout), secrets)
+	rj.stderr = rj.maskStream(logFile.streamWriter(StreamStderr), secrets)
 
 	r.mu.Lock()
 	r.running[key] = rj
 	r.mu.Unlock()
 
-	r.logEvent("job started name=%s branch=%s run=%s sha=%s pid=%d log=%s", req.Name, req.Branch, shortRunID(req.RunID), shortSHA(req.SHA), cmd.Process.Pid, logPath)
-	go r.waitJob(req, key, rj, logFile)
+	r.logEvent("job started name=%s branch=%s run=%s sha=%s steps=%d log=%s", req.Name, req.Branch, shortRunID(req.RunID), shortSHA(req.SHA), len(steps), logPath)
+	go r.runJob(runCtx, req, steps, key, rj, logFile)
 
 	return logPath, nil
 }
 
 func (r *JobRunner) Cancel(job Job) error {
+	return r.CancelWithReason(job, "")
+}
+
+// CancelWithReason cancels a running job and stores reason as the job
+// message instead of the process exit error.
+func (r *JobRunner) CancelWithReason(job Job, reason string) error {
 	key := strings.TrimSpace(job.RunID)
 	if key == "" {
 		return fmt.Errorf("job run id is required")
@@ -217,11 +488,12 @@ func (r *JobRunner) Cancel(job Job) error {
 	}
 
 	r.logEvent("cancel requested job=%s branch=%s run=%s sha=%s", job.Name, job.Branch, shortRunID(job.RunID), shortSHA(job.SHA))
+	rj.cancelReason.Store(strings.TrimSpace(reason))
 	rj.canceled.Store(true)
 	rj.cancel()
 
-	if rj.cmd.Process != nil {
-		_ = signalProcess(rj.cmd.Process.Pid, syscall.SIGTERM)
+	if pid := rj.pid(); pid > 0 {
+		_ = signalProcess(pid, syscall.SIGTERM)
 	}
 
 	select {
@@ -230,8 +502,8 @@ func (r *JobRunner) Cancel(job Job) error {
 	case <-time.After(r.cancelGrace):
 	}
 
-	if rj.cmd.Process != nil {
-		_ = signalProcess(rj.cmd.Process.Pid, syscall.SIGKILL)
+	if pid := rj.pid(); pid > 0 {
+		_ = signalProcess(pid, syscall.SIGKILL)
 		r.logEvent("cancel escalated to kill job=%s branch=%s run=%s sha=%s", job.Name, job.Branch, shortRunID(job.RunID), shortSHA(job.SHA))
 	}
 
@@ -246,25 +518,75 @@ func (r *JobRunner) IsRunning(runID string) bool {
 	return ok
 }
 
-func (r *JobRunner) waitJob(req RunJobRequest, key string, rj *runningJob, logFile *os.File) {
-	pid := 0
-	if rj.cmd.Process != nil {
-		pid = rj.cmd.Process.Pid
+// runJob runs the steps in order. The first failing step fails the job and
+// marks the remaining steps skipped; a cancel marks them canceled.
+func (r *JobRunner) runJob(ctx context.Context, req RunJobRequest, steps []RunStep, key string, rj *runningJob, logFile *jobLog) {
+	var (
+		status = StatusFinished
+		msg    string
+	)
+	r.applyJobLimits(req, rj, logFile)
+	cacheKey, cacheHit := r.restoreJobCache(ctx, req, logFile)
+	if err := r.prepareRunAs(req, logFile); err != nil {
+		status, msg = StatusFailed, err.Error()
+	}
+	for i, step := range steps {
+		if status != StatusFinished || rj.canceled.Load() {
+			rest := StatusSkipped
+			if rj.canceled.Load() {
+				rest = StatusCanceled
+			}
+			offset := logFile.Offset()
+			_ = r.dbRepo.UpdateJobStep(JobStep{RunID: req.RunID, Index: i, Status: rest, LogStart: offset, LogEnd: offset})
+			continue
+		}
+
+		if len(steps) > 1 {
+			fmt.Fprintf(logFile, "==> step %d/%d: %s\n", i+1, len(steps), step.Name)
+		}
+		stepStatus, stepMsg := r.runStep(ctx, req, i, step, rj, logFile)
+		if stepStatus != StatusFinished {
+			status = stepStatus
+			msg = stepMsg
+			if len(steps) > 1 && stepStatus == StatusFailed {
+				msg = fmt.Sprintf("step %s: %s", step.Name, stepMsg)
+			}
+		}
+	}
+	if status == StatusFinished && cacheKey != "" && !cacheHit && !rj.canceled.Load() {
+		r.saveJobCache(req, cacheKey, logFile)
+	}
+	r.collectTestReports(req, rj.started, logFile)
+	if rj.cgroup != nil {
+		if status == StatusFailed && rj.cgroup.oomKilled() {
+			msg += fmt.Sprintf(" (memory limit %s exceeded)", strings.TrimSpace(req.Limits.Memory))
+			fmt.Fprintf(logFile, "refci: killed by the out-of-memory killer, memory limit %s\n", strings.TrimSpace(req.Limits.Memory))
+		}
+		if u, err := rj.cgroup.usage(); err == nil {
+			rj.usage = u
+		}
+		rj.cgroup.close()
 	}
-	err := rj.cmd.Wait()
-	r.cleanupProcessGroup(req, pid)
+	_ = r.dbRepo.UpdateJobUsage(req.RunID, rj.usage)
 	_ = logFile.Close()
 
-	status, msg := classifyJobResult(err, rj.canceled.Load())
+	if reason, _ := rj.cancelReason.Load().(string); status == StatusCanceled && reason != "" {
+		msg = reason
+	}
 	_ = r.dbRepo.UpdateJob(req.RunID, status, msg, "")
+	if err := IndexJobLog(r.dbRepo, Job{RunID: req.RunID, LogPath: logFile.f.Name()}); err != nil {
+		r.logEvent("index job log failed run=%s: %v", shortRunID(req.RunID), err)
+	}
 	r.logEvent(
-		"job finished name=%s branch=%s run=%s sha=%s status=%s duration=%s msg=%s",
+		"job finished name=%s branch=%s run=%s sha=%s status=%s duration=%s cpu=%s peak_mem=%d msg=%s",
 		req.Name,
 		req.Branch,
 		shortRunID(req.RunID),
 		shortSHA(req.SHA),
 		status,
 		time.Since(rj.started).Round(time.Millisecond),
+		rj.usage.CPUTime.Round(time.Millisecond),
+		rj.usage.PeakMemory,
 		trimLogMessage(msg),
 	)
 
@@ -274,6 +596,258 @@ func (r *JobRunner) waitJob(req RunJobRequest, key string, rj *runningJob, logFi
 	close(rj.done)
 }
 
+// prepareRunAs hands the worktree and the run dir (inline scripts) to the
+// sandbox user. The log file stays refci's: steps only get its descriptor.
+func (r *JobRunner) prepareRunAs(req RunJobRequest, logFile *jobLog) error {
+	if req.RunAs == nil {
+		return nil
+	}
+	for _, dir := range []string{req.WorkDir, RunDir(req.Repo, req.RunID)} {
+		if err := req.RunAs.chownTree(dir); err != nil {
+			err = fmt.Errorf("run_as %s: %w", req.RunAs.Spec, err)
+			fmt.Fprintf(logFile, "refci: %v\n", err)
+			return err
+		}
+	}
+	fmt.Fprintf(logFile, "==> running as %s\n", req.RunAs)
+	return nil
+}
+
+// collectTestReports parses the reports the run wrote into its test cases.
+// Files older than the run are left over from an earlier one (the worktree
+// is reset, not cleaned) and are ignored. Report problems are noted in the
+// log and never fail the job.
+func (r *JobRunner) collectTestReports(req RunJobRequest, started time.Time, logFile *jobLog) {
+	if len(req.Reports) == 0 {
+		return
+	}
+	files, err := FindTestReports(req.WorkDir, req.Reports, started)
+	if err != nil {
+		fmt.Fprintf(logFile, "refci: reports: %v\n", err)
+		return
+	}
+	var cases []TestCase
+	for _, f := range files {
+		parsed, err := ParseTestReportFile(f)
+		if err != nil {
+			rel, _ := filepath.Rel(req.WorkDir, f)
+			fmt.Fprintf(logFile, "refci: report %s: %v\n", rel, err)
+			continue
+		}
+		cases = append(cases, parsed...)
+	}
+	if err := r.dbRepo.ReplaceTestCases(req.RunID, cases); err != nil {
+		fmt.Fprintf(logFile, "refci: reports: %v\n", err)
+		r.logEvent("save test cases failed job=%s run=%s: %v", req.Name, shortRunID(req.RunID), err)
+		return
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(logFile, "==> reports: no file written matching %s\n", strings.Join(req.Reports, ", "))
+		return
+	}
+	fmt.Fprintf(logFile, "==> reports: %s from %d files\n", SummarizeTests(cases), len(files))
+}
+
+// applyJobLimits creates the run's cgroup when the job has limits. When
+// cgroups are unavailable the job runs unbounded and the log says why.
+func (r *JobRunner) applyJobLimits(req RunJobRequest, rj *runningJob, logFile *jobLog) {
+	if req.Limits == nil {
+		return
+	}
+	cg, err := newRunCgroup(req.RunID, *req.Limits)
+	if err != nil {
+		fmt.Fprintf(logFile, "==> limits not enforced (%s): %v\n", req.Limits, err)
+		r.logEvent("limits not enforced job=%s run=%s: %v", req.Name, shortRunID(req.RunID), err)
+		return
+	}
+	rj.cgroup = cg
+	fmt.Fprintf(logFile, "==> limits %s\n", req.Limits)
+	r.logEvent("limits applied job=%s run=%s %s cgroup=%s", req.Name, shortRunID(req.RunID), req.Limits, cg.dir)
+}
+
+// restoreJobCache renders the job's cache key and restores a matching entry
+// into the worktree: the branch's own, else the default branch's. Branches
+// only ever save into their own scope, so an untrusted branch cannot poison
+// the caches of the default branch. Cache problems are logged and never fail
+// the job; the returned key is empty when nothing should be saved.
+func (r *JobRunner) restoreJobCache(ctx context.Context, req RunJobRequest, logFile *jobLog) (string, bool) {
+	if req.Cache == nil {
+		return "", false
+	}
+	key, err := RenderCacheKey(req.Cache.Key, req.WorkDir, CacheKeyData{Job: req.Name, Branch: req.Branch})
+	if err != nil {
+		fmt.Fprintf(logFile, "refci: cache key: %v\n", err)
+		r.logEvent("cache key failed job=%s run=%s: %v", req.Name, shortRunID(req.RunID), err)
+		return "", false
+	}
+	branches := []string{req.Branch}
+	if defaultBranch, err := DefaultBranch(ctx, req.Repo); err == nil && defaultBranch != req.Branch {
+		branches = append(branches, defaultBranch)
+	}
+	from, hit, err := RestoreCache(req.Repo, branches, key, req.Cache.Paths, req.WorkDir)
+	if err != nil {
+		fmt.Fprintf(logFile, "refci: cache restore %s: %v\n", key, err)
+		r.logEvent("cache restore failed job=%s run=%s key=%s: %v", req.Name, shortRunID(req.RunID), key, err)
+		return key, false
+	}
+	result := "miss"
+	switch {
+	case hit && from != req.Branch:
+		result = "hit from " + from
+	case hit:
+		result = "hit"
+	}
+	fmt.Fprintf(logFile, "==> cache restore %s: %s\n", key, result)
+	r.logEvent("cache restore job=%s run=%s key=%s result=%s", req.Name, shortRunID(req.RunID), key, result)
+	return key, hit
+}
+
+// saveJobCache saves the job's cache paths under key after a successful run
+// and evicts old entries beyond the repo's cache_max_size.
+func (r *JobRunner) saveJobCache(req RunJobRequest, key string, logFile *jobLog) {
+	maxSize, _ := ParseByteSize(DefaultCacheMaxSize)
+	if value, settingErr := RepoSettingValue(r.dbRepo, req.Repo, SettingCacheMaxSize); settingErr == nil {
+		if n, parseErr := ParseByteSize(value); parseErr == nil {
+			maxSize = n
+		}
+	}
+	entry, saved, err := SaveCache(req.Repo, req.Branch, key, req.Cache.Paths, req.WorkDir, maxSize)
+	switch {
+	case err != nil:
+		fmt.Fprintf(logFile, "refci: cache save %s: %v\n", key, err)
+		r.logEvent("cache save failed job=%s run=%s key=%s: %v", req.Name, shortRunID(req.RunID), key, err)
+	case !saved:
+		fmt.Fprintf(logFile, "==> cache save %s: nothing to save\n", key)
+	default:
+		fmt.Fprintf(logFile, "==> cache save %s: %s\n", key, FormatByteSize(entry.Size))
+		r.logEvent("cache saved job=%s run=%s key=%s size=%d", req.Name, shortRunID(req.RunID), key, entry.Size)
+	}
+}
+
+// runStep runs one step to completion, writing its output to logFile and
+// recording the log byte range the step produced.
+func (r *JobRunner) runStep(ctx context.Context, req RunJobRequest, idx int, step RunStep, rj *runningJob, logFile *jobLog) (string, string) {
+	row := JobStep{RunID: req.RunID, Index: idx, Status: StatusRunning, LogStart: logFile.Offset()}
+	row.LogEnd = row.LogStart
+	_ = r.dbRepo.UpdateJobStep(row)
+
+	cmd := exec.CommandContext(ctx, step.Args[0], step.Args[1:]...)
+	cmd.Dir = strings.TrimSpace(req.WorkDir)
+	output, err := newStepOutput(rj.stdout, rj.stderr)
+	if err != nil {
+		fmt.Fprintf(logFile, "refci: step %s output: %v\n", step.Name, err)
+		row.Status, row.Msg = StatusFailed, err.Error()
+		row.LogEnd = logFile.Offset()
+		_ = r.dbRepo.UpdateJobStep(row)
+		return row.Status, row.Msg
+	}
+	cmd.Stdout = output.outW
+	cmd.Stderr = output.errW
+	cmd.Env = append(jobProcessEnv(), req.Env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if rj.cgroup != nil {
+		rj.cgroup.apply(cmd.SysProcAttr)
+	}
+
+	if req.RunAs != nil {
+		cmd.Env = append(req.RunAs.env(jobProcessEnv(), cmd.Dir), req.Env...)
+		cmd.SysProcAttr.Credential, err = req.RunAs.credential()
+	}
+
+	started := time.Now()
+	if err == nil {
+		err = rj.startCmd(cmd)
+	}
+	output.started()
+	if err == nil {
+		r.logEvent("step started name=%s run=%s step=%s pid=%d", req.Name, shortRunID(req.RunID), step.Name, cmd.Process.Pid)
+		err = cmd.Wait()
+		r.cleanupProcessGroup(req, cmd.Process.Pid)
+		if cmd.ProcessState != nil {
+			rj.usage.CPUTime += cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+			rj.usage.PeakMemory = max(rj.usage.PeakMemory, rusagePeakMemory(cmd.ProcessState))
+		}
+	} else if !errors.Is(err, errJobCanceled) {
+		fmt.Fprintf(logFile, "refci: start step %s: %v\n", step.Name, err)
+	}
+
+	output.wait(stepOutputGrace)
+	for _, m := range rj.masks {
+		_ = m.Flush()
+	}
+
+	row.Status, row.Msg = classifyJobResult(err, rj.canceled.Load())
+	row.LogEnd = logFile.Offset()
+	_ = r.dbRepo.UpdateJobStep(row)
+	r.logEvent(
+		"step finished name=%s run=%s step=%s status=%s duration=%s msg=%s",
+		req.Name,
+		shortRunID(req.RunID),
+		step.Name,
+		row.Status,
+		time.Since(started).Round(time.Millisecond),
+		trimLogMessage(row.Msg),
+	)
+	return row.Status, row.Msg
+}
+
+// stepOutput copies a step's stdout and stderr from two pipes into the
+// log. The step only gets the pipes' write ends, so every line passes
+// through refci (to be indexed and masked) and the step's own descriptors
+// are gone once its process group has been cleaned up.
+type stepOutput struct {
+	outR, outW *os.File
+	errR, errW *os.File
+	done       sync.WaitGroup
+}
+
+func newStepOutput(stdout, stderr io.Writer) (*stepOutput, error) {
+	o := &stepOutput{}
+	var err error
+	if o.outR, o.outW, err = os.Pipe(); err != nil {
+		return nil, err
+	}
+	if o.errR, o.errW, err = os.Pipe(); err != nil {
+		o.outR.Close()
+		o.outW.Close()
+		return nil, err
+	}
+	copyPipe := func(dst io.Writer, r *os.File) {
+		defer o.done.Done()
+		_, _ = io.Copy(dst, r)
+	}
+	o.done.Add(2)
+	go copyPipe(stdout, o.outR)
+	go copyPipe(stderr, o.errR)
+	return o, nil
+}
+
+// started closes refci's copies of the write ends once the step has them
+// (or failed to start).
+func (o *stepOutput) started() {
+	_ = o.outW.Close()
+	_ = o.errW.Close()
+}
+
+// wait drains the pipes. A process that escaped the step's process group
+// may keep them open; its output is dropped after grace.
+func (o *stepOutput) wait(grace time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		o.done.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		_ = o.outR.Close()
+		_ = o.errR.Close()
+		<-done
+	}
+	_ = o.outR.Close()
+	_ = o.errR.Close()
+}
+
 func (r *JobRunner) cleanupProcessGroup(req RunJobRequest, pid int) {
 	if pid <= 0 || !processGroupExists(pid) {
 		return
@@ -337,7 +911,12 @@ func createJobLogFile(req RunJobRequest) (string, *os.File, error) {
 
 	name := fmt.Sprintf("%s-%s-%s-%s.log", refPart, branchPart, shaPart, runPart)
 	logPath := filepath.Join(dir, name)
-	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	mode := os.FileMode(0o644)
+	if req.RunAs != nil {
+		// Keep other runs' output away from the sandbox user.
+		mode = 0o600
+	}
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
 	if err != nil {
 		return "", nil, fmt.Errorf("open log file %q: %w", logPath, err)
 	}
@@ -400,6 +979,11 @@ func waitForProcessGroupExit(pid int, timeout time.Duration) bool {
 	return !processGroupExists(pid)
 }
 
+func pullRequestNumber(branch string) int {
+	n, _ := ParsePullRequestBranch(branch)
+	return n
+}
+
 func newRunID() string {
 	return uuid.NewString()
 }
@@ -10,23 +10,39 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// JobConfFile matches .refci/conf.yml as a top-level job map.
+// JobConfFile matches .refci/conf.yml as a top-level job map. The reserved
+// keys include and defaults, and templates whose names start with a dot, are
+// described in conf_merge.go.
 //
 //	my-job:
 //	  branch_pattern: main
 //	  path_patterns:
 //	    - services/**
+//	    - "!services/**/*.md"
+//	  paths_ignore:
+//	    - docs/**
 //	  script: .refci/main.sh
+//	  pull_requests: true
 type JobConfFile map[string]JobConfSpec
 
 // JobConfSpec matches one job entry in .refci/conf.yml.
 type JobConfSpec struct {
-	BranchPattern string   `yaml:"branch_pattern"`
-	PathPatterns  []string `yaml:"path_patterns"`
-	Script        string   `yaml:"script"`
+	BranchPattern string      `yaml:"branch_pattern"`
+	PathPatterns  []string    `yaml:"path_patterns"`
+	PathsIgnore   []string    `yaml:"paths_ignore"`
+	Script        string      `yaml:"script"`
+	Run           string      `yaml:"run"`
+	Shell         string      `yaml:"shell"`
+	Steps         []StepConf  `yaml:"steps"`
+	PullRequests  bool        `yaml:"pull_requests"`
+	If            string      `yaml:"if"`
+	Cache         *CacheConf  `yaml:"cache"`
+	Limits        *LimitsConf `yaml:"limits"`
+	Reports       []string    `yaml:"reports"`
 }
 
-// LoadJobConfs loads job definitions from .refci/conf.yml format.
+// LoadJobConfs loads job definitions from .refci/conf.yml format. Includes
+// are read from disk relative to the repo checkout holding the file.
 func LoadJobConfs(path string) ([]JobConf, error) {
 	confPath := strings.TrimSpace(path)
 	if confPath == "" {
@@ -38,49 +54,148 @@ func LoadJobConfs(path string) ([]JobConf, error) {
 		return nil, fmt.Errorf("read job conf: %w", err)
 	}
 
-	return ParseJobConfs(string(data)), nil
+	repoRoot := filepath.Dir(confPath)
+	if filepath.Base(repoRoot) == ".refci" {
+		repoRoot = filepath.Dir(repoRoot)
+	}
+	read := func(rel string) (string, error) {
+		b, err := os.ReadFile(filepath.Join(repoRoot, filepath.FromSlash(rel)))
+		return string(b), err
+	}
+	return ParseJobConfFiles(confPath, string(data), read)
 }
 
-func ParseJobConfs(raw string) []JobConf {
-	var file JobConfFile
-	if err := yaml.Unmarshal([]byte(raw), &file); err != nil {
-		return nil
+// ParseJobConfs parses and validates a single conf.yml document. Problems are
+// returned together as ConfErrors with the line and column of each; an empty
+// document yields no jobs and no error. include: needs ParseJobConfFiles.
+func ParseJobConfs(raw string) ([]JobConf, error) {
+	return ParseJobConfFiles("", raw, nil)
+}
+
+// ParseJobConfFiles parses the config in file, reading include: entries
+// through read, then applies defaults: and extends: before validating each
+// job. Errors name the file each problem is in.
+func ParseJobConfFiles(file, raw string, read ConfReader) ([]JobConf, error) {
+	p := &confParser{read: read, files: map[*yaml.Node]string{}}
+	doc := p.loadFile(file, raw, nil)
+	if len(p.errs) > 0 {
+		return nil, p.errs
 	}
-	if len(file) == 0 {
-		return nil
+
+	jobs := p.resolveJobs(doc)
+	if len(p.errs) > 0 {
+		return nil, p.errs
 	}
 
-	normalized := make(map[string]JobConfSpec, len(file))
-	for name, spec := range file {
-		key := strings.TrimSpace(name)
-		if key == "" {
+	out := make([]JobConf, 0, len(jobs))
+	for _, job := range jobs {
+		conf, errs := p.parseJobNode(job.name, job.value)
+		if len(errs) > 0 {
+			p.errs = append(p.errs, errs...)
 			continue
 		}
-		if _, exists := normalized[key]; exists {
-			continue
+		out = append(out, conf)
+	}
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (p *confParser) parseJobNode(name string, node *yaml.Node) (JobConf, ConfErrors) {
+	if node.Kind != yaml.MappingNode {
+		return JobConf{}, ConfErrors{p.nodeError(node, name, "", "job definition must be a map")}
+	}
+
+	errs := p.checkKnownFields(node, name, "", jobConfSpecFields)
+	if steps := mappingValue(node, "steps"); steps != nil && steps.Kind == yaml.SequenceNode {
+		for i, stepNode := range steps.Content {
+			if stepNode.Kind != yaml.MappingNode {
+				errs = append(errs, p.nodeError(stepNode, name, fmt.Sprintf("steps[%d]", i), "step must be a map"))
+				continue
+			}
+			errs = append(errs, p.checkKnownFields(stepNode, name, fmt.Sprintf("steps[%d].", i), stepConfFields)...)
 		}
-		normalized[key] = spec
 	}
-	if len(normalized) == 0 {
-		return nil
+	if cache := mappingValue(node, "cache"); cache != nil && cache.Kind == yaml.MappingNode {
+		errs = append(errs, p.checkKnownFields(cache, name, "cache.", cacheConfFields)...)
+	}
+	if limits := mappingValue(node, "limits"); limits != nil && limits.Kind == yaml.MappingNode {
+		errs = append(errs, p.checkKnownFields(limits, name, "limits.", limitsConfFields)...)
+	}
+	if len(errs) > 0 {
+		return JobConf{}, errs
+	}
+
+	var spec JobConfSpec
+	if err := node.Decode(&spec); err != nil {
+		e := yamlConfError(err, node).withJob(name)
+		e.File = p.files[node]
+		return JobConf{}, ConfErrors{e}
+	}
+	if errs := p.validateJobSpec(name, spec, node); len(errs) > 0 {
+		return JobConf{}, errs
 	}
 
-	keys := make([]string, 0, len(normalized))
-	for key := range normalized {
-		keys = append(keys, key)
+	return JobConf{
+		Name:          name,
+		BranchPattern: spec.BranchPattern,
+		PathPatterns:  spec.PathPatterns,
+		PathsIgnore:   spec.PathsIgnore,
+		ScriptPath:    spec.Script,
+		Run:           spec.Run,
+		Shell:         strings.TrimSpace(spec.Shell),
+		Steps:         normalizeSteps(spec.Steps),
+		PullRequests:  spec.PullRequests,
+		If:            strings.TrimSpace(spec.If),
+		Cache:         normalizeCache(spec.Cache),
+		Limits:        spec.Limits,
+		Reports:       normalizeReports(spec.Reports),
+	}, nil
+}
+
+// normalizeCache trims the cache key and cleans its paths; validation has
+// already rejected paths that leave the repo.
+func normalizeCache(c *CacheConf) *CacheConf {
+	if c == nil {
+		return nil
+	}
+	out := &CacheConf{Key: strings.TrimSpace(c.Key)}
+	for _, p := range c.Paths {
+		clean, _ := cleanCachePath(p)
+		out.Paths = append(out.Paths, clean)
 	}
-	sort.Strings(keys)
+	return out
+}
 
-	out := make([]JobConf, 0, len(keys))
-	for _, name := range keys {
-		spec := normalized[name]
-		out = append(out, JobConf{
-			Name:          name,
-			BranchPattern: spec.BranchPattern,
-			PathPatterns:  spec.PathPatterns,
-			ScriptPath:    spec.Script,
-		})
+// normalizeReports cleans the report globs; validation has already
+// rejected the ones that leave the repo.
+func normalizeReports(patterns []string) []string {
+	var out []string
+	for _, p := range patterns {
+		clean, _ := cleanReportPattern(p)
+		out = append(out, clean)
 	}
+	return out
+}
 
+// normalizeSteps trims step fields and names unnamed steps after their
+// position so every step row has a label.
+func normalizeSteps(steps []StepConf) []StepConf {
+	if len(steps) == 0 {
+		return nil
+	}
+	out := make([]StepConf, 0, len(steps))
+	for i, step := range steps {
+		step.Name = strings.TrimSpace(step.Name)
+		step.Script = strings.TrimSpace(step.Script)
+		step.Shell = strings.TrimSpace(step.Shell)
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		out = append(out, step)
+	}
 	return out
 }
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseJobConfsValid(t *testing.T) {
+	raw := `
+test:
+  branch_pattern: feature-*
+  path_patterns:
+    - services/**
+    - "!services/**/*.md"
+  script: .refci/test.sh
+build:
+  shell: bash -euo pipefail
+  steps:
+    - name: setup
+      run: go mod download
+    - script: .refci/build.sh
+`
+	confs, err := ParseJobConfs(raw)
+	if err != nil {
+		t.Fatalf("ParseJobConfs() error = %v", err)
+	}
+	if len(confs) != 2 || confs[0].Name != "build" || confs[1].Name != "test" {
+		t.Fatalf("confs = %+v, want build and test sorted by name", confs)
+	}
+	if got := confs[0].Steps[1].Name; got != "step-2" {
+		t.Fatalf("unnamed step name = %q, want step-2", got)
+	}
+
+	empty, err := ParseJobConfs("")
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("ParseJobConfs(empty) = %v, %v; want no jobs and no error", empty, err)
+	}
+}
+
+func TestParseJobConfsReportsPositions(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+		line int
+		col  int
+		want string
+	}{
+		{
+			name: "unknown field",
+			raw:  "build:\n  branch_patern: main\n  script: a.sh\n",
+			line: 2, col: 3,
+			want: "job build: branch_patern: unknown field (did you mean branch_pattern?)",
+		},
+		{
+			name: "unknown step field",
+			raw:  "build:\n  steps:\n    - name: a\n      scirpt: a.sh\n",
+			line: 4, col: 7,
+			want: "steps[0].scirpt: unknown field (did you mean script?)",
+		},
+		{
+			name: "missing script",
+			raw:  "build:\n  branch_pattern: main\n",
+			line: 2, col: 3,
+			want: "one of script, run or steps is required",
+		},
+		{
+			name: "script and run",
+			raw:  "build:\n  script: a.sh\n  run: make\n",
+			line: 3, col: 8,
+			want: "script and run are mutually exclusive",
+		},
+		{
+			name: "bad glob",
+			raw:  "build:\n  script: a.sh\n  path_patterns:\n    - src/**\n    - \"src/[a\"\n",
+			line: 5, col: 7,
+			want: "path_patterns[1]: bad glob",
+		},
+		{
+			name: "bad branch pattern",
+			raw:  "build:\n  script: a.sh\n  branch_pattern: \"*-release\"\n",
+			line: 3, col: 19,
+			want: "only a single trailing wildcard",
+		},
+		{
+			name: "duplicate job",
+			raw:  "build:\n  script: a.sh\nbuild:\n  script: b.sh\n",
+			line: 3, col: 1,
+			want: "duplicate job name (first defined at line 1)",
+		},
+		{
+			name: "bad if expression",
+			raw:  "build:\n  script: a.sh\n  if: brnch == 'main'\n",
+			line: 3, col: 7,
+			want: "job build: if: at character 1: unknown variable \"brnch\" (did you mean branch?)",
+		},
+		{
+			name: "cache path outside repo",
+			raw:  "build:\n  script: a.sh\n  cache:\n    key: deps\n    paths: [../shared]\n",
+			line: 5, col: 12,
+			want: "job build: cache.paths[0]: cache path \"../shared\" must stay inside the repo",
+		},
+		{
+			name: "unknown cache field",
+			raw:  "build:\n  script: a.sh\n  cache:\n    kye: deps\n",
+			line: 4, col: 5,
+			want: "cache.kye: unknown field (did you mean key?)",
+		},
+		{
+			name: "bad memory limit",
+			raw:  "build:\n  script: a.sh\n  limits:\n    memory: 4 gigs\n    cpu: 2\n",
+			line: 4, col: 13,
+			want: "job build: limits.memory: bad size \"4 gigs\"",
+		},
+		{
+			name: "report outside repo",
+			raw:  "build:\n  script: a.sh\n  reports:\n    - out/junit.xml\n    - /tmp/junit.xml\n",
+			line: 5, col: 7,
+			want: "job build: reports[1]: report pattern \"/tmp/junit.xml\" must be relative to the repo",
+		},
+		{
+			name: "wrong type",
+			raw:  "build:\n  script: a.sh\n  path_patterns: src/**\n",
+			line: 3,
+			want: "cannot unmarshal",
+		},
+		{
+			name: "syntax error",
+			raw:  "build:\n  script: a.sh\n   run: [\n",
+			line: 3,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			confs, err := ParseJobConfs(tc.raw)
+			if err == nil {
+				t.Fatalf("ParseJobConfs() = %+v, want error", confs)
+			}
+			var confErrs ConfErrors
+			if !errors.As(err, &confErrs) {
+				t.Fatalf("error type = %T, want ConfErrors", err)
+			}
+			first := confErrs[0]
+			if first.Line != tc.line || (tc.col > 0 && first.Column != tc.col) {
+				t.Fatalf("position = %d:%d, want %d:%d (%v)", first.Line, first.Column, tc.line, tc.col, first)
+			}
+			if !strings.Contains(first.Error(), tc.want) {
+				t.Fatalf("error = %q, want it to contain %q", first.Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestParseJobConfsCollectsAllErrors(t *testing.T) {
+	raw := "a:\n  script: a.sh\n  typo: 1\nb:\n  run: make\n  brnch_pattern: x\n"
+	_, err := ParseJobConfs(raw)
+	var confErrs ConfErrors
+	if !errors.As(err, &confErrs) || len(confErrs) != 2 {
+		t.Fatalf("error = %v, want two ConfErrors", err)
+	}
+
+	err = withConfFile(err, ".refci/conf.yml")
+	if got := err.Error(); !strings.HasPrefix(got, ".refci/conf.yml:3:3: job a: typo: unknown field") || !strings.HasSuffix(got, "(and 1 more)") {
+		t.Fatalf("Error() = %q", got)
+	}
+}
@@ -0,0 +1,356 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogStream is where a line of a job log came from.
+type LogStream uint8
+
+const (
+	StreamRefci  LogStream = iota // refci's own lines: step headers, cache and limit notes
+	StreamStdout                  // a step's standard output
+	StreamStderr                  // a step's standard error
+)
+
+func (s LogStream) String() string {
+	switch s {
+	case StreamRefci:
+		return "refci"
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	}
+	return fmt.Sprintf("stream(%d)", uint8(s))
+}
+
+// ParseLogStream parses a stream name as printed by LogStream.String.
+func ParseLogStream(name string) (LogStream, error) {
+	for _, s := range []LogStream{StreamRefci, StreamStdout, StreamStderr} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log stream %q (want refci, stdout or stderr)", name)
+}
+
+// A job log is plain text, so it can be read as is, with a sidecar index of
+// fixed-size frames, one per line: the byte offset the line starts at, and
+// the stream it came from in the top byte of the time its first byte was
+// written (unix milliseconds), both little-endian.
+const (
+	lineFrameSize = 16
+	lineTimeMask  = 1<<56 - 1
+)
+
+// LineIndexPath is the sidecar of a job log that records the stream and the
+// time of each of its lines.
+func LineIndexPath(logPath string) string {
+	return logPath + ".lines"
+}
+
+// jobLog is a job's log file. Every write goes through it so each line can
+// be indexed. A line holds the output of one stream: when another stream
+// writes while a line is open, the line is ended first.
+type jobLog struct {
+	mu        sync.Mutex
+	f         *os.File
+	index     *os.File
+	indexBuf  *bufio.Writer
+	offset    int64
+	lineStart bool
+	stream    LogStream // of the open line
+	now       func() time.Time
+}
+
+// newJobLog wraps a freshly created log file and creates its index with the
+// same permissions. Without an index the log still works, just without
+// streams and timestamps.
+func newJobLog(f *os.File) *jobLog {
+	l := &jobLog{f: f, lineStart: true, now: time.Now}
+	mode := os.FileMode(0o644)
+	if info, err := f.Stat(); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if index, err := os.OpenFile(LineIndexPath(f.Name()), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode); err == nil {
+		l.index = index
+		l.indexBuf = bufio.NewWriter(index)
+	}
+	return l
+}
+
+// Write writes refci's own output.
+func (l *jobLog) Write(p []byte) (int, error) {
+	return l.writeStream(StreamRefci, p)
+}
+
+// streamWriter returns a writer for a step's stdout or stderr.
+func (l *jobLog) streamWriter(stream LogStream) io.Writer {
+	return logStreamWriter{log: l, stream: stream}
+}
+
+type logStreamWriter struct {
+	log    *jobLog
+	stream LogStream
+}
+
+func (w logStreamWriter) Write(p []byte) (int, error) {
+	return w.log.writeStream(w.stream, p)
+}
+
+func (l *jobLog) writeStream(stream LogStream, p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if !l.lineStart && l.stream != stream {
+		n, err := l.f.Write([]byte{'\n'})
+		l.offset += int64(n)
+		if err != nil {
+			return 0, err
+		}
+		l.lineStart = true
+	}
+
+	if l.indexBuf != nil {
+		now := l.now().UnixMilli()
+		if l.lineStart {
+			l.indexLine(l.offset, stream, now)
+		}
+		for i, c := range p[:len(p)-1] {
+			if c == '\n' {
+				l.indexLine(l.offset+int64(i)+1, stream, now)
+			}
+		}
+		_ = l.indexBuf.Flush()
+	}
+
+	n, err := l.f.Write(p)
+	l.offset += int64(n)
+	l.lineStart = n > 0 && p[n-1] == '\n'
+	l.stream = stream
+	return n, err
+}
+
+func (l *jobLog) indexLine(offset int64, stream LogStream, unixMilli int64) {
+	var frame [lineFrameSize]byte
+	binary.LittleEndian.PutUint64(frame[:8], uint64(offset))
+	binary.LittleEndian.PutUint64(frame[8:], uint64(stream)<<56|uint64(unixMilli)&lineTimeMask)
+	_, _ = l.indexBuf.Write(frame[:])
+}
+
+// Offset is the size of the log written so far.
+func (l *jobLog) Offset() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.offset
+}
+
+func (l *jobLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.index != nil {
+		_ = l.indexBuf.Flush()
+		_ = l.index.Close()
+	}
+	return l.f.Close()
+}
+
+// LogLine is one entry of a job log's line index.
+type LogLine struct {
+	Offset int64
+	Time   time.Time
+	Stream LogStream
+}
+
+func decodeLogLine(frame []byte) LogLine {
+	v := binary.LittleEndian.Uint64(frame[8:])
+	return LogLine{
+		Offset: int64(binary.LittleEndian.Uint64(frame[:8])),
+		Time:   time.UnixMilli(int64(v & lineTimeMask)),
+		Stream: LogStream(v >> 56),
+	}
+}
+
+// LineIndex reads the line index of a job log.
+type LineIndex struct {
+	f     *os.File
+	count int64
+}
+
+// OpenLineIndex opens the index of the log at logPath. Logs written before
+// lines were indexed have none; the error then satisfies os.IsNotExist.
+func OpenLineIndex(logPath string) (*LineIndex, error) {
+	f, err := os.Open(LineIndexPath(logPath))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &LineIndex{f: f, count: info.Size() / lineFrameSize}, nil
+}
+
+func (x *LineIndex) Close() error {
+	return x.f.Close()
+}
+
+// Len is the number of lines indexed when x was opened.
+func (x *LineIndex) Len() int64 {
+	return x.count
+}
+
+// Line returns the i-th indexed line.
+func (x *LineIndex) Line(i int64) (LogLine, error) {
+	var frame [lineFrameSize]byte
+	if _, err := x.f.ReadAt(frame[:], i*lineFrameSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return LogLine{}, fmt.Errorf("read log line %d: %w", i, err)
+	}
+	return decodeLogLine(frame[:]), nil
+}
+
+// Start is when the first line of the log was written.
+func (x *LineIndex) Start() (time.Time, bool) {
+	if x.count == 0 {
+		return time.Time{}, false
+	}
+	line, err := x.Line(0)
+	return line.Time, err == nil
+}
+
+// Find returns the index of the line containing offset, or -1 when no line
+// starts at or before it.
+func (x *LineIndex) Find(offset int64) (int64, error) {
+	var searchErr error
+	i := sort.Search(int(x.count), func(i int) bool {
+		line, err := x.Line(int64(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return line.Offset > offset
+	})
+	if searchErr != nil {
+		return -1, searchErr
+	}
+	return int64(i) - 1, nil
+}
+
+// At returns the line containing offset; ok is false when no line starts at
+// or before it.
+func (x *LineIndex) At(offset int64) (line LogLine, ok bool, err error) {
+	i, err := x.Find(offset)
+	if err != nil || i < 0 {
+		return LogLine{}, false, err
+	}
+	line, err = x.Line(i)
+	return line, err == nil, err
+}
+
+// Lines reads up to limit lines from the i-th on.
+func (x *LineIndex) Lines(i int64, limit int) ([]LogLine, error) {
+	n := min(int64(limit), x.count-i)
+	if n <= 0 {
+		return nil, nil
+	}
+	buf := make([]byte, n*lineFrameSize)
+	if _, err := x.f.ReadAt(buf, i*lineFrameSize); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read log lines from %d: %w", i, err)
+	}
+	out := make([]LogLine, n)
+	for k := range out {
+		out[k] = decodeLogLine(buf[k*lineFrameSize:])
+	}
+	return out, nil
+}
+
+// LogExportOptions selects what ExportLog writes.
+type LogExportOptions struct {
+	Streams    []LogStream // only lines of these streams; all when empty
+	Timestamps bool        // prefix each line with when it was written
+}
+
+// ErrNoLineIndex is returned when an export needs the streams or times of a
+// log that has no line index.
+var ErrNoLineIndex = errors.New("log has no line index (written by an older refci)")
+
+// ExportLog writes the job log at logPath to w as plain text.
+func ExportLog(w io.Writer, logPath string, opts LogExportOptions) error {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if len(opts.Streams) == 0 && !opts.Timestamps {
+		_, err := io.Copy(w, f)
+		return err
+	}
+
+	index, err := OpenLineIndex(logPath)
+	if os.IsNotExist(err) {
+		return ErrNoLineIndex
+	}
+	if err != nil {
+		return err
+	}
+	defer index.Close()
+
+	const batch = 4096
+	src := bufio.NewReaderSize(f, 64<<10)
+	out := bufio.NewWriter(w)
+	pos := int64(0)
+	for i := int64(0); i < index.Len(); i += batch {
+		lines, err := index.Lines(i, batch+1) // one more for the end of the last
+		if err != nil {
+			return err
+		}
+		for k, line := range lines {
+			if k == batch {
+				break
+			}
+			if line.Offset > pos {
+				// Bytes before the first indexed line.
+				if _, err := io.CopyN(io.Discard, src, line.Offset-pos); err != nil {
+					return err
+				}
+				pos = line.Offset
+			}
+			keep := len(opts.Streams) == 0 || slices.Contains(opts.Streams, line.Stream)
+			var dst io.Writer = io.Discard
+			if keep {
+				dst = out
+				if opts.Timestamps {
+					fmt.Fprintf(out, "%s ", line.Time.Local().Format("2006-01-02T15:04:05.000Z07:00"))
+				}
+			}
+			var n int64
+			if k+1 < len(lines) {
+				n, err = io.CopyN(dst, src, lines[k+1].Offset-line.Offset)
+			} else {
+				n, err = io.Copy(dst, src)
+			}
+			pos += n
+			if err != nil && err != io.EOF {
+				return err
+			}
+		}
+	}
+	return out.Flush()
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobLogIndexesLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	log := newJobLog(f)
+	clock := time.UnixMilli(1_700_000_000_000)
+	log.now = func() time.Time { return clock }
+	stdout, stderr := log.streamWriter(StreamStdout), log.streamWriter(StreamStderr)
+
+	writes := []struct {
+		w       io.Writer
+		data    string
+		advance time.Duration
+	}{
+		{w: log, data: "==> step\n"},                          // @0
+		{w: stdout, data: "one\ntw"},                          // @9, @13
+		{w: stdout, data: "o\n", advance: time.Second},        // "two" was started by the first write
+		{w: stdout, data: "partial", advance: time.Second},    // @17
+		{w: stderr, data: "boom\n", advance: 2 * time.Second}, // ends "partial" first: @25
+	}
+	for _, w := range writes {
+		clock = clock.Add(w.advance)
+		if _, err := w.w.Write([]byte(w.data)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	want := "==> step\none\ntwo\npartial\nboom\n"
+	if got := log.Offset(); got != int64(len(want)) {
+		t.Fatalf("Offset() = %d, want %d", got, len(want))
+	}
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if body, _ := os.ReadFile(path); string(body) != want {
+		t.Fatalf("log = %q, want %q", body, want)
+	}
+
+	index, err := OpenLineIndex(path)
+	if err != nil {
+		t.Fatalf("OpenLineIndex() error = %v", err)
+	}
+	defer index.Close()
+	start, ok := index.Start()
+	if !ok || start.UnixMilli() != 1_700_000_000_000 {
+		t.Fatalf("Start() = %v, %v", start, ok)
+	}
+
+	tests := []struct {
+		offset int64
+		stream LogStream
+		after  time.Duration // start
+	}{
+		{offset: 0, stream: StreamRefci},
+		{offset: 9, stream: StreamStdout},
+		{offset: 15, stream: StreamStdout}, // inside "two"
+		{offset: 17, stream: StreamStdout, after: 2 * time.Second},
+		{offset: 25, stream: StreamStderr, after: 4 * time.Second},
+		{offset: 100, stream: StreamStderr, after: 4 * time.Second},
+	}
+	for _, tt := range tests {
+		line, ok, err := index.At(tt.offset)
+		if err != nil || !ok {
+			t.Fatalf("At(%d) = %v, %v, %v", tt.offset, line, ok, err)
+		}
+		if line.Stream != tt.stream || line.Time.Sub(start) != tt.after {
+			t.Fatalf("At(%d) = %s at start+%s, want %s at start+%s", tt.offset, line.Stream, line.Time.Sub(start), tt.stream, tt.after)
+		}
+	}
+
+	exports := []struct {
+		opts LogExportOptions
+		want string
+	}{
+		{opts: LogExportOptions{}, want: want},
+		{opts: LogExportOptions{Streams: []LogStream{StreamStderr}}, want: "boom\n"},
+		{opts: LogExportOptions{Streams: []LogStream{StreamStdout, StreamStderr}}, want: "one\ntwo\npartial\nboom\n"},
+	}
+	for _, tt := range exports {
+		var buf bytes.Buffer
+		if err := ExportLog(&buf, path, tt.opts); err != nil {
+			t.Fatalf("ExportLog(%+v) error = %v", tt.opts, err)
+		}
+		if buf.String() != tt.want {
+			t.Fatalf("ExportLog(%+v) = %q, want %q", tt.opts, buf.String(), tt.want)
+		}
+	}
+	var buf bytes.Buffer
+	if err := ExportLog(&buf, path, LogExportOptions{Timestamps: true}); err != nil {
+		t.Fatalf("ExportLog(timestamps) error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	stamp := start.Add(4 * time.Second).Local().Format("2006-01-02T15:04:05.000Z07:00")
+	if len(lines) != 5 || lines[4] != stamp+" boom" {
+		t.Fatalf("ExportLog(timestamps) = %q, want 5 lines ending with %q", buf.String(), stamp+" boom")
+	}
+
+	old := filepath.Join(t.TempDir(), "old.log")
+	if err := os.WriteFile(old, []byte("x\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := OpenLineIndex(old); !os.IsNotExist(err) {
+		t.Fatalf("OpenLineIndex(no index) error = %v, want not exist", err)
+	}
+	if err := ExportLog(&buf, old, LogExportOptions{Timestamps: true}); !errors.Is(err, ErrNoLineIndex) {
+		t.Fatalf("ExportLog(no index) error = %v, want ErrNoLineIndex", err)
+	}
+}
+
+func TestJobRunnerSeparatesStreams(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	sha := src.commit(t, "README.md", "hello\n", "init")
+	src.mirror(t, "acme/app")
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+	jc := JobConf{Repo: "acme/app", Name: "build", Run: "echo out1; echo err1 >&2; sleep 0.1; echo out2"}
+	if err := runner.QueueJob(jc, nil, "main", sha, "test"); err != nil {
+		t.Fatalf("QueueJob() error = %v", err)
+	}
+	job, err := repo.LatestJobByNameBranch("acme/app", "build", "main")
+	if err != nil {
+		t.Fatalf("LatestJobByNameBranch() error = %v", err)
+	}
+	job = waitJobDone(t, repo, job.RunID)
+	if job.Status != StatusFinished {
+		t.Fatalf("job status = %q (%s)", job.Status, job.Msg)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportLog(&buf, job.LogPath, LogExportOptions{Streams: []LogStream{StreamStderr}}); err != nil {
+		t.Fatalf("ExportLog() error = %v", err)
+	}
+	if buf.String() != "err1\n" {
+		t.Fatalf("stderr = %q, want %q", buf.String(), "err1\n")
+	}
+	buf.Reset()
+	if err := ExportLog(&buf, job.LogPath, LogExportOptions{Streams: []LogStream{StreamStdout}}); err != nil {
+		t.Fatalf("ExportLog() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "out1\n") || !strings.HasSuffix(buf.String(), "out2\n") || strings.Contains(buf.String(), "err1") {
+		t.Fatalf("stdout = %q, want out1 and out2 only", buf.String())
+	}
+
+	// Indexed for search when the run finished.
+	matches, err := repo.SearchJobLogs(LogSearch{Pattern: "err1"})
+	if err != nil || len(matches) != 1 || matches[0].Job.RunID != job.RunID {
+		t.Fatalf("SearchJobLogs(err1) = %+v, %v", matches, err)
+	}
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cgroupEnv names a delegated cgroup to create run cgroups under instead of
+// the runner's own. It is only read on Linux.
+const cgroupEnv = "REFCI_CGROUP"
+
+// LimitsConf is a job's limits: section. Memory is a size such as 4G or
+// 512MiB, CPU a number of cores (fractions allowed) and PIDs the maximum
+// number of processes. Zero values are unlimited.
+type LimitsConf struct {
+	Memory string  `yaml:"memory"`
+	CPU    float64 `yaml:"cpu"`
+	PIDs   int     `yaml:"pids"`
+}
+
+// String renders the configured limits, e.g. "memory=4G cpu=2 pids=512".
+func (l LimitsConf) String() string {
+	var parts []string
+	if strings.TrimSpace(l.Memory) != "" {
+		parts = append(parts, "memory="+strings.TrimSpace(l.Memory))
+	}
+	if l.CPU > 0 {
+		parts = append(parts, "cpu="+strconv.FormatFloat(l.CPU, 'f', -1, 64))
+	}
+	if l.PIDs > 0 {
+		parts = append(parts, fmt.Sprintf("pids=%d", l.PIDs))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l LimitsConf) memoryBytes() int64 {
+	n, _ := ParseByteSize(l.Memory)
+	return n
+}
+
+// validateLimitsConf checks a limits: section.
+func validateLimitsConf(l LimitsConf) (field string, err error) {
+	if strings.TrimSpace(l.Memory) != "" {
+		n, err := ParseByteSize(l.Memory)
+		if err != nil {
+			return "memory", err
+		}
+		if n < 1<<20 {
+			return "memory", fmt.Errorf("memory limit %q is below 1MiB", l.Memory)
+		}
+	}
+	if l.CPU < 0 {
+		return "cpu", errors.New("cpu must be positive")
+	}
+	if l.PIDs < 0 {
+		return "pids", errors.New("pids must be positive")
+	}
+	if l.String() == "" {
+		return "", errors.New("limits needs at least one of memory, cpu or pids")
+	}
+	return "", nil
+}
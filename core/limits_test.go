@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJobRunnerRecordsUsageWithLimits(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+
+	req := RunJobRequest{
+		RunID:   "run-limits",
+		Repo:    "acme/app",
+		Name:    "build",
+		Branch:  "main",
+		SHA:     "deadbeefcafebabe",
+		WorkDir: t.TempDir(),
+		Steps: []RunStep{{Name: "spin", Args: []string{"bash", "-c",
+			"i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done; echo done"}}},
+		Limits: &LimitsConf{Memory: "256M", CPU: 1, PIDs: 64},
+	}
+	logPath, err := runner.Start(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	job := waitJobDone(t, repo, req.RunID)
+	if job.Status != StatusFinished {
+		t.Fatalf("job status = %q (%s)", job.Status, job.Msg)
+	}
+	if job.PeakMemory <= 0 || job.CPUTime <= 0 {
+		t.Fatalf("job usage = peak %d, cpu %s; want both recorded", job.PeakMemory, job.CPUTime)
+	}
+
+	body, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	// Enforced where cgroup v2 is delegated; otherwise the log says why not.
+	if !strings.Contains(string(body), "==> limits memory=256M cpu=1 pids=64") &&
+		!strings.Contains(string(body), "==> limits not enforced (memory=256M cpu=1 pids=64)") {
+		t.Fatalf("log = %q, want a limits line", body)
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// logIndexLineMaxBytes caps how much of one log line is indexed.
+const logIndexLineMaxBytes = 4 << 10
+
+// IndexJobLog adds the finished job's log to the search index, replacing
+// what was indexed for it before. Lines are indexed as shown, without their
+// escape sequences; blank lines are left out. A log that no longer exists is
+// recorded with no lines, so it is not looked at again.
+func IndexJobLog(dbRepo DbRepo, job Job) error {
+	f, err := os.Open(job.LogPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var readErr error
+	lines := func(yield func(int, string) bool) {
+		if f == nil {
+			return
+		}
+		r := bufio.NewReaderSize(f, 64<<10)
+		var line []byte
+		for n := 0; ; {
+			chunk, err := r.ReadSlice('\n')
+			if room := logIndexLineMaxBytes - len(line); room > 0 {
+				line = append(line, chunk[:min(len(chunk), room)]...)
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if len(chunk) > 0 || len(line) > 0 {
+				if text := PlainLogLine(string(line)); strings.TrimSpace(text) != "" && !yield(n, text) {
+					return
+				}
+				line, n = line[:0], n+1
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr = err
+				}
+				return
+			}
+		}
+	}
+	if f != nil {
+		defer f.Close()
+	}
+	if err := dbRepo.IndexJobLog(job.RunID, lines); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return fmt.Errorf("read %s: %w", job.LogPath, readErr)
+	}
+	return nil
+}
+
+// IndexPendingLogs indexes the logs of done runs that are not in the search
+// index yet, e.g. of runs from before it existed or cut short by a restart.
+func IndexPendingLogs(dbRepo DbRepo, repo string) (int, error) {
+	jobs, err := dbRepo.ListUnindexedJobs(repo)
+	if err != nil {
+		return 0, err
+	}
+	for i, job := range jobs {
+		if err := IndexJobLog(dbRepo, job); err != nil {
+			return i, fmt.Errorf("index log of run %s: %w", job.RunID, err)
+		}
+	}
+	return len(jobs), nil
+}
+
+// SearchLogs finds the job log lines containing search.Pattern, oldest run
+// first, after indexing the logs not indexed yet. Like the log viewer's
+// search, it ignores case unless the pattern has upper-case letters.
+func SearchLogs(dbRepo DbRepo, search LogSearch) ([]LogMatch, error) {
+	if search.Pattern == "" {
+		return nil, fmt.Errorf("empty search pattern")
+	}
+	if _, err := IndexPendingLogs(dbRepo, search.Repo); err != nil {
+		return nil, err
+	}
+	if !strings.ContainsFunc(search.Pattern, unicode.IsUpper) {
+		return dbRepo.SearchJobLogs(search)
+	}
+
+	limit := search.Limit
+	search.Limit = 0
+	matches, err := dbRepo.SearchJobLogs(search)
+	if err != nil {
+		return nil, err
+	}
+	out := matches[:0]
+	for _, m := range matches {
+		if strings.Contains(m.Text, search.Pattern) {
+			out = append(out, m)
+		}
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// PlainLogLine is the text a log line shows on a terminal: escape sequences
+// and control characters are dropped, a carriage return (progress output)
+// keeps only the text after it, and a tab becomes a space.
+func PlainLogLine(line string) string {
+	line = strings.TrimRight(line, "\r\n")
+	if i := strings.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	var b strings.Builder
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == 0x1b:
+			i += EscapeSequenceLen(line[i:])
+		case c == '\t':
+			b.WriteByte(' ')
+			i++
+		case c < 0x20 || c == 0x7f:
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// EscapeSequenceLen is the length of the escape sequence at the start of s:
+// a CSI sequence, an OSC string or a two-byte escape.
+func EscapeSequenceLen(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(s)
+	case ']':
+		for i := 2; i < len(s); i++ {
+			if s[i] == 0x07 {
+				return i + 1
+			}
+			if s[i] == 0x1b && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	}
+	return 2
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSearchLogs(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	db := openTestDB(t)
+	repo, err := NewSQLiteRepo(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	logDir := filepath.Join(Root, "logs", ToLocalRepo("acme/app"))
+
+	runs := []struct {
+		id     string
+		name   string
+		status string
+		age    time.Duration
+		log    string
+	}{
+		{id: "old", name: "test", status: StatusFailed, age: 48 * time.Hour, log: "ok\n\x1b[31mError: connection refused\x1b[0m\n"},
+		{id: "new", name: "test", status: StatusFailed, age: time.Hour, log: "downloading 10%\rdownloading 100%\n\nerror: connection refused\n50% done\n"},
+		{id: "lint", name: "lint", status: StatusFinished, age: 2 * time.Hour, log: "connection refused? no\n"},
+		{id: "live", name: "test", status: StatusRunning, age: time.Minute, log: "connection refused\n"},
+	}
+	for _, r := range runs {
+		if err := repo.CreateJob(Job{RunID: r.id, Repo: "acme/app", Name: r.name, Branch: "main", SHA: r.id}); err != nil {
+			t.Fatalf("CreateJob(%s) error = %v", r.id, err)
+		}
+		logPath := filepath.Join(logDir, r.id+".log")
+		writeTestFile(t, logPath, r.log)
+		if err := repo.UpdateJob(r.id, r.status, "", logPath); err != nil {
+			t.Fatalf("UpdateJob(%s) error = %v", r.id, err)
+		}
+		if _, err := db.Exec(`UPDATE jobs SET start_at = ? WHERE run_id = ?`, formatStoredTime(now.Add(-r.age)), r.id); err != nil {
+			t.Fatalf("set start_at error = %v", err)
+		}
+	}
+
+	type hit struct {
+		run  string
+		line int
+		text string
+	}
+	tests := []struct {
+		name   string
+		search LogSearch
+		want   []hit
+	}{
+		{
+			name:   "oldest first, escapes dropped",
+			search: LogSearch{Pattern: "connection refused", Repo: "acme/app"},
+			want: []hit{
+				{run: "old", line: 1, text: "Error: connection refused"},
+				{run: "lint", line: 0, text: "connection refused? no"},
+				{run: "new", line: 2, text: "error: connection refused"},
+			},
+		},
+		{
+			name:   "upper case matches case",
+			search: LogSearch{Pattern: "Error:"},
+			want:   []hit{{run: "old", line: 1, text: "Error: connection refused"}},
+		},
+		{
+			name:   "job and since",
+			search: LogSearch{Pattern: "refused", Job: "test", Since: now.Add(-3 * time.Hour)},
+			want:   []hit{{run: "new", line: 2, text: "error: connection refused"}},
+		},
+		{
+			name:   "short pattern and wildcards",
+			search: LogSearch{Pattern: "0%"},
+			want: []hit{
+				{run: "new", line: 0, text: "downloading 100%"},
+				{run: "new", line: 3, text: "50% done"},
+			},
+		},
+		{
+			name:   "limit",
+			search: LogSearch{Pattern: "refused", Limit: 1},
+			want:   []hit{{run: "old", line: 1, text: "Error: connection refused"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := SearchLogs(repo, tt.search)
+			if err != nil {
+				t.Fatalf("SearchLogs() error = %v", err)
+			}
+			var got []hit
+			for _, m := range matches {
+				got = append(got, hit{run: m.Job.RunID, line: m.Line, text: m.Text})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("SearchLogs() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("SearchLogs()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	// The running job is indexed when it finishes.
+	if jobs, err := repo.ListUnindexedJobs(""); err != nil || len(jobs) != 0 {
+		t.Fatalf("ListUnindexedJobs() = %+v, %v; want none", jobs, err)
+	}
+	writeTestFile(t, filepath.Join(logDir, "old.log"), "rewritten\n")
+	if err := IndexJobLog(repo, Job{RunID: "old", LogPath: filepath.Join(logDir, "old.log")}); err != nil {
+		t.Fatalf("IndexJobLog() error = %v", err)
+	}
+	if err := repo.DeleteJob("lint"); err != nil {
+		t.Fatalf("DeleteJob() error = %v", err)
+	}
+	matches, err := repo.SearchJobLogs(LogSearch{Pattern: "refused"})
+	if err != nil || len(matches) != 1 || matches[0].Job.RunID != "new" {
+		t.Fatalf("SearchJobLogs() after reindex and delete = %+v, %v; want only run new", matches, err)
+	}
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// secretMask replaces secret values in job output.
+const secretMask = "***"
+
+// minSecretLen is the shortest value that is masked; masking "1" or "yes"
+// would garble logs without protecting anything.
+const minSecretLen = 4
+
+// secretPatterns returns the byte strings to mask for secrets, longest first:
+// each value, every line of a multi-line value, and their base64 and
+// URL-encoded forms. The base64 forms include the prefix made of whole
+// 3-byte groups, so a secret at the start of a longer encoded blob is caught.
+func secretPatterns(secrets []string) [][]byte {
+	seen := map[string]bool{}
+	add := func(s string) {
+		if len(s) >= minSecretLen {
+			seen[s] = true
+		}
+	}
+	for _, secret := range secrets {
+		values := []string{secret}
+		if strings.Contains(secret, "\n") {
+			values = append(values, strings.Split(secret, "\n")...)
+		}
+		for _, v := range values {
+			v = strings.TrimRight(v, "\r")
+			if len(v) < minSecretLen {
+				continue
+			}
+			add(v)
+			add(url.QueryEscape(v))
+			add(url.PathEscape(v))
+			for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+				add(enc.EncodeToString([]byte(v)))
+				add(enc.EncodeToString([]byte(v[:len(v)/3*3])))
+			}
+		}
+	}
+
+	out := make([][]byte, 0, len(seen))
+	for s := range seen {
+		out = append(out, []byte(s))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if len(out[i]) != len(out[j]) {
+			return len(out[i]) > len(out[j])
+		}
+		return bytes.Compare(out[i], out[j]) < 0
+	})
+	return out
+}
+
+// maskingWriter replaces secrets in everything written through it. Bytes
+// that could be the start of a secret are held back until the next write
+// shows whether they are, so a secret split across writes is still masked;
+// Flush writes what is held back once the writer is done.
+type maskingWriter struct {
+	w        io.Writer
+	patterns [][]byte
+	first    [256]bool // first bytes of the patterns
+
+	mu      sync.Mutex
+	pending []byte
+}
+
+// newMaskingWriter returns a writer masking secrets on the way to w, or nil
+// when none of them is long enough to mask.
+func newMaskingWriter(w io.Writer, secrets []string) *maskingWriter {
+	patterns := secretPatterns(secrets)
+	if len(patterns) == 0 {
+		return nil
+	}
+	m := &maskingWriter{w: w, patterns: patterns}
+	for _, p := range patterns {
+		m.first[p[0]] = true
+	}
+	return m
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pending = append(m.pending, p...)
+	out, held := m.mask(m.pending, false)
+	if len(out) > 0 {
+		if _, err := m.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	m.pending = append(m.pending[:0], held...)
+	return len(p), nil
+}
+
+// Flush masks and writes the held back bytes.
+func (m *maskingWriter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out, _ := m.mask(m.pending, true)
+	m.pending = m.pending[:0]
+	if len(out) == 0 {
+		return nil
+	}
+	_, err := m.w.Write(out)
+	return err
+}
+
+// mask returns buf with the secrets replaced, and, unless final, the tail of
+// buf that is a prefix of some secret and so cannot be written yet.
+func (m *maskingWriter) mask(buf []byte, final bool) ([]byte, []byte) {
+	out := make([]byte, 0, len(buf))
+	i := 0
+scan:
+	for i < len(buf) {
+		if !m.first[buf[i]] {
+			out = append(out, buf[i])
+			i++
+			continue
+		}
+		rest := buf[i:]
+		for _, p := range m.patterns {
+			if bytes.HasPrefix(rest, p) {
+				out = append(out, secretMask...)
+				i += len(p)
+				continue scan
+			}
+		}
+		if !final {
+			for _, p := range m.patterns {
+				if len(rest) < len(p) && bytes.HasPrefix(p, rest) {
+					return out, rest
+				}
+			}
+		}
+		out = append(out, buf[i])
+		i++
+	}
+	return out, nil
+}
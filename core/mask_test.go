@@ -0,0 +1,157 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMaskingWriter(t *testing.T) {
+	const secret = "s3cr3t+token/value"
+	tests := []struct {
+		name    string
+		secrets []string
+		input   string
+		chunk   int // write size; 0 writes everything at once
+		want    string
+	}{
+		{
+			name:    "plain",
+			secrets: []string{secret},
+			input:   "token=" + secret + "\n",
+			want:    "token=***\n",
+		},
+		{
+			name:    "base64",
+			secrets: []string{secret},
+			input:   "auth " + base64.StdEncoding.EncodeToString([]byte(secret)) + "\n",
+			want:    "auth ***\n",
+		},
+		{
+			name:    "base64 of a longer value",
+			secrets: []string{secret},
+			input:   base64.StdEncoding.EncodeToString([]byte(secret+":x-oauth")) + "\n",
+			want:    "***" + base64.StdEncoding.EncodeToString([]byte(secret + ":x-oauth"))[len(secret)/3*4:] + "\n",
+		},
+		{
+			name:    "url encoded",
+			secrets: []string{secret},
+			input:   "https://host/?t=" + url.QueryEscape(secret) + "\n",
+			want:    "https://host/?t=***\n",
+		},
+		{
+			name:    "split across writes",
+			secrets: []string{secret},
+			input:   "a " + secret + " b " + secret,
+			chunk:   1,
+			want:    "a *** b ***",
+		},
+		{
+			name:    "held prefix is flushed",
+			secrets: []string{secret},
+			input:   "ends with s3cr",
+			chunk:   3,
+			want:    "ends with s3cr",
+		},
+		{
+			name:    "multi-line secret",
+			secrets: []string{"-----BEGIN KEY-----\nMIIEabcdef\n-----END KEY-----"},
+			input:   "MIIEabcdef\n",
+			want:    "***\n",
+		},
+		{
+			name:    "short values are not masked",
+			secrets: []string{"abc"},
+			input:   "abc abcd",
+			want:    "abc abcd",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			m := newMaskingWriter(&out, tt.secrets)
+			if m == nil {
+				out.WriteString(tt.input)
+			} else {
+				input := []byte(tt.input)
+				chunk := tt.chunk
+				if chunk == 0 {
+					chunk = len(input)
+				}
+				for len(input) > 0 {
+					n := min(chunk, len(input))
+					if _, err := m.Write(input[:n]); err != nil {
+						t.Fatalf("Write() error = %v", err)
+					}
+					input = input[n:]
+				}
+				if err := m.Flush(); err != nil {
+					t.Fatalf("Flush() error = %v", err)
+				}
+			}
+			if out.String() != tt.want {
+				t.Fatalf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestJobRunnerMasksSecretsInLog(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+
+	req := RunJobRequest{
+		RunID:   "run-mask",
+		Repo:    "acme/app",
+		Name:    "deploy",
+		Branch:  "main",
+		SHA:     "deadbeefcafebabe",
+		WorkDir: t.TempDir(),
+		Env:     []string{"API_TOKEN=hunter2-token"},
+		Steps: []RunStep{
+			{Name: "echo", Args: []string{"bash", "-c", `echo "token $API_TOKEN"; printf '%s' "$API_TOKEN" | base64 >&2`}},
+			{Name: "after", Args: []string{"bash", "-c", "echo done"}},
+		},
+		Secrets: []string{"hunter2-token"},
+	}
+	logPath, err := runner.Start(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	job := waitJobDone(t, repo, req.RunID)
+	if job.Status != StatusFinished {
+		t.Fatalf("job status = %q (%s)", job.Status, job.Msg)
+	}
+
+	body, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if strings.Contains(string(body), "hunter2") || strings.Contains(string(body), "aHVudGVyMi10b2tlbg") {
+		t.Fatalf("log leaks the secret:\n%s", body)
+	}
+	steps, err := repo.ListJobSteps(req.RunID)
+	if err != nil {
+		t.Fatalf("ListJobSteps() error = %v", err)
+	}
+	if got := string(body[steps[0].LogStart:steps[0].LogEnd]); !strings.Contains(got, "token ***\n***\n") {
+		t.Fatalf("step 1 log = %q, want masked output", got)
+	}
+	if got := string(body[steps[1].LogStart:steps[1].LogEnd]); got != "done\n" {
+		t.Fatalf("step 2 log = %q, want %q", got, "done\n")
+	}
+}
@@ -0,0 +1,227 @@
+package core
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type RemoteKind string
+
+const (
+	RemoteSSH   RemoteKind = "ssh"
+	RemoteHTTPS RemoteKind = "https"
+	RemoteFile  RemoteKind = "file"
+)
+
+const githubHost = "github.com"
+
+// Remote is a parsed git remote URL.
+//
+// Accepted forms:
+//
+//	owner/repo                          (github.com over ssh)
+//	host/owner/repo                     (any host over ssh)
+//	git@host:owner/repo.git             (scp-like ssh)
+//	ssh://git@host:2222/owner/repo.git
+//	https://host/group/sub/repo.git
+//	file:///srv/git/repo.git
+type Remote struct {
+	Kind RemoteKind
+	User string
+	Host string
+	Port string
+	// Path is the repo path on the host without leading slash or .git suffix
+	// (owner/repo, group/sub/repo). For file remotes it is the absolute path.
+	Path string
+}
+
+func ParseRemoteURL(rawURL string) (Remote, error) {
+	raw := strings.TrimSpace(rawURL)
+	if raw == "" {
+		return Remote{}, fmt.Errorf("remote url is required")
+	}
+
+	// Direct owner/repo or host/owner/repo form.
+	if !strings.Contains(raw, "://") && !strings.Contains(raw, "@") {
+		parts := strings.SplitN(strings.Trim(raw, "/"), "/", 2)
+		host := githubHost
+		repoPath := raw
+		// GitHub owners cannot contain dots, so a dotted first segment is a host.
+		if len(parts) == 2 && strings.Contains(parts[0], ".") {
+			host = strings.ToLower(parts[0])
+			repoPath = parts[1]
+		}
+		p, err := normalizeRemoteRepoPath(repoPath)
+		if err != nil {
+			return Remote{}, fmt.Errorf("invalid remote %q: %w", raw, err)
+		}
+		return Remote{Kind: RemoteSSH, User: "git", Host: host, Path: p}, nil
+	}
+
+	// SCP-like SSH form: user@host:owner/repo(.git)
+	if !strings.Contains(raw, "://") {
+		at := strings.Index(raw, "@")
+		colon := strings.Index(raw, ":")
+		if at <= 0 || colon < at {
+			return Remote{}, fmt.Errorf("invalid remote %q: expected user@host:path", raw)
+		}
+		host := strings.ToLower(raw[at+1 : colon])
+		if host == "" {
+			return Remote{}, fmt.Errorf("invalid remote %q: host is required", raw)
+		}
+		p, err := normalizeRemoteRepoPath(raw[colon+1:])
+		if err != nil {
+			return Remote{}, fmt.Errorf("invalid remote %q: %w", raw, err)
+		}
+		return Remote{Kind: RemoteSSH, User: raw[:at], Host: host, Path: p}, nil
+	}
+
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return Remote{}, fmt.Errorf("invalid remote %q: %w", raw, err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "ssh", "https":
+		host := strings.ToLower(u.Hostname())
+		if host == "" {
+			return Remote{}, fmt.Errorf("invalid remote %q: host is required", raw)
+		}
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return Remote{}, fmt.Errorf("invalid remote %q: credentials in the url are not supported, pass a token file instead", raw)
+		}
+		p, err := normalizeRemoteRepoPath(u.Path)
+		if err != nil {
+			return Remote{}, fmt.Errorf("invalid remote %q: %w", raw, err)
+		}
+		r := Remote{
+			Kind: RemoteKind(strings.ToLower(u.Scheme)),
+			User: u.User.Username(),
+			Host: host,
+			Port: u.Port(),
+			Path: p,
+		}
+		if r.Kind == RemoteSSH && r.User == "" {
+			r.User = "git"
+		}
+		return r, nil
+	case "file":
+		p := filepath.Clean(u.Path)
+		if u.Host != "" || !filepath.IsAbs(p) || p == "/" {
+			return Remote{}, fmt.Errorf("invalid remote %q: file remotes need an absolute path (file:///path/to/repo.git)", raw)
+		}
+		return Remote{Kind: RemoteFile, Path: p}, nil
+	default:
+		return Remote{}, fmt.Errorf("invalid remote %q: unsupported scheme %q (use ssh, https or file)", raw, u.Scheme)
+	}
+}
+
+// RepoName is the repo identity refci stores jobs under and derives the
+// mirror directory from. github.com repos keep the short owner/repo form so
+// existing roots keep working; other hosts are host/path, and file remotes
+// are local/ plus their whole path with / as -- (local/srv--git--app), so
+// repos with the same basename in different directories stay apart.
+func (r Remote) RepoName() string {
+	switch r.Kind {
+	case RemoteFile:
+		p := strings.TrimSuffix(strings.Trim(filepath.ToSlash(r.Path), "/"), ".git")
+		return "local/" + ToLocalRepo(p)
+	default:
+		if r.Host == githubHost {
+			return r.Path
+		}
+		return r.Host + "/" + r.Path
+	}
+}
+
+// CloneURL returns the URL to clone from. sshHost overrides the ssh host
+// (a managed ~/.ssh/config alias carrying the port and identity).
+func (r Remote) CloneURL(sshHost string) string {
+	switch r.Kind {
+	case RemoteFile:
+		return (&neturl.URL{Scheme: "file", Path: filepath.ToSlash(r.Path)}).String()
+	case RemoteHTTPS:
+		host := r.Host
+		if r.Port != "" {
+			host += ":" + r.Port
+		}
+		u := neturl.URL{Scheme: "https", Host: host, Path: "/" + r.Path + ".git"}
+		if r.User != "" {
+			u.User = neturl.User(r.User)
+		}
+		return u.String()
+	default:
+		host := strings.TrimSpace(sshHost)
+		if host == "" {
+			host = r.Host
+		}
+		return fmt.Sprintf("%s@%s:%s.git", r.User, host, r.Path)
+	}
+}
+
+func normalizeRemoteRepoPath(v string) (string, error) {
+	p := strings.TrimSpace(v)
+	p = strings.Trim(p, "/")
+	p = strings.TrimSuffix(p, ".git")
+
+	parts := strings.Split(p, "/")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("repo path must be owner/repo")
+	}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid repo path %q", v)
+		}
+	}
+	return p, nil
+}
+
+// RepoTokenPath is where `refci clone` stores the HTTPS token for repo.
+func RepoTokenPath(repo string) string {
+	return filepath.Join(Root, "credentials", ToLocalRepo(strings.TrimSpace(repo))+".token")
+}
+
+// WriteRepoToken stores token for repo with owner-only permissions and
+// returns its path.
+func WriteRepoToken(repo, token string) (string, error) {
+	value := strings.TrimSpace(token)
+	if value == "" {
+		return "", fmt.Errorf("token is empty")
+	}
+	p := RepoTokenPath(repo)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return "", fmt.Errorf("create credentials dir: %w", err)
+	}
+	if err := os.WriteFile(p, []byte(value+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("write token file: %w", err)
+	}
+	return p, nil
+}
+
+// TokenCredentialHelper returns mirror git config that answers HTTPS
+// credential requests with the token stored at tokenPath. The empty helper
+// entry resets helpers inherited from global config so they never shadow it.
+func TokenCredentialHelper(user, tokenPath string) ([]GitConfig, error) {
+	if strings.ContainsAny(tokenPath, "'\n") {
+		return nil, fmt.Errorf("unsupported token path: %q", tokenPath)
+	}
+	if strings.ContainsAny(user, "'\n") {
+		return nil, fmt.Errorf("unsupported credential user: %q", user)
+	}
+	if strings.TrimSpace(user) == "" {
+		user = "refci"
+	}
+	helper := fmt.Sprintf(
+		`!f() { test "$1" = get || exit 0; echo 'username=%s'; echo "password=$(cat '%s')"; }; f`,
+		user,
+		tokenPath,
+	)
+	return []GitConfig{
+		{Key: "credential.helper", Value: ""},
+		{Key: "credential.helper", Value: helper},
+	}, nil
+}
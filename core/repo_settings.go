@@ -0,0 +1,315 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Repo setting keys managed with `refci config`.
+const (
+	SettingConfigSource          = "config_source"
+	SettingConfigTrustedBranches = "config_trusted_branches"
+	SettingDirectiveScope        = "directive_scope"
+	SettingCacheMaxSize          = "cache_max_size"
+)
+
+// Root-level setting keys managed with `refci config --global`.
+const (
+	SettingRunAs            = "run_as"
+	SettingLogRetentionDays = "log_retention_days"
+	SettingLogRetentionRuns = "log_retention_runs"
+	SettingCILogMaxSize     = "ci_log_max_size"
+	SettingPruneJobRows     = "prune_job_rows"
+)
+
+// GlobalSettingsRepo is the repo column value root-level settings are
+// stored under; it is never a valid repo name.
+const GlobalSettingsRepo = ""
+
+// Values of SettingConfigSource.
+const (
+	ConfigSourceHead   = "head"   // every branch is built with the config at HEAD
+	ConfigSourceBranch = "branch" // trusted branches are built with their own config
+)
+
+// RepoSettingSpec describes one known repo setting.
+type RepoSettingSpec struct {
+	Key      string
+	Default  string
+	Help     string
+	validate func(string) (string, error)
+}
+
+var repoSettingSpecs = []RepoSettingSpec{
+	{
+		Key:      SettingConfigSource,
+		Default:  ConfigSourceHead,
+		Help:     "where job configs come from: head (default branch only) or branch (each branch's tip)",
+		validate: oneOf(ConfigSourceHead, ConfigSourceBranch),
+	},
+	{
+		Key:      SettingConfigTrustedBranches,
+		Default:  "*",
+		Help:     "comma-separated branch patterns allowed to supply their own config in branch mode; pull requests need an explicit pr:* entry",
+		validate: validateBranchPatternList,
+	},
+	{
+		Key:      SettingDirectiveScope,
+		Default:  DirectiveScopeHead,
+		Help:     "which commit messages [skip ci], refci-skip and refci-run are read from: head (the new tip) or range (every pushed commit)",
+		validate: oneOf(DirectiveScopeHead, DirectiveScopeRange),
+	},
+	{
+		Key:      SettingCacheMaxSize,
+		Default:  DefaultCacheMaxSize,
+		Help:     "total size of this repo's job caches before the least recently used are evicted, e.g. 500MB or 5GiB",
+		validate: validateByteSize,
+	},
+}
+
+var globalSettingSpecs = []RepoSettingSpec{
+	{
+		Key:      SettingRunAs,
+		Default:  "",
+		Help:     "user, uid or uid:gid job processes run as; empty runs them as the refci user (switching needs refci to run as root)",
+		validate: validateRunAs,
+	},
+	{
+		Key:      SettingLogRetentionDays,
+		Default:  "0",
+		Help:     "days job logs and run dirs are kept, e.g. 30; 0 keeps them forever (the latest run of each job and branch is always kept)",
+		validate: validateCount,
+	},
+	{
+		Key:      SettingLogRetentionRuns,
+		Default:  "0",
+		Help:     "runs whose logs are kept per job and branch; 0 keeps all",
+		validate: validateCount,
+	},
+	{
+		Key:      SettingCILogMaxSize,
+		Default:  "10MB",
+		Help:     "size past which a repo's ci.log is rotated to ci.log.1",
+		validate: validateByteSize,
+	},
+	{
+		Key:      SettingPruneJobRows,
+		Default:  "false",
+		Help:     "true also deletes the job rows of runs whose logs were removed, false keeps them in the history",
+		validate: oneOf("true", "false"),
+	},
+}
+
+// RepoSettingSpecs lists the known repo settings.
+func RepoSettingSpecs() []RepoSettingSpec {
+	return append([]RepoSettingSpec(nil), repoSettingSpecs...)
+}
+
+// LookupRepoSetting returns the spec of a known setting key.
+func LookupRepoSetting(key string) (RepoSettingSpec, bool) {
+	for _, spec := range repoSettingSpecs {
+		if spec.Key == key {
+			return spec, true
+		}
+	}
+	return RepoSettingSpec{}, false
+}
+
+// NormalizeRepoSetting validates value for key and returns its stored form.
+func NormalizeRepoSetting(key, value string) (string, error) {
+	spec, ok := LookupRepoSetting(strings.TrimSpace(key))
+	if !ok {
+		return "", fmt.Errorf("unknown repo setting %q", key)
+	}
+	return spec.validate(strings.TrimSpace(value))
+}
+
+// RepoSettingValue returns the stored value of key for repo, or its default.
+func RepoSettingValue(dbRepo DbRepo, repo, key string) (string, error) {
+	spec, ok := LookupRepoSetting(key)
+	if !ok {
+		return "", fmt.Errorf("unknown repo setting %q", key)
+	}
+	return storedSettingValue(dbRepo, repo, spec)
+}
+
+// GlobalSettingSpecs lists the known root-level settings.
+func GlobalSettingSpecs() []RepoSettingSpec {
+	return append([]RepoSettingSpec(nil), globalSettingSpecs...)
+}
+
+// LookupGlobalSetting returns the spec of a known root-level setting key.
+func LookupGlobalSetting(key string) (RepoSettingSpec, bool) {
+	for _, spec := range globalSettingSpecs {
+		if spec.Key == key {
+			return spec, true
+		}
+	}
+	return RepoSettingSpec{}, false
+}
+
+// NormalizeGlobalSetting validates value for a root-level key and returns
+// its stored form.
+func NormalizeGlobalSetting(key, value string) (string, error) {
+	spec, ok := LookupGlobalSetting(strings.TrimSpace(key))
+	if !ok {
+		return "", fmt.Errorf("unknown global setting %q", key)
+	}
+	return spec.validate(strings.TrimSpace(value))
+}
+
+// GlobalSettingValue returns the stored root-level value of key, or its
+// default.
+func GlobalSettingValue(dbRepo DbRepo, key string) (string, error) {
+	spec, ok := LookupGlobalSetting(key)
+	if !ok {
+		return "", fmt.Errorf("unknown global setting %q", key)
+	}
+	return storedSettingValue(dbRepo, GlobalSettingsRepo, spec)
+}
+
+// LoadGlobalSetting reads the retention settings.
+func LoadGlobalSetting(dbRepo DbRepo) (GlobalSetting, error) {
+	values := map[string]string{}
+	for _, key := range []string{SettingLogRetentionDays, SettingLogRetentionRuns, SettingCILogMaxSize, SettingPruneJobRows} {
+		v, err := GlobalSettingValue(dbRepo, key)
+		if err != nil {
+			return GlobalSetting{}, err
+		}
+		values[key] = v
+	}
+
+	var (
+		gs  GlobalSetting
+		err error
+	)
+	if gs.LogRetentionDays, err = strconv.Atoi(values[SettingLogRetentionDays]); err != nil {
+		return GlobalSetting{}, fmt.Errorf("%s: %w", SettingLogRetentionDays, err)
+	}
+	if gs.LogRetentionRuns, err = strconv.Atoi(values[SettingLogRetentionRuns]); err != nil {
+		return GlobalSetting{}, fmt.Errorf("%s: %w", SettingLogRetentionRuns, err)
+	}
+	if gs.CILogMaxSize, err = ParseByteSize(values[SettingCILogMaxSize]); err != nil {
+		return GlobalSetting{}, fmt.Errorf("%s: %w", SettingCILogMaxSize, err)
+	}
+	gs.PruneJobRows = values[SettingPruneJobRows] == "true"
+	return gs, nil
+}
+
+func storedSettingValue(dbRepo DbRepo, repo string, spec RepoSettingSpec) (string, error) {
+	settings, err := dbRepo.RepoSettings(repo)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range settings {
+		if s.Key == spec.Key {
+			return s.Value, nil
+		}
+	}
+	return spec.Default, nil
+}
+
+func validateRunAs(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	if _, err := ResolveRunAs(v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+func oneOf(values ...string) func(string) (string, error) {
+	return func(v string) (string, error) {
+		lower := strings.ToLower(v)
+		for _, allowed := range values {
+			if lower == allowed {
+				return lower, nil
+			}
+		}
+		return "", fmt.Errorf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func validateByteSize(v string) (string, error) {
+	n, err := ParseByteSize(v)
+	if err != nil {
+		return "", err
+	}
+	if n <= 0 {
+		return "", fmt.Errorf("size must be positive")
+	}
+	return strings.TrimSpace(v), nil
+}
+
+func validateCount(v string) (string, error) {
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return "", fmt.Errorf("must be a whole number, 0 or more")
+	}
+	return strconv.Itoa(n), nil
+}
+
+func validateBranchPatternList(v string) (string, error) {
+	patterns := splitSettingList(v)
+	if len(patterns) == 0 {
+		return "", fmt.Errorf("at least one branch pattern is required")
+	}
+	for _, p := range patterns {
+		if err := validateBranchPattern(p); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(patterns, ","), nil
+}
+
+func splitSettingList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if p := strings.TrimSpace(part); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// ConfigSource decides which ref a branch's job config is read from.
+type ConfigSource struct {
+	Mode    string
+	Trusted []string
+}
+
+// LoadConfigSource reads the config source settings of repo.
+func LoadConfigSource(dbRepo DbRepo, repo string) (ConfigSource, error) {
+	mode, err := RepoSettingValue(dbRepo, repo, SettingConfigSource)
+	if err != nil {
+		return ConfigSource{}, err
+	}
+	trusted, err := RepoSettingValue(dbRepo, repo, SettingConfigTrustedBranches)
+	if err != nil {
+		return ConfigSource{}, err
+	}
+	return ConfigSource{Mode: mode, Trusted: splitSettingList(trusted)}, nil
+}
+
+// UsesBranchConfig reports whether branch is built with the config at its own
+// tip. Untrusted branches, and every branch in head mode, use HEAD's config.
+// Pull request branches (pr:<n>) are only trusted through a pattern that
+// names them explicitly, so "*" never lets a fork's PR change its own jobs.
+func (c ConfigSource) UsesBranchConfig(branch string) bool {
+	if c.Mode != ConfigSourceBranch {
+		return false
+	}
+	_, isPull := ParsePullRequestBranch(branch)
+	for _, p := range c.Trusted {
+		pattern := normalizeBranchPattern(p)
+		if isPull && !strings.HasPrefix(pattern, pullRequestBranchPrefix) {
+			continue
+		}
+		if branchMatchesPattern(branch, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GCReport is what CollectGarbage removed (or would remove on a dry run).
+type GCReport struct {
+	Runs    int      // runs whose logs and run dirs were removed
+	Rows    int      // job rows deleted
+	Orphans int      // logs and run dirs without a job row
+	Bytes   int64    // disk space freed
+	Rotated []string // ci logs rotated
+	DryRun  bool
+}
+
+func (r GCReport) String() string {
+	verb := "removed"
+	if r.DryRun {
+		verb = "would remove"
+	}
+	return fmt.Sprintf("%s logs of %d runs, %d job rows, %d orphaned files, %s; rotated %d ci logs",
+		verb, r.Runs, r.Rows, r.Orphans, FormatByteSize(r.Bytes), len(r.Rotated))
+}
+
+// CollectGarbage applies the retention policy to repo, or to every repo when
+// repo is empty. A run expires when it is older than LogRetentionDays or
+// beyond the LogRetentionRuns newest runs of its job and branch; its log,
+// line index, run dir and search index lines are removed, and its row too
+// with PruneJobRows. Running jobs and the latest row of each job and branch
+// are never touched: the poller compares new commits against that row. Logs
+// and run dirs without a row are removed once older than LogRetentionDays,
+// and ci.log files larger than CILogMaxSize are rotated.
+func CollectGarbage(dbRepo DbRepo, repo string, policy GlobalSetting, now time.Time, dryRun bool) (GCReport, error) {
+	report := GCReport{DryRun: dryRun}
+	jobs, err := dbRepo.ListJob(JobFilter{Repo: repo})
+	if err != nil {
+		return report, err
+	}
+
+	var cutoff time.Time
+	if policy.LogRetentionDays > 0 {
+		cutoff = now.AddDate(0, 0, -policy.LogRetentionDays)
+	}
+	known := map[string]bool{} // log paths and run dirs of rows that stay
+	keep := func(job Job) {
+		known[job.LogPath] = true
+		known[RunDir(job.Repo, job.RunID)] = true
+	}
+	latestSeen := map[string]bool{}
+	runs := map[string]int{}   // runs with a log per job and branch so far
+	for _, job := range jobs { // newest first
+		group := job.Repo + "\x00" + job.Name + "\x00" + job.Branch
+		latest := !latestSeen[group]
+		latestSeen[group] = true
+		expired := !cutoff.IsZero() && job.Start.Before(cutoff)
+		if job.Status != StatusSkipped {
+			expired = expired || policy.LogRetentionRuns > 0 && runs[group] >= policy.LogRetentionRuns
+			runs[group]++
+		}
+		if !expired || latest || job.Status == StatusRunning || job.Status == StatusPending {
+			keep(job)
+			continue
+		}
+
+		paths := []string{RunDir(job.Repo, job.RunID)}
+		if strings.TrimSpace(job.LogPath) != "" {
+			paths = append(paths, job.LogPath, LineIndexPath(job.LogPath))
+		}
+		removed := false
+		for _, p := range paths {
+			n, ok, err := removePath(p, dryRun)
+			if err != nil {
+				return report, err
+			}
+			if ok {
+				removed = true
+				report.Bytes += n
+			}
+		}
+		if removed {
+			report.Runs++
+		}
+		if !policy.PruneJobRows {
+			if removed && !dryRun {
+				if err := dbRepo.DeleteJobLogIndex(job.RunID); err != nil {
+					return report, err
+				}
+			}
+			// The row stays, so its files are not orphans if they come back.
+			keep(job)
+			continue
+		}
+		if !dryRun {
+			if err := dbRepo.DeleteJob(job.RunID); err != nil {
+				return report, err
+			}
+		}
+		report.Rows++
+	}
+
+	if !cutoff.IsZero() {
+		if err := removeOrphans(repo, known, cutoff, dryRun, &report); err != nil {
+			return report, err
+		}
+	}
+	if policy.CILogMaxSize > 0 {
+		if err := rotateCILogs(repo, policy.CILogMaxSize, dryRun, &report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// removeOrphans removes job logs and run dirs that no kept row refers to and
+// that were last modified before cutoff.
+func removeOrphans(repo string, known map[string]bool, cutoff time.Time, dryRun bool, report *GCReport) error {
+	for _, base := range []string{filepath.Join(Root, "logs"), filepath.Join(Root, "runs")} {
+		repoDirs, err := gcRepoDirs(base, repo)
+		if err != nil {
+			return err
+		}
+		for _, dir := range repoDirs {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return fmt.Errorf("read %s: %w", dir, err)
+			}
+			for _, e := range entries {
+				p := filepath.Join(dir, e.Name())
+				owner := p
+				if base == filepath.Join(Root, "logs") {
+					if e.Name() == "ci.log" || !strings.HasSuffix(p, ".log") && !strings.HasSuffix(p, ".log.lines") {
+						continue // ci.log is rotated instead
+					}
+					owner = strings.TrimSuffix(p, ".lines")
+				}
+				if known[owner] {
+					continue
+				}
+				info, err := e.Info()
+				if err != nil || !info.ModTime().Before(cutoff) {
+					continue
+				}
+				n, ok, err := removePath(p, dryRun)
+				if err != nil {
+					return err
+				}
+				if ok {
+					report.Orphans++
+					report.Bytes += n
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// gcRepoDirs lists the per-repo dirs under base: the one of repo, or all.
+func gcRepoDirs(base, repo string) ([]string, error) {
+	if repo != "" {
+		return []string{filepath.Join(base, ToLocalRepo(repo))}, nil
+	}
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read %s: %w", base, err)
+	}
+	var out []string
+	for _, e := range entries {
+		if e.IsDir() {
+			out = append(out, filepath.Join(base, e.Name()))
+		}
+	}
+	return out, nil
+}
+
+// rotateCILogs moves each ci.log larger than maxSize to ci.log.1, replacing
+// the previous one; the logger starts a new file on its next line.
+func rotateCILogs(repo string, maxSize int64, dryRun bool, report *GCReport) error {
+	dirs, err := gcRepoDirs(filepath.Join(Root, "logs"), repo)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		p := filepath.Join(dir, "ci.log")
+		info, err := os.Stat(p)
+		if err != nil || info.Size() <= maxSize {
+			continue
+		}
+		report.Rotated = append(report.Rotated, p)
+		if dryRun {
+			continue
+		}
+		if old, err := os.Stat(p + ".1"); err == nil {
+			report.Bytes += old.Size()
+		}
+		if err := os.Rename(p, p+".1"); err != nil {
+			return fmt.Errorf("rotate %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// removePath removes a file or dir and returns its size; ok is false when
+// there was nothing to remove.
+func removePath(p string, dryRun bool) (int64, bool, error) {
+	info, err := os.Lstat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	size := info.Size()
+	if info.IsDir() {
+		if size, err = dirSize(p); err != nil {
+			return 0, false, err
+		}
+	}
+	if dryRun {
+		return size, true, nil
+	}
+	if err := os.RemoveAll(p); err != nil {
+		return 0, false, fmt.Errorf("remove %s: %w", p, err)
+	}
+	return size, true, nil
+}
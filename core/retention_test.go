@@ -0,0 +1,135 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	db := openTestDB(t)
+	repo, err := NewSQLiteRepo(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	logDir := filepath.Join(Root, "logs", ToLocalRepo("acme/app"))
+
+	// Newest first per job and branch.
+	runs := []struct {
+		id     string
+		name   string
+		status string
+		age    time.Duration
+	}{
+		{id: "b1", name: "build", status: StatusFinished, age: time.Hour},
+		{id: "b2", name: "build", status: StatusFailed, age: 2 * time.Hour},
+		{id: "b3", name: "build", status: StatusSkipped, age: 3 * time.Hour},
+		{id: "b4", name: "build", status: StatusFinished, age: 4 * time.Hour},
+		{id: "b5", name: "build", status: StatusFinished, age: 40 * 24 * time.Hour},
+		{id: "d1", name: "deploy", status: StatusFinished, age: 90 * 24 * time.Hour}, // latest of its job: kept
+		{id: "d2", name: "deploy", status: StatusRunning, age: 91 * 24 * time.Hour},
+	}
+	for _, r := range runs {
+		if err := repo.CreateJob(Job{RunID: r.id, Repo: "acme/app", Name: r.name, Branch: "main", SHA: r.id}); err != nil {
+			t.Fatalf("CreateJob(%s) error = %v", r.id, err)
+		}
+		logPath := ""
+		if r.status != StatusSkipped {
+			logPath = filepath.Join(logDir, r.id+".log")
+			writeTestFile(t, logPath, "log of "+r.id+"\n")
+			writeTestFile(t, LineIndexPath(logPath), strings.Repeat("x", lineFrameSize))
+			writeTestFile(t, filepath.Join(RunDir("acme/app", r.id), "step-1.inline"), "echo\n")
+		}
+		if err := repo.UpdateJob(r.id, r.status, "", logPath); err != nil {
+			t.Fatalf("UpdateJob(%s) error = %v", r.id, err)
+		}
+		if _, err := db.Exec(`UPDATE jobs SET start_at = ? WHERE run_id = ?`, formatStoredTime(now.Add(-r.age)), r.id); err != nil {
+			t.Fatalf("set start_at error = %v", err)
+		}
+	}
+	orphan := filepath.Join(logDir, "gone.log")
+	writeTestFile(t, orphan, "no row\n")
+	fresh := filepath.Join(logDir, "starting.log")
+	writeTestFile(t, fresh, "row not created yet\n")
+	old := now.Add(-60 * 24 * time.Hour)
+	if err := os.Chtimes(orphan, old, old); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	ciLog := filepath.Join(logDir, "ci.log")
+	writeTestFile(t, ciLog, strings.Repeat("activity\n", 200))
+
+	// Out of the box no run or log is removed.
+	defaults, err := LoadGlobalSetting(repo)
+	if err != nil {
+		t.Fatalf("LoadGlobalSetting() error = %v", err)
+	}
+	if report, err := CollectGarbage(repo, "acme/app", defaults, now, true); err != nil || report.Runs != 0 || report.Rows != 0 || report.Orphans != 0 {
+		t.Fatalf("CollectGarbage(defaults) = %+v, %v; want nothing removed", report, err)
+	}
+
+	policy := GlobalSetting{LogRetentionDays: 30, LogRetentionRuns: 2, CILogMaxSize: 1000, PruneJobRows: true}
+	report, err := CollectGarbage(repo, "acme/app", policy, now, true)
+	if err != nil {
+		t.Fatalf("CollectGarbage(dry run) error = %v", err)
+	}
+	if report.Runs != 2 || report.Rows != 2 || report.Orphans != 1 || len(report.Rotated) != 1 {
+		t.Fatalf("dry run report = %+v, want 2 runs, 2 rows, 1 orphan, 1 rotation", report)
+	}
+	if _, err := os.Stat(filepath.Join(logDir, "b4.log")); err != nil {
+		t.Fatalf("dry run removed b4.log: %v", err)
+	}
+
+	report, err = CollectGarbage(repo, "acme/app", policy, now, false)
+	if err != nil {
+		t.Fatalf("CollectGarbage() error = %v", err)
+	}
+	if report.Runs != 2 || report.Rows != 2 {
+		t.Fatalf("report = %+v, want 2 runs and 2 rows", report)
+	}
+
+	for _, id := range []string{"b1", "b2", "d1", "d2"} {
+		if _, err := os.Stat(filepath.Join(logDir, id+".log")); err != nil {
+			t.Fatalf("%s.log was removed: %v", id, err)
+		}
+	}
+	for _, id := range []string{"b4", "b5"} { // beyond 2 runs, and older than 30 days
+		for _, p := range []string{filepath.Join(logDir, id+".log"), LineIndexPath(filepath.Join(logDir, id+".log")), RunDir("acme/app", id)} {
+			if _, err := os.Stat(p); !os.IsNotExist(err) {
+				t.Fatalf("%s still exists (err %v)", p, err)
+			}
+		}
+		if job, err := repo.JobByRunID(id); err != nil || job.RunID != "" {
+			t.Fatalf("JobByRunID(%s) = %+v, %v; want the row deleted", id, job, err)
+		}
+	}
+	if job, err := repo.JobByRunID("b3"); err != nil || job.RunID != "b3" {
+		t.Fatalf("skipped row b3 = %+v, %v; want it kept", job, err)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("orphaned log still exists (err %v)", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("recent log without a row was removed: %v", err)
+	}
+	if _, err := os.Stat(ciLog + ".1"); err != nil {
+		t.Fatalf("ci.log was not rotated: %v", err)
+	}
+
+	logger, err := NewCIActivityLogger("acme/app")
+	if err != nil {
+		t.Fatalf("NewCIActivityLogger() error = %v", err)
+	}
+	logger.Logf("after rotation")
+	if body, err := os.ReadFile(ciLog); err != nil || !strings.Contains(string(body), "after rotation") {
+		t.Fatalf("ci.log after rotation = %q, %v", body, err)
+	}
+}
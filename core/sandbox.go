@@ -0,0 +1,162 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// RunAs is the unprivileged account job processes run as when the run_as
+// setting is set.
+type RunAs struct {
+	Spec   string // the setting value, e.g. "refci-job" or "1500:1500"
+	UID    uint32
+	GID    uint32
+	Groups []uint32
+	User   string // account name, empty when the uid has no passwd entry
+	Home   string
+}
+
+// scrubbedEnv are variables of the refci process that give access to its
+// credentials; they are not passed to sandboxed jobs.
+var scrubbedEnv = []string{
+	"SSH_AUTH_SOCK",
+	"SSH_AGENT_PID",
+	"SSH_ASKPASS",
+	"GIT_SSH",
+	"GIT_SSH_COMMAND",
+	"GIT_ASKPASS",
+	"GIT_CONFIG_GLOBAL",
+	cgroupEnv,
+}
+
+// ResolveRunAs looks up spec, which is a user name, a uid or uid:gid. A user
+// name takes its primary group and supplementary groups from the system.
+func ResolveRunAs(spec string) (*RunAs, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	uidPart, gidPart, hasGID := strings.Cut(spec, ":")
+	var u *user.User
+	var err error
+	if _, numErr := strconv.ParseUint(uidPart, 10, 32); numErr == nil {
+		u, err = user.LookupId(uidPart)
+		if err != nil {
+			// A bare uid does not need a passwd entry.
+			u = &user.User{Uid: uidPart, Gid: uidPart, HomeDir: "/"}
+		}
+	} else {
+		u, err = user.Lookup(uidPart)
+		if err != nil {
+			return nil, fmt.Errorf("run_as user %q: %w", uidPart, err)
+		}
+	}
+	if hasGID {
+		if _, err := strconv.ParseUint(gidPart, 10, 32); err != nil {
+			g, err := user.LookupGroup(gidPart)
+			if err != nil {
+				return nil, fmt.Errorf("run_as group %q: %w", gidPart, err)
+			}
+			gidPart = g.Gid
+		}
+		u.Gid = gidPart
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("run_as uid %q: %w", u.Uid, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("run_as gid %q: %w", u.Gid, err)
+	}
+	if uid == 0 {
+		return nil, errors.New("run_as must not be root")
+	}
+
+	r := &RunAs{Spec: spec, UID: uint32(uid), GID: uint32(gid), User: u.Username, Home: u.HomeDir}
+	if u.Username != "" && !hasGID {
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if n, err := strconv.ParseUint(id, 10, 32); err == nil && uint32(n) != r.GID {
+					r.Groups = append(r.Groups, uint32(n))
+				}
+			}
+		}
+	}
+	return r, nil
+}
+
+// String renders the account for logs, e.g. "refci-job (1500:1500)".
+func (r *RunAs) String() string {
+	ids := fmt.Sprintf("%d:%d", r.UID, r.GID)
+	if r.User == "" {
+		return ids
+	}
+	return fmt.Sprintf("%s (%s)", r.User, ids)
+}
+
+// credential returns the process credential for steps, refusing when refci
+// cannot switch users.
+func (r *RunAs) credential() (*syscall.Credential, error) {
+	if euid := os.Geteuid(); euid != 0 && uint32(euid) != r.UID {
+		return nil, fmt.Errorf("run_as %s needs refci to run as root (euid %d)", r.Spec, euid)
+	}
+	return &syscall.Credential{Uid: r.UID, Gid: r.GID, Groups: r.Groups}, nil
+}
+
+// env returns the job environment for the sandbox user: refci's own
+// credentials are dropped and HOME/USER point at the sandbox account. Git is
+// told to trust the worktree, whose git dir in the mirror the sandbox user
+// does not own.
+func (r *RunAs) env(base []string, workDir string) []string {
+	out := make([]string, 0, len(base)+6)
+	for _, kv := range base {
+		key, _, _ := strings.Cut(kv, "=")
+		switch {
+		case containsString(scrubbedEnv, key),
+			key == "HOME", key == "USER", key == "LOGNAME",
+			strings.HasPrefix(key, "GIT_CONFIG_"):
+			continue
+		}
+		out = append(out, kv)
+	}
+	out = append(out, "HOME="+r.Home)
+	if r.User != "" {
+		out = append(out, "USER="+r.User, "LOGNAME="+r.User)
+	}
+	return append(out,
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=safe.directory",
+		"GIT_CONFIG_VALUE_0="+workDir,
+	)
+}
+
+// ownedByEUID reports whether the file belongs to the user refci runs as.
+func ownedByEUID(info fs.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Geteuid()
+}
+
+// chownTree gives the sandbox user every file under dir, so jobs can write
+// their worktree and read their inline scripts.
+func (r *RunAs) chownTree(dir string) error {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, int(r.UID), int(r.GID))
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestResolveRunAs(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantUID uint32
+		wantGID uint32
+		wantErr string
+	}{
+		{name: "empty", spec: ""},
+		{name: "uid only", spec: "65534", wantUID: 65534, wantGID: 65534},
+		{name: "uid and gid", spec: "1500:1600", wantUID: 1500, wantGID: 1600},
+		{name: "root", spec: "0", wantErr: "must not be root"},
+		{name: "unknown user", spec: "no-such-refci-user", wantErr: "run_as user"},
+		{name: "unknown group", spec: "1500:no-such-refci-group", wantErr: "run_as group"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveRunAs(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveRunAs(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveRunAs(%q) error = %v", tt.spec, err)
+			}
+			if tt.spec == "" {
+				if got != nil {
+					t.Fatalf("ResolveRunAs(\"\") = %v, want nil", got)
+				}
+				return
+			}
+			if got.UID != tt.wantUID || got.GID != tt.wantGID {
+				t.Fatalf("ResolveRunAs(%q) = %d:%d, want %d:%d", tt.spec, got.UID, got.GID, tt.wantUID, tt.wantGID)
+			}
+		})
+	}
+}
+
+func TestRunAsEnvScrubsCredentials(t *testing.T) {
+	r := &RunAs{UID: 1500, GID: 1500, User: "ci", Home: "/home/ci"}
+	env := r.env([]string{
+		"PATH=/usr/bin",
+		"SSH_AUTH_SOCK=/tmp/agent.sock",
+		"GIT_SSH_COMMAND=ssh -i key",
+		"GIT_CONFIG_COUNT=3",
+		"HOME=/root",
+		"LANG=C.UTF-8",
+	}, "/refci/worktrees/app/main")
+	got := strings.Join(env, "\n")
+	for _, want := range []string{"PATH=/usr/bin", "LANG=C.UTF-8", "HOME=/home/ci", "USER=ci", "GIT_CONFIG_COUNT=1", "GIT_CONFIG_VALUE_0=/refci/worktrees/app/main"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("env missing %q:\n%s", want, got)
+		}
+	}
+	for _, banned := range []string{"SSH_AUTH_SOCK", "GIT_SSH_COMMAND", "HOME=/root", "GIT_CONFIG_COUNT=3"} {
+		if strings.Contains(got, banned) {
+			t.Fatalf("env keeps %q:\n%s", banned, got)
+		}
+	}
+}
+
+func TestJobRunnerRunsStepsAsSandboxUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("switching users needs root")
+	}
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+	// The sandbox user has to reach the worktree through the temp dirs.
+	if err := os.Chmod(filepath.Dir(Root), 0o755); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if err := os.Chmod(Root, 0o755); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+	runAs, err := ResolveRunAs("65534:65534")
+	if err != nil {
+		t.Fatalf("ResolveRunAs() error = %v", err)
+	}
+
+	workDir := filepath.Join(Root, "work")
+	writeTestFile(t, filepath.Join(workDir, "input.txt"), "x")
+	t.Setenv("SSH_AUTH_SOCK", "/tmp/refci-agent.sock")
+	req := RunJobRequest{
+		RunID:   "run-sandbox",
+		Repo:    "acme/app",
+		Name:    "build",
+		Branch:  "main",
+		SHA:     "deadbeefcafebabe",
+		WorkDir: workDir,
+		Steps: []RunStep{{Name: "whoami", Args: []string{"sh", "-c",
+			`echo "uid=$(id -u) agent=${SSH_AUTH_SOCK:-none}"; touch output.txt`}}},
+		RunAs: runAs,
+	}
+	logPath, err := runner.Start(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	job := waitJobDone(t, repo, req.RunID)
+	body, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if job.Status != StatusFinished {
+		t.Fatalf("job status = %q (%s), log:\n%s", job.Status, job.Msg, body)
+	}
+	if !strings.Contains(string(body), "uid=65534 agent=none") {
+		t.Fatalf("log = %q, want the step to run as 65534 without the agent socket", body)
+	}
+
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0o600 || info.Sys().(*syscall.Stat_t).Uid != 0 {
+		t.Fatalf("log file mode = %v uid = %d, want 0600 owned by refci", info.Mode().Perm(), info.Sys().(*syscall.Stat_t).Uid)
+	}
+	out, err := os.Stat(filepath.Join(workDir, "output.txt"))
+	if err != nil {
+		t.Fatalf("Stat(output.txt) error = %v", err)
+	}
+	if uid := out.Sys().(*syscall.Stat_t).Uid; uid != 65534 {
+		t.Fatalf("output.txt uid = %d, want 65534", uid)
+	}
+}
+
+func TestEnsureWorktreeRecreatesSandboxWorktree(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("handing the worktree to another user needs root")
+	}
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	sha1 := src.commit(t, "README.md", "one\n", "one")
+	src.mirror(t, "acme/app")
+	workDir, err := EnsureWorktree(context.Background(), "acme/app", "main", sha1)
+	if err != nil {
+		t.Fatalf("EnsureWorktree() error = %v", err)
+	}
+	runAs := &RunAs{UID: 65534, GID: 65534}
+	if err := runAs.chownTree(workDir); err != nil {
+		t.Fatalf("chownTree() error = %v", err)
+	}
+
+	// The sandbox user points .git at a git dir whose config runs a command.
+	marker := filepath.Join(Root, "pwned")
+	evil := filepath.Join(Root, "evil.git")
+	src.git(t, "init", "-q", "--bare", evil)
+	src.git(t, "--git-dir", evil, "config", "core.fsmonitor", "touch "+marker)
+	src.git(t, "--git-dir", evil, "config", "core.bare", "false")
+	writeTestFile(t, filepath.Join(workDir, ".git"), "gitdir: "+evil+"\n")
+	writeTestFile(t, filepath.Join(workDir, "left-over.txt"), "x")
+
+	sha2 := src.commit(t, "README.md", "two\n", "two")
+	src.git(t, "--git-dir", filepath.Join(Root, "repos", ToLocalRepo("acme/app")), "fetch", "-q", "origin", "+refs/heads/*:refs/heads/*")
+	if _, err := EnsureWorktree(context.Background(), "acme/app", "main", sha2); err != nil {
+		t.Fatalf("EnsureWorktree() after sandbox run error = %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatalf("refci ran the sandbox user's git config")
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "left-over.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Stat(left-over.txt) error = %v, want the worktree checked out again", err)
+	}
+	if !ownedWorktree(workDir) {
+		t.Fatalf("worktree is not refci's after EnsureWorktree")
+	}
+	body, err := os.ReadFile(filepath.Join(workDir, "README.md"))
+	if err != nil || string(body) != "two\n" {
+		t.Fatalf("README.md = %q, %v; want the new commit", body, err)
+	}
+
+	// A worktree refci still owns is reset in place.
+	writeTestFile(t, filepath.Join(workDir, "kept.txt"), "x")
+	if _, err := EnsureWorktree(context.Background(), "acme/app", "main", sha1); err != nil {
+		t.Fatalf("EnsureWorktree() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "kept.txt")); err != nil {
+		t.Fatalf("Stat(kept.txt) error = %v, want the worktree reset in place", err)
+	}
+}
@@ -0,0 +1,285 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SecretPassphraseEnv, when set, derives the secret store key from a
+// passphrase instead of the key file.
+const SecretPassphraseEnv = "REFCI_SECRET_PASSPHRASE"
+
+const (
+	secretKeyLen     = 32 // AES-256
+	secretPBKDF2Iter = 600_000
+)
+
+// ErrNoSecretKey is returned when the store has no key yet, i.e. no secret
+// was ever set.
+var ErrNoSecretKey = errors.New("no secret key: set a secret first or export " + SecretPassphraseEnv)
+
+var secretKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretScope is where a secret applies: global (empty Repo), one repo
+// (empty Job) or one job of a repo.
+type SecretScope struct {
+	Repo string
+	Job  string
+}
+
+// String renders the scope as "global", "owner/repo" or "owner/repo:job".
+func (s SecretScope) String() string {
+	switch {
+	case s.Repo == "":
+		return "global"
+	case s.Job == "":
+		return s.Repo
+	}
+	return s.Repo + ":" + s.Job
+}
+
+// SecretStore encrypts secrets with AES-256-GCM before they reach the
+// database. The key is <root>/credentials/secret.key, or is derived with
+// PBKDF2 from $REFCI_SECRET_PASSPHRASE and <root>/credentials/secret.salt.
+type SecretStore struct {
+	dbRepo DbRepo
+	aead   cipher.AEAD
+}
+
+// SecretKeyPath is the store's key file.
+func SecretKeyPath() string {
+	return filepath.Join(Root, "credentials", "secret.key")
+}
+
+func secretSaltPath() string {
+	return filepath.Join(Root, "credentials", "secret.salt")
+}
+
+// OpenSecretStore loads the store key. With create, a missing key file (or
+// passphrase salt) is generated; without it ErrNoSecretKey is returned.
+func OpenSecretStore(dbRepo DbRepo, create bool) (*SecretStore, error) {
+	key, err := loadSecretKey(create)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("secret key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("secret key: %w", err)
+	}
+	return &SecretStore{dbRepo: dbRepo, aead: aead}, nil
+}
+
+func loadSecretKey(create bool) ([]byte, error) {
+	if passphrase := os.Getenv(SecretPassphraseEnv); passphrase != "" {
+		salt, err := readOrCreateKeyFile(secretSaltPath(), 16, create)
+		if err != nil {
+			return nil, err
+		}
+		return pbkdf2.Key(sha256.New, passphrase, salt, secretPBKDF2Iter, secretKeyLen)
+	}
+	return readOrCreateKeyFile(SecretKeyPath(), secretKeyLen, create)
+}
+
+func readOrCreateKeyFile(path string, size int, create bool) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if len(data) != size {
+			return nil, fmt.Errorf("%s: want %d bytes, got %d", path, size, len(data))
+		}
+		return data, nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	case !create:
+		return nil, ErrNoSecretKey
+	}
+
+	data = make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return nil, fmt.Errorf("generate %s: %w", path, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create credentials dir: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("write %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// ValidateSecretKey reports whether key can be used as an env var name.
+func ValidateSecretKey(key string) error {
+	if !secretKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid secret name %q: use letters, digits and _", key)
+	}
+	return nil
+}
+
+// secretAAD binds a ciphertext to its scope and name, so rows cannot be
+// swapped between keys or repos.
+func secretAAD(scope SecretScope, key string) []byte {
+	return []byte(scope.Repo + "\x00" + scope.Job + "\x00" + key)
+}
+
+// Set encrypts and stores value under key in scope.
+func (s *SecretStore) Set(scope SecretScope, key, value string) error {
+	if err := ValidateSecretKey(key); err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	return s.dbRepo.SetSecret(Secret{
+		Repo:       scope.Repo,
+		Job:        scope.Job,
+		Key:        key,
+		Nonce:      nonce,
+		Ciphertext: s.aead.Seal(nil, nonce, []byte(value), secretAAD(scope, key)),
+	})
+}
+
+// Get returns the value of key in exactly scope; ok is false when unset.
+func (s *SecretStore) Get(scope SecretScope, key string) (value string, ok bool, err error) {
+	secrets, err := s.List(scope)
+	if err != nil {
+		return "", false, err
+	}
+	for _, secret := range secrets {
+		if secret.Key == key {
+			value, err := s.decrypt(secret)
+			return value, err == nil, err
+		}
+	}
+	return "", false, nil
+}
+
+// Delete removes key from scope.
+func (s *SecretStore) Delete(scope SecretScope, key string) error {
+	return s.dbRepo.DeleteSecret(scope.Repo, scope.Job, key)
+}
+
+// List returns the (still encrypted) secrets set in exactly scope.
+func (s *SecretStore) List(scope SecretScope) ([]Secret, error) {
+	return ListScopeSecrets(s.dbRepo, scope)
+}
+
+// ListScopeSecrets returns the secrets set in exactly scope; listing needs no
+// key since values stay encrypted.
+func ListScopeSecrets(dbRepo DbRepo, scope SecretScope) ([]Secret, error) {
+	all, err := dbRepo.ListSecrets(scope.Repo)
+	if err != nil {
+		return nil, err
+	}
+	out := all[:0]
+	for _, secret := range all {
+		if secret.Job == scope.Job {
+			out = append(out, secret)
+		}
+	}
+	return out, nil
+}
+
+// ForJob returns the env entries (KEY=value) for one job: global secrets,
+// overridden by the repo's, overridden by the job's.
+func (s *SecretStore) ForJob(repo, job string) ([]string, error) {
+	global, err := s.dbRepo.ListSecrets("")
+	if err != nil {
+		return nil, err
+	}
+	scoped, err := s.dbRepo.ListSecrets(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	var order []string
+	for _, level := range []struct {
+		secrets []Secret
+		job     string
+	}{{global, ""}, {scoped, ""}, {scoped, job}} {
+		for _, secret := range level.secrets {
+			if secret.Job != level.job {
+				continue
+			}
+			value, err := s.decrypt(secret)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := values[secret.Key]; !ok {
+				order = append(order, secret.Key)
+			}
+			values[secret.Key] = value
+		}
+	}
+
+	env := make([]string, 0, len(order))
+	for _, key := range order {
+		env = append(env, key+"="+values[key])
+	}
+	return env, nil
+}
+
+func (s *SecretStore) decrypt(secret Secret) (string, error) {
+	scope := SecretScope{Repo: secret.Repo, Job: secret.Job}
+	plain, err := s.aead.Open(nil, secret.Nonce, secret.Ciphertext, secretAAD(scope, secret.Key))
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret %s (%s): wrong key or passphrase", secret.Key, scope)
+	}
+	return string(plain), nil
+}
+
+// jobProcessEnv is refci's environment as jobs and if: expressions see it,
+// without the secret store passphrase.
+func jobProcessEnv() []string {
+	env := os.Environ()
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		if key, _, _ := strings.Cut(kv, "="); key != SecretPassphraseEnv {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+// storeSecrets are refci's own secret values that must never show in a job
+// log, whatever the job's secrets are.
+func storeSecrets() []string {
+	if passphrase := os.Getenv(SecretPassphraseEnv); passphrase != "" {
+		return []string{passphrase}
+	}
+	return nil
+}
+
+// envValues returns the values of KEY=value entries.
+func envValues(env []string) []string {
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		if _, v, ok := strings.Cut(kv, "="); ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
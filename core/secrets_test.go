@@ -0,0 +1,268 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSecretStoreScopes(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	if _, err := OpenSecretStore(repo, false); !errors.Is(err, ErrNoSecretKey) {
+		t.Fatalf("OpenSecretStore(create=false) error = %v, want ErrNoSecretKey", err)
+	}
+	store, err := OpenSecretStore(repo, true)
+	if err != nil {
+		t.Fatalf("OpenSecretStore() error = %v", err)
+	}
+	info, err := os.Stat(SecretKeyPath())
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("key file = %v, %v; want mode 0600", info, err)
+	}
+
+	set := []struct {
+		scope SecretScope
+		key   string
+		value string
+	}{
+		{SecretScope{}, "TOKEN", "global-token"},
+		{SecretScope{}, "REGION", "eu"},
+		{SecretScope{Repo: "acme/app"}, "TOKEN", "repo-token"},
+		{SecretScope{Repo: "acme/app", Job: "deploy"}, "TOKEN", "deploy-token"},
+		{SecretScope{Repo: "acme/other"}, "TOKEN", "other-token"},
+	}
+	for _, s := range set {
+		if err := store.Set(s.scope, s.key, s.value); err != nil {
+			t.Fatalf("Set(%s, %s) error = %v", s.scope, s.key, err)
+		}
+	}
+
+	tests := []struct {
+		job  string
+		want string
+	}{
+		{job: "build", want: "REGION=eu,TOKEN=repo-token"},
+		{job: "deploy", want: "REGION=eu,TOKEN=deploy-token"},
+	}
+	for _, tt := range tests {
+		env, err := store.ForJob("acme/app", tt.job)
+		if err != nil {
+			t.Fatalf("ForJob(%s) error = %v", tt.job, err)
+		}
+		if got := strings.Join(env, ","); got != tt.want {
+			t.Fatalf("ForJob(%s) = %s, want %s", tt.job, got, tt.want)
+		}
+	}
+
+	value, ok, err := store.Get(SecretScope{Repo: "acme/app"}, "TOKEN")
+	if err != nil || !ok || value != "repo-token" {
+		t.Fatalf("Get() = %q, %v, %v; want repo-token", value, ok, err)
+	}
+	if err := store.Set(SecretScope{}, "bad-name", "x"); err == nil {
+		t.Fatal("Set(bad-name) error = nil, want invalid name")
+	}
+
+	// Ciphertexts are bound to their scope and name.
+	rows, err := repo.ListSecrets("acme/other")
+	if err != nil {
+		t.Fatalf("ListSecrets() error = %v", err)
+	}
+	swapped := rows[0]
+	swapped.Repo = "acme/app"
+	swapped.Key = "STOLEN"
+	if err := repo.SetSecret(swapped); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	if _, err := store.ForJob("acme/app", "build"); err == nil || !strings.Contains(err.Error(), "decrypt secret STOLEN") {
+		t.Fatalf("ForJob() with a moved ciphertext error = %v, want decrypt error", err)
+	}
+}
+
+func TestSecretStorePassphrase(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	t.Setenv(SecretPassphraseEnv, "correct horse")
+	store, err := OpenSecretStore(repo, true)
+	if err != nil {
+		t.Fatalf("OpenSecretStore() error = %v", err)
+	}
+	if err := store.Set(SecretScope{}, "TOKEN", "abc"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if _, err := os.Stat(SecretKeyPath()); !os.IsNotExist(err) {
+		t.Fatalf("key file stat error = %v, want no key file in passphrase mode", err)
+	}
+
+	t.Setenv(SecretPassphraseEnv, "wrong horse")
+	store, err = OpenSecretStore(repo, false)
+	if err != nil {
+		t.Fatalf("OpenSecretStore() error = %v", err)
+	}
+	if _, _, err := store.Get(SecretScope{}, "TOKEN"); err == nil || !strings.Contains(err.Error(), "wrong key or passphrase") {
+		t.Fatalf("Get() with the wrong passphrase error = %v", err)
+	}
+}
+
+func TestJobRunnerInjectsStoredSecrets(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	sha := src.commit(t, "README.md", "hello\n", "init")
+	src.mirror(t, "acme/app")
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	store, err := OpenSecretStore(repo, true)
+	if err != nil {
+		t.Fatalf("OpenSecretStore() error = %v", err)
+	}
+	if err := store.Set(SecretScope{Repo: "acme/app", Job: "deploy"}, "DEPLOY_TOKEN", "tok-123456"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+	runner.SetSecretStore(store)
+
+	jc := JobConf{Repo: "acme/app", Name: "deploy", Run: `echo "token=$DEPLOY_TOKEN len=${#DEPLOY_TOKEN}"`}
+	if err := runner.QueueJob(jc, nil, "main", sha, "test"); err != nil {
+		t.Fatalf("QueueJob() error = %v", err)
+	}
+	job, err := repo.LatestJobByNameBranch("acme/app", "deploy", "main")
+	if err != nil {
+		t.Fatalf("LatestJobByNameBranch() error = %v", err)
+	}
+	job = waitJobDone(t, repo, job.RunID)
+	if job.Status != StatusFinished {
+		t.Fatalf("job status = %q (%s)", job.Status, job.Msg)
+	}
+	body, err := os.ReadFile(job.LogPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(body), "token=*** len=10") {
+		t.Fatalf("log = %q, want the injected and masked secret", body)
+	}
+}
+
+func TestJobRunnerOpensSecretStoreOnceAKeyExists(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	first := src.commit(t, "README.md", "hello\n", "init")
+	second := src.commit(t, "README.md", "again\n", "again")
+	src.mirror(t, "acme/app")
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	// The runner starts before any secret, and so any key, exists.
+	runner := NewJobRunner(repo)
+	jc := JobConf{Repo: "acme/app", Name: "deploy", Run: `echo "token=${DEPLOY_TOKEN:-unset}"`}
+	run := func(sha string) string {
+		t.Helper()
+		if err := runner.QueueJob(jc, nil, "main", sha, "test"); err != nil {
+			t.Fatalf("QueueJob() error = %v", err)
+		}
+		job, err := repo.LatestJobByNameBranch("acme/app", "deploy", "main")
+		if err != nil {
+			t.Fatalf("LatestJobByNameBranch() error = %v", err)
+		}
+		job = waitJobDone(t, repo, job.RunID)
+		body, err := os.ReadFile(job.LogPath)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		return string(body)
+	}
+	if log := run(first); !strings.Contains(log, "token=unset") {
+		t.Fatalf("log = %q, want no secret before one is set", log)
+	}
+
+	store, err := OpenSecretStore(repo, true)
+	if err != nil {
+		t.Fatalf("OpenSecretStore() error = %v", err)
+	}
+	if err := store.Set(SecretScope{Repo: "acme/app"}, "DEPLOY_TOKEN", "tok-123456"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if log := run(second); !strings.Contains(log, "token=***") {
+		t.Fatalf("log = %q, want the secret set after start injected and masked", log)
+	}
+}
+
+func TestJobRunnerHidesSecretPassphrase(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	sha := src.commit(t, "README.md", "hello\n", "init")
+	src.mirror(t, "acme/app")
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	t.Setenv(SecretPassphraseEnv, "correct horse battery")
+	runner := NewJobRunner(repo)
+	// The step cannot see the passphrase, and it is masked should it get to
+	// the log another way.
+	jc := JobConf{Repo: "acme/app", Name: "build", Run: `echo "env=${REFCI_SECRET_PASSPHRASE:-unset}"; echo "typed=correct horse battery"`}
+	if err := runner.QueueJob(jc, nil, "main", sha, "test"); err != nil {
+		t.Fatalf("QueueJob() error = %v", err)
+	}
+	job, err := repo.LatestJobByNameBranch("acme/app", "build", "main")
+	if err != nil {
+		t.Fatalf("LatestJobByNameBranch() error = %v", err)
+	}
+	job = waitJobDone(t, repo, job.RunID)
+	if job.Status != StatusFinished {
+		t.Fatalf("job status = %q (%s)", job.Status, job.Msg)
+	}
+	body, err := os.ReadFile(job.LogPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(body), "env=unset") || !strings.Contains(string(body), "typed=***") {
+		t.Fatalf("log = %q, want the passphrase unset in the step and masked", body)
+	}
+
+	env, err := NewExprEnv(context.Background(), "acme/app", "main", "", sha, nil)
+	if err != nil {
+		t.Fatalf("NewExprEnv() error = %v", err)
+	}
+	if _, ok := env.Env[SecretPassphraseEnv]; ok {
+		t.Fatalf("NewExprEnv() exposes %s to if: expressions", SecretPassphraseEnv)
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultShell runs scripts the way refci always has: bash <script>.
+const DefaultShell = "bash"
+
+// ShellArgs returns the argv that runs scriptPath with shell. shell is an
+// interpreter with optional flags (sh, bash -euo pipefail, python3) or an
+// argv template where {0} stands for the script path, e.g. "node {0} --ci".
+// Without {0} the path is appended. Arguments are split on whitespace.
+func ShellArgs(shell, scriptPath string) ([]string, error) {
+	template := strings.TrimSpace(shell)
+	if template == "" {
+		template = DefaultShell
+	}
+	fields := strings.Fields(template)
+	if strings.Contains(fields[0], "{0}") {
+		return nil, fmt.Errorf("invalid shell %q: the first word must be the interpreter", shell)
+	}
+
+	args := make([]string, 0, len(fields)+1)
+	substituted := false
+	for _, f := range fields {
+		if strings.Contains(f, "{0}") {
+			f = strings.ReplaceAll(f, "{0}", scriptPath)
+			substituted = true
+		}
+		args = append(args, f)
+	}
+	if !substituted {
+		args = append(args, scriptPath)
+	}
+	return args, nil
+}
+
+// FormatCommandLine renders argv as a shell-quoted command line for display
+// and storage on job rows.
+func FormatCommandLine(args []string) string {
+	parts := make([]string, 0, len(args))
+	for _, a := range args {
+		parts = append(parts, shellQuote(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(s, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// RunDir is the per-run scratch directory holding inline scripts.
+func RunDir(repo, runID string) string {
+	return filepath.Join(Root, "runs", ToLocalRepo(repo), sanitizePathToken(runID))
+}
+
+// writeInlineScript stores an inline `run:` body in runDir and returns its
+// path. A trailing newline is added so interpreters see a complete last line.
+func writeInlineScript(runDir string, idx int, body string) (string, error) {
+	if err := os.MkdirAll(runDir, 0o755); err != nil {
+		return "", fmt.Errorf("create run dir %q: %w", runDir, err)
+	}
+	p := filepath.Join(runDir, fmt.Sprintf("step-%d.inline", idx+1))
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		return "", fmt.Errorf("write inline script: %w", err)
+	}
+	return p, nil
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellArgs(t *testing.T) {
+	cases := []struct {
+		shell string
+		want  []string
+	}{
+		{"", []string{"bash", "/tmp/s"}},
+		{"sh", []string{"sh", "/tmp/s"}},
+		{"bash -euo pipefail", []string{"bash", "-euo", "pipefail", "/tmp/s"}},
+		{"python3", []string{"python3", "/tmp/s"}},
+		{"node {0} --ci", []string{"node", "/tmp/s", "--ci"}},
+		{"env RUN={0} make", []string{"env", "RUN=/tmp/s", "make"}},
+	}
+	for _, tc := range cases {
+		got, err := ShellArgs(tc.shell, "/tmp/s")
+		if err != nil {
+			t.Fatalf("ShellArgs(%q) error = %v", tc.shell, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("ShellArgs(%q) = %q, want %q", tc.shell, got, tc.want)
+		}
+	}
+
+	if _, err := ShellArgs("{0}", "/tmp/s"); err == nil {
+		t.Fatalf("ShellArgs({0}) error = nil, want error")
+	}
+}
+
+func TestResolveRunStepsWritesInlineScripts(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "build.sh"), []byte("echo build\n"), 0o755); err != nil {
+		t.Fatalf("WriteFile(build.sh) error = %v", err)
+	}
+
+	conf := JobConf{
+		Repo:  "acme/refci",
+		Name:  "build",
+		Shell: "bash -euo pipefail",
+		Steps: []StepConf{
+			{Name: "build", Script: "build.sh"},
+			{Name: "check", Run: "echo one\necho two", Shell: "sh"},
+		},
+	}
+	runDir := RunDir(conf.Repo, "run-1")
+	steps, err := resolveRunSteps(conf, workDir, runDir)
+	if err != nil {
+		t.Fatalf("resolveRunSteps() error = %v", err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("steps len = %d, want 2", len(steps))
+	}
+
+	wantBuild := []string{"bash", "-euo", "pipefail", filepath.Join(workDir, "build.sh")}
+	if !reflect.DeepEqual(steps[0].Args, wantBuild) {
+		t.Fatalf("build args = %q, want %q", steps[0].Args, wantBuild)
+	}
+	inline := filepath.Join(runDir, "step-2.inline")
+	if !reflect.DeepEqual(steps[1].Args, []string{"sh", inline}) {
+		t.Fatalf("check args = %q, want sh %s", steps[1].Args, inline)
+	}
+	body, err := os.ReadFile(inline)
+	if err != nil {
+		t.Fatalf("ReadFile(inline) error = %v", err)
+	}
+	if string(body) != "echo one\necho two\n" {
+		t.Fatalf("inline body = %q", body)
+	}
+
+	if got := FormatCommandLine([]string{"node", "/a b/s", "it's"}); !strings.Contains(got, `'/a b/s'`) || !strings.Contains(got, `'it'\''s'`) {
+		t.Fatalf("FormatCommandLine() = %q, want quoted args", got)
+	}
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (r SQLiteRepo) ensureCommitsTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS commits (
+		repo TEXT NOT NULL,
+		sha TEXT NOT NULL,
+		subject TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '',
+		author_name TEXT NOT NULL DEFAULT '',
+		author_email TEXT NOT NULL DEFAULT '',
+		author_at TEXT NOT NULL DEFAULT '',
+		committer_name TEXT NOT NULL DEFAULT '',
+		committer_email TEXT NOT NULL DEFAULT '',
+		commit_at TEXT NOT NULL DEFAULT '',
+		parents TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (repo, sha)
+	);`)
+	if err != nil {
+		return fmt.Errorf("ensure commits table: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) UpsertCommit(c Commit) error {
+	if strings.TrimSpace(c.Repo) == "" || strings.TrimSpace(c.SHA) == "" {
+		return fmt.Errorf("commit repo and sha are required")
+	}
+	_, err := r.db.Exec(
+		`INSERT INTO commits (repo, sha, subject, body, author_name, author_email, author_at, committer_name, committer_email, commit_at, parents)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(repo, sha) DO UPDATE SET
+		     subject = excluded.subject,
+		     body = excluded.body,
+		     author_name = excluded.author_name,
+		     author_email = excluded.author_email,
+		     author_at = excluded.author_at,
+		     committer_name = excluded.committer_name,
+		     committer_email = excluded.committer_email,
+		     commit_at = excluded.commit_at,
+		     parents = excluded.parents`,
+		c.Repo,
+		c.SHA,
+		c.Subject,
+		c.Body,
+		c.AuthorName,
+		c.AuthorEmail,
+		formatOptionalTime(c.AuthorTime),
+		c.CommitterName,
+		c.CommitterEmail,
+		formatOptionalTime(c.CommitTime),
+		strings.Join(c.Parents, " "),
+	)
+	if err != nil {
+		return fmt.Errorf("upsert commit: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) CommitBySHA(repo, sha string) (Commit, error) {
+	var (
+		c          Commit
+		authorAt   string
+		commitAt   string
+		parentsRaw string
+	)
+	err := r.db.QueryRow(
+		`SELECT repo, sha, subject, body, author_name, author_email, author_at, committer_name, committer_email, commit_at, parents
+		 FROM commits
+		 WHERE repo = ? AND sha = ?`,
+		repo, sha,
+	).Scan(
+		&c.Repo,
+		&c.SHA,
+		&c.Subject,
+		&c.Body,
+		&c.AuthorName,
+		&c.AuthorEmail,
+		&authorAt,
+		&c.CommitterName,
+		&c.CommitterEmail,
+		&commitAt,
+		&parentsRaw,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Commit{}, nil
+	}
+	if err != nil {
+		return Commit{}, fmt.Errorf("get commit: %w", err)
+	}
+
+	c.AuthorTime, err = parseStoredTime(authorAt)
+	if err != nil {
+		return Commit{}, fmt.Errorf("parse commit author time: %w", err)
+	}
+	c.CommitTime, err = parseStoredTime(commitAt)
+	if err != nil {
+		return Commit{}, fmt.Errorf("parse commit time: %w", err)
+	}
+	c.Parents = strings.Fields(parentsRaw)
+	return c, nil
+}
+
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatStoredTime(t)
+}
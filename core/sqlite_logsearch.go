@@ -0,0 +1,226 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The log search index is an FTS5 table of job log lines with the trigram
+// tokenizer, so any substring of three or more characters is found through
+// the index. job_log_index records which runs are indexed and the rowid range
+// of their lines, so a run is removed without scanning the whole table.
+func (r SQLiteRepo) ensureLogSearchTables() error {
+	_, err := r.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS job_log_lines USING fts5(
+		text,
+		run_id UNINDEXED,
+		line UNINDEXED,
+		tokenize = 'trigram'
+	);`)
+	if err != nil {
+		return fmt.Errorf("ensure job_log_lines table: %w", err)
+	}
+	_, err = r.db.Exec(`CREATE TABLE IF NOT EXISTS job_log_index (
+		run_id TEXT PRIMARY KEY,
+		first_rowid INTEGER NOT NULL,
+		lines INTEGER NOT NULL,
+		indexed_at TEXT NOT NULL
+	);`)
+	if err != nil {
+		return fmt.Errorf("ensure job_log_index table: %w", err)
+	}
+	return nil
+}
+
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func deleteJobLogIndex(db sqlExecer, runID string) error {
+	var first, lines int64
+	err := db.QueryRow(`SELECT first_rowid, lines FROM job_log_index WHERE run_id = ?`, runID).Scan(&first, &lines)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read job log index: %w", err)
+	}
+	if lines > 0 {
+		if _, err := db.Exec(`DELETE FROM job_log_lines WHERE rowid BETWEEN ? AND ?`, first, first+lines-1); err != nil {
+			return fmt.Errorf("delete job log lines: %w", err)
+		}
+	}
+	if _, err := db.Exec(`DELETE FROM job_log_index WHERE run_id = ?`, runID); err != nil {
+		return fmt.Errorf("delete job log index: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) IndexJobLog(runID string, lines iter.Seq2[int, string]) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin index job log: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = deleteJobLogIndex(tx, runID); err != nil {
+		return err
+	}
+	// The lines get consecutive rowids: the transaction is the only writer.
+	var first int64
+	if err = tx.QueryRow(`SELECT COALESCE(MAX(rowid), 0) + 1 FROM job_log_lines`).Scan(&first); err != nil {
+		return fmt.Errorf("read job log lines rowid: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO job_log_lines (rowid, text, run_id, line) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("prepare index job log: %w", err)
+	}
+	defer stmt.Close()
+	var n int64
+	for line, text := range lines {
+		if _, err = stmt.Exec(first+n, text, runID, line); err != nil {
+			return fmt.Errorf("index job log line: %w", err)
+		}
+		n++
+	}
+	if _, err = tx.Exec(
+		`INSERT INTO job_log_index (run_id, first_rowid, lines, indexed_at) VALUES (?, ?, ?, ?)`,
+		runID, first, n, formatStoredTime(time.Now()),
+	); err != nil {
+		return fmt.Errorf("record job log index: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit index job log: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) DeleteJobLogIndex(runID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin delete job log index: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err = deleteJobLogIndex(tx, runID); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit delete job log index: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) ListUnindexedJobs(repo string) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs
+		WHERE log_path <> '' AND status NOT IN (?, ?)
+		AND run_id NOT IN (SELECT run_id FROM job_log_index)`
+	args := []any{StatusRunning, StatusPending}
+	if strings.TrimSpace(repo) != "" {
+		query += ` AND repo = ?`
+		args = append(args, repo)
+	}
+	query += ` ORDER BY start_at ASC`
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list unindexed jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate unindexed jobs: %w", err)
+	}
+	return out, nil
+}
+
+func (r SQLiteRepo) SearchJobLogs(search LogSearch) ([]LogMatch, error) {
+	// The trigram index only finds patterns of three or more characters;
+	// shorter ones scan the table.
+	match := `job_log_lines MATCH ?`
+	arg := `"` + strings.ReplaceAll(search.Pattern, `"`, `""`) + `"`
+	if utf8.RuneCountInString(search.Pattern) < 3 {
+		match = `text LIKE ? ESCAPE '\'`
+		arg = "%" + escapeLike(search.Pattern) + "%"
+	}
+	where := []string{"1 = 1"}
+	args := []any{arg}
+	if strings.TrimSpace(search.Repo) != "" {
+		where = append(where, "repo = ?")
+		args = append(args, search.Repo)
+	}
+	if strings.TrimSpace(search.Job) != "" {
+		where = append(where, "name = ?")
+		args = append(args, search.Job)
+	}
+	if !search.Since.IsZero() {
+		where = append(where, "start_at >= ?")
+		args = append(args, formatStoredTime(search.Since))
+	}
+
+	query := `SELECT ` + jobColumns + `, m_line, m_text FROM jobs
+		JOIN (SELECT run_id AS m_run, line AS m_line, text AS m_text FROM job_log_lines WHERE ` + match + `)
+		ON run_id = m_run
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY start_at ASC, m_line ASC`
+	if search.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, search.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search job logs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []LogMatch
+	for rows.Next() {
+		var m LogMatch
+		m.Job, err = scanJob(jobMatchScanner{rows: rows, extra: []any{&m.Line, &m.Text}})
+		if err != nil {
+			return nil, fmt.Errorf("scan log match: %w", err)
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate log matches: %w", err)
+	}
+	return out, nil
+}
+
+// jobMatchScanner scans a job row followed by the columns of a match.
+type jobMatchScanner struct {
+	rows  *sql.Rows
+	extra []any
+}
+
+func (s jobMatchScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.extra...)...)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern for ESCAPE '\'.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -23,6 +23,28 @@ func NewSQLiteRepo(db *sql.DB) (*SQLiteRepo, error) {
 }
 
 func (r SQLiteRepo) ensureSchema() error {
+	if err := r.ensureJobsSchema(); err != nil {
+		return err
+	}
+	if err := r.ensureCommitsTable(); err != nil {
+		return err
+	}
+	if err := r.ensureJobStepsTable(); err != nil {
+		return err
+	}
+	if err := r.ensureRepoSettingsTable(); err != nil {
+		return err
+	}
+	if err := r.ensureSecretsTable(); err != nil {
+		return err
+	}
+	if err := r.ensureLogSearchTables(); err != nil {
+		return err
+	}
+	return r.ensureTestCasesTable()
+}
+
+func (r SQLiteRepo) ensureJobsSchema() error {
 	exists, err := r.jobsTableExists()
 	if err != nil {
 		return err
@@ -50,8 +72,11 @@ func (r SQLiteRepo) ensureSchema() error {
 			return err
 		}
 	}
-	if !cols["log_path"] {
-		if err := r.ensureJobsColumn("log_path", "TEXT NOT NULL DEFAULT ''"); err != nil {
+	for _, col := range jobsAddedColumns {
+		if cols[col.name] {
+			continue
+		}
+		if err := r.ensureJobsColumn(col.name, col.spec); err != nil {
 			return err
 		}
 	}
@@ -59,6 +84,22 @@ func (r SQLiteRepo) ensureSchema() error {
 	return r.createJobsIndexes()
 }
 
+// jobsAddedColumns are columns added after the run_id migration; older
+// databases get them through ALTER TABLE.
+var jobsAddedColumns = []struct {
+	name string
+	spec string
+}{
+	{"log_path", "TEXT NOT NULL DEFAULT ''"},
+	{"reason", "TEXT NOT NULL DEFAULT ''"},
+	{"pr_number", "INTEGER NOT NULL DEFAULT 0"},
+	{"command", "TEXT NOT NULL DEFAULT ''"},
+	{"peak_memory", "INTEGER NOT NULL DEFAULT 0"},
+	{"cpu_time_ms", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+const jobColumns = `run_id, repo, name, branch, sha, commit_author, reason, pr_number, command, log_path, start_at, end_at, status, msg, peak_memory, cpu_time_ms`
+
 func (r SQLiteRepo) jobsTableExists() (bool, error) {
 	var name string
 	err := r.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'jobs'`).Scan(&name)
@@ -72,9 +113,13 @@ func (r SQLiteRepo) jobsTableExists() (bool, error) {
 }
 
 func (r SQLiteRepo) jobsColumns() (map[string]bool, error) {
-	rows, err := r.db.Query(`PRAGMA table_info(jobs)`)
+	return r.tableColumns("jobs")
+}
+
+func (r SQLiteRepo) tableColumns(table string) (map[string]bool, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
 	if err != nil {
-		return nil, fmt.Errorf("list jobs columns: %w", err)
+		return nil, fmt.Errorf("list %s columns: %w", table, err)
 	}
 	defer rows.Close()
 
@@ -89,12 +134,12 @@ func (r SQLiteRepo) jobsColumns() (map[string]bool, error) {
 			pk         int
 		)
 		if err := rows.Scan(&cid, &columnName, &columnType, &notNull, &defaultVal, &pk); err != nil {
-			return nil, fmt.Errorf("scan jobs column: %w", err)
+			return nil, fmt.Errorf("scan %s column: %w", table, err)
 		}
 		cols[strings.ToLower(strings.TrimSpace(columnName))] = true
 	}
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("iterate jobs columns: %w", err)
+		return nil, fmt.Errorf("iterate %s columns: %w", table, err)
 	}
 	return cols, nil
 }
@@ -112,7 +157,12 @@ func (r SQLiteRepo) createJobsTable() error {
 			end_at TEXT,
 			status TEXT NOT NULL,
 			msg TEXT NOT NULL DEFAULT '',
-			log_path TEXT NOT NULL DEFAULT ''
+			log_path TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL DEFAULT '',
+			pr_number INTEGER NOT NULL DEFAULT 0,
+			command TEXT NOT NULL DEFAULT '',
+			peak_memory INTEGER NOT NULL DEFAULT 0,
+			cpu_time_ms INTEGER NOT NULL DEFAULT 0
 		);`,
 		`CREATE INDEX IF NOT EXISTS idx_jobs_repo_name_branch_status_start
 		 ON jobs(repo, name, branch, status, start_at DESC);`,
@@ -215,7 +265,7 @@ func (r SQLiteRepo) ensureJobsColumn(name, spec string) error {
 
 func (r SQLiteRepo) LatestJobByNameBranch(repo, name, branch string) (Job, error) {
 	return r.queryOne(
-		`SELECT run_id, repo, name, branch, sha, commit_author, log_path, start_at, end_at, status, msg
+		`SELECT `+jobColumns+`
 		 FROM jobs
 		 WHERE repo = ? AND name = ? AND branch = ?
 		 ORDER BY start_at DESC
@@ -226,19 +276,19 @@ func (r SQLiteRepo) LatestJobByNameBranch(repo, name, branch string) (Job, error
 
 func (r SQLiteRepo) JobByRunID(runID string) (Job, error) {
 	return r.queryOne(
-		`SELECT run_id, repo, name, branch, sha, commit_author, log_path, start_at, end_at, status, msg
+		`SELECT `+jobColumns+`
 		 FROM jobs
 		 WHERE run_id = ?`,
 		runID,
 	)
 }
 
-func (r SQLiteRepo) CreateJob(runID, repo, name, branch, sha, commitAuthor string) error {
+func (r SQLiteRepo) CreateJob(job Job) error {
 	now := formatStoredTime(time.Now().UTC())
 	_, err := r.db.Exec(
-		`INSERT INTO jobs (run_id, repo, name, branch, sha, commit_author, start_at, status, msg, log_path)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, '', '')`,
-		runID, repo, name, branch, sha, strings.TrimSpace(commitAuthor), now, StatusPending,
+		`INSERT INTO jobs (run_id, repo, name, branch, sha, commit_author, reason, pr_number, command, start_at, status, msg, log_path)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, '', '')`,
+		job.RunID, job.Repo, job.Name, job.Branch, job.SHA, strings.TrimSpace(job.CommitAuthor), strings.TrimSpace(job.Reason), job.PRNumber, job.Command, now, StatusPending,
 	)
 	if err != nil {
 		return fmt.Errorf("create job: %w", err)
@@ -246,6 +296,17 @@ func (r SQLiteRepo) CreateJob(runID, repo, name, branch, sha, commitAuthor strin
 	return nil
 }
 
+func (r SQLiteRepo) UpdateJobUsage(runID string, usage JobUsage) error {
+	_, err := r.db.Exec(
+		`UPDATE jobs SET peak_memory = ?, cpu_time_ms = ? WHERE run_id = ?`,
+		usage.PeakMemory, usage.CPUTime.Milliseconds(), runID,
+	)
+	if err != nil {
+		return fmt.Errorf("update job usage: %w", err)
+	}
+	return nil
+}
+
 func (r SQLiteRepo) UpdateJob(runID, status, msg, logPath string) error {
 	now := formatStoredTime(time.Now().UTC())
 	_, err := r.db.Exec(
@@ -257,14 +318,14 @@ func (r SQLiteRepo) UpdateJob(runID, status, msg, logPath string) error {
 		                  ELSE log_path
 		                END,
 		     end_at = CASE
-		                WHEN ? IN (?, ?, ?) THEN ?
+		                WHEN ? IN (?, ?, ?, ?) THEN ?
 		                ELSE end_at
 		              END
 		 WHERE run_id = ?`,
 		status,
 		msg,
 		logPath, logPath,
-		status, StatusFinished, StatusFailed, StatusCanceled,
+		status, StatusFinished, StatusFailed, StatusCanceled, StatusSkipped,
 		now,
 		runID,
 	)
@@ -274,6 +335,35 @@ func (r SQLiteRepo) UpdateJob(runID, status, msg, logPath string) error {
 	return nil
 }
 
+func (r SQLiteRepo) DeleteJob(runID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin delete job: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM job_steps WHERE run_id = ?`, runID); err != nil {
+		return fmt.Errorf("delete job steps: %w", err)
+	}
+	if _, err = tx.Exec(`DELETE FROM test_cases WHERE run_id = ?`, runID); err != nil {
+		return fmt.Errorf("delete test cases: %w", err)
+	}
+	if err = deleteJobLogIndex(tx, runID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(`DELETE FROM jobs WHERE run_id = ?`, runID); err != nil {
+		return fmt.Errorf("delete job: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit delete job: %w", err)
+	}
+	return nil
+}
+
 func (r SQLiteRepo) ListJob(filter JobFilter) ([]Job, error) {
 	var (
 		where []string
@@ -296,12 +386,33 @@ func (r SQLiteRepo) ListJob(filter JobFilter) ([]Job, error) {
 		where = append(where, "status = ?")
 		args = append(args, filter.Status)
 	}
+	if strings.TrimSpace(filter.ExcludeStatus) != "" {
+		where = append(where, "status <> ?")
+		args = append(args, filter.ExcludeStatus)
+	}
+	if author := strings.TrimSpace(filter.Author); author != "" {
+		where = append(where, `commit_author LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(author)+"%")
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "start_at >= ?")
+		args = append(args, formatStoredTime(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "start_at < ?")
+		args = append(args, formatStoredTime(filter.Until))
+	}
+	if filter.After.RunID != "" {
+		start := formatStoredTime(filter.After.Start)
+		where = append(where, "(start_at < ? OR (start_at = ? AND run_id < ?))")
+		args = append(args, start, start, filter.After.RunID)
+	}
 
-	query := `SELECT run_id, repo, name, branch, sha, commit_author, log_path, start_at, end_at, status, msg FROM jobs`
+	query := `SELECT ` + jobColumns + ` FROM jobs`
 	if len(where) > 0 {
 		query += " WHERE " + strings.Join(where, " AND ")
 	}
-	query += " ORDER BY start_at DESC"
+	query += " ORDER BY start_at DESC, run_id DESC"
 	if filter.Limit > 0 {
 		query += " LIMIT ?"
 		args = append(args, filter.Limit)
@@ -379,6 +490,7 @@ func scanJob(scanner jobScanner) (Job, error) {
 		j       Job
 		startAt string
 		endAt   sql.NullString
+		cpuMS   int64
 	)
 	err := scanner.Scan(
 		&j.RunID,
@@ -387,15 +499,21 @@ func scanJob(scanner jobScanner) (Job, error) {
 		&j.Branch,
 		&j.SHA,
 		&j.CommitAuthor,
+		&j.Reason,
+		&j.PRNumber,
+		&j.Command,
 		&j.LogPath,
 		&startAt,
 		&endAt,
 		&j.Status,
 		&j.Msg,
+		&j.PeakMemory,
+		&cpuMS,
 	)
 	if err != nil {
 		return Job{}, err
 	}
+	j.CPUTime = time.Duration(cpuMS) * time.Millisecond
 
 	j.Start, err = parseStoredTime(startAt)
 	if err != nil {
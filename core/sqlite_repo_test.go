@@ -26,7 +26,7 @@ func TestSQLiteRepoAllowsMultipleRunsPerSHA(t *testing.T) {
 		sha = "deadbeefcafebabe"
 	)
 
-	if err := repo.CreateJob(run1, repoName, jobName, branch, sha, "alice"); err != nil {
+	if err := repo.CreateJob(Job{RunID: run1, Repo: repoName, Name: jobName, Branch: branch, SHA: sha, CommitAuthor: "alice"}); err != nil {
 		t.Fatalf("CreateJob(run1) error = %v", err)
 	}
 	if err := repo.UpdateJob(run1, StatusCanceled, "canceled", "/tmp/first.log"); err != nil {
@@ -35,7 +35,7 @@ func TestSQLiteRepoAllowsMultipleRunsPerSHA(t *testing.T) {
 
 	time.Sleep(time.Millisecond)
 
-	if err := repo.CreateJob(run2, repoName, jobName, branch, sha, "alice"); err != nil {
+	if err := repo.CreateJob(Job{RunID: run2, Repo: repoName, Name: jobName, Branch: branch, SHA: sha, CommitAuthor: "alice", Reason: "manual restart"}); err != nil {
 		t.Fatalf("CreateJob(run2) error = %v", err)
 	}
 	if err := repo.UpdateJob(run2, StatusRunning, "", "/tmp/second.log"); err != nil {
@@ -66,6 +66,9 @@ func TestSQLiteRepoAllowsMultipleRunsPerSHA(t *testing.T) {
 	if latest.LogPath != "/tmp/second.log" {
 		t.Fatalf("LatestJobByNameBranch().LogPath = %q, want %q", latest.LogPath, "/tmp/second.log")
 	}
+	if latest.Reason != "manual restart" {
+		t.Fatalf("LatestJobByNameBranch().Reason = %q, want %q", latest.Reason, "manual restart")
+	}
 }
 
 func TestCreateJobLogFileUsesFreshRunPath(t *testing.T) {
@@ -243,6 +246,284 @@ func TestJobRunnerKillsBackgroundWritersOnExit(t *testing.T) {
 	}
 }
 
+func TestJobRunnerRunsStepsInOrder(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	db := openTestDB(t)
+	repo, err := NewSQLiteRepo(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+
+	req := RunJobRequest{
+		RunID:   "run-steps",
+		Repo:    "acme/refci",
+		Name:    "build",
+		Branch:  "main",
+		SHA:     "deadbeefcafebabe",
+		WorkDir: t.TempDir(),
+		Steps: []RunStep{
+			{Name: "setup", Args: []string{"bash", "-c", "echo setting-up"}},
+			{Name: "build", Args: []string{"bash", "-c", "echo compiling; exit 3"}},
+			{Name: "test", Args: []string{"bash", "-c", "echo testing"}},
+		},
+	}
+	logPath, err := runner.Start(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	var job Job
+	for {
+		job, err = repo.JobByRunID(req.RunID)
+		if err != nil {
+			t.Fatalf("JobByRunID() error = %v", err)
+		}
+		if job.Status != StatusRunning && job.Status != StatusPending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish before deadline; last status=%q", job.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if job.Status != StatusFailed || !strings.HasPrefix(job.Msg, "step build:") {
+		t.Fatalf("job status/msg = %q/%q, want failed in step build", job.Status, job.Msg)
+	}
+
+	steps, err := repo.ListJobSteps(req.RunID)
+	if err != nil {
+		t.Fatalf("ListJobSteps() error = %v", err)
+	}
+	wantStatus := []string{StatusFinished, StatusFailed, StatusSkipped}
+	if len(steps) != len(wantStatus) {
+		t.Fatalf("steps len = %d, want %d", len(steps), len(wantStatus))
+	}
+	for i, step := range steps {
+		if step.Status != wantStatus[i] {
+			t.Fatalf("step %d (%s) status = %q, want %q", i, step.Name, step.Status, wantStatus[i])
+		}
+	}
+	if steps[0].Start.IsZero() || steps[0].End.IsZero() {
+		t.Fatalf("step setup times = %v/%v, want both set", steps[0].Start, steps[0].End)
+	}
+
+	body, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile(logPath) error = %v", err)
+	}
+	build := string(body[steps[1].LogStart:steps[1].LogEnd])
+	if build != "compiling\n" {
+		t.Fatalf("build segment = %q, want only build output", build)
+	}
+	if strings.Contains(string(body), "testing") {
+		t.Fatalf("log contains output of skipped step: %q", body)
+	}
+}
+
+func TestJobRunnerRecordSkipStoresSkippedRow(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	db := openTestDB(t)
+	repo, err := NewSQLiteRepo(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+
+	conf := JobConf{Repo: "acme/refci", Name: "build"}
+	if err := runner.RecordSkip(conf, "main", "deadbeefcafebabe", "path-skip: 1 changed files"); err != nil {
+		t.Fatalf("RecordSkip() error = %v", err)
+	}
+
+	latest, err := repo.LatestJobByNameBranch("acme/refci", "build", "main")
+	if err != nil {
+		t.Fatalf("LatestJobByNameBranch() error = %v", err)
+	}
+	if latest.Status != StatusSkipped || latest.SHA != "deadbeefcafebabe" {
+		t.Fatalf("latest = %+v, want skipped row at sha", latest)
+	}
+	if latest.Reason != "path-skip: 1 changed files" || latest.End.IsZero() {
+		t.Fatalf("latest reason/end = %q/%v, want reason and end time", latest.Reason, latest.End)
+	}
+
+	visible, err := repo.ListJob(JobFilter{Repo: "acme/refci", ExcludeStatus: StatusSkipped})
+	if err != nil {
+		t.Fatalf("ListJob() error = %v", err)
+	}
+	if len(visible) != 0 {
+		t.Fatalf("ListJob(ExcludeStatus=skipped) len = %d, want 0", len(visible))
+	}
+}
+
+// waitJobDone polls until runID leaves running/pending.
+func waitJobDone(t *testing.T, repo DbRepo, runID string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := repo.JobByRunID(runID)
+		if err != nil {
+			t.Fatalf("JobByRunID() error = %v", err)
+		}
+		if job.Status != StatusRunning && job.Status != StatusPending {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job did not finish before deadline; last status=%q", job.Status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestQueueJobCancelsRunBehindSkippedRow(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	src.commit(t, "slow.sh", "echo started\nsleep 30\n", "slow")
+	sha1 := src.commit(t, "fast.sh", "true\n", "fast")
+	sha2 := src.commit(t, "a.txt", "2\n", "two")
+	sha3 := src.commit(t, "a.txt", "3\n", "three")
+	src.mirror(t, "acme/app")
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+	jc := JobConf{Repo: "acme/app", Name: "build", ScriptPath: "slow.sh"}
+	if err := runner.QueueJob(jc, nil, "main", sha1, "test"); err != nil {
+		t.Fatalf("QueueJob(sha1) error = %v", err)
+	}
+	first, err := repo.LatestJobByNameBranch("acme/app", "build", "main")
+	if err != nil {
+		t.Fatalf("LatestJobByNameBranch() error = %v", err)
+	}
+	// Queueing resets the shared worktree, so wait until the script runs.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if b, _ := os.ReadFile(first.LogPath); strings.Contains(string(b), "started") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first run did not start before deadline")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := runner.RecordSkip(jc, "main", sha2, "path-skip: no matches"); err != nil {
+		t.Fatalf("RecordSkip(sha2) error = %v", err)
+	}
+
+	jc.ScriptPath = "fast.sh"
+	if err := runner.QueueJob(jc, nil, "main", sha3, "test"); err != nil {
+		t.Fatalf("QueueJob(sha3) error = %v", err)
+	}
+	if first = waitJobDone(t, repo, first.RunID); first.Status != StatusCanceled {
+		t.Fatalf("first run = %s (%s), want canceled", first.Status, first.Msg)
+	}
+	latest, err := repo.LatestJobByNameBranch("acme/app", "build", "main")
+	if err != nil {
+		t.Fatalf("LatestJobByNameBranch() error = %v", err)
+	}
+	if latest = waitJobDone(t, repo, latest.RunID); latest.SHA != sha3 || latest.Status != StatusFinished {
+		t.Fatalf("latest run = %s at %s, want finished at sha3", latest.Status, shortSHA(latest.SHA))
+	}
+}
+
+func TestListJobFiltersAndPages(t *testing.T) {
+	db := openTestDB(t)
+	repo, err := NewSQLiteRepo(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	// r3 and r4 start at the same time, so paging must break the tie.
+	runs := []struct {
+		id     string
+		name   string
+		author string
+		age    time.Duration
+	}{
+		{id: "r1", name: "test", author: "Ann Lee", age: 72 * time.Hour},
+		{id: "r2", name: "lint", author: "Bob", age: 48 * time.Hour},
+		{id: "r3", name: "test", author: "bob 50%", age: 24 * time.Hour},
+		{id: "r4", name: "test", author: "ANN", age: 24 * time.Hour},
+		{id: "r5", name: "test", author: "Ann Lee", age: time.Hour},
+	}
+	for _, r := range runs {
+		if err := repo.CreateJob(Job{RunID: r.id, Repo: "acme/app", Name: r.name, Branch: "main", SHA: r.id, CommitAuthor: r.author}); err != nil {
+			t.Fatalf("CreateJob(%s) error = %v", r.id, err)
+		}
+		if _, err := db.Exec(`UPDATE jobs SET start_at = ? WHERE run_id = ?`, formatStoredTime(now.Add(-r.age)), r.id); err != nil {
+			t.Fatalf("set start_at error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter JobFilter
+		want   string
+	}{
+		{name: "newest first", filter: JobFilter{Repo: "acme/app"}, want: "r5 r4 r3 r2 r1"},
+		{name: "author ignores case", filter: JobFilter{Author: "ann"}, want: "r5 r4 r1"},
+		{name: "author wildcards are literal", filter: JobFilter{Author: "50%"}, want: "r3"},
+		{name: "name", filter: JobFilter{Name: "lint"}, want: "r2"},
+		{name: "date range", filter: JobFilter{Since: now.Add(-48 * time.Hour), Until: now.Add(-2 * time.Hour)}, want: "r4 r3 r2"},
+		{name: "after a tie", filter: JobFilter{After: JobCursor{Start: now.Add(-24 * time.Hour), RunID: "r4"}}, want: "r3 r2 r1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs, err := repo.ListJob(tt.filter)
+			if err != nil {
+				t.Fatalf("ListJob() error = %v", err)
+			}
+			var ids []string
+			for _, j := range jobs {
+				ids = append(ids, j.RunID)
+			}
+			if got := strings.Join(ids, " "); got != tt.want {
+				t.Fatalf("ListJob() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Pages of two cover every job once.
+	var pages []string
+	filter := JobFilter{Repo: "acme/app", Limit: 2}
+	for {
+		jobs, err := repo.ListJob(filter)
+		if err != nil {
+			t.Fatalf("ListJob() error = %v", err)
+		}
+		if len(jobs) == 0 {
+			break
+		}
+		var ids []string
+		for _, j := range jobs {
+			ids = append(ids, j.RunID)
+		}
+		pages = append(pages, strings.Join(ids, " "))
+		filter.After = jobs[len(jobs)-1].Cursor()
+	}
+	if got := strings.Join(pages, " | "); got != "r5 r4 | r3 r2 | r1" {
+		t.Fatalf("pages = %s, want r5 r4 | r3 r2 | r1", got)
+	}
+}
+
 func openTestDB(t *testing.T) *sql.DB {
 	t.Helper()
 
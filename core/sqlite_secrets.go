@@ -0,0 +1,77 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+func (r SQLiteRepo) ensureSecretsTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS secrets (
+		repo TEXT NOT NULL,
+		job TEXT NOT NULL,
+		key TEXT NOT NULL,
+		nonce BLOB NOT NULL,
+		ciphertext BLOB NOT NULL,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (repo, job, key)
+	);`)
+	if err != nil {
+		return fmt.Errorf("ensure secrets table: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) ListSecrets(repo string) ([]Secret, error) {
+	rows, err := r.db.Query(
+		`SELECT repo, job, key, nonce, ciphertext, updated_at FROM secrets
+		 WHERE repo = ? ORDER BY job ASC, key ASC`,
+		repo,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+	defer rows.Close()
+
+	var out []Secret
+	for rows.Next() {
+		var s Secret
+		var updated string
+		if err := rows.Scan(&s.Repo, &s.Job, &s.Key, &s.Nonce, &s.Ciphertext, &updated); err != nil {
+			return nil, fmt.Errorf("scan secret: %w", err)
+		}
+		if s.UpdatedAt, err = parseStoredTime(updated); err != nil {
+			return nil, fmt.Errorf("parse secret updated_at: %w", err)
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate secrets: %w", err)
+	}
+	return out, nil
+}
+
+func (r SQLiteRepo) SetSecret(s Secret) error {
+	updated := s.UpdatedAt
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	_, err := r.db.Exec(
+		`INSERT INTO secrets (repo, job, key, nonce, ciphertext, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(repo, job, key) DO UPDATE SET
+			nonce = excluded.nonce,
+			ciphertext = excluded.ciphertext,
+			updated_at = excluded.updated_at`,
+		s.Repo, s.Job, s.Key, s.Nonce, s.Ciphertext, formatStoredTime(updated),
+	)
+	if err != nil {
+		return fmt.Errorf("set secret: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) DeleteSecret(repo, job, key string) error {
+	if _, err := r.db.Exec(`DELETE FROM secrets WHERE repo = ? AND job = ? AND key = ?`, repo, job, key); err != nil {
+		return fmt.Errorf("delete secret: %w", err)
+	}
+	return nil
+}
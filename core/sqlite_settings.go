@@ -0,0 +1,59 @@
+package core
+
+import "fmt"
+
+func (r SQLiteRepo) ensureRepoSettingsTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS repo_settings (
+		repo TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (repo, key)
+	);`)
+	if err != nil {
+		return fmt.Errorf("ensure repo_settings table: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) RepoSettings(repo string) ([]RepoSetting, error) {
+	rows, err := r.db.Query(
+		`SELECT repo, key, value FROM repo_settings WHERE repo = ? ORDER BY key ASC`,
+		repo,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list repo settings: %w", err)
+	}
+	defer rows.Close()
+
+	var out []RepoSetting
+	for rows.Next() {
+		var s RepoSetting
+		if err := rows.Scan(&s.Repo, &s.Key, &s.Value); err != nil {
+			return nil, fmt.Errorf("scan repo setting: %w", err)
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate repo settings: %w", err)
+	}
+	return out, nil
+}
+
+func (r SQLiteRepo) SetRepoSetting(s RepoSetting) error {
+	_, err := r.db.Exec(
+		`INSERT INTO repo_settings (repo, key, value) VALUES (?, ?, ?)
+		 ON CONFLICT(repo, key) DO UPDATE SET value = excluded.value`,
+		s.Repo, s.Key, s.Value,
+	)
+	if err != nil {
+		return fmt.Errorf("set repo setting: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) DeleteRepoSetting(repo, key string) error {
+	if _, err := r.db.Exec(`DELETE FROM repo_settings WHERE repo = ? AND key = ?`, repo, key); err != nil {
+		return fmt.Errorf("delete repo setting: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+func (r SQLiteRepo) ensureJobStepsTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS job_steps (
+		run_id TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		command TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		start_at TEXT NOT NULL DEFAULT '',
+		end_at TEXT NOT NULL DEFAULT '',
+		log_start INTEGER NOT NULL DEFAULT 0,
+		log_end INTEGER NOT NULL DEFAULT 0,
+		msg TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (run_id, idx)
+	);`)
+	if err != nil {
+		return fmt.Errorf("ensure job_steps table: %w", err)
+	}
+
+	cols, err := r.tableColumns("job_steps")
+	if err != nil {
+		return err
+	}
+	if !cols["command"] {
+		if _, err := r.db.Exec(`ALTER TABLE job_steps ADD COLUMN command TEXT NOT NULL DEFAULT ''`); err != nil {
+			return fmt.Errorf("add job_steps.command: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r SQLiteRepo) CreateJobSteps(runID string, steps []JobStep) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin create job steps: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for i, step := range steps {
+		if _, err = tx.Exec(
+			`INSERT INTO job_steps (run_id, idx, name, command, status) VALUES (?, ?, ?, ?, ?)`,
+			runID, i, step.Name, step.Command, StatusPending,
+		); err != nil {
+			return fmt.Errorf("create job step: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit job steps: %w", err)
+	}
+	return nil
+}
+
+// UpdateJobStep stores the step status, message and log range. start_at is
+// set when the step starts running and end_at when it reaches a final status.
+func (r SQLiteRepo) UpdateJobStep(step JobStep) error {
+	now := formatStoredTime(time.Now().UTC())
+	_, err := r.db.Exec(
+		`UPDATE job_steps
+		 SET status = ?,
+		     msg = ?,
+		     log_start = ?,
+		     log_end = ?,
+		     start_at = CASE
+		                  WHEN ? = ? AND start_at = '' THEN ?
+		                  ELSE start_at
+		                END,
+		     end_at = CASE
+		                WHEN ? IN (?, ?, ?, ?) THEN ?
+		                ELSE end_at
+		              END
+		 WHERE run_id = ? AND idx = ?`,
+		step.Status,
+		step.Msg,
+		step.LogStart,
+		step.LogEnd,
+		step.Status, StatusRunning, now,
+		step.Status, StatusFinished, StatusFailed, StatusCanceled, StatusSkipped, now,
+		step.RunID, step.Index,
+	)
+	if err != nil {
+		return fmt.Errorf("update job step: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) ListJobSteps(runID string) ([]JobStep, error) {
+	rows, err := r.db.Query(
+		`SELECT run_id, idx, name, command, status, start_at, end_at, log_start, log_end, msg
+		 FROM job_steps
+		 WHERE run_id = ?
+		 ORDER BY idx ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list job steps: %w", err)
+	}
+	defer rows.Close()
+
+	var out []JobStep
+	for rows.Next() {
+		step, err := scanJobStep(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, step)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate job steps: %w", err)
+	}
+	return out, nil
+}
+
+func scanJobStep(rows *sql.Rows) (JobStep, error) {
+	var (
+		step    JobStep
+		startAt string
+		endAt   string
+	)
+	if err := rows.Scan(
+		&step.RunID,
+		&step.Index,
+		&step.Name,
+		&step.Command,
+		&step.Status,
+		&startAt,
+		&endAt,
+		&step.LogStart,
+		&step.LogEnd,
+		&step.Msg,
+	); err != nil {
+		return JobStep{}, fmt.Errorf("scan job step: %w", err)
+	}
+
+	var err error
+	if step.Start, err = parseStoredTime(startAt); err != nil {
+		return JobStep{}, fmt.Errorf("parse step start time: %w", err)
+	}
+	if step.End, err = parseStoredTime(endAt); err != nil {
+		return JobStep{}, fmt.Errorf("parse step end time: %w", err)
+	}
+	return step, nil
+}
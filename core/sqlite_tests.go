@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+func (r SQLiteRepo) ensureTestCasesTable() error {
+	_, err := r.db.Exec(`CREATE TABLE IF NOT EXISTS test_cases (
+		run_id TEXT NOT NULL,
+		idx INTEGER NOT NULL,
+		suite TEXT NOT NULL,
+		name TEXT NOT NULL,
+		status TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		failure TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (run_id, idx)
+	);`)
+	if err != nil {
+		return fmt.Errorf("ensure test_cases table: %w", err)
+	}
+	if _, err := r.db.Exec(`CREATE INDEX IF NOT EXISTS idx_test_cases_suite_name ON test_cases(suite, name)`); err != nil {
+		return fmt.Errorf("ensure test_cases index: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) ReplaceTestCases(runID string, cases []TestCase) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin replace test cases: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(`DELETE FROM test_cases WHERE run_id = ?`, runID); err != nil {
+		return fmt.Errorf("delete test cases: %w", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO test_cases (run_id, idx, suite, name, status, duration_ms, failure) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("prepare insert test case: %w", err)
+	}
+	defer stmt.Close()
+	for i, c := range cases {
+		if _, err = stmt.Exec(runID, i, c.Suite, c.Name, c.Status, c.Duration.Milliseconds(), c.Failure); err != nil {
+			return fmt.Errorf("insert test case: %w", err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit replace test cases: %w", err)
+	}
+	return nil
+}
+
+func (r SQLiteRepo) ListTestCases(runID string) ([]TestCase, error) {
+	rows, err := r.db.Query(
+		`SELECT run_id, suite, name, status, duration_ms, failure FROM test_cases
+		 WHERE run_id = ? ORDER BY idx ASC`,
+		runID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list test cases: %w", err)
+	}
+	defer rows.Close()
+
+	var out []TestCase
+	for rows.Next() {
+		var c TestCase
+		var durationMS int64
+		if err := rows.Scan(&c.RunID, &c.Suite, &c.Name, &c.Status, &durationMS, &c.Failure); err != nil {
+			return nil, fmt.Errorf("scan test case: %w", err)
+		}
+		c.Duration = time.Duration(durationMS) * time.Millisecond
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate test cases: %w", err)
+	}
+	return out, nil
+}
+
+func (r SQLiteRepo) TestCaseHistory(repo, job string, runs int) ([]TestCaseRun, error) {
+	rows, err := r.db.Query(
+		`SELECT t.run_id, t.suite, t.name, t.status, t.duration_ms, j.sha, j.branch, j.start_at
+		 FROM test_cases t JOIN jobs j ON j.run_id = t.run_id
+		 WHERE t.run_id IN (
+			SELECT run_id FROM jobs
+			WHERE repo = ? AND name = ? AND run_id IN (SELECT run_id FROM test_cases)
+			ORDER BY start_at DESC LIMIT ?
+		 )
+		 ORDER BY j.start_at DESC, t.idx ASC`,
+		repo, job, runs,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list test case history: %w", err)
+	}
+	defer rows.Close()
+
+	var out []TestCaseRun
+	for rows.Next() {
+		var c TestCaseRun
+		var durationMS int64
+		var startAt string
+		if err := rows.Scan(&c.RunID, &c.Suite, &c.Name, &c.Status, &durationMS, &c.SHA, &c.Branch, &startAt); err != nil {
+			return nil, fmt.Errorf("scan test case history: %w", err)
+		}
+		c.Duration = time.Duration(durationMS) * time.Millisecond
+		if c.Start, err = parseStoredTime(startAt); err != nil {
+			return nil, fmt.Errorf("parse job start: %w", err)
+		}
+		out = append(out, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate test case history: %w", err)
+	}
+	return out, nil
+}
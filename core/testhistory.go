@@ -0,0 +1,88 @@
+package core
+
+import (
+	"sort"
+	"time"
+)
+
+// TestHistoryRuns is how many of a job's recent runs with test reports the
+// test history looks at.
+const TestHistoryRuns = 20
+
+// TestCaseRun is a test result with the run it came from. Failure is not
+// loaded for history.
+type TestCaseRun struct {
+	TestCase
+	SHA    string
+	Branch string
+	Start  time.Time
+}
+
+// TestHistory is the recent results of one test of a job, newest first.
+type TestHistory struct {
+	Suite   string
+	Name    string
+	Results []TestCaseRun
+	Flaky   bool
+}
+
+// LoadTestHistory groups the results of the job's last runs by test, in
+// suite and name order, and marks the flaky ones.
+func LoadTestHistory(dbRepo DbRepo, repo, job string, runs int) ([]TestHistory, error) {
+	results, err := dbRepo.TestCaseHistory(repo, job, runs)
+	if err != nil {
+		return nil, err
+	}
+	index := map[[2]string]int{}
+	var out []TestHistory
+	for _, res := range results {
+		key := [2]string{res.Suite, res.Name}
+		i, ok := index[key]
+		if !ok {
+			i = len(out)
+			index[key] = i
+			out = append(out, TestHistory{Suite: res.Suite, Name: res.Name})
+		}
+		// A test reported twice in one run (e.g. by two reports) counts once.
+		if n := len(out[i].Results); n > 0 && out[i].Results[n-1].RunID == res.RunID {
+			if res.Status == TestFailed {
+				out[i].Results[n-1].Status = TestFailed
+			}
+			continue
+		}
+		out[i].Results = append(out[i].Results, res)
+	}
+	for i := range out {
+		out[i].Flaky = isFlaky(out[i].Results)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Suite != out[j].Suite {
+			return out[i].Suite < out[j].Suite
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// isFlaky reports whether a test both passed and failed in a way a code
+// change does not explain: on the same commit, or flipping between passing
+// and failing more than once (a break and its fix flip once). Skips are
+// ignored.
+func isFlaky(results []TestCaseRun) bool {
+	bySHA := map[string]string{}
+	flips, last := 0, ""
+	for _, res := range results {
+		if res.Status != TestPassed && res.Status != TestFailed {
+			continue
+		}
+		if prev, ok := bySHA[res.SHA]; ok && prev != res.Status {
+			return true
+		}
+		bySHA[res.SHA] = res.Status
+		if last != "" && last != res.Status {
+			flips++
+		}
+		last = res.Status
+	}
+	return flips >= 2
+}
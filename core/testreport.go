@@ -0,0 +1,332 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Test case statuses.
+const (
+	TestPassed  = "passed"
+	TestFailed  = "failed"
+	TestSkipped = "skipped"
+)
+
+// testFailureMaxBytes caps the failure text kept for one test case.
+const testFailureMaxBytes = 8 << 10
+
+// TestCase is one test result read from a job's reports.
+type TestCase struct {
+	RunID    string
+	Suite    string // JUnit class name or suite, or the Go package
+	Name     string
+	Status   string // TestPassed, TestFailed or TestSkipped
+	Duration time.Duration
+	Failure  string // message and output of a failed test
+}
+
+// TestSummary counts the test cases of a run.
+type TestSummary struct {
+	Passed   int
+	Failed   int
+	Skipped  int
+	Duration time.Duration
+}
+
+func SummarizeTests(cases []TestCase) TestSummary {
+	var s TestSummary
+	for _, c := range cases {
+		switch c.Status {
+		case TestPassed:
+			s.Passed++
+		case TestFailed:
+			s.Failed++
+		case TestSkipped:
+			s.Skipped++
+		}
+		s.Duration += c.Duration
+	}
+	return s
+}
+
+func (s TestSummary) Total() int {
+	return s.Passed + s.Failed + s.Skipped
+}
+
+func (s TestSummary) String() string {
+	return fmt.Sprintf("%d tests: %d passed, %d failed, %d skipped in %s",
+		s.Total(), s.Passed, s.Failed, s.Skipped, s.Duration.Round(time.Millisecond))
+}
+
+// cleanReportPattern checks a reports: glob: relative to the repo, inside
+// it, and without negation.
+func cleanReportPattern(p string) (string, error) {
+	v := strings.TrimSpace(p)
+	if strings.HasPrefix(v, "!") {
+		return "", fmt.Errorf("report pattern %q cannot be negated", p)
+	}
+	if v == "" || path.IsAbs(v) || filepath.IsAbs(v) {
+		return "", fmt.Errorf("report pattern %q must be relative to the repo", p)
+	}
+	v = path.Clean(filepath.ToSlash(v))
+	if v == ".." || strings.HasPrefix(v, "../") {
+		return "", fmt.Errorf("report pattern %q must stay inside the repo", p)
+	}
+	return v, nil
+}
+
+// FindTestReports lists the files under workDir matching the reports:
+// globs that were modified since the given time, in walk order. Only the
+// directories below the fixed prefix of each glob are walked, and .git is
+// skipped.
+func FindTestReports(workDir string, patterns []string, since time.Time) ([]string, error) {
+	// Some filesystems keep modification times to the second.
+	since = since.Truncate(time.Second)
+	seen := map[string]bool{}
+	var out []string
+	for _, raw := range patterns {
+		pattern, err := cleanReportPattern(raw)
+		if err != nil {
+			return nil, err
+		}
+		parts := splitPathParts(pattern)
+		fixed := 0
+		for fixed < len(parts)-1 && !strings.ContainsAny(parts[fixed], `*?[\`) {
+			fixed++
+		}
+		root := filepath.Join(workDir, filepath.FromSlash(strings.Join(parts[:fixed], "/")))
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel, err := filepath.Rel(workDir, p)
+			if err != nil {
+				return err
+			}
+			if seen[p] || !d.Type().IsRegular() || !matchPathPattern(pattern, filepath.ToSlash(rel)) {
+				return nil
+			}
+			if info, err := d.Info(); err != nil || info.ModTime().Before(since) {
+				return nil
+			}
+			seen[p] = true
+			out = append(out, p)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("find reports %s: %w", raw, err)
+		}
+	}
+	return out, nil
+}
+
+// ParseTestReportFile reads a JUnit XML report or go test -json output; a
+// file starting with '<' is XML.
+func ParseTestReportFile(p string) ([]TestCase, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = r.ReadByte()
+		case '<':
+			return parseJUnit(r)
+		default:
+			return parseGoTestJSON(r)
+		}
+	}
+}
+
+type junitSuite struct {
+	Name   string       `xml:"name,attr"`
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []junitCase  `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string         `xml:"name,attr"`
+	ClassName string         `xml:"classname,attr"`
+	Time      string         `xml:"time,attr"`
+	Failures  []junitFailure `xml:"failure"`
+	Errors    []junitFailure `xml:"error"`
+	Skipped   *struct{}      `xml:"skipped"`
+	SystemOut string         `xml:"system-out"`
+	SystemErr string         `xml:"system-err"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// parseJUnit reads a <testsuites> or <testsuite> document. A case belongs
+// to its class name, or to the innermost suite without one.
+func parseJUnit(r io.Reader) ([]TestCase, error) {
+	var root struct {
+		XMLName xml.Name
+		junitSuite
+	}
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return nil, fmt.Errorf("parse JUnit XML: %w", err)
+	}
+	if root.XMLName.Local != "testsuites" && root.XMLName.Local != "testsuite" {
+		return nil, fmt.Errorf("parse JUnit XML: unexpected root element <%s>", root.XMLName.Local)
+	}
+
+	var out []TestCase
+	var walk func(s junitSuite)
+	walk = func(s junitSuite) {
+		for _, c := range s.Cases {
+			tc := TestCase{Suite: strings.TrimSpace(c.ClassName), Name: strings.TrimSpace(c.Name), Status: TestPassed}
+			if tc.Suite == "" {
+				tc.Suite = strings.TrimSpace(s.Name)
+			}
+			if secs, err := strconv.ParseFloat(strings.TrimSpace(c.Time), 64); err == nil {
+				tc.Duration = time.Duration(secs * float64(time.Second))
+			}
+			switch {
+			case len(c.Failures) > 0 || len(c.Errors) > 0:
+				tc.Status = TestFailed
+				var parts []string
+				for _, f := range append(c.Failures, c.Errors...) {
+					parts = append(parts, strings.TrimSpace(f.Message), strings.TrimSpace(f.Text))
+				}
+				parts = append(parts, strings.TrimSpace(c.SystemOut), strings.TrimSpace(c.SystemErr))
+				tc.Failure = joinFailure(parts)
+			case c.Skipped != nil:
+				tc.Status = TestSkipped
+			}
+			out = append(out, tc)
+		}
+		for _, child := range s.Suites {
+			walk(child)
+		}
+	}
+	walk(root.junitSuite)
+	return out, nil
+}
+
+// goTestEvent is one line of go test -json (test2json) output.
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+var goTestStatus = map[string]string{"pass": TestPassed, "fail": TestFailed, "skip": TestSkipped}
+
+// parseGoTestJSON reads go test -json output. Lines that are not events
+// (build output mixed in) are skipped, but a file without any is not a
+// report. A test's failure text is its output; a test that started but
+// never ended (a panic or a timeout) fails.
+func parseGoTestJSON(r io.Reader) ([]TestCase, error) {
+	type key struct{ pkg, test string }
+	var (
+		order  []key
+		cases  = map[key]*TestCase{}
+		output = map[key]*bytes.Buffer{}
+		events int
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if !bytes.HasPrefix(line, []byte("{")) {
+			continue
+		}
+		var ev goTestEvent
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return nil, fmt.Errorf("parse go test -json line %d: %w", n, err)
+		}
+		events++
+		if ev.Test == "" {
+			continue
+		}
+		k := key{ev.Package, ev.Test}
+		tc, ok := cases[k]
+		if !ok {
+			tc = &TestCase{Suite: ev.Package, Name: ev.Test}
+			cases[k], output[k] = tc, &bytes.Buffer{}
+			order = append(order, k)
+		}
+		switch ev.Action {
+		case "output":
+			if buf := output[k]; buf.Len() < testFailureMaxBytes {
+				buf.WriteString(ev.Output)
+			}
+		case "pass", "fail", "skip":
+			tc.Status = goTestStatus[ev.Action]
+			tc.Duration = time.Duration(ev.Elapsed * float64(time.Second))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("parse go test -json: %w", err)
+	}
+	if events == 0 {
+		return nil, errors.New("not a JUnit XML or go test -json report")
+	}
+
+	out := make([]TestCase, 0, len(order))
+	for _, k := range order {
+		tc := cases[k]
+		if tc.Status == "" {
+			tc.Status = TestFailed
+		}
+		if tc.Status == TestFailed {
+			tc.Failure = joinFailure([]string{output[k].String()})
+		}
+		out = append(out, *tc)
+	}
+	return out, nil
+}
+
+// joinFailure joins the non-empty parts of a failure text, capped at
+// testFailureMaxBytes.
+func joinFailure(parts []string) string {
+	var kept []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" && !containsString(kept, p) {
+			kept = append(kept, p)
+		}
+	}
+	s := strings.Join(kept, "\n")
+	if len(s) > testFailureMaxBytes {
+		s = strings.ToValidUTF8(s[:testFailureMaxBytes], "") + "\n..."
+	}
+	return s
+}
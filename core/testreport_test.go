@@ -0,0 +1,257 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTestReportFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    []TestCase
+		wantErr string
+	}{
+		{
+			name: "junit",
+			content: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="api">
+    <testcase classname="api.UserTest" name="create" time="0.25"/>
+    <testcase classname="api.UserTest" name="delete" time="1.5">
+      <failure message="expected 204">got 500</failure>
+      <system-out>request log</system-out>
+    </testcase>
+    <testsuite name="nested">
+      <testcase name="slow"><skipped/></testcase>
+      <testcase name="boom"><error message="panic"/></testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>
+`,
+			want: []TestCase{
+				{Suite: "api.UserTest", Name: "create", Status: TestPassed, Duration: 250 * time.Millisecond},
+				{Suite: "api.UserTest", Name: "delete", Status: TestFailed, Duration: 1500 * time.Millisecond, Failure: "expected 204\ngot 500\nrequest log"},
+				{Suite: "nested", Name: "slow", Status: TestSkipped},
+				{Suite: "nested", Name: "boom", Status: TestFailed, Failure: "panic"},
+			},
+		},
+		{
+			name: "go test -json",
+			content: `go: downloading example.com/dep v1.0.0
+{"Action":"run","Package":"acme/app","Test":"TestOK"}
+{"Action":"output","Package":"acme/app","Test":"TestOK","Output":"=== RUN   TestOK\n"}
+{"Action":"pass","Package":"acme/app","Test":"TestOK","Elapsed":0.01}
+{"Action":"run","Package":"acme/app","Test":"TestBad"}
+{"Action":"output","Package":"acme/app","Test":"TestBad","Output":"    app_test.go:9: want 2, got 3\n"}
+{"Action":"fail","Package":"acme/app","Test":"TestBad","Elapsed":0.5}
+{"Action":"skip","Package":"acme/app","Test":"TestSkip","Elapsed":0}
+{"Action":"run","Package":"acme/app","Test":"TestHang"}
+{"Action":"output","Package":"acme/app","Test":"TestHang","Output":"panic: test timed out\n"}
+{"Action":"fail","Package":"acme/app","Elapsed":30}
+`,
+			want: []TestCase{
+				{Suite: "acme/app", Name: "TestOK", Status: TestPassed, Duration: 10 * time.Millisecond},
+				{Suite: "acme/app", Name: "TestBad", Status: TestFailed, Duration: 500 * time.Millisecond, Failure: "app_test.go:9: want 2, got 3"},
+				{Suite: "acme/app", Name: "TestSkip", Status: TestSkipped},
+				{Suite: "acme/app", Name: "TestHang", Status: TestFailed, Failure: "panic: test timed out"},
+			},
+		},
+		{
+			name:    "not a report",
+			content: "PASS\nok  acme/app 0.1s\n",
+			wantErr: "not a JUnit XML or go test -json report",
+		},
+		{
+			name:    "other xml",
+			content: "<project/>",
+			wantErr: "unexpected root element <project>",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(dir, strings.Repeat("r", i+1))
+			writeTestFile(t, p, tt.content)
+			got, err := ParseTestReportFile(p)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseTestReportFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTestReportFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ParseTestReportFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindTestReports(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"out/junit.xml", "svc/a/target/reports/TEST-a.xml", "svc/b/target/reports/TEST-b.xml", "svc/b/target/reports/notes.txt", ".git/junit.xml", "stale/junit.xml"} {
+		writeTestFile(t, filepath.Join(dir, p), "<testsuite/>")
+	}
+	start := time.Now()
+	old := start.Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "stale/junit.xml"), old, old); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	got, err := FindTestReports(dir, []string{"out/junit.xml", "svc/**/TEST-*.xml", "**/junit.xml", "missing/*.xml"}, start)
+	if err != nil {
+		t.Fatalf("FindTestReports() error = %v", err)
+	}
+	var rel []string
+	for _, p := range got {
+		r, _ := filepath.Rel(dir, p)
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	want := []string{"out/junit.xml", "svc/a/target/reports/TEST-a.xml", "svc/b/target/reports/TEST-b.xml"}
+	if !reflect.DeepEqual(rel, want) {
+		t.Fatalf("FindTestReports() = %v, want %v", rel, want)
+	}
+
+	if _, err := FindTestReports(dir, []string{"../junit.xml"}, start); err == nil {
+		t.Fatalf("FindTestReports(../junit.xml) error = nil, want error")
+	}
+}
+
+func TestLoadTestHistory(t *testing.T) {
+	db := openTestDB(t)
+	repo, err := NewSQLiteRepo(db)
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Oldest run first. "flip" breaks, is fixed and breaks again across
+	// commits; "retry" fails and passes on the same commit; "broken" breaks
+	// once and stays broken.
+	runs := []struct {
+		id     string
+		sha    string
+		job    string
+		status map[string]string
+	}{
+		{id: "r1", sha: "a", job: "test", status: map[string]string{"flip": TestPassed, "retry": TestPassed, "broken": TestPassed}},
+		{id: "r2", sha: "b", job: "test", status: map[string]string{"flip": TestFailed, "retry": TestFailed, "broken": TestFailed}},
+		{id: "r3", sha: "b", job: "test", status: map[string]string{"flip": TestFailed, "retry": TestPassed, "broken": TestFailed}},
+		{id: "r4", sha: "c", job: "test", status: map[string]string{"flip": TestPassed, "retry": TestSkipped, "broken": TestFailed}},
+		{id: "r5", sha: "d", job: "test", status: map[string]string{"flip": TestFailed, "broken": TestFailed}},
+		{id: "lint", sha: "d", job: "lint", status: map[string]string{"flip": TestPassed}},
+	}
+	for i, r := range runs {
+		if err := repo.CreateJob(Job{RunID: r.id, Repo: "acme/app", Name: r.job, Branch: "main", SHA: r.sha}); err != nil {
+			t.Fatalf("CreateJob(%s) error = %v", r.id, err)
+		}
+		if _, err := db.Exec(`UPDATE jobs SET start_at = ? WHERE run_id = ?`, formatStoredTime(now.Add(time.Duration(i)*time.Minute)), r.id); err != nil {
+			t.Fatalf("set start_at error = %v", err)
+		}
+		var cases []TestCase
+		for _, name := range []string{"broken", "flip", "retry"} {
+			if status, ok := r.status[name]; ok {
+				cases = append(cases, TestCase{Suite: "acme/app", Name: name, Status: status})
+			}
+		}
+		if err := repo.ReplaceTestCases(r.id, cases); err != nil {
+			t.Fatalf("ReplaceTestCases(%s) error = %v", r.id, err)
+		}
+	}
+
+	history, err := LoadTestHistory(repo, "acme/app", "test", 4)
+	if err != nil {
+		t.Fatalf("LoadTestHistory() error = %v", err)
+	}
+	type result struct {
+		name  string
+		runs  string
+		flaky bool
+	}
+	var got []result
+	for _, h := range history {
+		var ids []string
+		for _, r := range h.Results {
+			ids = append(ids, r.RunID+":"+r.Status)
+		}
+		got = append(got, result{name: h.Name, runs: strings.Join(ids, " "), flaky: h.Flaky})
+	}
+	want := []result{
+		{name: "broken", runs: "r5:failed r4:failed r3:failed r2:failed"},
+		{name: "flip", runs: "r5:failed r4:passed r3:failed r2:failed", flaky: true},
+		{name: "retry", runs: "r4:skipped r3:passed r2:failed", flaky: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("LoadTestHistory() = %+v, want %+v", got, want)
+	}
+
+	if err := repo.DeleteJob("r5"); err != nil {
+		t.Fatalf("DeleteJob() error = %v", err)
+	}
+	if cases, err := repo.ListTestCases("r5"); err != nil || len(cases) != 0 {
+		t.Fatalf("ListTestCases(r5) after delete = %+v, %v; want none", cases, err)
+	}
+}
+
+func TestJobRunnerCollectsTestReports(t *testing.T) {
+	oldRoot := Root
+	Root = t.TempDir()
+	defer func() {
+		Root = oldRoot
+	}()
+
+	src := newTestGitRepo(t)
+	sha := src.commit(t, "README.md", "hello\n", "init")
+	src.mirror(t, "acme/app")
+
+	repo, err := NewSQLiteRepo(openTestDB(t))
+	if err != nil {
+		t.Fatalf("NewSQLiteRepo() error = %v", err)
+	}
+	runner := NewJobRunner(repo)
+	jc := JobConf{
+		Repo:    "acme/app",
+		Name:    "test",
+		Run:     `mkdir -p out && echo '<testsuite name="s"><testcase name="a"/><testcase name="b"><failure message="bad"/></testcase></testsuite>' > out/junit.xml && echo garbage > out/notes.xml && exit 1`,
+		Reports: []string{"out/*.xml"},
+	}
+	if err := runner.QueueJob(jc, nil, "main", sha, "test"); err != nil {
+		t.Fatalf("QueueJob() error = %v", err)
+	}
+	job, err := repo.LatestJobByNameBranch("acme/app", "test", "main")
+	if err != nil {
+		t.Fatalf("LatestJobByNameBranch() error = %v", err)
+	}
+	job = waitJobDone(t, repo, job.RunID)
+	if job.Status != StatusFailed {
+		t.Fatalf("job status = %q (%s), want failed", job.Status, job.Msg)
+	}
+
+	cases, err := repo.ListTestCases(job.RunID)
+	if err != nil {
+		t.Fatalf("ListTestCases() error = %v", err)
+	}
+	want := []TestCase{
+		{RunID: job.RunID, Suite: "s", Name: "a", Status: TestPassed},
+		{RunID: job.RunID, Suite: "s", Name: "b", Status: TestFailed, Failure: "bad"},
+	}
+	if !reflect.DeepEqual(cases, want) {
+		t.Fatalf("ListTestCases() = %+v, want %+v", cases, want)
+	}
+	logData, err := os.ReadFile(job.LogPath)
+	if err != nil {
+		t.Fatalf("ReadFile(log) error = %v", err)
+	}
+	for _, want := range []string{"refci: report out/notes.xml: ", "==> reports: 2 tests: 1 passed, 1 failed, 0 skipped in 0s from 2 files"} {
+		if !strings.Contains(string(logData), want) {
+			t.Fatalf("log = %q, want it to contain %q", logData, want)
+		}
+	}
+}
@@ -1,9 +1,61 @@
 package core
 
+import (
+	"path/filepath"
+	"strings"
+)
+
 type JobConf struct {
-	Repo          string   `yaml:"-"`
-	Name          string   `yaml:"-"`
-	BranchPattern string   `yaml:"branch_pattern"`
-	PathPatterns  []string `yaml:"path_patterns"`
-	ScriptPath    string   `yaml:"script"`
+	Repo          string      `yaml:"-"`
+	Name          string      `yaml:"-"`
+	BranchPattern string      `yaml:"branch_pattern"`
+	PathPatterns  []string    `yaml:"path_patterns"`
+	PathsIgnore   []string    `yaml:"paths_ignore"`
+	ScriptPath    string      `yaml:"script"`
+	Run           string      `yaml:"run"`
+	Shell         string      `yaml:"shell"`
+	Steps         []StepConf  `yaml:"steps"`
+	PullRequests  bool        `yaml:"pull_requests"`
+	If            string      `yaml:"if"`
+	Cache         *CacheConf  `yaml:"cache"`
+	Limits        *LimitsConf `yaml:"limits"`
+	Reports       []string    `yaml:"reports"` // globs of JUnit XML or go test -json files the run writes
+}
+
+// StepConf is one named step of a job. Exactly one of Script (a path in the
+// repo) or Run (inline commands) is set. Shell overrides the job's shell.
+type StepConf struct {
+	Name   string `yaml:"name"`
+	Script string `yaml:"script"`
+	Run    string `yaml:"run"`
+	Shell  string `yaml:"shell"`
+}
+
+// ScansBranches reports whether the job runs on branch heads. A pull request
+// job without a branch_pattern only runs on pull requests.
+func (jc JobConf) ScansBranches() bool {
+	return !jc.PullRequests || strings.TrimSpace(jc.BranchPattern) != ""
+}
+
+// StepList returns the steps the job runs with the job shell applied. A job
+// with only a script or run block runs it as a single step.
+func (jc JobConf) StepList() []StepConf {
+	var steps []StepConf
+	switch {
+	case len(jc.Steps) > 0:
+		steps = append(steps, jc.Steps...)
+	case strings.TrimSpace(jc.Run) != "":
+		steps = []StepConf{{Name: "run", Run: jc.Run}}
+	case strings.TrimSpace(jc.ScriptPath) != "":
+		script := strings.TrimSpace(jc.ScriptPath)
+		steps = []StepConf{{Name: filepath.Base(script), Script: script}}
+	default:
+		return nil
+	}
+	for i := range steps {
+		if strings.TrimSpace(steps[i].Shell) == "" {
+			steps[i].Shell = jc.Shell
+		}
+	}
+	return steps
 }
@@ -1,10 +1,12 @@
 package core
 
 import (
-	neturl "net/url"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var Root, _ = os.Getwd()
@@ -17,51 +19,24 @@ func ToLocalRepo(repo string) string {
 	return strings.ReplaceAll(repo, "/", "--")
 }
 
-func ParseGithubUrl(rawURL string) string {
-	raw := strings.TrimSpace(rawURL)
-	if raw == "" {
-		return ""
-	}
-
-	// Direct owner/repo form.
-	if !strings.Contains(raw, "://") && !strings.Contains(raw, "@") {
-		return normalizeGithubRepoPath(raw)
-	}
-
-	// SCP-like SSH form: git@github.com:owner/repo(.git)
-	if strings.HasPrefix(strings.ToLower(raw), "git@github.com:") {
-		return normalizeGithubRepoPath(raw[len("git@github.com:"):])
-	}
-
-	u, err := neturl.Parse(raw)
-	if err != nil {
-		return ""
-	}
-
-	host := strings.ToLower(u.Hostname())
-	if host != "github.com" {
-		return ""
+// ParseSince reads a point in time given as a duration back from now, with
+// d for days, or as a local date or time.
+func ParseSince(v string, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
 	}
-
-	return normalizeGithubRepoPath(u.Path)
-}
-
-func normalizeGithubRepoPath(path string) string {
-	p := strings.TrimSpace(path)
-	p = strings.TrimPrefix(p, "/")
-	p = strings.TrimSuffix(p, "/")
-	p = strings.TrimSuffix(p, ".git")
-
-	parts := strings.Split(p, "/")
-	if len(parts) < 2 {
-		return ""
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(-d), nil
 	}
-	owner := strings.TrimSpace(parts[0])
-	repo := strings.TrimSpace(parts[1])
-	if owner == "" || repo == "" {
-		return ""
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
 	}
-	return owner + "/" + repo
+	return time.Time{}, fmt.Errorf("invalid time %q (want a duration like 36h or 7d, or a date like 2006-01-02)", v)
 }
 
 func SafeIdx[T any](idx int, slice []T) (ret T) {
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"36h", now.Add(-36 * time.Hour)},
+		{"7d", time.Date(2026, 3, 3, 12, 0, 0, 0, time.Local)},
+		{"2026-01-02", time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local)},
+		{"2026-01-02 15:04", time.Date(2026, 1, 2, 15, 4, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseSince(tt.in, now)
+		if err != nil {
+			t.Fatalf("ParseSince(%q) error = %v", tt.in, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Fatalf("ParseSince(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"", "yesterday", "-3d", "-1h"} {
+		if _, err := ParseSince(in, now); err == nil {
+			t.Fatalf("ParseSince(%q) error = nil, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,772 @@
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"dexianta/refci/core"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+const (
+	// logLineMaxBytes caps how much of one line is read for display.
+	logLineMaxBytes = 16 << 10
+	// logSearchMaxMatches caps the lines remembered for one search.
+	logSearchMaxMatches = 100000
+	// logSearchLineMaxBytes caps how much of one line a stderr-only search
+	// reads.
+	logSearchLineMaxBytes = 1 << 20
+	logViewerMinHeight    = 5
+)
+
+var (
+	logMatchStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("16")).Background(lipgloss.Color("179"))
+	logCurrentStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("16")).Background(lipgloss.Color("214")).Bold(true)
+	logStderrStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+)
+
+// logViewer shows a byte range of a log file that may still be growing.
+// Only the offsets of line starts are kept in memory; new bytes are indexed
+// incrementally from the last line and the visible lines are read on demand,
+// so multi-hundred-MB logs stay cheap to scroll and follow.
+//
+// With stderrOnly the lines come from the log's line index instead: only
+// the stderr lines, each with its own end since they are not contiguous.
+type logViewer struct {
+	path  string
+	start int64 // first byte of the shown range
+	limit int64 // end of the shown range, -1 to follow the file
+
+	lines    []int64 // start offset of every line; the last one may be partial
+	end      int64   // bytes before end are indexed
+	scanning bool    // a scan is in flight
+	err      error
+
+	stderrOnly bool
+	ends       []int64 // with stderrOnly, the end of each line; -1 while it may grow
+	nextLine   int64   // with stderrOnly, the first line index entry not read yet
+
+	height    int
+	top       int  // first visible line
+	follow    bool // keep the last line in view as the log grows
+	window    []string
+	streams   []core.LogStream // of each window line, when the log is indexed
+	showTimes bool
+	times     []string // relative time of each window line, when shown
+	noIndex   bool     // the log has no line index: no streams or times
+
+	stderrUnavailable bool // stderrOnly was asked for but the log has no index
+
+	inputActive bool
+	input       string
+	query       string
+	matches     []int // line numbers containing query, ascending
+	matchIdx    int
+	jumpLine    int // line to show once scanned, -1 for none
+}
+
+// logScanMsg carries the line starts (and search matches) found from the
+// start of the viewer's last line to the end of the readable range.
+type logScanMsg struct {
+	path     string
+	from     int64 // offset, or line index entry with stderrOnly
+	baseLine int
+	starts   []int64
+	to       int64
+	query    string
+	matches  []int
+	reset    bool // the file shrank below the indexed range
+	err      error
+
+	stderrOnly bool
+	ends       []int64
+	next       int64
+}
+
+// logSearchMsg carries the matches of a new search over [start, to).
+type logSearchMsg struct {
+	path    string
+	query   string
+	matches []int
+	err     error
+}
+
+func newLogViewer(path string, start, limit int64) logViewer {
+	return logViewer{
+		path:     path,
+		start:    start,
+		limit:    limit,
+		lines:    []int64{start},
+		end:      start,
+		follow:   true,
+		height:   logViewerMinHeight,
+		jumpLine: -1,
+	}
+}
+
+// jumpTo searches for query and shows line, counted from the start of the
+// range, once the first scan has read it. Call it before the first scanCmd.
+func (v *logViewer) jumpTo(line int, query string) {
+	v.query, v.jumpLine, v.follow = query, line, false
+}
+
+// scanCmd indexes what was appended since the last scan; nil while a scan
+// is still running.
+func (v *logViewer) scanCmd() tea.Cmd {
+	if v.path == "" || v.scanning {
+		return nil
+	}
+	v.scanning = true
+	if v.stderrOnly {
+		return v.scanStderrCmd()
+	}
+	path, from, baseLine, limit, query := v.path, v.lines[len(v.lines)-1], len(v.lines)-1, v.limit, v.query
+	return func() tea.Msg {
+		msg := logScanMsg{path: path, from: from, baseLine: baseLine, query: query}
+		msg.starts, msg.matches, msg.to, msg.reset, msg.err = scanLogRange(path, from, baseLine, limit, query)
+		return msg
+	}
+}
+
+// scanStderrCmd reads the line index entries added since the last scan.
+// The last stderr line stays open until the next entry shows where it ends,
+// so it is scanned (and searched) again.
+func (v *logViewer) scanStderrCmd() tea.Cmd {
+	baseLine, open := len(v.lines), int64(-1)
+	if n := len(v.ends); n > 0 && v.ends[n-1] < 0 {
+		baseLine, open = n-1, v.lines[n-1]
+	}
+	path, from, start, limit, query := v.path, v.nextLine, v.start, v.limit, v.query
+	return func() tea.Msg {
+		msg := logScanMsg{path: path, from: from, baseLine: baseLine, query: query, stderrOnly: true}
+		msg.starts, msg.ends, msg.next, msg.to, msg.err = scanStderrLines(path, from, open, start, limit)
+		if msg.err == nil && query != "" {
+			msg.matches, msg.err = searchLines(path, msg.starts, msg.ends, msg.to, baseLine, query)
+		}
+		return msg
+	}
+}
+
+func (v *logViewer) searchCmd() tea.Cmd {
+	path, start, end, query := v.path, v.start, v.end, v.query
+	if v.stderrOnly {
+		starts, ends := slices.Clone(v.lines), slices.Clone(v.ends)
+		return func() tea.Msg {
+			matches, err := searchLines(path, starts, ends, end, 0, query)
+			return logSearchMsg{path: path, query: query, matches: matches, err: err}
+		}
+	}
+	return func() tea.Msg {
+		_, matches, _, _, err := scanLogRange(path, start, 0, end, query)
+		return logSearchMsg{path: path, query: query, matches: matches, err: err}
+	}
+}
+
+// scanStderrLines reads the line index of path from entry from on and
+// returns the stderr lines that start in [start, limit), with their ends
+// (-1 when the line may still grow). open is the start of a line left open
+// by the previous scan, or -1; it is returned again as the first line.
+func scanStderrLines(path string, from, open, start, limit int64) (starts, ends []int64, next, to int64, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, from, 0, err
+	}
+	to = info.Size()
+	if limit >= 0 && limit < to {
+		to = limit
+	}
+	index, err := core.OpenLineIndex(path)
+	if err != nil {
+		return nil, nil, from, 0, err
+	}
+	defer index.Close()
+
+	if open >= 0 {
+		starts, ends = append(starts, open), append(ends, -1)
+	}
+	const batch = 4096
+	for next = from; next < index.Len(); {
+		lines, err := index.Lines(next, batch)
+		if err != nil {
+			return nil, nil, from, 0, err
+		}
+		for _, line := range lines {
+			if n := len(ends); n > 0 && ends[n-1] < 0 {
+				ends[n-1] = min(line.Offset, to)
+			}
+			if line.Stream == core.StreamStderr && line.Offset >= start && line.Offset < to {
+				starts, ends = append(starts, line.Offset), append(ends, -1)
+			}
+		}
+		next += int64(len(lines))
+	}
+	return starts, ends, next, to, nil
+}
+
+// searchLines returns the numbers of the lines [starts[i], ends[i]) that
+// contain query, counted from baseLine; an end of -1 means to.
+func searchLines(path string, starts, ends []int64, to int64, baseLine int, query string) ([]int, error) {
+	match := visibleMatcher(queryMatcher(query))
+	if match == nil || len(starts) == 0 {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var matches []int
+	for i, start := range starts {
+		end := ends[i]
+		if end < 0 {
+			end = to
+		}
+		buf := make([]byte, min(end-start, logSearchLineMaxBytes))
+		n, err := f.ReadAt(buf, start)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if match(buf[:n]) && len(matches) < logSearchMaxMatches {
+			matches = append(matches, baseLine+i)
+		}
+	}
+	return matches, nil
+}
+
+// scanLogRange reads path from from (the start of line baseLine) up to limit
+// or EOF. It returns the starts of the lines that follow and the lines that
+// contain query.
+func scanLogRange(path string, from int64, baseLine int, limit int64, query string) (starts []int64, matches []int, to int64, reset bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, from, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, from, false, err
+	}
+	to = info.Size()
+	if limit >= 0 && limit < to {
+		to = limit
+	}
+	if to < from {
+		return nil, nil, from, true, nil
+	}
+
+	match := visibleMatcher(queryMatcher(query))
+	r := bufio.NewReaderSize(io.NewSectionReader(f, from, to-from), 64<<10)
+	pos, line, lineMatched := from, baseLine, false
+	for {
+		chunk, readErr := r.ReadSlice('\n')
+		if len(chunk) > 0 {
+			if match != nil && !lineMatched && match(chunk) && len(matches) < logSearchMaxMatches {
+				matches = append(matches, line)
+				lineMatched = true
+			}
+			pos += int64(len(chunk))
+			if chunk[len(chunk)-1] == '\n' {
+				line++
+				starts = append(starts, pos)
+				lineMatched = false
+			}
+		}
+		if readErr == bufio.ErrBufferFull {
+			continue
+		}
+		if readErr != nil && readErr != io.EOF {
+			return nil, nil, from, false, readErr
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	return starts, matches, pos, false, nil
+}
+
+// queryMatcher matches query as a substring, ignoring case unless the query
+// has upper-case letters.
+func queryMatcher(query string) func([]byte) bool {
+	if query == "" {
+		return nil
+	}
+	if hasUpper(query) {
+		q := []byte(query)
+		return func(b []byte) bool { return bytes.Contains(b, q) }
+	}
+	q := []byte(strings.ToLower(query))
+	return func(b []byte) bool { return bytes.Contains(bytes.ToLower(b), q) }
+}
+
+// visibleMatcher applies match to the text of a line without its escape
+// sequences, so a search finds what the viewer shows.
+func visibleMatcher(match func([]byte) bool) func([]byte) bool {
+	if match == nil {
+		return nil
+	}
+	return func(b []byte) bool {
+		if bytes.IndexByte(b, 0x1b) >= 0 {
+			b = []byte(ansi.Strip(string(b)))
+		}
+		return match(b)
+	}
+}
+
+func hasUpper(s string) bool {
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyScan merges a scan result; stale results (another file, or a scan
+// that started from an older last line) are dropped.
+func (v *logViewer) applyScan(msg logScanMsg) tea.Cmd {
+	if msg.path != v.path || msg.stderrOnly != v.stderrOnly {
+		return nil
+	}
+	if v.stderrOnly && msg.from != v.nextLine || !v.stderrOnly && msg.from != v.lines[len(v.lines)-1] {
+		return nil
+	}
+	v.scanning = false
+	if msg.err != nil {
+		if v.stderrOnly && os.IsNotExist(msg.err) {
+			// Nothing to filter by; show the whole log again.
+			v.noIndex = true
+			*v = v.restarted()
+			v.stderrUnavailable = true
+			return v.scanCmd()
+		}
+		v.err = msg.err
+		return nil
+	}
+	v.err = nil
+	if msg.reset {
+		*v = v.restarted()
+		return nil
+	}
+	if v.stderrOnly {
+		v.lines = append(v.lines[:msg.baseLine], msg.starts...)
+		v.ends = append(v.ends[:msg.baseLine], msg.ends...)
+		v.nextLine = msg.next
+	} else {
+		v.lines = append(v.lines, msg.starts...)
+	}
+	v.end = msg.to
+	if msg.query == v.query && v.query != "" {
+		keep := len(v.matches)
+		for keep > 0 && v.matches[keep-1] >= msg.baseLine {
+			keep--
+		}
+		v.matches = append(v.matches[:keep], msg.matches...)
+	}
+	if v.jumpLine >= 0 {
+		if i := slices.Index(v.matches, v.jumpLine); i >= 0 {
+			v.matchIdx = i
+		}
+		v.top = min(max(v.jumpLine-v.height/3, 0), v.maxTop())
+		v.jumpLine = -1
+	}
+	if v.follow {
+		v.top = v.maxTop()
+	}
+	v.loadWindow()
+	return nil
+}
+
+func (v *logViewer) applySearch(msg logSearchMsg) {
+	if msg.path != v.path || msg.query != v.query {
+		return
+	}
+	if msg.err != nil {
+		v.err = msg.err
+		return
+	}
+	v.matches = msg.matches
+	v.matchIdx = 0
+	v.jumpToMatch(0)
+}
+
+// restarted returns an empty viewer over the same range, keeping the view
+// settings.
+func (v logViewer) restarted() logViewer {
+	n := newLogViewer(v.path, v.start, v.limit)
+	n.height, n.follow, n.query, n.showTimes, n.noIndex = v.height, v.follow, v.query, v.showTimes, v.noIndex
+	if v.stderrOnly && !v.noIndex {
+		n.stderrOnly = true
+		n.lines = nil
+	}
+	return n
+}
+
+// setLimit changes the end of the range, e.g. when a followed step finishes.
+func (v *logViewer) setLimit(limit int64) {
+	if limit == v.limit {
+		return
+	}
+	v.limit = limit
+	if limit >= 0 && v.end > limit {
+		*v = v.restarted()
+	}
+}
+
+func (v *logViewer) setHeight(h int) {
+	h = max(h, logViewerMinHeight)
+	if h == v.height {
+		return
+	}
+	v.height = h
+	if v.follow {
+		v.top = v.maxTop()
+	}
+	v.top = min(v.top, v.maxTop())
+	v.loadWindow()
+}
+
+// lineCount is the number of lines to show; a trailing newline does not
+// start another line.
+func (v *logViewer) lineCount() int {
+	n := len(v.lines)
+	if !v.stderrOnly && v.lines[n-1] >= v.end {
+		n--
+	}
+	return n
+}
+
+func (v *logViewer) lineEnd(i int) int64 {
+	if v.stderrOnly {
+		if v.ends[i] >= 0 {
+			return v.ends[i]
+		}
+		return v.end
+	}
+	if i+1 < len(v.lines) {
+		return v.lines[i+1]
+	}
+	return v.end
+}
+
+func (v *logViewer) maxTop() int {
+	return max(v.lineCount()-v.height, 0)
+}
+
+func (v *logViewer) scrollTo(top int) {
+	v.top = min(max(top, 0), v.maxTop())
+	v.loadWindow()
+}
+
+// loadWindow reads the visible lines (and their streams and times) from
+// disk.
+func (v *logViewer) loadWindow() {
+	v.window = v.window[:0]
+	v.streams = v.streams[:0]
+	v.times = v.times[:0]
+	count := v.lineCount()
+	if count == 0 {
+		return
+	}
+	f, err := os.Open(v.path)
+	if err != nil {
+		v.err = err
+		return
+	}
+	defer f.Close()
+
+	for i := v.top; i < count && i < v.top+v.height; i++ {
+		size := min(v.lineEnd(i)-v.lines[i], logLineMaxBytes)
+		buf := make([]byte, size)
+		n, err := f.ReadAt(buf, v.lines[i])
+		if err != nil && err != io.EOF {
+			v.err = err
+			return
+		}
+		v.window = append(v.window, sanitizeLogLine(strings.TrimRight(string(buf[:n]), "\r\n")))
+	}
+	v.loadLineInfo()
+}
+
+// loadLineInfo looks up the stream of each window line and, when shown,
+// when it was written relative to the first line of the log.
+func (v *logViewer) loadLineInfo() {
+	index, err := core.OpenLineIndex(v.path)
+	v.noIndex = os.IsNotExist(err)
+	if err != nil {
+		if !v.noIndex {
+			v.err = err
+		}
+		return
+	}
+	defer index.Close()
+	start, _ := index.Start()
+	for i := range v.window {
+		line, ok, err := index.At(v.lines[v.top+i])
+		if err != nil {
+			v.err = err
+			return
+		}
+		v.streams = append(v.streams, line.Stream)
+		switch {
+		case !v.showTimes:
+		case ok:
+			v.times = append(v.times, formatLogElapsed(line.Time.Sub(start)))
+		default:
+			v.times = append(v.times, "")
+		}
+	}
+}
+
+// formatLogElapsed formats a line time as mm:ss.t, or h:mm:ss past an hour.
+func formatLogElapsed(d time.Duration) string {
+	d = max(d, 0)
+	if d >= time.Hour {
+		return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%02d:%02d.%d", int(d.Minutes()), int(d.Seconds())%60, int(d.Milliseconds()/100)%10)
+}
+
+// sanitizeLogLine keeps what a line shows on a terminal: SGR color
+// sequences are kept, other escape sequences and control characters are
+// dropped, a carriage return (progress output) keeps only the text after
+// it, and tabs are expanded.
+func sanitizeLogLine(line string) string {
+	if i := strings.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == 0x1b:
+			seq := core.EscapeSequenceLen(line[i:])
+			if seq > 2 && line[i+1] == '[' && line[i+seq-1] == 'm' {
+				b.WriteString(line[i : i+seq])
+			}
+			i += seq
+		case c == '\t':
+			pad := 8 - col%8
+			b.WriteString(strings.Repeat(" ", pad))
+			col += pad
+			i++
+		case c < 0x20 || c == 0x7f:
+			i++
+		default:
+			b.WriteByte(c)
+			if c < 0x80 || c >= 0xc0 {
+				col++
+			}
+			i++
+		}
+	}
+	return b.String()
+}
+
+// update handles viewer keys; handled is false for keys the viewer leaves
+// to the page.
+func (v *logViewer) update(key tea.KeyMsg) (cmd tea.Cmd, handled bool) {
+	if v.inputActive {
+		switch key.Type {
+		case tea.KeyCtrlC:
+			return nil, false
+		case tea.KeyEsc:
+			v.inputActive = false
+		case tea.KeyEnter:
+			v.inputActive = false
+			v.query, v.matches, v.matchIdx = v.input, nil, 0
+			if v.query != "" {
+				return v.searchCmd(), true
+			}
+		case tea.KeyBackspace:
+			if r := []rune(v.input); len(r) > 0 {
+				v.input = string(r[:len(r)-1])
+			}
+		case tea.KeyRunes, tea.KeySpace:
+			v.input += string(key.Runes)
+		}
+		return nil, true
+	}
+
+	switch key.String() {
+	case "up", "k":
+		v.follow = false
+		v.scrollTo(v.top - 1)
+	case "down", "j":
+		v.scrollTo(v.top + 1)
+	case "pgup", "ctrl+b":
+		v.follow = false
+		v.scrollTo(v.top - v.height)
+	case "pgdown", "ctrl+f", " ":
+		v.scrollTo(v.top + v.height)
+	case "g", "home":
+		v.follow = false
+		v.scrollTo(0)
+	case "G", "end":
+		v.follow = true
+		v.scrollTo(v.maxTop())
+	case "f", "F":
+		v.follow = !v.follow
+		if v.follow {
+			v.scrollTo(v.maxTop())
+		}
+	case "t", "T":
+		v.showTimes = !v.showTimes
+		v.loadWindow()
+	case "e", "E":
+		v.noIndex, v.stderrUnavailable = false, false
+		v.stderrOnly = !v.stderrOnly
+		v.matches, v.matchIdx = nil, 0
+		*v = v.restarted()
+		return v.scanCmd(), true
+	case "/":
+		v.inputActive = true
+		v.input = v.query
+	case "n":
+		v.jumpToMatch(1)
+	case "N":
+		v.jumpToMatch(-1)
+	default:
+		return nil, false
+	}
+	return nil, true
+}
+
+// jumpToMatch moves to the next (delta 1) or previous (-1) match from the
+// visible lines; 0 picks the first match at or below the top.
+func (v *logViewer) jumpToMatch(delta int) {
+	if len(v.matches) == 0 {
+		return
+	}
+	cur := -1
+	if v.matchIdx < len(v.matches) && v.matches[v.matchIdx] >= v.top && v.matches[v.matchIdx] < v.top+v.height {
+		cur = v.matchIdx
+	}
+	switch {
+	case cur >= 0 && delta != 0:
+		v.matchIdx = (cur + delta + len(v.matches)) % len(v.matches)
+	case delta < 0:
+		v.matchIdx = len(v.matches) - 1
+		for i := len(v.matches) - 1; i >= 0; i-- {
+			if v.matches[i] < v.top {
+				v.matchIdx = i
+				break
+			}
+		}
+	default:
+		v.matchIdx = 0
+		for i, line := range v.matches {
+			if line >= v.top {
+				v.matchIdx = i
+				break
+			}
+		}
+	}
+	v.follow = false
+	v.scrollTo(v.matches[v.matchIdx] - v.height/3)
+}
+
+// status is the one-line position summary shown above the log.
+func (v *logViewer) status() string {
+	count := v.lineCount()
+	parts := []string{fmt.Sprintf("lines %d-%d of %d", min(v.top+1, count), min(v.top+v.height, count), count)}
+	if v.follow {
+		parts = append(parts, "following")
+	} else {
+		parts = append(parts, "paused")
+	}
+	if v.stderrOnly {
+		parts = append(parts, "stderr only")
+	}
+	if (v.showTimes || v.stderrUnavailable) && v.noIndex {
+		parts = append(parts, "no line index")
+	}
+	if v.query != "" {
+		if len(v.matches) == 0 {
+			parts = append(parts, fmt.Sprintf("/%s: no matches", v.query))
+		} else {
+			parts = append(parts, fmt.Sprintf("/%s: %d/%d", v.query, v.matchIdx+1, len(v.matches)))
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+// render returns the visible lines, cut to width (0 for no limit), with the
+// search matches highlighted. Lines keep their colors unless they contain a
+// match.
+func (v *logViewer) render(width int) []string {
+	current := -1
+	if v.matchIdx < len(v.matches) {
+		current = v.matches[v.matchIdx]
+	}
+	out := make([]string, 0, len(v.window)+1)
+	for i, line := range v.window {
+		prefix := ""
+		if i < len(v.times) {
+			prefix = mutedStyle.Render(fmt.Sprintf("%8s", v.times[i])) + " "
+		}
+		lineWidth := width
+		if width > 0 && prefix != "" {
+			lineWidth = max(width-9, 1)
+		}
+		if v.query != "" && strings.Contains(line, "\x1b") && queryMatcher(v.query)([]byte(ansi.Strip(line))) {
+			line = ansi.Strip(line)
+		}
+		if lineWidth > 0 {
+			line = ansi.Truncate(line, lineWidth, "…")
+		}
+		if strings.Contains(line, "\x1b") {
+			line += ansi.ResetStyle
+		} else if i < len(v.streams) && v.streams[i] == core.StreamStderr && !v.stderrOnly {
+			line = logStderrStyle.Render(line)
+		}
+		style := logMatchStyle
+		if v.top+i == current {
+			style = logCurrentStyle
+		}
+		out = append(out, prefix+highlightQuery(line, v.query, style))
+	}
+	if v.inputActive {
+		out = append(out, keycapStyle.Render("/")+" "+v.input+"█")
+	}
+	return out
+}
+
+// highlightQuery marks the matches of query in line. Colored lines are left
+// alone: render strips the colors of lines that match.
+func highlightQuery(line, query string, style lipgloss.Style) string {
+	if query == "" || strings.Contains(line, "\x1b") {
+		return line
+	}
+	haystack, needle := line, query
+	if !hasUpper(query) {
+		haystack, needle = strings.ToLower(line), strings.ToLower(query)
+	}
+	if len(haystack) != len(line) {
+		// Case folding changed byte offsets; fall back to exact matches.
+		haystack, needle = line, query
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(haystack, needle)
+		if i < 0 {
+			b.WriteString(line)
+			return b.String()
+		}
+		b.WriteString(line[:i])
+		b.WriteString(style.Render(line[i : i+len(needle)]))
+		line, haystack = line[i+len(needle):], haystack[i+len(needle):]
+	}
+}
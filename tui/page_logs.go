@@ -6,6 +6,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -19,8 +20,15 @@ const (
 	logsModeList logsViewMode = iota
 	logsModeDetail
 	logsModeCI
+	logsModeSearch // results of a search across the repo's job logs
 )
 
+// logGrepMaxMatches caps the lines one log search shows.
+const logGrepMaxMatches = 500
+
+// jobPageSize is how many jobs one page of the job list shows.
+const jobPageSize = 15
+
 type logsModel struct {
 	dbRepo   core.DbRepo
 	repo     string
@@ -28,13 +36,43 @@ type logsModel struct {
 	cancelCh chan<- CancelRequest
 
 	jobs     []core.Job
+	commits  map[string]core.Commit
 	selected int
 
 	actionNameColors map[string]lipgloss.Color
 
-	mode    logsViewMode
-	logPath string
-	logRows []string
+	mode      logsViewMode
+	logPath   string
+	viewer    logViewer
+	detailJob core.Job
+
+	width  int
+	height int
+
+	steps         []core.JobStep
+	stepsExpanded bool
+	stepFocus     int // index into steps whose log segment is shown, -1 for the whole log
+
+	tests       []core.TestCase    // the detail run's test cases
+	testHistory []core.TestHistory // the job's recent results per test
+
+	showSkipped bool
+
+	listFilter        core.JobFilter // parsed from listFilterText; Repo and paging are set per query
+	listFilterText    string
+	filterInputActive bool
+	filterInput       string
+	pageCursors       []core.JobCursor // where each page after the first starts
+	hasOlder          bool
+
+	grepInputActive bool
+	grepInput       string
+	grepQuery       string
+	grepMatches     []core.LogMatch
+	grepSelected    int
+	grepLoading     bool
+	grepErr         error
+	fromSearch      bool // the detail was opened from the search results
 
 	statusMsg   string
 	statusInErr bool
@@ -55,17 +93,34 @@ func (m logsModel) Init() tea.Cmd {
 	if m.repo == "" {
 		return nil
 	}
-	return loadRepoJobsCmd(m.dbRepo, m.repo)
+	return loadRepoJobsCmd(m.dbRepo, m.jobFilter())
 }
 
-func loadRepoJobsCmd(dbRepo core.DbRepo, repo string) tea.Cmd {
+// jobFilter is the query for the shown page of the job list. It asks for
+// one job more than a page to tell whether there are older ones.
+func (m logsModel) jobFilter() core.JobFilter {
+	filter := m.listFilter
+	filter.Repo = m.repo
+	filter.Limit = jobPageSize + 1
+	if !m.showSkipped && filter.Status == "" {
+		filter.ExcludeStatus = core.StatusSkipped
+	}
+	if n := len(m.pageCursors); n > 0 {
+		filter.After = m.pageCursors[n-1]
+	}
+	return filter
+}
+
+func loadRepoJobsCmd(dbRepo core.DbRepo, filter core.JobFilter) tea.Cmd {
+	repo := filter.Repo
 	return func() tea.Msg {
-		jobs, err := dbRepo.ListJob(core.JobFilter{Repo: repo, Limit: 10})
+		jobs, err := dbRepo.ListJob(filter)
 		if err != nil {
 			return loadRepoJobsMsg{
-				repo: repo,
-				jobs: jobs,
-				err:  err,
+				repo:   repo,
+				filter: filter,
+				jobs:   jobs,
+				err:    err,
 			}
 		}
 		jobNames, namesErr := dbRepo.ListJobNames(repo)
@@ -74,24 +129,173 @@ func loadRepoJobsCmd(dbRepo core.DbRepo, repo string) tea.Cmd {
 		}
 		return loadRepoJobsMsg{
 			repo:     repo,
+			filter:   filter,
 			jobs:     jobs,
 			jobNames: jobNames,
+			commits:  loadJobCommits(dbRepo, repo, jobs),
 			err:      nil,
 		}
 	}
 }
 
-func loadJobLogCmd(path string) tea.Cmd {
+// groupJobsBySHA orders jobs so the jobs of one commit are next to each
+// other, commits in the order of their newest job.
+func groupJobsBySHA(jobs []core.Job) []core.Job {
+	index := map[string]int{}
+	var groups [][]core.Job
+	for _, job := range jobs {
+		i, ok := index[job.SHA]
+		if !ok {
+			i = len(groups)
+			index[job.SHA] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], job)
+	}
+	out := make([]core.Job, 0, len(jobs))
+	for _, g := range groups {
+		out = append(out, g...)
+	}
+	return out
+}
+
+// jobStatuses are the statuses a job list filter accepts.
+var jobStatuses = []string{core.StatusPending, core.StatusRunning, core.StatusFinished, core.StatusFailed, core.StatusCanceled, core.StatusSkipped}
+
+// parseJobListFilter reads the job list filter, space separated key:value
+// terms: name, branch and status match exactly, author matches part of the
+// commit author, since and until take a duration back from now (36h, 7d)
+// or a date.
+func parseJobListFilter(text string, now time.Time) (core.JobFilter, error) {
+	var filter core.JobFilter
+	for _, term := range strings.Fields(text) {
+		key, value, ok := strings.Cut(term, ":")
+		if !ok || value == "" {
+			return core.JobFilter{}, fmt.Errorf("filter term %q is not key:value", term)
+		}
+		switch strings.ToLower(key) {
+		case "name":
+			filter.Name = value
+		case "branch":
+			filter.Branch = value
+		case "status":
+			value = strings.ToLower(value)
+			if !slices.Contains(jobStatuses, value) {
+				return core.JobFilter{}, fmt.Errorf("unknown status %q (want one of %s)", value, strings.Join(jobStatuses, ", "))
+			}
+			filter.Status = value
+		case "author":
+			filter.Author = value
+		case "since", "until":
+			t, err := core.ParseSince(value, now)
+			if err != nil {
+				return core.JobFilter{}, fmt.Errorf("%s: %w", key, err)
+			}
+			if strings.ToLower(key) == "since" {
+				filter.Since = t
+			} else {
+				filter.Until = t
+			}
+		default:
+			return core.JobFilter{}, fmt.Errorf("unknown filter %q (want name, branch, status, author, since or until)", key)
+		}
+	}
+	return filter, nil
+}
+
+// loadJobCommits looks up commit metadata for the jobs' shas. Missing rows
+// (jobs queued before metadata capture) are simply absent from the map.
+func loadJobCommits(dbRepo core.DbRepo, repo string, jobs []core.Job) map[string]core.Commit {
+	commits := make(map[string]core.Commit, len(jobs))
+	for _, job := range jobs {
+		if _, ok := commits[job.SHA]; ok {
+			continue
+		}
+		c, err := dbRepo.CommitBySHA(repo, job.SHA)
+		if err != nil || c.SHA == "" {
+			continue
+		}
+		commits[job.SHA] = c
+	}
+	return commits
+}
+
+func grepLogsCmd(dbRepo core.DbRepo, repo, query string) tea.Cmd {
+	return func() tea.Msg {
+		matches, err := core.SearchLogs(dbRepo, core.LogSearch{Pattern: query, Repo: repo, Limit: logGrepMaxMatches})
+		return logGrepMsg{repo: repo, query: query, matches: matches, err: err}
+	}
+}
+
+func loadJobStepsCmd(dbRepo core.DbRepo, runID string) tea.Cmd {
 	return func() tea.Msg {
-		rows, err := readTail(path, 220)
-		return loadJobLogMsg{
-			path:  path,
-			lines: rows,
+		steps, err := dbRepo.ListJobSteps(runID)
+		return loadJobStepsMsg{
+			runID: runID,
+			steps: steps,
 			err:   err,
 		}
 	}
 }
 
+// loadJobTestsCmd loads the run's test cases and, when it has any, the
+// job's test history for spotting flaky tests.
+func loadJobTestsCmd(dbRepo core.DbRepo, job core.Job) tea.Cmd {
+	return func() tea.Msg {
+		cases, err := dbRepo.ListTestCases(job.RunID)
+		if err != nil || len(cases) == 0 {
+			return loadJobTestsMsg{runID: job.RunID, err: err}
+		}
+		history, err := core.LoadTestHistory(dbRepo, job.Repo, job.Name, core.TestHistoryRuns)
+		return loadJobTestsMsg{runID: job.RunID, cases: cases, history: history, err: err}
+	}
+}
+
+// enterDetail switches to the log detail of job.
+func (m *logsModel) enterDetail(job core.Job) {
+	m.mode = logsModeDetail
+	m.detailJob = job
+	m.logPath = pathForJob(job)
+	m.steps = nil
+	m.tests, m.testHistory = nil, nil
+	m.stepsExpanded = true
+	m.stepFocus = -1
+}
+
+// openViewer starts a viewer on the detail log, limited to the focused
+// step's segment when one is selected.
+func (m *logsModel) openViewer() tea.Cmd {
+	start, limit := m.viewerRange()
+	m.viewer = newLogViewer(m.logPath, start, limit)
+	m.resizeViewer()
+	return m.viewer.scanCmd()
+}
+
+// viewerRange is the byte range of the shown log; a negative limit follows
+// the file.
+func (m logsModel) viewerRange() (int64, int64) {
+	if m.mode != logsModeDetail || m.stepFocus < 0 || m.stepFocus >= len(m.steps) {
+		return 0, -1
+	}
+	step := m.steps[m.stepFocus]
+	if step.Status == core.StatusRunning || step.Status == core.StatusPending {
+		return step.LogStart, -1
+	}
+	return step.LogStart, step.LogEnd
+}
+
+// logChromeRows are the rows around the log body: page header, repo label,
+// region title, viewer status and the footer.
+const logChromeRows = 22
+
+// resizeViewer fits the viewer between the detail metadata and the footer.
+func (m *logsModel) resizeViewer() {
+	if m.height == 0 {
+		return
+	}
+	m.viewer.setHeight(m.height - logChromeRows - lipgloss.Height(m.renderDetailMeta()))
+}
+
 func requestRerunCmd(ch chan<- RerunRequest, req RerunRequest) tea.Cmd {
 	if ch == nil {
 		return nil
@@ -135,7 +339,7 @@ func requestCancelCmd(ch chan<- CancelRequest, req CancelRequest) tea.Cmd {
 func (m logsModel) Update(msg tea.Msg) (logsModel, tea.Cmd, bool) {
 	switch mg := msg.(type) {
 	case loadRepoJobsMsg:
-		if mg.repo != m.repo {
+		if mg.repo != m.repo || mg.filter != m.jobFilter() {
 			return m, nil, true
 		}
 		if mg.err != nil {
@@ -144,7 +348,9 @@ func (m logsModel) Update(msg tea.Msg) (logsModel, tea.Cmd, bool) {
 			m.jobsLoadErr = true
 			return m, nil, true
 		}
-		m.jobs = mg.jobs
+		m.hasOlder = len(mg.jobs) > jobPageSize
+		m.jobs = groupJobsBySHA(mg.jobs[:min(len(mg.jobs), jobPageSize)])
+		m.commits = mg.commits
 		if len(mg.jobNames) > 0 {
 			m.actionNameColors = buildActionNameColors(mg.jobNames)
 		}
@@ -160,17 +366,60 @@ func (m logsModel) Update(msg tea.Msg) (logsModel, tea.Cmd, bool) {
 		m.jobsLoadErr = false
 		return m, nil, true
 
-	case loadJobLogMsg:
-		if (m.mode != logsModeDetail && m.mode != logsModeCI) || mg.path != m.logPath {
+	case logScanMsg:
+		if m.mode == logsModeList {
+			return m, nil, true
+		}
+		return m, m.viewer.applyScan(mg), true
+	case logSearchMsg:
+		if m.mode == logsModeList {
+			return m, nil, true
+		}
+		m.viewer.applySearch(mg)
+		return m, nil, true
+	case logGrepMsg:
+		if mg.repo != m.repo || mg.query != m.grepQuery {
+			return m, nil, true
+		}
+		m.grepLoading = false
+		m.grepMatches, m.grepErr = mg.matches, mg.err
+		m.grepSelected = 0
+		return m, nil, true
+	case tea.WindowSizeMsg:
+		m.width, m.height = mg.Width, mg.Height
+		if m.mode != logsModeList {
+			m.resizeViewer()
+		}
+		return m, nil, true
+	case loadJobStepsMsg:
+		if m.mode != logsModeDetail || mg.runID != m.detailJob.RunID {
+			return m, nil, true
+		}
+		if mg.err != nil {
+			m.statusInErr = true
+			m.statusMsg = mg.err.Error()
+			return m, nil, true
+		}
+		m.steps = mg.steps
+		if m.stepFocus >= len(m.steps) {
+			m.stepFocus = -1
+			return m, m.openViewer(), true
+		}
+		_, limit := m.viewerRange()
+		m.viewer.setLimit(limit)
+		m.resizeViewer()
+		return m, nil, true
+	case loadJobTestsMsg:
+		if m.mode != logsModeDetail || mg.runID != m.detailJob.RunID {
 			return m, nil, true
 		}
 		if mg.err != nil {
 			m.statusInErr = true
 			m.statusMsg = mg.err.Error()
-			m.logRows = nil
 			return m, nil, true
 		}
-		m.logRows = mg.lines
+		m.tests, m.testHistory = mg.cases, mg.history
+		m.resizeViewer()
 		return m, nil, true
 	case statusEventMsg:
 		m.statusInErr = mg.inErr
@@ -181,24 +430,50 @@ func (m logsModel) Update(msg tea.Msg) (logsModel, tea.Cmd, bool) {
 		if m.repo == "" {
 			return m, nil, false
 		}
-		if (m.mode == logsModeDetail || m.mode == logsModeCI) && strings.TrimSpace(m.logPath) != "" {
-			return m, loadJobLogCmd(m.logPath), true
+		if m.mode == logsModeDetail && strings.TrimSpace(m.logPath) != "" {
+			cmds := []tea.Cmd{m.viewer.scanCmd(), loadJobStepsCmd(m.dbRepo, m.detailJob.RunID)}
+			if len(m.tests) == 0 {
+				// Reports are read when the run ends.
+				cmds = append(cmds, loadJobTestsCmd(m.dbRepo, m.detailJob))
+			}
+			return m, tea.Batch(cmds...), true
+		}
+		if m.mode == logsModeCI && strings.TrimSpace(m.logPath) != "" {
+			return m, m.viewer.scanCmd(), true
 		}
-		return m, loadRepoJobsCmd(m.dbRepo, m.repo), true
+		return m, loadRepoJobsCmd(m.dbRepo, m.jobFilter()), true
 
 	case tea.KeyMsg:
 		if m.repo == "" {
 			return m, nil, false
 		}
 
+		if m.grepInputActive {
+			return m.updateGrepInput(mg)
+		}
+		if m.filterInputActive {
+			return m.updateFilterInput(mg)
+		}
 		if m.mode == logsModeDetail || m.mode == logsModeCI {
+			if cmd, handled := m.viewer.update(mg); handled {
+				return m, cmd, true
+			}
 			switch mg.String() {
 			case "esc", "enter", "backspace":
 				m.mode = logsModeList
+				if m.fromSearch {
+					m.mode = logsModeSearch
+				}
 				return m, nil, true
 			}
+			if m.mode == logsModeDetail {
+				return m.updateDetailKey(mg)
+			}
 			return m, nil, false
 		}
+		if m.mode == logsModeSearch {
+			return m.updateSearchKey(mg)
+		}
 
 		switch mg.String() {
 		case "up":
@@ -211,24 +486,63 @@ func (m logsModel) Update(msg tea.Msg) (logsModel, tea.Cmd, bool) {
 			if len(m.jobs) == 0 {
 				return m, nil, true
 			}
-			m.mode = logsModeDetail
-			m.logPath = pathForJob(m.jobs[m.selected])
-			m.logRows = nil
-			return m, loadJobLogCmd(m.logPath), true
+			m.enterDetail(m.jobs[m.selected])
+			m.fromSearch = false
+			return m, tea.Batch(m.openViewer(), loadJobStepsCmd(m.dbRepo, m.detailJob.RunID), loadJobTestsCmd(m.dbRepo, m.detailJob)), true
 		case "l", "L":
 			m.mode = logsModeCI
+			m.fromSearch = false
 			m.logPath = core.CIActivityLogPath(m.repo)
-			m.logRows = nil
-			return m, loadJobLogCmd(m.logPath), true
+			return m, m.openViewer(), true
+		case "/":
+			m.grepInputActive = true
+			m.grepInput = m.grepQuery
+			return m, nil, true
+		case "f", "F":
+			m.filterInputActive = true
+			m.filterInput = m.listFilterText
+			return m, nil, true
+		case "pgdown", "]":
+			if !m.hasOlder || len(m.jobs) == 0 {
+				return m, nil, true
+			}
+			// The page's jobs are regrouped, so its last one in time order
+			// is found by cursor.
+			last := m.jobs[0].Cursor()
+			for _, job := range m.jobs[1:] {
+				if c := job.Cursor(); c.Start.Before(last.Start) || (c.Start.Equal(last.Start) && c.RunID < last.RunID) {
+					last = c
+				}
+			}
+			m.pageCursors = append(m.pageCursors, last)
+			m.selected = 0
+			return m, loadRepoJobsCmd(m.dbRepo, m.jobFilter()), true
+		case "pgup", "[":
+			if len(m.pageCursors) == 0 {
+				return m, nil, true
+			}
+			m.pageCursors = m.pageCursors[:len(m.pageCursors)-1]
+			m.selected = 0
+			return m, loadRepoJobsCmd(m.dbRepo, m.jobFilter()), true
+		case "s", "S":
+			m.showSkipped = !m.showSkipped
+			m.statusInErr = false
+			if m.showSkipped {
+				m.statusMsg = "showing skipped evaluations"
+			} else {
+				m.statusMsg = "hiding skipped evaluations"
+			}
+			m.pageCursors, m.selected = nil, 0
+			return m, loadRepoJobsCmd(m.dbRepo, m.jobFilter()), true
 		case "r":
 			if len(m.jobs) == 0 {
 				return m, nil, true
 			}
 			job := m.jobs[m.selected]
 			status := strings.ToLower(strings.TrimSpace(job.Status))
-			if status != core.StatusFailed && status != core.StatusCanceled {
+			if status != core.StatusFailed && status != core.StatusCanceled && status != core.StatusSkipped {
 				m.statusInErr = true
-				m.statusMsg = "select a failed/canceled job to restart"
+				m.statusMsg = "select a failed/canceled/skipped job to restart"
 				return m, nil, true
 			}
 			return m, requestRerunCmd(m.rerunCh, RerunRequest{
@@ -262,30 +576,186 @@ func (m logsModel) Update(msg tea.Msg) (logsModel, tea.Cmd, bool) {
 	return m, nil, false
 }
 
+// updateGrepInput edits the log search pattern; ENTER runs the search.
+func (m logsModel) updateGrepInput(mg tea.KeyMsg) (logsModel, tea.Cmd, bool) {
+	switch mg.Type {
+	case tea.KeyCtrlC:
+		return m, nil, false
+	case tea.KeyEsc:
+		m.grepInputActive = false
+	case tea.KeyEnter:
+		m.grepInputActive = false
+		if m.grepInput == "" {
+			return m, nil, true
+		}
+		m.mode = logsModeSearch
+		m.grepQuery = m.grepInput
+		m.grepMatches, m.grepErr, m.grepSelected = nil, nil, 0
+		m.grepLoading = true
+		return m, grepLogsCmd(m.dbRepo, m.repo, m.grepQuery), true
+	case tea.KeyBackspace:
+		if r := []rune(m.grepInput); len(r) > 0 {
+			m.grepInput = string(r[:len(r)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.grepInput += string(mg.Runes)
+	}
+	return m, nil, true
+}
+
+// updateFilterInput edits the job list filter; ENTER applies it from the
+// first page, an empty filter shows all jobs.
+func (m logsModel) updateFilterInput(mg tea.KeyMsg) (logsModel, tea.Cmd, bool) {
+	switch mg.Type {
+	case tea.KeyCtrlC:
+		return m, nil, false
+	case tea.KeyEsc:
+		m.filterInputActive = false
+	case tea.KeyEnter:
+		filter, err := parseJobListFilter(m.filterInput, time.Now())
+		if err != nil {
+			m.statusInErr = true
+			m.statusMsg = err.Error()
+			return m, nil, true
+		}
+		m.filterInputActive = false
+		m.listFilter = filter
+		m.listFilterText = strings.Join(strings.Fields(m.filterInput), " ")
+		m.pageCursors, m.selected = nil, 0
+		m.statusMsg, m.statusInErr = "", false
+		return m, loadRepoJobsCmd(m.dbRepo, m.jobFilter()), true
+	case tea.KeyBackspace:
+		if r := []rune(m.filterInput); len(r) > 0 {
+			m.filterInput = string(r[:len(r)-1])
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.filterInput += string(mg.Runes)
+	}
+	return m, nil, true
+}
+
+// updateSearchKey handles the search results: ENTER opens the run's log at
+// the matching line.
+func (m logsModel) updateSearchKey(mg tea.KeyMsg) (logsModel, tea.Cmd, bool) {
+	switch mg.String() {
+	case "up":
+		m.grepSelected = modIdx(m.grepSelected, len(m.grepMatches), -1)
+	case "down":
+		m.grepSelected = modIdx(m.grepSelected, len(m.grepMatches), 1)
+	case "/":
+		m.grepInputActive = true
+		m.grepInput = m.grepQuery
+	case "esc", "backspace":
+		m.mode = logsModeList
+	case "enter":
+		if len(m.grepMatches) == 0 {
+			return m, nil, true
+		}
+		match := m.grepMatches[m.grepSelected]
+		m.enterDetail(match.Job)
+		m.fromSearch = true
+		m.viewer = newLogViewer(m.logPath, 0, -1)
+		m.viewer.jumpTo(match.Line, m.grepQuery)
+		m.resizeViewer()
+		return m, tea.Batch(m.viewer.scanCmd(), loadJobStepsCmd(m.dbRepo, m.detailJob.RunID), loadJobTestsCmd(m.dbRepo, m.detailJob)), true
+	default:
+		return m, nil, false
+	}
+	return m, nil, true
+}
+
+func (m logsModel) updateDetailKey(mg tea.KeyMsg) (logsModel, tea.Cmd, bool) {
+	switch mg.String() {
+	case "s", "S":
+		m.stepsExpanded = !m.stepsExpanded
+		m.resizeViewer()
+		return m, nil, true
+	case "]", "[":
+		if len(m.steps) == 0 {
+			return m, nil, true
+		}
+		// Cycle through -1 (whole log) and each step.
+		delta := 1
+		if mg.String() == "[" {
+			delta = -1
+		}
+		m.stepFocus = modIdx(m.stepFocus+1, len(m.steps)+1, delta) - 1
+		return m, m.openViewer(), true
+	}
+	return m, nil, false
+}
+
 func (m logsModel) View() string {
-	if m.mode != logsModeList {
-		return m.renderLogDetail()
+	switch m.mode {
+	case logsModeList:
+		return m.renderJobList()
+	case logsModeSearch:
+		return m.renderLogSearch()
 	}
-	return m.renderJobList()
+	return m.renderLogDetail()
 }
 
 func (m logsModel) help() string {
-	if m.mode == logsModeDetail || m.mode == logsModeCI {
+	if m.mode == logsModeDetail {
+		stepsHint := "hide steps"
+		if !m.stepsExpanded {
+			stepsHint = "show steps"
+		}
+		return footerBarStyle.Render(
+			renderHint("ESC/ENTER", "back"),
+			renderHint("S", stepsHint),
+			renderHint("[/]", "step log"),
+			viewerHints(),
+		)
+	}
+	if m.mode == logsModeCI {
 		return footerBarStyle.Render(
 			renderHint("ESC/ENTER", "back"),
+			viewerHints(),
+		)
+	}
+	if m.mode == logsModeSearch {
+		return footerBarStyle.Render(
+			renderHint("UP/DOWN", "move"),
+			renderHint("ENTER", "open at line"),
+			renderHint("/", "new search"),
+			renderHint("ESC", "jobs"),
 		)
 	}
 
 	hints := []string{
 		renderHint("UP/DOWN", "move"),
+		renderHint("PGUP/PGDN", "newer/older"),
+		renderHint("F", "filter"),
 		renderHint("ENTER", "job log"),
 		renderHint("L", "ci log"),
+		renderHint("/", "search logs"),
 		renderHint("R", "restart"),
 		renderHint("C", "cancel"),
+		renderHint("S", skippedHint(m.showSkipped)),
 	}
 	return footerBarStyle.Render(hints...)
 }
 
+func viewerHints() string {
+	return strings.Join([]string{
+		renderHint("PGUP/PGDN", "scroll"),
+		renderHint("G/SHIFT+G", "top/end"),
+		renderHint("/", "search"),
+		renderHint("N", "next match"),
+		renderHint("F", "follow"),
+		renderHint("T", "times"),
+		renderHint("E", "stderr only"),
+	}, " ")
+}
+
+func skippedHint(shown bool) string {
+	if shown {
+		return "hide skipped"
+	}
+	return "show skipped"
+}
+
 const (
 	actionNameColWidth = 22
 	branchColWidth     = 12
@@ -293,37 +763,41 @@ const (
 	authorColWidth     = 14
 	statusColWidth     = 9
 	elapsedColWidth    = 7
+	timeAgoColWidth    = 7
+	subjectColWidth    = 48
 )
 
+// renderJobList renders the page of jobs under a header per commit.
 func (m logsModel) renderJobList() string {
-	lines := make([]string, 0, len(m.jobs))
+	lines := make([]string, 0, 2*len(m.jobs)+1)
+	if m.listFilterText != "" || len(m.pageCursors) > 0 {
+		lines = append(lines, mutedStyle.Render(m.pageLabel()))
+	}
 	now := time.Now()
 	for i, j := range m.jobs {
-		nameCell := m.renderActionName(j.Name, actionNameColWidth)
-		branchCell := fixedCell(j.Branch, branchColWidth)
-		shaCell := fixedCell(shortSHA(j.SHA), shaColWidth)
-		authorCell := fixedCell(displayCommitAuthor(j.CommitAuthor), authorColWidth)
-		statusCell := renderStatusCell(j.Status, statusColWidth)
-		elapsedCell := fixedCell(elapsedForJob(now, j), elapsedColWidth)
-
+		if i == 0 || j.SHA != m.jobs[i-1].SHA {
+			lines = append(lines, m.renderCommitHeader(j))
+		}
 		line := strings.Join([]string{
-			nameCell,
-			branchCell,
-			shaCell,
-			authorCell,
-			statusCell,
-			elapsedCell,
-			timeAgo(now, j.Start),
+			m.renderActionName(j.Name, actionNameColWidth),
+			fixedCell(j.Branch, branchColWidth),
+			renderStatusCell(j.Status, statusColWidth),
+			fixedCell(elapsedForJob(now, j), elapsedColWidth),
+			fixedCell(timeAgo(now, j.Start), timeAgoColWidth),
 		}, "  ")
 
 		if i == m.selected {
-			lines = append(lines, selectedItemStyle.Render("> "+line))
+			lines = append(lines, selectedItemStyle.Render("  > "+line))
 		} else {
-			lines = append(lines, "  "+line)
+			lines = append(lines, "    "+line)
 		}
 	}
-	if len(lines) == 0 {
-		lines = append(lines, mutedStyle.Render("No jobs yet."))
+	if len(m.jobs) == 0 {
+		empty := "No jobs yet."
+		if m.listFilterText != "" || len(m.pageCursors) > 0 {
+			empty = "No matching jobs."
+		}
+		lines = append(lines, mutedStyle.Render(empty))
 	}
 
 	help := ""
@@ -334,10 +808,99 @@ func (m logsModel) renderJobList() string {
 			help = successStyle.Render(m.statusMsg)
 		}
 	}
+	if m.grepInputActive {
+		help = m.renderGrepInput()
+	}
+	if m.filterInputActive {
+		help = keycapStyle.Render("F") + " filter (name: branch: status: author: since: until:): " + m.filterInput + "█"
+		if m.statusInErr && m.statusMsg != "" {
+			help += "\n" + errorStyle.Render(m.statusMsg)
+		}
+	}
 
 	return renderRegion("Jobs", []string{strings.Join(lines, "\n")}, help, true)
 }
 
+// renderCommitHeader renders the commit line above a commit's jobs.
+func (m logsModel) renderCommitHeader(j core.Job) string {
+	return strings.Join([]string{
+		lipgloss.NewStyle().Bold(true).Render(fixedCell(shortSHA(j.SHA), shaColWidth)),
+		fixedCell(displayCommitAuthor(j.CommitAuthor), authorColWidth),
+		mutedStyle.Render(fixedCell(m.commits[j.SHA].Subject, subjectColWidth)),
+	}, "  ")
+}
+
+// pageLabel describes the active filter and page of the job list.
+func (m logsModel) pageLabel() string {
+	parts := []string{fmt.Sprintf("page %d", len(m.pageCursors)+1)}
+	if m.hasOlder {
+		parts[0] += ", older jobs on PGDN"
+	}
+	if m.listFilterText != "" {
+		parts = append(parts, "filter: "+m.listFilterText)
+	}
+	return strings.Join(parts, "  ·  ")
+}
+
+func (m logsModel) renderGrepInput() string {
+	return keycapStyle.Render("/") + " search logs of " + m.repo + ": " + m.grepInput + "█"
+}
+
+const (
+	grepLineColWidth = 10
+	grepTextColWidth = 80
+	// logSearchChromeRows are the rows around the search results.
+	logSearchChromeRows = 20
+)
+
+// renderLogSearch lists the log lines matching the search, oldest run
+// first, scrolled to keep the selected one in view.
+func (m logsModel) renderLogSearch() string {
+	rows := max(m.height-logSearchChromeRows, logViewerMinHeight)
+	top := min(max(m.grepSelected-rows/2, 0), max(len(m.grepMatches)-rows, 0))
+	now := time.Now()
+	lines := make([]string, 0, rows)
+	for i := top; i < len(m.grepMatches) && i < top+rows; i++ {
+		match := m.grepMatches[i]
+		j := match.Job
+		line := strings.Join([]string{
+			m.renderActionName(j.Name, actionNameColWidth),
+			fixedCell(j.Branch, branchColWidth),
+			fixedCell(shortSHA(j.SHA), shaColWidth),
+			fixedCell(timeAgo(now, j.Start), timeAgoColWidth),
+			mutedStyle.Render(fixedCell(fmt.Sprintf("line %d", match.Line+1), grepLineColWidth)),
+			highlightQuery(fixedCell(match.Text, grepTextColWidth), m.grepQuery, logMatchStyle),
+		}, "  ")
+		if i == m.grepSelected {
+			lines = append(lines, selectedItemStyle.Render("> "+line))
+		} else {
+			lines = append(lines, "  "+line)
+		}
+	}
+
+	var help string
+	switch {
+	case m.grepInputActive:
+		help = m.renderGrepInput()
+	case m.grepLoading:
+		help = "searching..."
+	case m.grepErr != nil:
+		help = errorStyle.Render(m.grepErr.Error())
+	case len(m.grepMatches) == 0:
+		lines = append(lines, mutedStyle.Render("No matches."))
+	default:
+		runs := map[string]bool{}
+		for _, match := range m.grepMatches {
+			runs[match.Job.RunID] = true
+		}
+		help = fmt.Sprintf("%d matching lines in %d runs, oldest first", len(m.grepMatches), len(runs))
+		if len(m.grepMatches) == logGrepMaxMatches {
+			help = fmt.Sprintf("first %d matching lines in %d runs, oldest first; refci grep lists all", len(m.grepMatches), len(runs))
+		}
+	}
+	return renderRegion("Log search /"+m.grepQuery, []string{strings.Join(lines, "\n")}, help, true)
+}
+
 func (m logsModel) renderActionName(name string, width int) string {
 	cell := fixedCell(name, width)
 	if strings.TrimSpace(name) == "" {
@@ -479,11 +1042,15 @@ func (m logsModel) renderLogDetail() string {
 	}
 
 	header := sectionTitleStyle.Render(title)
-	meta := mutedStyle.Render(fmt.Sprintf("path=%s", m.logPath))
+	meta := m.renderDetailMeta()
 
 	body := mutedStyle.Render(emptyText)
-	if len(m.logRows) > 0 {
-		body = strings.Join(m.logRows, "\n")
+	if rows := m.viewer.render(m.width - 8); len(rows) > 0 {
+		body = strings.Join(rows, "\n")
+	}
+	body = mutedStyle.Render(m.viewer.status()) + "\n" + body
+	if m.viewer.err != nil && !os.IsNotExist(m.viewer.err) {
+		body = errorStyle.Render(m.viewer.err.Error()) + "\n\n" + body
 	}
 	if m.statusMsg != "" && m.statusInErr {
 		body = errorStyle.Render(m.statusMsg) + "\n\n" + body
@@ -493,6 +1060,205 @@ func (m logsModel) renderLogDetail() string {
 	return regionFocusedStyle.Render(content)
 }
 
+// renderDetailMeta renders the lines between the title and the log body.
+func (m logsModel) renderDetailMeta() string {
+	meta := mutedStyle.Render(fmt.Sprintf("path=%s", m.logPath))
+	if m.mode == logsModeDetail && m.detailJob.PRNumber > 0 {
+		meta += "\n" + mutedStyle.Render(fmt.Sprintf("pull request #%d (merge ref)", m.detailJob.PRNumber))
+	}
+	if m.mode == logsModeDetail && strings.TrimSpace(m.detailJob.Command) != "" {
+		meta += "\n" + mutedStyle.Render(fmt.Sprintf("command=%s", m.detailJob.Command))
+	}
+	if m.mode == logsModeDetail && (m.detailJob.PeakMemory > 0 || m.detailJob.CPUTime > 0) {
+		meta += "\n" + mutedStyle.Render(fmt.Sprintf("peak_mem=%s cpu=%s", core.FormatByteSize(m.detailJob.PeakMemory), m.detailJob.CPUTime.Round(time.Millisecond)))
+	}
+	if m.mode == logsModeDetail && strings.TrimSpace(m.detailJob.Reason) != "" {
+		meta += "\n" + mutedStyle.Render(fmt.Sprintf("reason=%s", m.detailJob.Reason))
+	}
+	if m.mode == logsModeDetail {
+		if commit, ok := m.commits[m.detailJob.SHA]; ok {
+			meta += "\n\n" + renderCommitInfo(commit)
+		}
+	}
+	if m.mode == logsModeDetail && len(m.steps) > 0 {
+		meta += "\n\n" + m.renderSteps(time.Now())
+	}
+	if m.mode == logsModeDetail && len(m.tests) > 0 {
+		meta += "\n\n" + m.renderTests()
+	}
+	return meta
+}
+
+const (
+	testNameColWidth  = 48
+	testListMaxRows   = 6
+	testFailureColMax = 80
+)
+
+// renderTests renders the run's test summary, its failed tests and the
+// job's flaky tests, each with its recent results.
+func (m logsModel) renderTests() string {
+	summary := core.SummarizeTests(m.tests)
+	style := successStyle
+	if summary.Failed > 0 {
+		style = errorStyle
+	}
+	lines := []string{style.Render("tests: " + summary.String())}
+
+	history := make(map[[2]string]core.TestHistory, len(m.testHistory))
+	for _, h := range m.testHistory {
+		history[[2]string{h.Suite, h.Name}] = h
+	}
+	var failed []core.TestCase
+	for _, c := range m.tests {
+		if c.Status == core.TestFailed {
+			failed = append(failed, c)
+		}
+	}
+	for i, c := range failed {
+		if i == testListMaxRows {
+			lines = append(lines, mutedStyle.Render(fmt.Sprintf("  ... %d more failed", len(failed)-i)))
+			break
+		}
+		h := history[[2]string{c.Suite, c.Name}]
+		line := "  " + errorStyle.Render(fixedCell(testName(c.Suite, c.Name), testNameColWidth)) + "  " + renderTestResults(h.Results)
+		if h.Flaky {
+			line += "  " + warningStyle.Render("flaky")
+		}
+		if first, _, _ := strings.Cut(c.Failure, "\n"); first != "" {
+			line += "\n    " + mutedStyle.Render(fixedCell(first, testFailureColMax))
+		}
+		lines = append(lines, line)
+	}
+
+	var flaky []core.TestHistory
+	for _, h := range m.testHistory {
+		if h.Flaky {
+			flaky = append(flaky, h)
+		}
+	}
+	if len(flaky) > 0 {
+		lines = append(lines, warningStyle.Render(fmt.Sprintf("flaky in the last %d runs of %s (%d):", core.TestHistoryRuns, m.detailJob.Name, len(flaky))))
+	}
+	for i, h := range flaky {
+		if i == testListMaxRows {
+			lines = append(lines, mutedStyle.Render(fmt.Sprintf("  ... %d more flaky", len(flaky)-i)))
+			break
+		}
+		lines = append(lines, "  "+fixedCell(testName(h.Suite, h.Name), testNameColWidth)+"  "+renderTestResults(h.Results))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// renderTestResults renders a test's recent results oldest to newest, one
+// mark per run.
+func renderTestResults(results []core.TestCaseRun) string {
+	var b strings.Builder
+	for i := len(results) - 1; i >= 0; i-- {
+		switch results[i].Status {
+		case core.TestPassed:
+			b.WriteString(successStyle.Render("+"))
+		case core.TestFailed:
+			b.WriteString(errorStyle.Render("x"))
+		default:
+			b.WriteString(mutedStyle.Render("-"))
+		}
+	}
+	return b.String()
+}
+
+func testName(suite, name string) string {
+	if suite == "" {
+		return name
+	}
+	return suite + "." + name
+}
+
+const stepNameColWidth = 24
+
+// renderSteps renders the step list with per-step timing, or a one-line
+// summary when collapsed.
+func (m logsModel) renderSteps(now time.Time) string {
+	if !m.stepsExpanded {
+		counts := map[string]int{}
+		for _, step := range m.steps {
+			counts[step.Status]++
+		}
+		parts := make([]string, 0, len(counts))
+		for _, status := range []string{core.StatusFinished, core.StatusFailed, core.StatusRunning, core.StatusPending, core.StatusCanceled, core.StatusSkipped} {
+			if counts[status] > 0 {
+				parts = append(parts, statusStyle(status).Render(fmt.Sprintf("%d %s", counts[status], status)))
+			}
+		}
+		return mutedStyle.Render(fmt.Sprintf("steps (%d): ", len(m.steps))) + strings.Join(parts, mutedStyle.Render(", "))
+	}
+
+	focus := "whole log"
+	if m.stepFocus >= 0 && m.stepFocus < len(m.steps) {
+		focus = "log of " + m.steps[m.stepFocus].Name
+	}
+	lines := []string{mutedStyle.Render(fmt.Sprintf("steps (%d), showing %s", len(m.steps), focus))}
+	for i, step := range m.steps {
+		line := strings.Join([]string{
+			fixedCell(fmt.Sprintf("%d. %s", i+1, step.Name), stepNameColWidth),
+			renderStatusCell(step.Status, statusColWidth),
+			fixedCell(stepElapsed(now, step), elapsedColWidth),
+			mutedStyle.Render(strings.TrimSpace(step.Msg)),
+		}, "  ")
+		if i == m.stepFocus {
+			lines = append(lines, selectedItemStyle.Render("> "+line))
+		} else {
+			lines = append(lines, "  "+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func stepElapsed(now time.Time, step core.JobStep) string {
+	if step.Start.IsZero() {
+		return "--"
+	}
+	end := step.End
+	if end.IsZero() {
+		end = now
+	}
+	return compactDuration(end.Sub(step.Start))
+}
+
+const commitBodyMaxLines = 6
+
+func renderCommitInfo(c core.Commit) string {
+	lines := []string{
+		lipgloss.NewStyle().Bold(true).Render(c.Subject),
+		mutedStyle.Render(fmt.Sprintf("author    %s <%s>  %s", c.AuthorName, c.AuthorEmail, formatCommitTime(c.AuthorTime))),
+	}
+	if c.CommitterName != c.AuthorName || c.CommitterEmail != c.AuthorEmail || !c.CommitTime.Equal(c.AuthorTime) {
+		lines = append(lines, mutedStyle.Render(fmt.Sprintf("committer %s <%s>  %s", c.CommitterName, c.CommitterEmail, formatCommitTime(c.CommitTime))))
+	}
+	parents := make([]string, 0, len(c.Parents))
+	for _, p := range c.Parents {
+		parents = append(parents, shortSHA(p))
+	}
+	if len(parents) > 0 {
+		lines = append(lines, mutedStyle.Render("parents   "+strings.Join(parents, " ")))
+	}
+	if body := strings.TrimSpace(c.Body); body != "" {
+		bodyLines := strings.Split(body, "\n")
+		if len(bodyLines) > commitBodyMaxLines {
+			bodyLines = append(bodyLines[:commitBodyMaxLines], "...")
+		}
+		lines = append(lines, "", strings.Join(bodyLines, "\n"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatCommitTime(t time.Time) string {
+	if t.IsZero() {
+		return "--"
+	}
+	return t.Local().Format("2006-01-02 15:04:05 Z07:00")
+}
+
 func shortSHA(sha string) string {
 	s := strings.TrimSpace(sha)
 	if len(s) <= 8 {
@@ -521,6 +1287,8 @@ func statusTag(v string) string {
 		return "PENDING"
 	case core.StatusCanceled:
 		return "CANCELED"
+	case core.StatusSkipped:
+		return "SKIPPED"
 	default:
 		return strings.ToUpper(v)
 	}
@@ -540,7 +1308,7 @@ func statusStyle(v string) lipgloss.Style {
 		return successStyle
 	case core.StatusFailed:
 		return errorStyle
-	case core.StatusCanceled:
+	case core.StatusCanceled, core.StatusSkipped:
 		return mutedStyle
 	default:
 		return lipgloss.NewStyle()
@@ -599,22 +1367,6 @@ func compactDuration(d time.Duration) string {
 	}
 }
 
-func readTail(path string, max int) ([]string, error) {
-	if path == "" {
-		return nil, nil
-	}
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	rows := strings.Split(string(b), "\n")
-	if len(rows) > max {
-		rows = rows[len(rows)-max:]
-	}
-	return rows, nil
-}
-
 func pathForJob(job core.Job) string {
 	if logPath := strings.TrimSpace(job.LogPath); logPath != "" {
 		return logPath
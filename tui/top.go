@@ -111,7 +111,7 @@ func (m topModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.selectedRepo = len(m.repos) - 1
 		}
 		return m, nil
-	case loadRepoJobsMsg, loadJobLogMsg:
+	case loadRepoJobsMsg, logScanMsg, logSearchMsg, loadJobStepsMsg, loadJobTestsMsg, logGrepMsg:
 		if m.mode != topModeLogs {
 			return m, nil
 		}
@@ -138,7 +138,8 @@ func (m topModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
 		m.height = msg.Height
-		return m, nil
+		m.logsModel, cmd, _ = m.logsModel.Update(msg)
+		return m, cmd
 	case tickMsg:
 		m.now = time.Time(msg)
 		if m.mode == topModeRepoPicker {
@@ -175,6 +176,7 @@ func (m topModel) updateRepoPickerKey(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.repo = repo
 		m.mode = topModeLogs
 		m.logsModel = newLogsModel(m.dbRepo, repo, m.rerunCh, m.cancelCh)
+		m.logsModel.width, m.logsModel.height = m.width, m.height
 		return m, m.logsModel.Init()
 	}
 	return m, nil
@@ -280,8 +282,10 @@ func (m topModel) logsFooter() string {
 		renderHint("UP/DOWN", "move"),
 		renderHint("ENTER", "job log"),
 		renderHint("L", "ci log"),
+		renderHint("/", "search logs"),
 		renderHint("R", "restart"),
 		renderHint("C", "cancel"),
+		renderHint("S", skippedHint(m.logsModel.showSkipped)),
 		renderHint("ESC/P", "repos"),
 	)
 }
@@ -25,8 +25,10 @@ type CancelRequest struct {
 
 type loadRepoJobsMsg struct {
 	repo     string
+	filter   core.JobFilter // the page's query, to drop stale results
 	jobs     []core.Job
 	jobNames []string
+	commits  map[string]core.Commit
 	err      error
 }
 
@@ -35,12 +37,26 @@ type loadRepoListMsg struct {
 	err   error
 }
 
-type loadJobLogMsg struct {
-	path  string
-	lines []string
+type loadJobStepsMsg struct {
+	runID string
+	steps []core.JobStep
 	err   error
 }
 
+type loadJobTestsMsg struct {
+	runID   string
+	cases   []core.TestCase
+	history []core.TestHistory
+	err     error
+}
+
+type logGrepMsg struct {
+	repo    string
+	query   string
+	matches []core.LogMatch
+	err     error
+}
+
 type statusEventMsg struct {
 	message string
 	inErr   bool